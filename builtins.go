@@ -1,5 +1,7 @@
 package nanojs
 
+import "context"
+
 var builtinFuncs = []*BuiltinFunction{
 	{
 		Name:  "len",
@@ -42,8 +44,9 @@ var builtinFuncs = []*BuiltinFunction{
 		Value: builtinChar,
 	},
 	{
-		Name:  "bytes",
-		Value: builtinBytes,
+		Name:         "bytes",
+		Value:        backgroundFunc(builtinBytes),
+		ContextValue: builtinBytes,
 	},
 	{
 		Name:  "time",
@@ -314,13 +317,16 @@ func builtinLen(args ...Object) (Object, error) {
 		return &Int{Value: int64(len(arg.Value))}, nil
 	case *ImmutableMap:
 		return &Int{Value: int64(len(arg.Value))}, nil
-	default:
-		return nil, ErrInvalidArgumentType{
-			Name:     "first",
-			Expected: "array/string/bytes/map",
-			Found:    arg.TypeName(),
+	case *GoObject:
+		if n, ok := arg.len(); ok {
+			return &Int{Value: int64(n)}, nil
 		}
 	}
+	return nil, ErrInvalidArgumentType{
+		Name:     "first",
+		Expected: "array/string/bytes/map",
+		Found:    args[0].TypeName(),
+	}
 }
 
 func builtinFormat(args ...Object) (Object, error) {
@@ -446,7 +452,7 @@ func builtinChar(args ...Object) (Object, error) {
 	return UndefinedValue, nil
 }
 
-func builtinBytes(args ...Object) (Object, error) {
+func builtinBytes(ctx context.Context, args ...Object) (Object, error) {
 	argsLen := len(args)
 	if !(argsLen == 1 || argsLen == 2) {
 		return nil, ErrWrongNumArguments
@@ -457,6 +463,9 @@ func builtinBytes(args ...Object) (Object, error) {
 		if n.Value > int64(MaxBytesLen) {
 			return nil, ErrBytesLimit
 		}
+		if err := CheckMemory(ctx, n.Value); err != nil {
+			return nil, err
+		}
 		return &Bytes{Value: make([]byte, int(n.Value))}, nil
 	}
 	v, ok := ToByteSlice(args[0])
@@ -1,7 +1,6 @@
 package nanojs
 
 import (
-	"encoding/gob"
 	"fmt"
 	"io"
 	"reflect"
@@ -16,16 +15,10 @@ type Bytecode struct {
 	Constants    []Object
 }
 
-// Encode writes Bytecode data to the writer.
+// Encode writes Bytecode data to the writer in the binary format described
+// in docs/bytecode-format.md.
 func (b *Bytecode) Encode(w io.Writer) error {
-	enc := gob.NewEncoder(w)
-	if err := enc.Encode(b.FileSet); err != nil {
-		return err
-	}
-	if err := enc.Encode(b.MainFunction); err != nil {
-		return err
-	}
-	return enc.Encode(b.Constants)
+	return encodeBytecode(w, b)
 }
 
 // CountObjects returns the number of objects found in Constants.
@@ -62,32 +55,23 @@ func (b *Bytecode) FormatConstants() (output []string) {
 	return
 }
 
-// Decode reads Bytecode data from the reader.
+// Decode reads Bytecode data from the reader. The builtin modules imported
+// by the bytecode are taken from the module map. It returns an error wrapping
+// ErrBytecodeVersion if the data was encoded for a different format or opcode
+// set version, and ErrInvalidBytecode if the data is malformed or the decoded
+// bytecode does not pass Verify.
 func (b *Bytecode) Decode(r io.Reader, modules *ModuleMap) error {
 	if modules == nil {
 		modules = NewModuleMap()
 	}
-
-	dec := gob.NewDecoder(r)
-	if err := dec.Decode(&b.FileSet); err != nil {
-		return err
-	}
-	// TODO: files in b.FileSet.File does not have their 'set' field properly
-	//  set to b.FileSet as it's private field and not serialized by gob
-	//  encoder/decoder.
-	if err := dec.Decode(&b.MainFunction); err != nil {
+	decoded, err := decodeBytecode(r, modules)
+	if err != nil {
 		return err
 	}
-	if err := dec.Decode(&b.Constants); err != nil {
+	if err := decoded.Verify(); err != nil {
 		return err
 	}
-	for i, v := range b.Constants {
-		fv, err := fixDecodedObject(v, modules)
-		if err != nil {
-			return err
-		}
-		b.Constants[i] = fv
-	}
+	*b = *decoded
 	return nil
 }
 
@@ -183,64 +167,6 @@ func (b *Bytecode) RemoveDuplicates() {
 	}
 }
 
-func fixDecodedObject(
-	o Object,
-	modules *ModuleMap,
-) (Object, error) {
-	switch o := o.(type) {
-	case *Bool:
-		if o.IsFalsy() {
-			return FalseValue, nil
-		}
-		return TrueValue, nil
-	case *Undefined:
-		return UndefinedValue, nil
-	case *Array:
-		for i, v := range o.Value {
-			fv, err := fixDecodedObject(v, modules)
-			if err != nil {
-				return nil, err
-			}
-			o.Value[i] = fv
-		}
-	case *ImmutableArray:
-		for i, v := range o.Value {
-			fv, err := fixDecodedObject(v, modules)
-			if err != nil {
-				return nil, err
-			}
-			o.Value[i] = fv
-		}
-	case *Map:
-		for k, v := range o.Value {
-			fv, err := fixDecodedObject(v, modules)
-			if err != nil {
-				return nil, err
-			}
-			o.Value[k] = fv
-		}
-	case *ImmutableMap:
-		modName := inferModuleName(o)
-		if mod := modules.GetBuiltinModule(modName); mod != nil {
-			return mod.AsImmutableMap(modName), nil
-		}
-
-		for k, v := range o.Value {
-			// encoding of user function not supported
-			if _, isUserFunction := v.(*UserFunction); isUserFunction {
-				return nil, fmt.Errorf("user function not decodable")
-			}
-
-			fv, err := fixDecodedObject(v, modules)
-			if err != nil {
-				return nil, err
-			}
-			o.Value[k] = fv
-		}
-	}
-	return o, nil
-}
-
 func updateConstIndexes(insts []byte, indexMap map[int]int) {
 	i := 0
 	for i < len(insts) {
@@ -276,23 +202,3 @@ func inferModuleName(mod *ImmutableMap) string {
 	}
 	return ""
 }
-
-func init() {
-	gob.Register(&parser.SourceFileSet{})
-	gob.Register(&parser.SourceFile{})
-	gob.Register(&Array{})
-	gob.Register(&Bool{})
-	gob.Register(&Bytes{})
-	gob.Register(&Char{})
-	gob.Register(&CompiledFunction{})
-	gob.Register(&Error{})
-	gob.Register(&Float{})
-	gob.Register(&ImmutableArray{})
-	gob.Register(&ImmutableMap{})
-	gob.Register(&Int{})
-	gob.Register(&Map{})
-	gob.Register(&String{})
-	gob.Register(&Time{})
-	gob.Register(&Undefined{})
-	gob.Register(&UserFunction{})
-}
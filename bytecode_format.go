@@ -0,0 +1,592 @@
+package nanojs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+// BytecodeFormatVersion is the version of the binary format written by
+// Bytecode.Encode. Bytecode of the other versions is rejected by
+// Bytecode.Decode with ErrBytecodeVersion. See docs/bytecode-format.md.
+const BytecodeFormatVersion = 2
+
+// bytecodeMagic is the header of the encoded bytecode.
+const bytecodeMagic = "NJSB"
+
+// maxBytecodeDepth is the maximum nesting depth of the encoded objects.
+const maxBytecodeDepth = 256
+
+// object tags of the constant pool encoding
+const (
+	bytecodeUndefined byte = iota + 1
+	bytecodeFalse
+	bytecodeTrue
+	bytecodeInt
+	bytecodeFloat
+	bytecodeChar
+	bytecodeString
+	bytecodeBytes
+	bytecodeArray
+	bytecodeImmutableArray
+	bytecodeMap
+	bytecodeImmutableMap
+	bytecodeError
+	bytecodeTime
+	bytecodeCompiledFunction
+	bytecodeModule
+)
+
+// bytecodeEncoder writes the sections of the encoded bytecode.
+type bytecodeEncoder struct {
+	buf     bytes.Buffer
+	imports []string
+	modules map[string]int // import index by module name
+}
+
+func encodeBytecode(w io.Writer, b *Bytecode) error {
+	body := &bytecodeEncoder{modules: make(map[string]int)}
+	body.fileSet(b.FileSet)
+	body.uvarint(uint64(len(b.Constants)))
+	for i, c := range b.Constants {
+		if err := body.object(c, 0); err != nil {
+			return fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+	if b.MainFunction == nil {
+		return fmt.Errorf("missing main function")
+	}
+	if err := body.function(b.MainFunction); err != nil {
+		return fmt.Errorf("main function: %w", err)
+	}
+
+	header := &bytecodeEncoder{}
+	header.buf.WriteString(bytecodeMagic)
+	header.uvarint(BytecodeFormatVersion)
+	header.uvarint(parser.OpcodeVersion)
+	header.uvarint(uint64(len(body.imports)))
+	for _, name := range body.imports {
+		header.string(name)
+	}
+	if _, err := w.Write(header.buf.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(body.buf.Bytes())
+	return err
+}
+
+func (e *bytecodeEncoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (e *bytecodeEncoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *bytecodeEncoder) bytes(v []byte) {
+	e.uvarint(uint64(len(v)))
+	e.buf.Write(v)
+}
+
+func (e *bytecodeEncoder) string(v string) {
+	e.uvarint(uint64(len(v)))
+	e.buf.WriteString(v)
+}
+
+func (e *bytecodeEncoder) strings(v []string) {
+	e.uvarint(uint64(len(v)))
+	for _, s := range v {
+		e.string(s)
+	}
+}
+
+func (e *bytecodeEncoder) fileSet(fs *parser.SourceFileSet) {
+	if fs == nil {
+		fs = parser.NewFileSet()
+	}
+	e.uvarint(uint64(fs.Base))
+	e.uvarint(uint64(len(fs.Files)))
+	lastFile := 0
+	for i, f := range fs.Files {
+		e.string(f.Name)
+		e.uvarint(uint64(f.Base))
+		e.uvarint(uint64(f.Size))
+		e.uvarint(uint64(len(f.Lines)))
+		prev := 0
+		for _, offset := range f.Lines {
+			e.uvarint(uint64(offset - prev))
+			prev = offset
+		}
+		if f == fs.LastFile {
+			lastFile = i + 1
+		}
+	}
+	e.uvarint(uint64(lastFile))
+}
+
+func (e *bytecodeEncoder) object(o Object, depth int) error {
+	if depth > maxBytecodeDepth {
+		return fmt.Errorf("objects nested too deeply")
+	}
+	switch o := o.(type) {
+	case *Undefined:
+		e.buf.WriteByte(bytecodeUndefined)
+	case *Bool:
+		if o.IsFalsy() {
+			e.buf.WriteByte(bytecodeFalse)
+		} else {
+			e.buf.WriteByte(bytecodeTrue)
+		}
+	case *Int:
+		e.buf.WriteByte(bytecodeInt)
+		e.varint(o.Value)
+	case *Float:
+		e.buf.WriteByte(bytecodeFloat)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(o.Value))
+		e.buf.Write(b[:])
+	case *Char:
+		e.buf.WriteByte(bytecodeChar)
+		e.varint(int64(o.Value))
+	case *String:
+		e.buf.WriteByte(bytecodeString)
+		e.string(o.Value)
+	case *Bytes:
+		e.buf.WriteByte(bytecodeBytes)
+		e.bytes(o.Value)
+	case *Array:
+		e.buf.WriteByte(bytecodeArray)
+		return e.objects(o.Value, depth)
+	case *ImmutableArray:
+		e.buf.WriteByte(bytecodeImmutableArray)
+		return e.objects(o.Value, depth)
+	case *Map:
+		e.buf.WriteByte(bytecodeMap)
+		return e.objectMap(o.Value, depth)
+	case *ImmutableMap:
+		if name := inferModuleName(o); name != "" {
+			idx, ok := e.modules[name]
+			if !ok {
+				idx = len(e.imports)
+				e.modules[name] = idx
+				e.imports = append(e.imports, name)
+			}
+			e.buf.WriteByte(bytecodeModule)
+			e.uvarint(uint64(idx))
+			return nil
+		}
+		e.buf.WriteByte(bytecodeImmutableMap)
+		return e.objectMap(o.Value, depth)
+	case *Error:
+		e.buf.WriteByte(bytecodeError)
+		return e.object(o.Value, depth+1)
+	case *Time:
+		data, err := o.Value.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.buf.WriteByte(bytecodeTime)
+		e.bytes(data)
+	case *CompiledFunction:
+		e.buf.WriteByte(bytecodeCompiledFunction)
+		return e.function(o)
+	default:
+		return fmt.Errorf("cannot encode object of type %s", o.TypeName())
+	}
+	return nil
+}
+
+func (e *bytecodeEncoder) objects(v []Object, depth int) error {
+	e.uvarint(uint64(len(v)))
+	for _, elem := range v {
+		if err := e.object(elem, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *bytecodeEncoder) objectMap(v map[string]Object, depth int) error {
+	e.uvarint(uint64(len(v)))
+	for _, key := range sortedKeys(v) {
+		e.string(key)
+		if err := e.object(v[key], depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *bytecodeEncoder) function(fn *CompiledFunction) error {
+	if len(fn.Free) > 0 {
+		return fmt.Errorf("cannot encode closure with free variables")
+	}
+	e.string(fn.Name)
+	e.uvarint(uint64(fn.NumLocals))
+	e.uvarint(uint64(fn.NumParameters))
+	if fn.VarArgs {
+		e.buf.WriteByte(1)
+	} else {
+		e.buf.WriteByte(0)
+	}
+	e.bytes(fn.Instructions)
+
+	ips := make([]int, 0, len(fn.SourceMap))
+	for ip := range fn.SourceMap {
+		ips = append(ips, ip)
+	}
+	sort.Ints(ips)
+	e.uvarint(uint64(len(ips)))
+	prev := 0
+	for _, ip := range ips {
+		e.uvarint(uint64(ip - prev))
+		e.uvarint(uint64(fn.SourceMap[ip]))
+		prev = ip
+	}
+
+	e.uvarint(uint64(len(fn.Locals)))
+	for _, l := range fn.Locals {
+		e.string(l.Name)
+		e.uvarint(uint64(l.Index))
+		e.uvarint(uint64(l.Start))
+		e.uvarint(uint64(l.End))
+	}
+	e.strings(fn.FreeNames)
+	return nil
+}
+
+// bytecodeDecoder reads the encoded bytecode. The first error is kept and the
+// following reads return zero values.
+type bytecodeDecoder struct {
+	data    []byte
+	off     int
+	imports []Object
+	err     error
+	invalid error // the error wrapped by fail, ErrInvalidBytecode if nil
+}
+
+func decodeBytecode(r io.Reader, modules *ModuleMap) (*Bytecode, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(bytecodeMagic)) {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidBytecode)
+	}
+	d := &bytecodeDecoder{data: data, off: len(bytecodeMagic)}
+	if v := d.uvarint(); d.err == nil && v != BytecodeFormatVersion {
+		return nil, fmt.Errorf("%w: format version %d (supported: %d)",
+			ErrBytecodeVersion, v, BytecodeFormatVersion)
+	}
+	if v := d.uvarint(); d.err == nil && v != parser.OpcodeVersion {
+		return nil, fmt.Errorf("%w: opcode version %d (supported: %d)",
+			ErrBytecodeVersion, v, parser.OpcodeVersion)
+	}
+
+	numImports := d.length()
+	for i := 0; i < numImports && d.err == nil; i++ {
+		name := d.string()
+		if d.err != nil {
+			break
+		}
+		mod := modules.GetBuiltinModule(name)
+		if mod == nil {
+			return nil, fmt.Errorf("module '%s' not found", name)
+		}
+		d.imports = append(d.imports, mod.AsImmutableMap(name))
+	}
+
+	b := &Bytecode{FileSet: d.fileSet()}
+	numConstants := d.length()
+	for i := 0; i < numConstants && d.err == nil; i++ {
+		b.Constants = append(b.Constants, d.object(0))
+	}
+	b.MainFunction = d.function()
+	if d.err == nil && d.off != len(d.data) {
+		d.fail("unexpected data after main function")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return b, nil
+}
+
+func (d *bytecodeDecoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		invalid := d.invalid
+		if invalid == nil {
+			invalid = ErrInvalidBytecode
+		}
+		d.err = fmt.Errorf("%w: %s at offset %d", invalid,
+			fmt.Sprintf(format, args...), d.off)
+	}
+}
+
+func (d *bytecodeDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if d.off >= len(d.data) {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[d.off]
+	d.off++
+	return b
+}
+
+func (d *bytecodeDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.off:])
+	if n <= 0 {
+		d.fail("malformed varint")
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *bytecodeDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.off:])
+	if n <= 0 {
+		d.fail("malformed varint")
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+// int reads an unsigned integer that must fit in int32.
+func (d *bytecodeDecoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail("integer %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+// length reads the number of the following elements or bytes. Every element
+// takes at least one byte, so it cannot exceed the remaining data.
+func (d *bytecodeDecoder) length() int {
+	v := d.uvarint()
+	if v > uint64(len(d.data)-d.off) {
+		d.fail("length %d exceeds the remaining data", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (d *bytecodeDecoder) bytes() []byte {
+	n := d.length()
+	if d.err != nil {
+		return nil
+	}
+	v := make([]byte, n)
+	copy(v, d.data[d.off:])
+	d.off += n
+	return v
+}
+
+func (d *bytecodeDecoder) string() string {
+	n := d.length()
+	if d.err != nil {
+		return ""
+	}
+	v := string(d.data[d.off : d.off+n])
+	d.off += n
+	return v
+}
+
+func (d *bytecodeDecoder) strings() []string {
+	n := d.length()
+	var v []string
+	for i := 0; i < n && d.err == nil; i++ {
+		v = append(v, d.string())
+	}
+	return v
+}
+
+func (d *bytecodeDecoder) fileSet() *parser.SourceFileSet {
+	fs := parser.NewFileSet()
+	base := d.int()
+	numFiles := d.length()
+	for i := 0; i < numFiles && d.err == nil; i++ {
+		name := d.string()
+		fileBase := d.int()
+		size := d.int()
+		numLines := d.length()
+		if d.err == nil && (fileBase < fs.Base || fileBase+size >= base) {
+			d.fail("invalid file '%s'", name)
+		}
+		if d.err != nil {
+			return nil
+		}
+		f := fs.AddFile(name, fileBase, size)
+		f.Lines = make([]int, 0, numLines)
+		offset := 0
+		for j := 0; j < numLines && d.err == nil; j++ {
+			offset += d.int()
+			f.Lines = append(f.Lines, offset)
+		}
+		if d.err == nil && offset > size {
+			d.fail("invalid lines of file '%s'", name)
+		}
+	}
+	if d.err == nil && base < fs.Base {
+		d.fail("invalid file set base %d", base)
+	}
+	fs.Base = base
+	fs.LastFile = nil
+	if lastFile := d.int(); lastFile > 0 {
+		if lastFile > len(fs.Files) {
+			d.fail("invalid last file %d", lastFile)
+			return nil
+		}
+		fs.LastFile = fs.Files[lastFile-1]
+	}
+	return fs
+}
+
+func (d *bytecodeDecoder) object(depth int) Object {
+	if depth > maxBytecodeDepth {
+		d.fail("objects nested too deeply")
+		return nil
+	}
+	switch tag := d.byte(); tag {
+	case bytecodeUndefined:
+		return UndefinedValue
+	case bytecodeFalse:
+		return FalseValue
+	case bytecodeTrue:
+		return TrueValue
+	case bytecodeInt:
+		return &Int{Value: d.varint()}
+	case bytecodeFloat:
+		if d.err == nil && len(d.data)-d.off < 8 {
+			d.fail("unexpected end of data")
+		}
+		if d.err != nil {
+			return nil
+		}
+		bits := binary.LittleEndian.Uint64(d.data[d.off:])
+		d.off += 8
+		return &Float{Value: math.Float64frombits(bits)}
+	case bytecodeChar:
+		v := d.varint()
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			d.fail("char %d out of range", v)
+		}
+		return &Char{Value: rune(v)}
+	case bytecodeString:
+		return &String{Value: d.string()}
+	case bytecodeBytes:
+		return &Bytes{Value: d.bytes()}
+	case bytecodeArray:
+		return &Array{Value: d.objects(depth)}
+	case bytecodeImmutableArray:
+		return &ImmutableArray{Value: d.objects(depth)}
+	case bytecodeMap:
+		return &Map{Value: d.objectMap(depth)}
+	case bytecodeImmutableMap:
+		return &ImmutableMap{Value: d.objectMap(depth)}
+	case bytecodeError:
+		return &Error{Value: d.object(depth + 1)}
+	case bytecodeTime:
+		data := d.bytes()
+		t := &Time{}
+		if d.err == nil {
+			if err := t.Value.UnmarshalBinary(data); err != nil {
+				d.fail("invalid time: %s", err)
+			}
+		}
+		return t
+	case bytecodeCompiledFunction:
+		return d.function()
+	case bytecodeModule:
+		idx := d.int()
+		if d.err == nil && idx >= len(d.imports) {
+			d.fail("invalid module import %d", idx)
+		}
+		if d.err != nil {
+			return nil
+		}
+		return d.imports[idx]
+	default:
+		d.fail("unknown object tag %d", tag)
+		return nil
+	}
+}
+
+func (d *bytecodeDecoder) objects(depth int) []Object {
+	n := d.length()
+	v := make([]Object, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		v = append(v, d.object(depth+1))
+	}
+	return v
+}
+
+func (d *bytecodeDecoder) objectMap(depth int) map[string]Object {
+	n := d.length()
+	v := make(map[string]Object, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		v[key] = d.object(depth + 1)
+	}
+	return v
+}
+
+func (d *bytecodeDecoder) function() *CompiledFunction {
+	fn := &CompiledFunction{
+		Name:          d.string(),
+		NumLocals:     d.int(),
+		NumParameters: d.int(),
+	}
+	switch flags := d.byte(); flags {
+	case 0:
+	case 1:
+		fn.VarArgs = true
+	default:
+		d.fail("invalid function flags %d", flags)
+	}
+	fn.Instructions = d.bytes()
+
+	numSourceMap := d.length()
+	if numSourceMap > 0 {
+		fn.SourceMap = make(map[int]parser.Pos, numSourceMap)
+	}
+	ip := 0
+	for i := 0; i < numSourceMap && d.err == nil; i++ {
+		ip += d.int()
+		fn.SourceMap[ip] = parser.Pos(d.int())
+	}
+
+	numLocals := d.length()
+	for i := 0; i < numLocals && d.err == nil; i++ {
+		fn.Locals = append(fn.Locals, LocalVar{
+			Name:  d.string(),
+			Index: d.int(),
+			Start: d.int(),
+			End:   d.int(),
+		})
+	}
+	fn.FreeNames = d.strings()
+	if d.err != nil {
+		return nil
+	}
+	return fn
+}
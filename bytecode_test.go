@@ -2,31 +2,43 @@ package nanojs_test
 
 import (
 	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/zeaphoo/nanojs/v2"
 	"github.com/zeaphoo/nanojs/v2/parser"
 	"github.com/zeaphoo/nanojs/v2/require"
+	"github.com/zeaphoo/nanojs/v2/stdlib"
 )
 
+var updateBytecode = flag.Bool("update-bytecode", false,
+	"regenerate the compiled files of the bytecode compatibility corpus")
+
 type srcfile struct {
 	name string
 	size int
 }
 
 func TestBytecode(t *testing.T) {
-	testBytecodeSerialization(t, bytecode(concatInsts(), objectsArray()))
+	testBytecodeSerialization(t, bytecode(
+		concatInsts(nanojs.MakeInstruction(parser.OpSuspend)),
+		objectsArray()))
 
 	testBytecodeSerialization(t, bytecode(
-		concatInsts(), objectsArray(
+		concatInsts(nanojs.MakeInstruction(parser.OpSuspend)), objectsArray(
 			&nanojs.Char{Value: 'y'},
 			&nanojs.Float{Value: 93.11},
-			compiledFunction(1, 0,
+			freeNames(compiledFunction(1, 0,
 				nanojs.MakeInstruction(parser.OpConstant, 3),
 				nanojs.MakeInstruction(parser.OpSetLocal, 0),
 				nanojs.MakeInstruction(parser.OpGetGlobal, 0),
-				nanojs.MakeInstruction(parser.OpGetFree, 0)),
+				nanojs.MakeInstruction(parser.OpGetFree, 0),
+				nanojs.MakeInstruction(parser.OpReturn, 1)), "a"),
 			&nanojs.Float{Value: 39.2},
 			&nanojs.Int{Value: 192},
 			&nanojs.String{Value: "bar"})))
@@ -35,8 +47,9 @@ func TestBytecode(t *testing.T) {
 		concatInsts(
 			nanojs.MakeInstruction(parser.OpConstant, 0),
 			nanojs.MakeInstruction(parser.OpSetGlobal, 0),
-			nanojs.MakeInstruction(parser.OpConstant, 6),
-			nanojs.MakeInstruction(parser.OpPop)),
+			nanojs.MakeInstruction(parser.OpConstant, 7),
+			nanojs.MakeInstruction(parser.OpPop),
+			nanojs.MakeInstruction(parser.OpSuspend)),
 		objectsArray(
 			&nanojs.Int{Value: 55},
 			&nanojs.Int{Value: 66},
@@ -98,7 +111,7 @@ func TestBytecode(t *testing.T) {
 					"undefined": nanojs.UndefinedValue,
 				},
 			},
-			compiledFunction(1, 0,
+			freeNames(compiledFunction(1, 0,
 				nanojs.MakeInstruction(parser.OpConstant, 3),
 				nanojs.MakeInstruction(parser.OpSetLocal, 0),
 				nanojs.MakeInstruction(parser.OpGetGlobal, 0),
@@ -108,24 +121,267 @@ func TestBytecode(t *testing.T) {
 				nanojs.MakeInstruction(parser.OpBinaryOp, 11),
 				nanojs.MakeInstruction(parser.OpGetLocal, 0),
 				nanojs.MakeInstruction(parser.OpBinaryOp, 11),
-				nanojs.MakeInstruction(parser.OpReturn, 1)),
-			compiledFunction(1, 0,
+				nanojs.MakeInstruction(parser.OpReturn, 1)), "a", "b"),
+			freeNames(compiledFunction(1, 0,
 				nanojs.MakeInstruction(parser.OpConstant, 2),
 				nanojs.MakeInstruction(parser.OpSetLocal, 0),
 				nanojs.MakeInstruction(parser.OpGetFree, 0),
 				nanojs.MakeInstruction(parser.OpGetLocal, 0),
-				nanojs.MakeInstruction(parser.OpClosure, 4, 2),
-				nanojs.MakeInstruction(parser.OpReturn, 1)),
+				nanojs.MakeInstruction(parser.OpClosure, 5, 2),
+				nanojs.MakeInstruction(parser.OpReturn, 1)), "a"),
 			compiledFunction(1, 0,
 				nanojs.MakeInstruction(parser.OpConstant, 1),
 				nanojs.MakeInstruction(parser.OpSetLocal, 0),
 				nanojs.MakeInstruction(parser.OpGetLocal, 0),
-				nanojs.MakeInstruction(parser.OpClosure, 5, 1),
+				nanojs.MakeInstruction(parser.OpClosure, 6, 1),
 				nanojs.MakeInstruction(parser.OpReturn, 1))),
 		fileSet(srcfile{name: "file1", size: 100},
 			srcfile{name: "file2", size: 200})))
 }
 
+func TestBytecode_Errors(t *testing.T) {
+	var buf bytes.Buffer
+	b := bytecode(concatInsts(nanojs.MakeInstruction(parser.OpSuspend)),
+		objectsArray(&nanojs.Int{Value: 1}))
+	require.NoError(t, b.Encode(&buf))
+	data := buf.Bytes()
+
+	decode := func(data []byte, modules *nanojs.ModuleMap) error {
+		return (&nanojs.Bytecode{}).Decode(bytes.NewReader(data), modules)
+	}
+	patch := func(offset int, v byte) []byte {
+		patched := append([]byte{}, data...)
+		patched[offset] = v
+		return patched
+	}
+
+	// header
+	err := decode([]byte("gob data"), nil)
+	require.True(t, errors.Is(err, nanojs.ErrInvalidBytecode), err)
+	err = decode(patch(4, 1), nil)
+	require.True(t, errors.Is(err, nanojs.ErrBytecodeVersion), err)
+	require.Equal(t,
+		"unsupported bytecode version: format version 1 (supported: 2)",
+		err.Error())
+	err = decode(patch(5, 99), nil)
+	require.True(t, errors.Is(err, nanojs.ErrBytecodeVersion), err)
+	require.Equal(t,
+		"unsupported bytecode version: opcode version 99 (supported: 1)",
+		err.Error())
+
+	// malformed data
+	for i := 6; i < len(data); i++ {
+		err = decode(data[:i], nil)
+		require.True(t, errors.Is(err, nanojs.ErrInvalidBytecode), i, err)
+	}
+	err = decode(append(append([]byte{}, data...), 0), nil)
+	require.True(t, errors.Is(err, nanojs.ErrInvalidBytecode), err)
+
+	// unverified instructions
+	b = bytecode([]byte{parser.OpReturn, 0}, nil)
+	buf.Reset()
+	require.NoError(t, b.Encode(&buf))
+	err = decode(buf.Bytes(), nil)
+	require.True(t, errors.Is(err, nanojs.ErrInvalidBytecode), err)
+
+	// module imports
+	b = bytecode(concatInsts(nanojs.MakeInstruction(parser.OpSuspend)),
+		objectsArray(stdlib.GetModuleMap("math").GetBuiltinModule("math").
+			AsImmutableMap("math")))
+	buf.Reset()
+	require.NoError(t, b.Encode(&buf))
+	err = decode(buf.Bytes(), nil)
+	require.Error(t, err)
+	require.Equal(t, "module 'math' not found", err.Error())
+	require.NoError(t, decode(buf.Bytes(), stdlib.GetModuleMap("math")))
+
+	// unsupported objects
+	b = bytecode(concatInsts(), objectsArray(&nanojs.UserFunction{}))
+	err = b.Encode(&buf)
+	require.Error(t, err)
+	require.Equal(t,
+		"constant 0: cannot encode object of type user-function:",
+		err.Error())
+}
+
+func TestBytecode_Verify(t *testing.T) {
+	suspend := nanojs.MakeInstruction(parser.OpSuspend)
+	expectVerify := func(
+		insts []byte,
+		constants []nanojs.Object,
+		expected string,
+	) {
+		t.Helper()
+		err := bytecode(insts, constants).Verify()
+		if expected == "" {
+			require.NoError(t, err)
+			return
+		}
+		require.True(t, errors.Is(err, nanojs.ErrInvalidBytecode), err)
+		require.Equal(t, "invalid bytecode: "+expected, err.Error())
+	}
+
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpConstant, 0),
+		nanojs.MakeInstruction(parser.OpJumpFalsy, 10),
+		nanojs.MakeInstruction(parser.OpConstant, 1),
+		nanojs.MakeInstruction(parser.OpPop),
+		suspend), objectsArray(
+		nanojs.TrueValue,
+		compiledFunction(1, 1,
+			nanojs.MakeInstruction(parser.OpGetLocal, 0),
+			nanojs.MakeInstruction(parser.OpReturn, 1))), "")
+
+	// instructions
+	expectVerify(nil, nil, "main function: no instructions")
+	expectVerify([]byte{255}, nil, "main function: 0000: unknown opcode 255")
+	expectVerify(concatInsts(suspend,
+		nanojs.MakeInstruction(parser.OpConstant, 0)[:2]), nil,
+		"main function: 0001: CONST: truncated operands")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpTrue),
+		nanojs.MakeInstruction(parser.OpPop)), nil,
+		"main function: 0001: execution runs past the end of the function")
+	expectVerify(nanojs.MakeInstruction(parser.OpReturn, 0), nil,
+		"main function: 0000: RET in the main function")
+
+	// jumps and stack heights
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpJump, 2),
+		suspend), nil,
+		"main function: 0000: jump target 2 is not an instruction")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpJump, 100),
+		suspend), nil,
+		"main function: 0000: jump target 100 is not an instruction")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpPop),
+		suspend), nil, "main function: 0000: stack underflow")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpTrue),
+		nanojs.MakeInstruction(parser.OpAndJump, 6),
+		nanojs.MakeInstruction(parser.OpTrue),
+		nanojs.MakeInstruction(parser.OpTrue),
+		suspend), nil,
+		"main function: 0006: inconsistent stack height 1 and 2")
+
+	// indexes
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpConstant, 1),
+		suspend), objectsArray(nanojs.TrueValue),
+		"main function: 0000: constant index 1 out of range")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpGetLocal, 0),
+		suspend), nil, "main function: 0000: local index 0 out of range")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpGetFree, 0),
+		suspend), nil, "main function: 0000: free index 0 out of range")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpGetGlobal, nanojs.GlobalsSize),
+		suspend), nil, "main function: 0000: global index 1024 out of range")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpGetBuiltin, 255),
+		suspend), nil, "main function: 0000: builtin index 255 out of range")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpClosure, 0, 0),
+		suspend), objectsArray(nanojs.TrueValue),
+		"main function: 0000: constant 0 is not a function")
+	expectVerify(concatInsts(
+		nanojs.MakeInstruction(parser.OpConstant, 0),
+		suspend), objectsArray(freeNames(compiledFunction(0, 0,
+		nanojs.MakeInstruction(parser.OpGetFree, 0),
+		nanojs.MakeInstruction(parser.OpReturn, 1)), "a")),
+		"main function: 0000: constant 0 is a closure")
+	main := bytecode(concatInsts(
+		nanojs.MakeInstruction(parser.OpGetFree, 0),
+		nanojs.MakeInstruction(parser.OpPop),
+		suspend), nil)
+	main.MainFunction.FreeNames = []string{"a"}
+	err := main.Verify()
+	require.True(t, errors.Is(err, nanojs.ErrInvalidBytecode), err)
+	require.Equal(t, "invalid bytecode: main function: invalid number of "+
+		"free variables", err.Error())
+	expectVerify(concatInsts(suspend), objectsArray(
+		&nanojs.Array{Value: []nanojs.Object{freeNames(compiledFunction(0, 0,
+			nanojs.MakeInstruction(parser.OpGetFree, 0),
+			nanojs.MakeInstruction(parser.OpReturn, 1)), "a")}}),
+		"constant 0: closure nested in an object")
+
+	// functions
+	expectVerify(concatInsts(suspend), objectsArray(
+		&nanojs.Array{Value: []nanojs.Object{compiledFunction(0, 0,
+			nanojs.MakeInstruction(parser.OpTrue),
+			nanojs.MakeInstruction(parser.OpSuspend))}}),
+		"constant 0: 0001: SUSPEND outside the main function")
+	expectVerify(concatInsts(suspend), objectsArray(
+		&nanojs.CompiledFunction{
+			Name:         "f",
+			Instructions: nanojs.MakeInstruction(parser.OpTrue),
+		}),
+		"constant 0 (function 'f'): 0000: execution runs past the end "+
+			"of the function")
+	expectVerify(concatInsts(suspend), objectsArray(compiledFunction(0, 1,
+		nanojs.MakeInstruction(parser.OpReturn, 0))),
+		"constant 0: invalid number of locals 0 or parameters 1")
+}
+
+// TestBytecode_Compatibility runs the compiled files of the corpus to make
+// sure that the bytecode written by the previous releases is still decoded
+// and executed. The files must be regenerated with -update-bytecode only
+// when BytecodeFormatVersion or parser.OpcodeVersion is incremented.
+func TestBytecode_Compatibility(t *testing.T) {
+	expected := map[string]string{
+		"values.js": `[1, -2, 3.5, x, "str", true, false, <undefined>] v ` +
+			`[1, 2] oops`,
+		"functions.js": "2 10",
+		"modules.js":   "NANOJS 7",
+		"runtime_error.js": "Runtime Error: not indexable: string\n" +
+			"\tat f (runtime_error.js:4:11)\n" +
+			"\tat runtime_error.js:6:1",
+	}
+
+	files, err := filepath.Glob("testdata/bytecode/*.js")
+	require.NoError(t, err)
+	require.Equal(t, len(expected), len(files))
+	modules := stdlib.GetModuleMap(stdlib.AllModuleNames()...)
+	for _, file := range files {
+		name := filepath.Base(file)
+		compiledFile := strings.TrimSuffix(file, ".js") + ".njsb"
+		if *updateBytecode {
+			src, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+			fileSet := parser.NewFileSet()
+			srcFile := fileSet.AddFile(name, -1, len(src))
+			f, err := parser.NewParser(srcFile, src, nil).ParseFile()
+			require.NoError(t, err)
+			symbols := nanojs.NewSymbolTable()
+			symbols.Define(testOut)
+			c := nanojs.NewCompiler(srcFile, symbols, nil, modules, nil)
+			require.NoError(t, c.Compile(f))
+			b := c.Bytecode()
+			b.RemoveDuplicates()
+			var buf bytes.Buffer
+			require.NoError(t, b.Encode(&buf))
+			require.NoError(t,
+				ioutil.WriteFile(compiledFile, buf.Bytes(), 0644))
+		}
+
+		data, err := ioutil.ReadFile(compiledFile)
+		require.NoError(t, err, name)
+		b := &nanojs.Bytecode{}
+		require.NoError(t, b.Decode(bytes.NewReader(data), modules), name)
+		globals := make([]nanojs.Object, nanojs.GlobalsSize)
+		err = nanojs.NewVM(b, globals, -1).Run()
+		if err != nil {
+			require.Equal(t, expected[name], err.Error(), name)
+			continue
+		}
+		out, ok := globals[0].(*nanojs.String)
+		require.True(t, ok, name)
+		require.Equal(t, expected[name], out.Value, name)
+	}
+}
+
 func TestBytecode_RemoveDuplicates(t *testing.T) {
 	testBytecodeRemoveDuplicates(t,
 		bytecode(
@@ -252,6 +508,14 @@ func TestBytecode_CountObjects(t *testing.T) {
 	require.Equal(t, 7, b.CountObjects())
 }
 
+func freeNames(
+	fn *nanojs.CompiledFunction,
+	names ...string,
+) *nanojs.CompiledFunction {
+	fn.FreeNames = names
+	return fn
+}
+
 func fileSet(files ...srcfile) *parser.SourceFileSet {
 	fileSet := parser.NewFileSet()
 	for _, f := range files {
@@ -0,0 +1,376 @@
+package nanojs
+
+import (
+	"fmt"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+// Verify checks that the instructions of the main function and all the
+// compiled functions in the constants are well-formed, so that the bytecode
+// from an untrusted source cannot crash the VM. It checks the opcodes and
+// their operands, the jump targets, the stack heights, the indexes of the
+// constants, globals, locals, free variables and builtin functions, that only
+// the closures created by OpClosure have free variables, and that no
+// function can run past its last instruction. The maximum stack height of
+// each function is recorded for the VM to check before calling it. It
+// returns an error wrapping ErrInvalidBytecode. Bytecode.Decode verifies the
+// bytecode automatically.
+func (b *Bytecode) Verify() error {
+	if b.MainFunction == nil {
+		return fmt.Errorf("%w: missing main function", ErrInvalidBytecode)
+	}
+	v := &bytecodeVerifier{
+		constants: b.Constants,
+		verified:  make(map[*CompiledFunction]bool),
+	}
+	main := b.MainFunction
+	if err := v.function(main, "main function", true); err != nil {
+		return err
+	}
+	if len(main.FreeNames) != len(main.Free) {
+		return fmt.Errorf("%w: main function: invalid number of free "+
+			"variables", ErrInvalidBytecode)
+	}
+	for i, c := range b.Constants {
+		if err := v.object(c, fmt.Sprintf("constant %d", i), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bytecodeVerifier verifies the compiled functions of a bytecode.
+type bytecodeVerifier struct {
+	constants []Object
+	verified  map[*CompiledFunction]bool
+}
+
+// object verifies the compiled functions in the constant, including those
+// nested in arrays, maps and errors as they can be called after indexing.
+func (v *bytecodeVerifier) object(o Object, where string, depth int) error {
+	if depth > maxBytecodeDepth {
+		return fmt.Errorf("%w: %s: objects nested too deeply",
+			ErrInvalidBytecode, where)
+	}
+	switch o := o.(type) {
+	case *CompiledFunction:
+		// only the functions in the constants can be closures, which are
+		// created by OpClosure with the free variables
+		if depth > 0 && len(o.FreeNames) != len(o.Free) {
+			return fmt.Errorf("%w: %s: closure nested in an object",
+				ErrInvalidBytecode, where)
+		}
+		return v.function(o, where, false)
+	case *Array:
+		return v.objects(o.Value, where, depth)
+	case *ImmutableArray:
+		return v.objects(o.Value, where, depth)
+	case *Map:
+		return v.objectMap(o.Value, where, depth)
+	case *ImmutableMap:
+		return v.objectMap(o.Value, where, depth)
+	case *Error:
+		return v.object(o.Value, where, depth+1)
+	case nil:
+		return fmt.Errorf("%w: %s: nil object", ErrInvalidBytecode, where)
+	}
+	return nil
+}
+
+func (v *bytecodeVerifier) objects(
+	values []Object,
+	where string,
+	depth int,
+) error {
+	for _, elem := range values {
+		if err := v.object(elem, where, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *bytecodeVerifier) objectMap(
+	values map[string]Object,
+	where string,
+	depth int,
+) error {
+	for _, elem := range values {
+		if err := v.object(elem, where, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// function verifies the instructions of the compiled function. The main
+// function is the only one allowed to end with OpSuspend.
+func (v *bytecodeVerifier) function(
+	fn *CompiledFunction,
+	where string,
+	main bool,
+) error {
+	if v.verified[fn] {
+		return nil
+	}
+	v.verified[fn] = true
+	if fn.Name != "" {
+		where = fmt.Sprintf("%s (function '%s')", where, fn.Name)
+	}
+	errorf := func(ip int, format string, args ...interface{}) error {
+		if ip < 0 {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidBytecode, where,
+				fmt.Sprintf(format, args...))
+		}
+		return fmt.Errorf("%w: %s: %04d: %s", ErrInvalidBytecode, where, ip,
+			fmt.Sprintf(format, args...))
+	}
+
+	if fn.NumLocals < 0 || fn.NumLocals > StackSize ||
+		fn.NumParameters < 0 || fn.NumParameters > fn.NumLocals {
+		return errorf(-1, "invalid number of locals %d or parameters %d",
+			fn.NumLocals, fn.NumParameters)
+	}
+	if fn.VarArgs && fn.NumParameters == 0 {
+		return errorf(-1, "variadic function without parameters")
+	}
+	if len(fn.Free) > len(fn.FreeNames) {
+		return errorf(-1, "invalid number of free variables")
+	}
+	for _, l := range fn.Locals {
+		if l.Index < 0 || l.Index >= fn.NumLocals || l.Start < 0 ||
+			l.Start > l.End || l.End > len(fn.Instructions) {
+			return errorf(-1, "invalid local variable '%s'", l.Name)
+		}
+	}
+
+	// decode the instructions and mark the instruction boundaries
+	insts := fn.Instructions
+	operands := make(map[int][]int)
+	for ip := 0; ip < len(insts); {
+		op := insts[ip]
+		if int(op) >= len(parser.OpcodeOperands) ||
+			parser.OpcodeNames[op] == "" {
+			return errorf(ip, "unknown opcode %d", op)
+		}
+		width := 0
+		for _, w := range parser.OpcodeOperands[op] {
+			width += w
+		}
+		if ip+1+width > len(insts) {
+			return errorf(ip, "%s: truncated operands",
+				parser.OpcodeNames[op])
+		}
+		operands[ip], _ = parser.ReadOperands(parser.OpcodeOperands[op],
+			insts[ip+1:])
+		ip += 1 + width
+	}
+	if len(insts) == 0 {
+		return errorf(-1, "no instructions")
+	}
+
+	// follow all the paths of the control flow, checking that the stack
+	// height at each instruction is the same regardless of the path
+	heights := make(map[int]int, len(operands))
+	type state struct{ ip, height int }
+	work := []state{{0, 0}}
+	maxHeight := 0
+	enter := func(from, ip, height int, jump bool) error {
+		if _, ok := operands[ip]; !ok {
+			if jump {
+				return errorf(from, "jump target %d is not an instruction",
+					ip)
+			}
+			return errorf(from, "execution runs past the end of "+
+				"the function")
+		}
+		if h, ok := heights[ip]; ok {
+			if h != height {
+				return errorf(ip, "inconsistent stack height %d and %d",
+					h, height)
+			}
+			return nil
+		}
+		heights[ip] = height
+		if height > maxHeight {
+			maxHeight = height
+			if fn.NumLocals+maxHeight > StackSize {
+				return errorf(ip, "stack height exceeds the limit")
+			}
+		}
+		work = append(work, state{ip, height})
+		return nil
+	}
+	heights[0] = 0
+	for len(work) > 0 {
+		s := work[len(work)-1]
+		work = work[:len(work)-1]
+		ip, height := s.ip, s.height
+		op := insts[ip]
+		ops := operands[ip]
+		next := ip + 1
+		for _, w := range parser.OpcodeOperands[op] {
+			next += w
+		}
+
+		// pops and pushes of the instruction
+		pop, push := 0, 0
+		switch op {
+		case parser.OpConstant:
+			if ops[0] >= len(v.constants) {
+				return errorf(ip, "constant index %d out of range", ops[0])
+			}
+			if f, ok := v.constants[ops[0]].(*CompiledFunction); ok &&
+				len(f.FreeNames) > len(f.Free) {
+				return errorf(ip, "constant %d is a closure", ops[0])
+			}
+			push = 1
+		case parser.OpNull, parser.OpTrue, parser.OpFalse:
+			push = 1
+		case parser.OpGetGlobal:
+			if ops[0] >= GlobalsSize {
+				return errorf(ip, "global index %d out of range", ops[0])
+			}
+			push = 1
+		case parser.OpSetGlobal:
+			if ops[0] >= GlobalsSize {
+				return errorf(ip, "global index %d out of range", ops[0])
+			}
+			pop = 1
+		case parser.OpSetSelGlobal:
+			if ops[0] >= GlobalsSize {
+				return errorf(ip, "global index %d out of range", ops[0])
+			}
+			pop = ops[1] + 1
+		case parser.OpGetLocal, parser.OpGetLocalPtr:
+			if ops[0] >= fn.NumLocals {
+				return errorf(ip, "local index %d out of range", ops[0])
+			}
+			push = 1
+		case parser.OpSetLocal, parser.OpDefineLocal:
+			if ops[0] >= fn.NumLocals {
+				return errorf(ip, "local index %d out of range", ops[0])
+			}
+			pop = 1
+		case parser.OpSetSelLocal:
+			if ops[0] >= fn.NumLocals {
+				return errorf(ip, "local index %d out of range", ops[0])
+			}
+			pop = ops[1] + 1
+		case parser.OpGetFree, parser.OpGetFreePtr:
+			if ops[0] >= len(fn.FreeNames) {
+				return errorf(ip, "free index %d out of range", ops[0])
+			}
+			push = 1
+		case parser.OpSetFree:
+			if ops[0] >= len(fn.FreeNames) {
+				return errorf(ip, "free index %d out of range", ops[0])
+			}
+			pop = 1
+		case parser.OpSetSelFree:
+			if ops[0] >= len(fn.FreeNames) {
+				return errorf(ip, "free index %d out of range", ops[0])
+			}
+			pop = ops[1] + 1
+		case parser.OpGetBuiltin:
+			if ops[0] >= len(builtinFuncs) {
+				return errorf(ip, "builtin index %d out of range", ops[0])
+			}
+			push = 1
+		case parser.OpPop:
+			pop = 1
+		case parser.OpBinaryOp, parser.OpEqual, parser.OpNotEqual,
+			parser.OpIndex:
+			pop, push = 2, 1
+		case parser.OpSliceIndex:
+			pop, push = 3, 1
+		case parser.OpLNot, parser.OpBComplement, parser.OpMinus,
+			parser.OpError, parser.OpImmutable, parser.OpIteratorInit,
+			parser.OpIteratorNext, parser.OpIteratorKey,
+			parser.OpIteratorValue:
+			pop, push = 1, 1
+		case parser.OpArray:
+			pop, push = ops[0], 1
+		case parser.OpMap:
+			if ops[0]%2 != 0 {
+				return errorf(ip, "odd number of map elements %d", ops[0])
+			}
+			pop, push = ops[0], 1
+		case parser.OpCall:
+			if ops[1] > 1 || ops[1] == 1 && ops[0] == 0 {
+				return errorf(ip, "invalid spread operand %d", ops[1])
+			}
+			pop, push = ops[0]+1, 1
+		case parser.OpClosure:
+			if ops[0] >= len(v.constants) {
+				return errorf(ip, "constant index %d out of range", ops[0])
+			}
+			f, ok := v.constants[ops[0]].(*CompiledFunction)
+			if !ok {
+				return errorf(ip, "constant %d is not a function", ops[0])
+			}
+			if ops[1] != len(f.FreeNames) {
+				return errorf(ip, "closure with %d free variables, "+
+					"function expects %d", ops[1], len(f.FreeNames))
+			}
+			pop, push = ops[1], 1
+		case parser.OpJumpFalsy:
+			if height < 1 {
+				return errorf(ip, "stack underflow")
+			}
+			if err := enter(ip, ops[0], height-1, true); err != nil {
+				return err
+			}
+			pop = 1
+		case parser.OpAndJump, parser.OpOrJump:
+			if height < 1 {
+				return errorf(ip, "stack underflow")
+			}
+			// the value is kept on the stack if the jump is taken
+			if err := enter(ip, ops[0], height, true); err != nil {
+				return err
+			}
+			pop = 1
+		case parser.OpJump:
+			if err := enter(ip, ops[0], height, true); err != nil {
+				return err
+			}
+			continue
+		case parser.OpReturn:
+			if main {
+				return errorf(ip, "RET in the main function")
+			}
+			if ops[0] > 1 {
+				return errorf(ip, "invalid return operand %d", ops[0])
+			}
+			if height < ops[0] {
+				return errorf(ip, "stack underflow")
+			}
+			continue
+		case parser.OpSuspend:
+			if !main {
+				return errorf(ip, "SUSPEND outside the main function")
+			}
+			continue
+		}
+		if height < pop {
+			return errorf(ip, "stack underflow")
+		}
+		if err := enter(ip, next, height-pop+push, false); err != nil {
+			return err
+		}
+	}
+	fn.maxStack = maxHeight
+	return nil
+}
+
+// setMaxStack sets the maximum stack height of the function compiled from the
+// source, which the VM checks before calling the function.
+func setMaxStack(fn *CompiledFunction, constants []Object, main bool) {
+	v := &bytecodeVerifier{
+		constants: constants,
+		verified:  make(map[*CompiledFunction]bool),
+	}
+	_ = v.function(fn, "", main)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2"
+)
+
+const debugPrompt = "(debug) "
+
+func doDebug(modules *nanojs.ModuleMap, inputFile string) error {
+	if inputFile == "" {
+		return fmt.Errorf("missing input file")
+	}
+	inputData, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("Error reading input file: %s", err.Error())
+	}
+	inputFile, err = filepath.Abs(inputFile)
+	if err != nil {
+		return fmt.Errorf("Error file path: %s", err)
+	}
+	if len(inputData) > 1 && string(inputData[:2]) == "#!" {
+		copy(inputData, "//")
+	}
+	return Debug(modules, inputData, inputFile, os.Stdin, os.Stdout)
+}
+
+// Debug compiles the source code and executes it with an interactive
+// debugger. The execution stops at the first line.
+func Debug(
+	modules *nanojs.ModuleMap,
+	data []byte,
+	inputFile string,
+	in io.Reader,
+	out io.Writer,
+) error {
+	symbolTable := nanojs.NewSymbolTable()
+	bytecode, err := compileSrc(modules, data, inputFile, symbolTable)
+	if err != nil {
+		return err
+	}
+
+	globals := make(map[string]int)
+	for _, name := range symbolTable.Names() {
+		symbol, _, _ := symbolTable.Resolve(name)
+		if symbol.Scope == nanojs.ScopeGlobal {
+			globals[name] = symbol.Index
+		}
+	}
+
+	fileName := filepath.Base(inputFile)
+	srcLines := bytes.Split(data, []byte("\n"))
+	stdin := bufio.NewScanner(in)
+
+	machine := nanojs.NewVM(bytecode, nil, -1)
+	// the script prints to the output of the debugger
+	machine.SetStreams(&nanojs.Streams{Stdout: out})
+	debugger := nanojs.NewDebugger(machine, globals, func(d *nanojs.Debugger) {
+		pos := d.Position()
+		if pos.Filename == fileName && pos.Line <= len(srcLines) {
+			_, _ = fmt.Fprintf(out, "%s\t%s\n", pos, srcLines[pos.Line-1])
+		} else {
+			_, _ = fmt.Fprintln(out, pos)
+		}
+
+		for {
+			_, _ = fmt.Fprint(out, debugPrompt)
+			if !stdin.Scan() {
+				machine.Abort()
+				return
+			}
+			args := strings.Fields(stdin.Text())
+			if len(args) == 0 {
+				continue
+			}
+			switch args[0] {
+			case "c", "continue":
+				d.Continue()
+				return
+			case "s", "step":
+				d.StepIn()
+				return
+			case "n", "next":
+				d.StepOver()
+				return
+			case "o", "out":
+				d.StepOut()
+				return
+			case "q", "quit":
+				machine.Abort()
+				return
+			case "b", "break", "clear":
+				file, line, err := parseBreakpoint(args, fileName)
+				if err != nil {
+					_, _ = fmt.Fprintln(out, err.Error())
+					continue
+				}
+				if args[0] == "clear" {
+					d.ClearBreakpoint(file, line)
+				} else {
+					d.SetBreakpoint(file, line)
+				}
+			case "p", "print":
+				if len(args) != 2 {
+					_, _ = fmt.Fprintln(out, "usage: print {name}")
+					continue
+				}
+				if val, ok := d.Lookup(args[1]); ok {
+					_, _ = fmt.Fprintln(out, val.String())
+				} else {
+					_, _ = fmt.Fprintf(out, "'%s' is not defined\n", args[1])
+				}
+			case "locals":
+				printVars(out, d.Locals())
+			case "globals":
+				printVars(out, d.Globals())
+			case "bt", "backtrace":
+				for _, f := range d.Frames() {
+					_, _ = fmt.Fprintf(out, "\tat %s\n", f)
+				}
+			default:
+				printDebugHelp(out)
+			}
+		}
+	})
+	debugger.StepIn()
+	return machine.Run()
+}
+
+func parseBreakpoint(args []string, fileName string) (string, int, error) {
+	if len(args) != 2 {
+		return "", 0, fmt.Errorf("usage: %s [file:]{line}", args[0])
+	}
+	loc := args[1]
+	if idx := strings.LastIndexByte(loc, ':'); idx >= 0 {
+		fileName, loc = loc[:idx], loc[idx+1:]
+	}
+	line, err := strconv.Atoi(loc)
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid line: %s", loc)
+	}
+	return fileName, line, nil
+}
+
+func printVars(out io.Writer, vars map[string]nanojs.Object) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(out, "%s = %s\n", name, vars[name].String())
+	}
+}
+
+func printDebugHelp(out io.Writer) {
+	_, _ = fmt.Fprintln(out, "Commands:")
+	_, _ = fmt.Fprintln(out, "	b, break [file:]{line}  set a breakpoint")
+	_, _ = fmt.Fprintln(out, "	clear [file:]{line}     clear a breakpoint")
+	_, _ = fmt.Fprintln(out, "	c, continue             run until the next breakpoint")
+	_, _ = fmt.Fprintln(out, "	s, step                 step into the next line")
+	_, _ = fmt.Fprintln(out, "	n, next                 step over the next line")
+	_, _ = fmt.Fprintln(out, "	o, out                  step out of the current function")
+	_, _ = fmt.Fprintln(out, "	p, print {name}         print a variable")
+	_, _ = fmt.Fprintln(out, "	locals                  print local variables")
+	_, _ = fmt.Fprintln(out, "	globals                 print global variables")
+	_, _ = fmt.Fprintln(out, "	bt, backtrace           print the call frames")
+	_, _ = fmt.Fprintln(out, "	q, quit                 stop debugging")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+func doDisasm(modules *nanojs.ModuleMap, args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print JSON output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	inputFile := flags.Arg(0)
+	if inputFile == "" {
+		return fmt.Errorf("missing input file")
+	}
+	inputData, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("Error reading input file: %s", err.Error())
+	}
+	inputFile, err = filepath.Abs(inputFile)
+	if err != nil {
+		return fmt.Errorf("Error file path: %s", err)
+	}
+	if len(inputData) > 1 && string(inputData[:2]) == "#!" {
+		copy(inputData, "//")
+	}
+	return Disasm(modules, inputData, inputFile, *jsonOutput, os.Stdout)
+}
+
+// Disasm prints the disassembly of the source code (*.js) or the compiled
+// bytecode.
+func Disasm(
+	modules *nanojs.ModuleMap,
+	data []byte,
+	inputFile string,
+	jsonOutput bool,
+	out io.Writer,
+) error {
+	var bytecode *nanojs.Bytecode
+	globals := make(map[string]int)
+	sources := make(map[string][]byte)
+	if filepath.Ext(inputFile) == sourceFileExt {
+		symbolTable := nanojs.NewSymbolTable()
+		var err error
+		bytecode, err = compileSrc(modules, data, inputFile, symbolTable)
+		if err != nil {
+			return err
+		}
+		for idx, name := range symbolTable.GlobalNames() {
+			// the names shared by the globals of different blocks are
+			// left to the first index
+			if _, ok := globals[name]; name != "" && !ok {
+				globals[name] = idx
+			}
+		}
+		sources[filepath.Base(inputFile)] = data
+	} else {
+		bytecode = &nanojs.Bytecode{}
+		err := bytecode.Decode(bytes.NewReader(data), modules)
+		if err != nil {
+			return err
+		}
+	}
+	loadSources(bytecode.FileSet, modules, filepath.Dir(inputFile), sources)
+
+	disasm := nanojs.Disassemble(bytecode, sources, globals)
+	if jsonOutput {
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(disasm)
+	}
+	_, err := disasm.WriteTo(out)
+	return err
+}
+
+// loadSources reads the source code of the files in the file set that are
+// not loaded yet. The files are the source modules in the module map or the
+// files relative to the directory.
+func loadSources(
+	fileSet *parser.SourceFileSet,
+	modules *nanojs.ModuleMap,
+	dir string,
+	sources map[string][]byte,
+) {
+	if fileSet == nil {
+		return
+	}
+	for _, f := range fileSet.Files {
+		if _, ok := sources[f.Name]; ok {
+			continue
+		}
+		if mod := modules.GetSourceModule(f.Name); mod != nil {
+			sources[f.Name] = mod.Src
+			continue
+		}
+		path := f.Name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if src, err := ioutil.ReadFile(path); err == nil {
+			sources[f.Name] = src
+		}
+	}
+}
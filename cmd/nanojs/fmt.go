@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2/format"
+)
+
+// diffContext is the number of unchanged lines around the changes in the
+// diff.
+const diffContext = 3
+
+func doFmt(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false,
+		"Write result to the source file instead of stdout")
+	diff := flags.Bool("d", false, "Print diffs instead of the result")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("missing input file")
+	}
+
+	files, err := sourceFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, file := range files {
+		err := Fmt(file, *write, *diff, os.Stdout)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", file, err.Error())
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("formatting failed")
+	}
+	return nil
+}
+
+// sourceFiles returns the files, and the source files (*.js) in the
+// directories.
+func sourceFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.Walk(arg,
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() && filepath.Ext(path) == sourceFileExt {
+					files = append(files, path)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Fmt formats the source file. The formatted code is written to the file if
+// write is true and the code is changed, the diff between the source code
+// and the formatted code is printed if diff is true, and the formatted code
+// is printed otherwise.
+func Fmt(inputFile string, write, diff bool, out io.Writer) error {
+	src, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return err
+	}
+	res, err := format.Source(src)
+	if err != nil {
+		return err
+	}
+
+	if diff {
+		if !bytes.Equal(src, res) {
+			_, err = io.WriteString(out, unifiedDiff(inputFile, src, res))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if write {
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		info, err := os.Stat(inputFile)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(inputFile, res, info.Mode().Perm())
+	}
+	if !diff {
+		_, err = out.Write(res)
+	}
+	return err
+}
+
+// unifiedDiff returns the unified diff between the lines of the old and new
+// contents of the file.
+func unifiedDiff(name string, old, new []byte) string {
+	a := splitLines(old)
+	b := splitLines(new)
+
+	// longest common subsequence of the lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// edit script: ' ' keeps, '-' deletes and '+' inserts a line
+	type edit struct {
+		op   byte
+		line string
+		i, j int // line indexes in a and b before the edit
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", name, name)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// hunk from the changes and their surrounding unchanged lines
+		start := k - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*diffContext {
+				end += diffContext
+				if end > len(edits) {
+					end = len(edits)
+				}
+				break
+			}
+			end = next
+		}
+
+		numOld, numNew := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				numOld++
+			}
+			if e.op != '-' {
+				numNew++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(edits[start].i, numOld),
+			hunkRange(edits[start].j, numNew))
+		for _, e := range edits[start:end] {
+			buf.WriteByte(e.op)
+			buf.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = end
+	}
+	return buf.String()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// splitLines splits the data into the lines including their newlines.
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
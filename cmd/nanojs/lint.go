@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2/lint"
+)
+
+func doLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print JSON output")
+	enable := flags.String("enable", "",
+		"Comma-separated rules to enable instead of all the rules")
+	disable := flags.String("disable", "", "Comma-separated rules to disable")
+	globals := flags.String("globals", "",
+		"Comma-separated global variables defined by the host application")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("missing input file")
+	}
+
+	linter := lint.New()
+	if *enable != "" {
+		_ = linter.Disable(lint.Rules...)
+		if err := linter.Enable(splitList(*enable)...); err != nil {
+			return err
+		}
+	}
+	if err := linter.Disable(splitList(*disable)...); err != nil {
+		return err
+	}
+	linter.SetGlobals(splitList(*globals)...)
+
+	files, err := sourceFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	n, err := Lint(linter, files, *jsonOutput, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%d problems found", n)
+	}
+	return nil
+}
+
+// Lint checks the source files and prints the diagnostics as text or JSON.
+// It returns the number of the diagnostics.
+func Lint(
+	linter *lint.Linter,
+	files []string,
+	jsonOutput bool,
+	out io.Writer,
+) (int, error) {
+	diagnostics := []*lint.Diagnostic{}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return 0, err
+		}
+		res, err := linter.Source(file, src)
+		if err != nil {
+			return 0, err
+		}
+		diagnostics = append(diagnostics, res...)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return len(diagnostics), enc.Encode(diagnostics)
+	}
+	for _, d := range diagnostics {
+		if _, err := fmt.Fprintln(out, d.String()); err != nil {
+			return 0, err
+		}
+	}
+	return len(diagnostics), nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/lsp"
+)
+
+// doLsp runs the language server over the standard input and output.
+func doLsp(modules *nanojs.ModuleMap) error {
+	return lsp.NewServer(modules).Serve(os.Stdin, os.Stdout)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -35,6 +36,7 @@ func init() {
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.BoolVar(&resolvePath, "resolve", false,
 		"Resolve relative import paths")
+	registerSandboxFlags()
 	flag.Parse()
 }
 
@@ -47,8 +49,55 @@ func main() {
 		return
 	}
 
-	modules := stdlib.GetModuleMap(stdlib.AllModuleNames()...)
+	modules, err := sandboxModules(
+		stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 	inputFile := flag.Arg(0)
+	if inputFile == "run" {
+		if err := doRun(modules, flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, errorText(err))
+			os.Exit(1)
+		}
+		return
+	}
+	if inputFile == "debug" {
+		if err := doDebug(modules, flag.Arg(1)); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if inputFile == "disasm" {
+		if err := doDisasm(modules, flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if inputFile == "lsp" {
+		if err := doLsp(modules); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if inputFile == "lint" {
+		if err := doLint(flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	if inputFile == "fmt" {
+		if err := doFmt(flag.Args()[1:]); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 	if inputFile == "" {
 		// REPL
 		RunREPL(modules, os.Stdin, os.Stdout)
@@ -76,13 +125,13 @@ func main() {
 		err := CompileOnly(modules, inputData, inputFile,
 			compileOutput)
 		if err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			_, _ = fmt.Fprintln(os.Stderr, errorText(err))
 			os.Exit(1)
 		}
 	} else if filepath.Ext(inputFile) == sourceFileExt {
 		err := CompileAndRun(modules, inputData, inputFile)
 		if err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, err.Error())
+			_, _ = fmt.Fprintln(os.Stderr, errorText(err))
 			os.Exit(1)
 		}
 	} else {
@@ -100,7 +149,7 @@ func CompileOnly(
 	data []byte,
 	inputFile, outputFile string,
 ) (err error) {
-	bytecode, err := compileSrc(modules, data, inputFile)
+	bytecode, err := compileSrc(modules, data, inputFile, nil)
 	if err != nil {
 		return
 	}
@@ -109,7 +158,8 @@ func CompileOnly(
 		outputFile = basename(inputFile) + ".out"
 	}
 
-	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+		os.ModePerm)
 	if err != nil {
 		return
 	}
@@ -135,7 +185,7 @@ func CompileAndRun(
 	data []byte,
 	inputFile string,
 ) (err error) {
-	bytecode, err := compileSrc(modules, data, inputFile)
+	bytecode, err := compileSrc(modules, data, inputFile, nil)
 	if err != nil {
 		return
 	}
@@ -177,17 +227,22 @@ func RunREPL(modules *nanojs.ModuleMap, in io.Reader, out io.Writer) {
 			for _, arg := range args {
 				if _, isUndefined := arg.(*nanojs.Undefined); isUndefined {
 					printArgs = append(printArgs, "<undefined>")
+				} else if fn, ok := arg.(*nanojs.UserFunction); ok &&
+					fn.Signature != nil {
+					printArgs = append(printArgs, signatureHelp(fn.Signature))
 				} else {
 					s, _ := nanojs.ToString(arg)
 					printArgs = append(printArgs, s)
 				}
 			}
 			printArgs = append(printArgs, "\n")
-			_, _ = fmt.Print(printArgs...)
+			_, _ = fmt.Fprint(out, printArgs...)
 			return
 		},
 	}
 
+	// the script prints to the output of the REPL
+	streams := &nanojs.Streams{Stdout: out}
 	var constants []nanojs.Object
 	for {
 		_, _ = fmt.Fprint(out, replPrompt)
@@ -214,6 +269,7 @@ func RunREPL(modules *nanojs.ModuleMap, in io.Reader, out io.Writer) {
 
 		bytecode := c.Bytecode()
 		machine := nanojs.NewVM(bytecode, globals, -1)
+		machine.SetStreams(streams)
 		if err := machine.Run(); err != nil {
 			_, _ = fmt.Fprintln(out, err.Error())
 			continue
@@ -226,6 +282,7 @@ func compileSrc(
 	modules *nanojs.ModuleMap,
 	src []byte,
 	inputFile string,
+	symbolTable *nanojs.SymbolTable,
 ) (*nanojs.Bytecode, error) {
 	fileSet := parser.NewFileSet()
 	srcFile := fileSet.AddFile(filepath.Base(inputFile), -1, len(src))
@@ -236,7 +293,7 @@ func compileSrc(
 		return nil, err
 	}
 
-	c := nanojs.NewCompiler(srcFile, nil, nil, modules, nil)
+	c := nanojs.NewCompiler(srcFile, symbolTable, nil, modules, nil)
 	c.EnableFileImport(true)
 	if resolvePath {
 		c.SetImportDir(filepath.Dir(inputFile))
@@ -251,15 +308,49 @@ func compileSrc(
 	return bytecode, nil
 }
 
+// errorText returns the text of the error, which lists all the errors if
+// it's a list of the parse or compile errors.
+func errorText(err error) string {
+	var lines []string
+	var parseErrs parser.ErrorList
+	var compileErrs nanojs.CompilerErrorList
+	switch {
+	case errors.As(err, &parseErrs):
+		for _, e := range parseErrs {
+			lines = append(lines, e.Error())
+		}
+	case errors.As(err, &compileErrs):
+		for _, e := range compileErrs {
+			lines = append(lines, e.Error())
+		}
+	default:
+		return err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
 func doHelp() {
 	fmt.Println("Usage:")
 	fmt.Println()
 	fmt.Println("	nanojs [flags] {input-file}")
+	fmt.Println("	nanojs run [-cpuprofile file] {input-file}")
+	fmt.Println("	nanojs debug {input-file}")
+	fmt.Println("	nanojs disasm [-json] {input-file}")
+	fmt.Println("	nanojs fmt [-w] [-d] {input-file|dir}...")
+	fmt.Println("	nanojs lint [-json] [-enable rules] [-disable rules] [-globals names] {input-file|dir}...")
+	fmt.Println("	nanojs lsp")
 	fmt.Println()
 	fmt.Println("Flags:")
 	fmt.Println()
-	fmt.Println("	-o        compile output file")
-	fmt.Println("	-version  show version")
+	fmt.Println("	-o            compile output file")
+	fmt.Println("	-version      show version")
+	fmt.Println("	-sandbox      restrict the standard library modules")
+	fmt.Println("	-allow        allow the functions in the sandbox (os.exit,os.*)")
+	fmt.Println("	-deny         deny the functions in the sandbox")
+	fmt.Println("	-allow-read   allow reading the files of the directories")
+	fmt.Println("	-allow-write  allow writing the files of the directories")
+	fmt.Println("	-allow-exec   allow running the commands")
+	fmt.Println("	-allow-env    copy the environment variables to the sandbox")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println()
@@ -280,6 +371,32 @@ func doHelp() {
 	fmt.Println()
 	fmt.Println("	          Run bytecode file (myapp)")
 	fmt.Println()
+	fmt.Println("	nanojs run -cpuprofile cpu.pprof myapp.js")
+	fmt.Println()
+	fmt.Println("	          Run source or bytecode file (myapp.js), writing the")
+	fmt.Println("	          profile of the script functions (go tool pprof cpu.pprof)")
+	fmt.Println()
+	fmt.Println("	nanojs debug myapp.js")
+	fmt.Println()
+	fmt.Println("	          Debug source file (myapp.js) interactively")
+	fmt.Println()
+	fmt.Println("	nanojs disasm [-json] myapp.js")
+	fmt.Println()
+	fmt.Println("	          Print disassembly of source file or bytecode file")
+	fmt.Println()
+	fmt.Println("	nanojs fmt -w .")
+	fmt.Println()
+	fmt.Println("	          Format source files (*.js) in the directory in place")
+	fmt.Println("	          (-d prints the diffs instead)")
+	fmt.Println()
+	fmt.Println("	nanojs lint -disable shadow,unused .")
+	fmt.Println()
+	fmt.Println("	          Check source files (*.js) in the directory for mistakes")
+	fmt.Println()
+	fmt.Println("	nanojs lsp")
+	fmt.Println()
+	fmt.Println("	          Start language server over stdin and stdout for editors")
+	fmt.Println()
 	fmt.Println()
 }
 
@@ -323,3 +440,12 @@ func basename(s string) string {
 	}
 	return s
 }
+
+// signatureHelp returns the signature and the documentation of the function
+// printed in the REPL.
+func signatureHelp(sig *nanojs.FuncSignature) string {
+	if sig.Doc == "" {
+		return sig.String()
+	}
+	return sig.String() + "\n  " + sig.Doc
+}
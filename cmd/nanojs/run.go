@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zeaphoo/nanojs/v2"
+)
+
+func doRun(modules *nanojs.ModuleMap, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	cpuProfile := flags.String("cpuprofile", "",
+		"Write the wall-clock profile of the script to the file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	inputFile := flags.Arg(0)
+	if inputFile == "" {
+		return fmt.Errorf("missing input file")
+	}
+	inputData, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return fmt.Errorf("Error reading input file: %s", err.Error())
+	}
+	inputFile, err = filepath.Abs(inputFile)
+	if err != nil {
+		return fmt.Errorf("Error file path: %s", err)
+	}
+	if len(inputData) > 1 && string(inputData[:2]) == "#!" {
+		copy(inputData, "//")
+	}
+
+	var prof *nanojs.Profiler
+	if *cpuProfile != "" {
+		prof = nanojs.NewProfiler(0)
+	}
+	err = Run(modules, inputData, inputFile, prof)
+	if prof != nil {
+		if perr := writeProfile(prof, *cpuProfile); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// Run compiles and executes the source code (*.js), or executes the compiled
+// bytecode, sampled by the profiler if it's not nil.
+func Run(
+	modules *nanojs.ModuleMap,
+	data []byte,
+	inputFile string,
+	prof *nanojs.Profiler,
+) error {
+	var bytecode *nanojs.Bytecode
+	if filepath.Ext(inputFile) == sourceFileExt {
+		var err error
+		bytecode, err = compileSrc(modules, data, inputFile, nil)
+		if err != nil {
+			return err
+		}
+	} else {
+		bytecode = &nanojs.Bytecode{}
+		err := bytecode.Decode(bytes.NewReader(data), modules)
+		if err != nil {
+			return err
+		}
+	}
+	machine := nanojs.NewVM(bytecode, nil, -1)
+	if prof != nil {
+		machine.SetProfiler(prof)
+		prof.Start()
+		defer prof.Stop()
+	}
+	return machine.Run()
+}
+
+// writeProfile writes the profile of the profiler to the file.
+func writeProfile(prof *nanojs.Profiler, file string) (err error) {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return prof.WriteProfile(out)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/stdlib"
+)
+
+// listFlag is a flag of comma-separated values, which can be repeated.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, strings.Split(value, ",")...)
+	return nil
+}
+
+var (
+	sandbox    bool
+	allowFuncs listFlag
+	denyFuncs  listFlag
+	allowRead  listFlag
+	allowWrite listFlag
+	allowExec  listFlag
+	allowEnv   bool
+)
+
+func registerSandboxFlags() {
+	flag.BoolVar(&sandbox, "sandbox", false,
+		"Restrict the standard library modules")
+	flag.Var(&allowFuncs, "allow", "Allow the functions in the sandbox")
+	flag.Var(&denyFuncs, "deny", "Deny the functions in the sandbox")
+	flag.Var(&allowRead, "allow-read",
+		"Allow reading the files of the directories in the sandbox")
+	flag.Var(&allowWrite, "allow-write",
+		"Allow writing the files of the directories in the sandbox")
+	flag.Var(&allowExec, "allow-exec", "Allow the commands in the sandbox")
+	flag.BoolVar(&allowEnv, "allow-env", false,
+		"Copy the environment variables to the sandbox")
+}
+
+// sandboxModules returns the modules restricted by the policy of the sandbox
+// flags. The sandbox is enabled by any of them.
+func sandboxModules(modules *nanojs.ModuleMap) (*nanojs.ModuleMap, error) {
+	if !sandbox && len(allowFuncs) == 0 && len(denyFuncs) == 0 &&
+		len(allowRead) == 0 && len(allowWrite) == 0 &&
+		len(allowExec) == 0 && !allowEnv {
+		return modules, nil
+	}
+	p := stdlib.NewPolicy()
+	if err := p.Allow(allowFuncs...); err != nil {
+		return nil, err
+	}
+	if err := p.Deny(denyFuncs...); err != nil {
+		return nil, err
+	}
+	if err := p.AllowRead(allowRead...); err != nil {
+		return nil, err
+	}
+	if err := p.AllowWrite(allowWrite...); err != nil {
+		return nil, err
+	}
+	for _, name := range allowExec {
+		if err := p.AllowCommand(name, ".*"); err != nil {
+			return nil, err
+		}
+	}
+	if allowEnv {
+		env := make(map[string]string)
+		for _, kv := range os.Environ() {
+			if idx := strings.IndexByte(kv, '='); idx > 0 {
+				env[kv[:idx]] = kv[idx+1:]
+			}
+		}
+		p.SetEnv(env)
+	}
+	return modules.Restrict(p), nil
+}
@@ -1,12 +1,16 @@
 package nanojs
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/zeaphoo/nanojs/v2/parser"
 	"github.com/zeaphoo/nanojs/v2/token"
@@ -18,6 +22,8 @@ type compilationScope struct {
 	Instructions []byte
 	SymbolInit   map[string]bool
 	SourceMap    map[int]parser.Pos
+	Locals       []LocalVar
+	blocks       []int // number of the locals when the blocks are entered
 }
 
 // loop represents a loop construct that the compiler uses to track the current
@@ -39,6 +45,58 @@ func (e *CompilerError) Error() string {
 	return fmt.Sprintf("Compile Error: %s\n\tat %s", e.Err.Error(), filePos)
 }
 
+// CompilerErrorList is a list of compiler errors sorted by their positions.
+type CompilerErrorList []*CompilerError
+
+func (p CompilerErrorList) Len() int {
+	return len(p)
+}
+
+func (p CompilerErrorList) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+func (p CompilerErrorList) Less(i, j int) bool {
+	e := p[i].FileSet.Position(p[i].Node.Pos())
+	f := p[j].FileSet.Position(p[j].Node.Pos())
+	if e.Filename != f.Filename {
+		return e.Filename < f.Filename
+	}
+	if e.Line != f.Line {
+		return e.Line < f.Line
+	}
+	return e.Column < f.Column
+}
+
+func (p CompilerErrorList) Error() string {
+	switch len(p) {
+	case 0:
+		return "no errors"
+	case 1:
+		return p[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", p[0], len(p)-1)
+}
+
+// As finds the first error of the list that matches the target, so that
+// errors.As finds a *CompilerError in the list.
+func (p CompilerErrorList) As(target interface{}) bool {
+	for _, e := range p {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMaxErrors is the default maximum number of the errors reported by
+// the compiler.
+const defaultMaxErrors = 10
+
+// errMaxErrors stops the compilation when the maximum number of the errors
+// is reported.
+var errMaxErrors = errors.New("too many errors")
+
 // Compiler compiles the AST into a bytecode.
 type Compiler struct {
 	file            *parser.SourceFile
@@ -50,12 +108,118 @@ type Compiler struct {
 	scopes          []compilationScope
 	scopeIndex      int
 	modules         *ModuleMap
-	compiledModules map[string]*CompiledFunction
+	compiledModules map[string]*compiledModule
 	allowFileImport bool
+	resolver        ModuleResolver
+	importer        string // path of the module resolved by the resolver
 	loops           []*loop
 	loopIndex       int
 	trace           io.Writer
 	indent          int
+	funcName        string // name of the next compiled function literal
+	exports         []moduleExport
+	exportValue     bool // module exports a value with export statement
+	cache           *ModuleCache
+	cacheImports    map[int]moduleImport // imports of the cached module
+	errors          CompilerErrorList    // recoverable errors
+	maxErrors       int
+	globalSources   map[*Symbol]*globalSource
+	signatureCalls  []signatureCall
+}
+
+// maxGlobalValueDepth is the maximum number of the global variables that
+// are followed to find the value of a global variable.
+const maxGlobalValueDepth = 16
+
+// globalSource is how a global variable is assigned, which is used to find
+// the builtin module functions that the variable refers to.
+type globalSource struct {
+	assigns int
+	module  *BuiltinModule // assigned the imported builtin module
+	symbol  *Symbol        // assigned the other global variable or its member
+	member  string
+}
+
+// signatureCall is a call to a global variable or its member that's checked
+// against the signature of the function when the file is compiled, as the
+// variable must not be assigned later.
+type signatureCall struct {
+	node   *parser.CallExpr
+	symbol *Symbol
+	member string
+}
+
+// compiledModule is a module compiled from the source code.
+type compiledModule struct {
+	fn      *CompiledFunction
+	exports []string // named exports, or nil if the module exports a value
+}
+
+// moduleExport is a named export of the module.
+type moduleExport struct {
+	node  parser.Node
+	name  string // exported name
+	local string // name of the exported variable
+}
+
+// importedModule is a module imported by the compiler.
+type importedModule struct {
+	name    string
+	fn      *CompiledFunction // module written in Nanojs
+	exports []string          // named exports of the module
+	object  Object            // builtin module
+}
+
+// value returns the constant that's loaded by the import.
+func (m *importedModule) value() Object {
+	if m.fn != nil {
+		return m.fn
+	}
+	return m.object
+}
+
+// signature returns a string that identifies the kind and the names of the
+// module. Compiled code that imports the module can be reused if the
+// signature of the module does not change.
+func (m *importedModule) signature() string {
+	switch obj := m.object.(type) {
+	case nil:
+		if m.exports == nil {
+			return "value"
+		}
+		return "exports:" + strings.Join(m.exports, ",")
+	case *ImmutableMap:
+		return "map:" + strings.Join(sortedKeys(obj.Value), ",")
+	case *Map:
+		return "map:" + strings.Join(sortedKeys(obj.Value), ",")
+	}
+	return "object:" + m.object.TypeName()
+}
+
+// hasNamedExports returns true if the names can be imported from the module.
+func (m *importedModule) hasNamedExports() bool {
+	switch m.object.(type) {
+	case *ImmutableMap, *Map:
+		return true
+	}
+	return m.exports != nil
+}
+
+func (m *importedModule) hasExport(name string) bool {
+	switch obj := m.object.(type) {
+	case *ImmutableMap:
+		_, ok := obj.Value[name]
+		return ok
+	case *Map:
+		_, ok := obj.Value[name]
+		return ok
+	}
+	for _, export := range m.exports {
+		if export == name {
+			return true
+		}
+	}
+	return false
 }
 
 // NewCompiler creates a Compiler.
@@ -95,7 +259,8 @@ func NewCompiler(
 		loopIndex:       -1,
 		trace:           trace,
 		modules:         modules,
-		compiledModules: make(map[string]*CompiledFunction),
+		compiledModules: make(map[string]*compiledModule),
+		maxErrors:       defaultMaxErrors,
 	}
 }
 
@@ -112,11 +277,19 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 	switch node := node.(type) {
 	case *parser.File:
+		c.errors = nil
+		c.signatureCalls = nil
 		for _, stmt := range node.Stmts {
 			if err := c.Compile(stmt); err != nil {
-				return err
+				return c.fileErrors(stmt, err)
 			}
 		}
+		if err := c.checkSignatureCalls(); err != nil {
+			return c.fileErrors(node, err)
+		}
+		if len(c.errors) > 0 {
+			return c.fileErrors(node, nil)
+		}
 	case *parser.ExprStmt:
 		if err := c.Compile(node.Expr); err != nil {
 			return err
@@ -241,10 +414,8 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 	case *parser.IfStmt:
 		// open new symbol table for the statement
-		c.symbolTable = c.symbolTable.Fork(true)
-		defer func() {
-			c.symbolTable = c.symbolTable.Parent(false)
-		}()
+		c.enterBlock()
+		defer c.leaveBlock()
 
 		if node.Init != nil {
 			if err := c.Compile(node.Init); err != nil {
@@ -287,14 +458,14 @@ func (c *Compiler) Compile(node parser.Node) error {
 		if node.Token == token.Break {
 			curLoop := c.currentLoop()
 			if curLoop == nil {
-				return c.errorf(node, "break not allowed outside loop")
+				return c.reportf(node, "break not allowed outside loop")
 			}
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Breaks = append(curLoop.Breaks, pos)
 		} else if node.Token == token.Continue {
 			curLoop := c.currentLoop()
 			if curLoop == nil {
-				return c.errorf(node, "continue not allowed outside loop")
+				return c.reportf(node, "continue not allowed outside loop")
 			}
 			pos := c.emit(node, parser.OpJump, 0)
 			curLoop.Continues = append(curLoop.Continues, pos)
@@ -307,10 +478,8 @@ func (c *Compiler) Compile(node parser.Node) error {
 			return nil
 		}
 
-		c.symbolTable = c.symbolTable.Fork(true)
-		defer func() {
-			c.symbolTable = c.symbolTable.Parent(false)
-		}()
+		c.enterBlock()
+		defer c.leaveBlock()
 
 		for _, stmt := range node.Stmts {
 			if err := c.Compile(stmt); err != nil {
@@ -325,7 +494,8 @@ func (c *Compiler) Compile(node parser.Node) error {
 	case *parser.Ident:
 		symbol, _, ok := c.symbolTable.Resolve(node.Name)
 		if !ok {
-			return c.errorf(node, "unresolved reference '%s'", node.Name)
+			c.emit(node, parser.OpNull)
+			return c.reportf(node, "unresolved reference '%s'", node.Name)
 		}
 
 		switch symbol.Scope {
@@ -355,6 +525,9 @@ func (c *Compiler) Compile(node parser.Node) error {
 				c.addConstant(&String{Value: elt.Key}))
 
 			// value
+			if _, isFunc := elt.Value.(*parser.FuncLit); isFunc {
+				c.funcName = elt.Key
+			}
 			if err := c.Compile(elt.Value); err != nil {
 				return err
 			}
@@ -397,10 +570,13 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 		c.emit(node, parser.OpSliceIndex)
 	case *parser.FuncLit:
+		funcName := c.funcName
+		c.funcName = ""
+
 		c.enterScope()
 
 		for _, p := range node.Type.Params.List {
-			s := c.symbolTable.Define(p.Name)
+			s := c.defineSymbol(p.Name)
 
 			// function arguments is not assigned directly.
 			s.LocalAssigned = true
@@ -415,8 +591,14 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols()
 		numLocals := c.symbolTable.MaxSymbols()
+		locals := c.locals()
 		instructions, sourceMap := c.leaveScope()
 
+		freeNames := make([]string, len(freeSymbols))
+		for i, s := range freeSymbols {
+			freeNames[i] = s.Name
+		}
+
 		for _, s := range freeSymbols {
 			switch s.Scope {
 			case ScopeLocal:
@@ -461,7 +643,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 					//   0009 SETL    0
 					//
 					c.emit(node, parser.OpNull)
-					c.emit(node, parser.OpDefineLocal, s.Index)
+					c.defineLocal(node, s)
 					s.LocalAssigned = true
 				}
 				c.emit(node, parser.OpGetLocalPtr, s.Index)
@@ -471,12 +653,16 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 
 		compiledFunction := &CompiledFunction{
+			Name:          funcName,
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Type.Params.List),
 			VarArgs:       node.Type.Params.VarArgs,
 			SourceMap:     sourceMap,
+			Locals:        locals,
+			FreeNames:     freeNames,
 		}
+		setMaxStack(compiledFunction, c.constants, false)
 		if len(freeSymbols) > 0 {
 			c.emit(node, parser.OpClosure,
 				c.addConstant(compiledFunction), len(freeSymbols))
@@ -486,7 +672,7 @@ func (c *Compiler) Compile(node parser.Node) error {
 	case *parser.ReturnStmt:
 		if c.symbolTable.Parent(true) == nil {
 			// outside the function
-			return c.errorf(node, "return not allowed outside function")
+			return c.reportf(node, "return not allowed outside function")
 		}
 
 		if node.Result == nil {
@@ -509,66 +695,28 @@ func (c *Compiler) Compile(node parser.Node) error {
 		ellipsis := 0
 		if node.Ellipsis.IsValid() {
 			ellipsis = 1
+		} else {
+			c.addSignatureCall(node)
 		}
 		c.emit(node, parser.OpCall, len(node.Args), ellipsis)
 	case *parser.ImportExpr:
-		if node.ModuleName == "" {
-			return c.errorf(node, "empty module name")
+		if _, err := c.importModule(node); err != nil {
+			return err
 		}
-
-		if mod := c.modules.Get(node.ModuleName); mod != nil {
-			v, err := mod.Import(node.ModuleName)
-			if err != nil {
-				return err
-			}
-
-			switch v := v.(type) {
-			case []byte: // module written in Nanojs
-				compiled, err := c.compileModule(node,
-					node.ModuleName, v, false)
-				if err != nil {
-					return err
-				}
-				c.emit(node, parser.OpConstant, c.addConstant(compiled))
-				c.emit(node, parser.OpCall, 0, 0)
-			case Object: // builtin module
-				c.emit(node, parser.OpConstant, c.addConstant(v))
-			default:
-				panic(fmt.Errorf("invalid import value type: %T", v))
-			}
-		} else if c.allowFileImport {
-			moduleName := node.ModuleName
-			if !strings.HasSuffix(moduleName, ".js") {
-				moduleName += ".js"
-			}
-
-			modulePath, err := filepath.Abs(
-				filepath.Join(c.importDir, moduleName))
-			if err != nil {
-				return c.errorf(node, "module file path error: %s",
-					err.Error())
-			}
-
-			moduleSrc, err := ioutil.ReadFile(modulePath)
-			if err != nil {
-				return c.errorf(node, "module file read error: %s",
-					err.Error())
-			}
-
-			compiled, err := c.compileModule(node, modulePath, moduleSrc, true)
-			if err != nil {
-				return err
-			}
-			c.emit(node, parser.OpConstant, c.addConstant(compiled))
-			c.emit(node, parser.OpCall, 0, 0)
-		} else {
-			return c.errorf(node, "module '%s' not found", node.ModuleName)
+	case *parser.ImportDecl:
+		if err := c.compileImportDecl(node); err != nil {
+			return err
 		}
 	case *parser.ExportStmt:
 		// export statement must be in top-level scope
 		if c.scopeIndex != 0 {
 			return c.errorf(node, "export not allowed inside function")
 		}
+		if len(c.exports) > 0 {
+			return c.errorf(node,
+				"export statement not allowed with named exports")
+		}
+		c.exportValue = true
 
 		// export statement is simply ignore when compiling non-module code
 		if c.parent == nil {
@@ -579,6 +727,10 @@ func (c *Compiler) Compile(node parser.Node) error {
 		}
 		c.emit(node, parser.OpImmutable)
 		c.emit(node, parser.OpReturn, 1)
+	case *parser.ExportDecl:
+		if err := c.compileExportDecl(node); err != nil {
+			return err
+		}
 	case *parser.ErrorExpr:
 		if err := c.Compile(node.Expr); err != nil {
 			return err
@@ -619,13 +771,15 @@ func (c *Compiler) Compile(node parser.Node) error {
 
 // Bytecode returns a compiled bytecode.
 func (c *Compiler) Bytecode() *Bytecode {
+	main := &CompiledFunction{
+		Instructions: append(c.currentInstructions(), parser.OpSuspend),
+		SourceMap:    c.currentSourceMap(),
+	}
+	setMaxStack(main, c.constants, true)
 	return &Bytecode{
-		FileSet: c.file.Set(),
-		MainFunction: &CompiledFunction{
-			Instructions: append(c.currentInstructions(), parser.OpSuspend),
-			SourceMap:    c.currentSourceMap(),
-		},
-		Constants: c.constants,
+		FileSet:      c.file.Set(),
+		MainFunction: main,
+		Constants:    c.constants,
 	}
 }
 
@@ -635,11 +789,410 @@ func (c *Compiler) EnableFileImport(enable bool) {
 	c.allowFileImport = enable
 }
 
-// SetImportDir sets the initial import directory path for file imports.
+// SetImportDir sets the initial import directory path for file imports. The
+// modules imported by a file module are resolved against the directory of
+// the module file.
 func (c *Compiler) SetImportDir(dir string) {
 	c.importDir = dir
 }
 
+// SetMaxErrors sets the maximum number of the errors reported before the
+// compilation stops. The default is 10, and 0 or a negative number means no
+// limit.
+func (c *Compiler) SetMaxErrors(n int) {
+	c.maxErrors = n
+}
+
+// SetModuleResolver sets the resolver of the modules that are not found in
+// the module map. It takes precedence over the file imports.
+func (c *Compiler) SetModuleResolver(resolver ModuleResolver) {
+	c.resolver = resolver
+}
+
+// SetModuleCache sets the cache of the compiled source modules. Modules found
+// in the cache are not parsed and compiled again.
+func (c *Compiler) SetModuleCache(cache *ModuleCache) {
+	c.cache = cache
+}
+
+// moduleResolver returns the module resolver, or a FileResolver of the import
+// directory if file imports are enabled.
+func (c *Compiler) moduleResolver() ModuleResolver {
+	if c.resolver != nil {
+		return c.resolver
+	}
+	if c.allowFileImport {
+		return NewFileResolver(c.importDir)
+	}
+	return nil
+}
+
+// importModule compiles the import of the module, which pushes the module
+// value to the stack.
+func (c *Compiler) importModule(
+	node *parser.ImportExpr,
+) (*importedModule, error) {
+	imported, err := c.resolveImport(node)
+	if err != nil {
+		return nil, err
+	}
+	c.emit(node, parser.OpConstant, c.addImportConstant(imported))
+	if imported.fn != nil {
+		c.emit(node, parser.OpCall, 0, 0)
+	}
+	return imported, nil
+}
+
+// resolveImport resolves the module and compiles it if it's written in
+// Nanojs.
+func (c *Compiler) resolveImport(
+	node *parser.ImportExpr,
+) (*importedModule, error) {
+	if node.ModuleName == "" {
+		return nil, c.errorf(node, "empty module name")
+	}
+
+	if mod := c.modules.Get(node.ModuleName); mod != nil {
+		v, err := mod.Import(node.ModuleName)
+		if err != nil {
+			return nil, err
+		}
+		return c.compileImport(node, node.ModuleName, v, false)
+	}
+
+	resolver := c.moduleResolver()
+	if resolver == nil {
+		return nil, c.errorf(node, "module '%s' not found", node.ModuleName)
+	}
+	modulePath, v, err := resolver.Resolve(c.importer, node.ModuleName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, c.errorf(node, "module '%s' not found",
+			node.ModuleName)
+	} else if err != nil {
+		return nil, c.errorf(node, "module '%s' resolve error: %s",
+			node.ModuleName, err.Error())
+	}
+	return c.compileImport(node, modulePath, v, true)
+}
+
+func (c *Compiler) compileImport(
+	node *parser.ImportExpr,
+	modulePath string,
+	mod interface{},
+	isFile bool,
+) (*importedModule, error) {
+	imported := &importedModule{name: node.ModuleName}
+	switch mod := mod.(type) {
+	case []byte: // module written in Nanojs
+		compiled, err := c.compileModule(node, modulePath, mod, isFile)
+		if err != nil {
+			return nil, err
+		}
+		imported.fn = compiled.fn
+		imported.exports = compiled.exports
+	case Object: // builtin module
+		imported.object = mod
+	default:
+		panic(fmt.Errorf("invalid import value type: %T", mod))
+	}
+	return imported, nil
+}
+
+func (c *Compiler) compileImportDecl(node *parser.ImportDecl) error {
+	// import declaration must be in top-level scope
+	if c.scopeIndex != 0 {
+		return c.errorf(node, "import not allowed inside function")
+	}
+
+	imported, err := c.importModule(&parser.ImportExpr{
+		ModuleName: node.Module.Value,
+		Token:      token.Import,
+		TokenPos:   node.Module.ValuePos,
+	})
+	if err != nil {
+		return err
+	}
+
+	// validate the imported names
+	if node.Default != nil {
+		if imported.exports != nil && !imported.hasExport("default") {
+			return c.errorf(node.Default,
+				"module '%s' has no default export", imported.name)
+		}
+	}
+	for _, spec := range node.Specs {
+		if !imported.hasNamedExports() {
+			return c.errorf(spec, "module '%s' has no named exports",
+				imported.name)
+		}
+		if !imported.hasExport(spec.Name.Name) {
+			return c.errorf(spec, "module '%s' has no export '%s'",
+				imported.name, spec.Name.Name)
+		}
+	}
+
+	// the module value is stored to the namespace variable, or to a hidden
+	// variable that the other imported names are loaded from.
+	moduleVar := ":import"
+	if node.Namespace != nil {
+		moduleVar = node.Namespace.Name
+	}
+	c.storeSymbol(node, moduleVar,
+		&parser.ImportExpr{ModuleName: node.Module.Value})
+
+	module := &parser.Ident{Name: moduleVar, NamePos: node.Module.ValuePos}
+	if node.Default != nil {
+		var value parser.Expr = module
+		if imported.exports != nil {
+			value = &parser.IndexExpr{
+				Expr:  module,
+				Index: &parser.StringLit{Value: "default"},
+			}
+		}
+		if err := c.compileAssign(node.Default,
+			[]parser.Expr{node.Default}, []parser.Expr{value},
+			token.Assign); err != nil {
+			return err
+		}
+	}
+	for _, spec := range node.Specs {
+		local := &parser.Ident{
+			Name:    spec.LocalName(),
+			NamePos: spec.Pos(),
+		}
+		value := &parser.IndexExpr{
+			Expr:  module,
+			Index: &parser.StringLit{Value: spec.Name.Name},
+		}
+		if err := c.compileAssign(spec, []parser.Expr{local},
+			[]parser.Expr{value}, token.Assign); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compileExportDecl(node *parser.ExportDecl) error {
+	// export declaration must be in top-level scope
+	if c.scopeIndex != 0 {
+		return c.errorf(node, "export not allowed inside function")
+	}
+	if c.exportValue {
+		return c.errorf(node,
+			"named exports not allowed with export statement")
+	}
+
+	switch {
+	case node.Default != nil:
+		if err := c.addExport(node, "default", ":default"); err != nil {
+			return err
+		}
+		// default export is simply ignored when compiling non-module code
+		if c.parent == nil {
+			break
+		}
+		local := &parser.Ident{Name: ":default", NamePos: node.ExportPos}
+		if _, isFunc := node.Default.(*parser.FuncLit); isFunc {
+			c.funcName = "default"
+		}
+		if err := c.Compile(node.Default); err != nil {
+			return err
+		}
+		c.storeSymbol(local, local.Name, nil)
+	case node.Value != nil:
+		if err := c.addExport(node.Name, node.Name.Name,
+			node.Name.Name); err != nil {
+			return err
+		}
+		return c.compileAssign(node, []parser.Expr{node.Name},
+			[]parser.Expr{node.Value}, token.Assign)
+	default:
+		for _, spec := range node.Specs {
+			if err := c.addExport(spec, spec.ExportedName(),
+				spec.Name.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) addExport(node parser.Node, name, local string) error {
+	for _, export := range c.exports {
+		if export.name == name {
+			return c.errorf(node, "duplicate export '%s'", name)
+		}
+	}
+	c.exports = append(c.exports, moduleExport{
+		node:  node,
+		name:  name,
+		local: local,
+	})
+	return nil
+}
+
+// compileExports compiles the return of the named exports of the module as an
+// immutable map.
+func (c *Compiler) compileExports(node parser.Node) error {
+	for _, export := range c.exports {
+		c.emit(export.node, parser.OpConstant,
+			c.addConstant(&String{Value: export.name}))
+		if err := c.Compile(&parser.Ident{
+			Name:    export.local,
+			NamePos: export.node.Pos(),
+		}); err != nil {
+			return err
+		}
+		c.emit(export.node, parser.OpImmutable)
+	}
+	c.emit(node, parser.OpMap, len(c.exports)*2)
+	c.emit(node, parser.OpImmutable)
+	c.emit(node, parser.OpReturn, 1)
+	return nil
+}
+
+// storeSymbol compiles the assignment of the value on top of the stack to the
+// variable, which is defined if it does not exist.
+func (c *Compiler) storeSymbol(
+	node parser.Node,
+	name string,
+	value parser.Expr,
+) {
+	symbol, _, exists := c.symbolTable.Resolve(name)
+	if !exists {
+		symbol = c.defineSymbol(name)
+	}
+	c.assignGlobal(symbol, value)
+
+	switch symbol.Scope {
+	case ScopeGlobal:
+		c.emit(node, parser.OpSetGlobal, symbol.Index)
+	case ScopeLocal:
+		if !symbol.LocalAssigned {
+			c.defineLocal(node, symbol)
+		} else {
+			c.emit(node, parser.OpSetLocal, symbol.Index)
+		}
+		symbol.LocalAssigned = true
+	case ScopeFree:
+		c.emit(node, parser.OpSetFree, symbol.Index)
+	default:
+		panic(fmt.Errorf("invalid assignment variable scope: %s",
+			symbol.Scope))
+	}
+}
+
+// assignGlobal records the assignment of the value to the symbol if it's a
+// global variable. The value is nil if it's not a plain assignment.
+func (c *Compiler) assignGlobal(symbol *Symbol, value parser.Expr) {
+	if symbol.Scope != ScopeGlobal {
+		return
+	}
+	if c.globalSources == nil {
+		c.globalSources = make(map[*Symbol]*globalSource)
+	}
+	src := c.globalSources[symbol]
+	if src == nil {
+		src = &globalSource{}
+		c.globalSources[symbol] = src
+	}
+	src.assigns++
+	src.module, src.symbol, src.member = nil, nil, ""
+	switch value := value.(type) {
+	case *parser.ImportExpr:
+		src.module = c.modules.GetBuiltinModule(value.ModuleName)
+	default:
+		src.symbol, src.member = c.globalMember(value)
+	}
+}
+
+// globalMember returns the global variable and the member name of the
+// expression that is a global variable, or its member selected by a string.
+func (c *Compiler) globalMember(expr parser.Expr) (*Symbol, string) {
+	var member string
+	switch x := expr.(type) {
+	case *parser.SelectorExpr:
+		sel, ok := x.Sel.(*parser.StringLit)
+		if !ok {
+			return nil, ""
+		}
+		expr, member = x.Expr, sel.Value
+	case *parser.IndexExpr:
+		index, ok := x.Index.(*parser.StringLit)
+		if !ok {
+			return nil, ""
+		}
+		expr, member = x.Expr, index.Value
+	}
+	ident, ok := expr.(*parser.Ident)
+	if !ok {
+		return nil, ""
+	}
+	symbol, _, ok := c.symbolTable.Resolve(ident.Name)
+	if !ok || symbol.Scope != ScopeGlobal {
+		return nil, ""
+	}
+	return symbol, member
+}
+
+// addSignatureCall records the call to be checked against the signature of
+// the function.
+func (c *Compiler) addSignatureCall(node *parser.CallExpr) {
+	if symbol, member := c.globalMember(node.Func); symbol != nil {
+		c.signatureCalls = append(c.signatureCalls, signatureCall{
+			node:   node,
+			symbol: symbol,
+			member: member,
+		})
+	}
+}
+
+// checkSignatureCalls reports the calls to the builtin module functions with
+// the wrong numbers of the arguments.
+func (c *Compiler) checkSignatureCalls() error {
+	for _, call := range c.signatureCalls {
+		fn, ok := c.globalValue(call.symbol, call.member, 0).(*UserFunction)
+		if !ok || fn.Signature == nil {
+			continue
+		}
+		err := fn.Signature.checkNumArgs(len(call.node.Args))
+		if err == nil {
+			continue
+		}
+		if err := c.reportf(call.node, "%s", err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globalValue returns the builtin module or its member that the global
+// variable is assigned once, or nil.
+func (c *Compiler) globalValue(
+	symbol *Symbol,
+	member string,
+	depth int,
+) Object {
+	src := c.globalSources[symbol]
+	if src == nil || src.assigns != 1 || depth > maxGlobalValueDepth {
+		return nil
+	}
+	var value Object
+	switch {
+	case src.module != nil:
+		value = &ImmutableMap{Value: src.module.Attrs}
+	case src.symbol != nil:
+		value = c.globalValue(src.symbol, src.member, depth+1)
+	}
+	if member == "" {
+		return value
+	}
+	if m, ok := value.(*ImmutableMap); ok {
+		return m.Value[member]
+	}
+	return nil
+}
+
 func (c *Compiler) compileAssign(
 	node parser.Node,
 	lhs, rhs []parser.Expr,
@@ -647,17 +1200,25 @@ func (c *Compiler) compileAssign(
 ) error {
 	numLHS, numRHS := len(lhs), len(rhs)
 	if numLHS > 1 || numRHS > 1 {
-		return c.errorf(node, "tuple assignment not allowed")
+		return c.reportf(node, "tuple assignment not allowed")
 	}
 
 	// resolve and compile left-hand side
 	ident, selectors := resolveAssignLHS(lhs[0])
 	numSel := len(selectors)
+	if ident == "" {
+		return c.reportf(node, "cannot assign to %s", lhs[0].String())
+	}
 
 	symbol, _, exists := c.symbolTable.Resolve(ident)
-
-	if !exists {
-		symbol = c.symbolTable.Define(ident)
+	switch {
+	case !exists && (op != token.Assign || numSel > 0):
+		return c.reportf(node, "unresolved reference '%s'", ident)
+	case !exists:
+		symbol = c.defineSymbol(ident)
+	case symbol.Scope == ScopeBuiltin:
+		return c.reportf(node, "cannot assign to builtin function '%s'",
+			ident)
 	}
 
 	// +=, -=, *=, /=
@@ -667,6 +1228,17 @@ func (c *Compiler) compileAssign(
 		}
 	}
 
+	// name the function literal after the variable or the field it's
+	// assigned to
+	if _, isFunc := rhs[0].(*parser.FuncLit); isFunc && op == token.Assign {
+		c.funcName = ident
+		if numSel > 0 {
+			if sel, ok := selectors[numSel-1].(*parser.StringLit); ok {
+				c.funcName = sel.Value
+			}
+		}
+	}
+
 	// compile RHSs
 	for _, expr := range rhs {
 		if err := c.Compile(expr); err != nil {
@@ -699,6 +1271,14 @@ func (c *Compiler) compileAssign(
 		c.emit(node, parser.OpBinaryOp, int(token.Shr))
 	}
 
+	if numSel == 0 {
+		var value parser.Expr
+		if op == token.Assign {
+			value = rhs[0]
+		}
+		c.assignGlobal(symbol, value)
+	}
+
 	// compile selector expressions (right to left)
 	for i := numSel - 1; i >= 0; i-- {
 		if err := c.Compile(selectors[i]); err != nil {
@@ -718,7 +1298,7 @@ func (c *Compiler) compileAssign(
 			c.emit(node, parser.OpSetSelLocal, symbol.Index, numSel)
 		} else {
 			if !symbol.LocalAssigned {
-				c.emit(node, parser.OpDefineLocal, symbol.Index)
+				c.defineLocal(node, symbol)
 			} else {
 				c.emit(node, parser.OpSetLocal, symbol.Index)
 			}
@@ -763,10 +1343,8 @@ func (c *Compiler) compileLogical(node *parser.BinaryExpr) error {
 }
 
 func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
-	c.symbolTable = c.symbolTable.Fork(true)
-	defer func() {
-		c.symbolTable = c.symbolTable.Parent(false)
-	}()
+	c.enterBlock()
+	defer c.leaveBlock()
 
 	// init statement
 	if stmt.Init != nil {
@@ -829,10 +1407,8 @@ func (c *Compiler) compileForStmt(stmt *parser.ForStmt) error {
 }
 
 func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
-	c.symbolTable = c.symbolTable.Fork(true)
-	defer func() {
-		c.symbolTable = c.symbolTable.Parent(false)
-	}()
+	c.enterBlock()
+	defer c.leaveBlock()
 
 	// for-in statement is compiled like following:
 	//
@@ -847,7 +1423,7 @@ func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 
 	// init
 	//   :it = iterator(iterable)
-	itSymbol := c.symbolTable.Define(":it")
+	itSymbol := c.defineSymbol(":it")
 	if err := c.Compile(stmt.Iterable); err != nil {
 		return err
 	}
@@ -855,7 +1431,7 @@ func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 	if itSymbol.Scope == ScopeGlobal {
 		c.emit(stmt, parser.OpSetGlobal, itSymbol.Index)
 	} else {
-		c.emit(stmt, parser.OpDefineLocal, itSymbol.Index)
+		c.defineLocal(stmt, itSymbol)
 	}
 
 	// pre-condition position
@@ -878,7 +1454,7 @@ func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 
 	// assign key variable
 	if stmt.Key.Name != "_" {
-		keySymbol := c.symbolTable.Define(stmt.Key.Name)
+		keySymbol := c.defineSymbol(stmt.Key.Name)
 		if itSymbol.Scope == ScopeGlobal {
 			c.emit(stmt, parser.OpGetGlobal, itSymbol.Index)
 		} else {
@@ -889,7 +1465,7 @@ func (c *Compiler) compileForInStmt(stmt *parser.ForInStmt) error {
 			c.emit(stmt, parser.OpSetGlobal, keySymbol.Index)
 		} else {
 			keySymbol.LocalAssigned = true
-			c.emit(stmt, parser.OpDefineLocal, keySymbol.Index)
+			c.defineLocal(stmt, keySymbol)
 		}
 	}
 
@@ -938,14 +1514,25 @@ func (c *Compiler) compileModule(
 	modulePath string,
 	src []byte,
 	isFile bool,
-) (*CompiledFunction, error) {
+) (*compiledModule, error) {
 	if err := c.checkCyclicImports(node, modulePath); err != nil {
 		return nil, err
 	}
 
-	compiledModule, exists := c.loadCompiledModule(modulePath)
+	compiled, exists := c.loadCompiledModule(modulePath)
 	if exists {
-		return compiledModule, nil
+		return compiled, nil
+	}
+
+	var hash [sha256.Size]byte
+	if c.cache != nil {
+		hash = sha256.Sum256(src)
+		if cached := c.cache.get(modulePath, hash); cached != nil {
+			if compiled := c.linkModule(cached, isFile, nil); compiled != nil {
+				c.storeCompiledModule(modulePath, compiled)
+				return compiled, nil
+			}
+		}
 	}
 
 	modFile := c.file.Set().AddFile(modulePath, -1, len(src))
@@ -970,17 +1557,118 @@ func (c *Compiler) compileModule(
 		return nil, err
 	}
 
+	var exports []string
+	if len(moduleCompiler.exports) > 0 {
+		err := moduleCompiler.compileExports(file)
+		if err != nil || len(moduleCompiler.errors) > 0 {
+			return nil, moduleCompiler.fileErrors(file, err)
+		}
+		for _, export := range moduleCompiler.exports {
+			exports = append(exports, export.name)
+		}
+	}
+
 	// code optimization
 	moduleCompiler.optimizeFunc(node)
 	compiledFunc := moduleCompiler.Bytecode().MainFunction
 	compiledFunc.NumLocals = symbolTable.MaxSymbols()
-	c.storeCompiledModule(modulePath, compiledFunc)
-	return compiledFunc, nil
+	compiledFunc.Locals = moduleCompiler.locals()
+
+	if c.cache != nil {
+		cached := &cachedModule{
+			path:      modulePath,
+			hash:      hash,
+			fileName:  modFile.Name,
+			fileBase:  modFile.Base,
+			fileSize:  modFile.Size,
+			fileLines: modFile.Lines,
+			fn:        compiledFunc,
+			constants: moduleCompiler.constants,
+			imports:   moduleCompiler.cacheImports,
+			exports:   exports,
+		}
+		if r, ok := c.moduleResolver().(modTimer); ok && isFile {
+			cached.modTime = func() (time.Time, error) {
+				return r.modTime(modulePath)
+			}
+			cached.mtime, err = cached.modTime()
+		}
+		compiled = c.linkModule(cached, isFile, modFile)
+
+		// the imported modules are resolved again when the module is reused
+		cached.constants = append([]Object(nil), cached.constants...)
+		for idx := range cached.imports {
+			cached.constants[idx] = nil
+		}
+		if err == nil {
+			c.cache.put(cached)
+		}
+	} else {
+		compiled = &compiledModule{fn: compiledFunc, exports: exports}
+	}
+	c.storeCompiledModule(modulePath, compiled)
+	return compiled, nil
+}
+
+// linkModule adds the constants of the cached module to the constants of the
+// compilation, and returns the module whose instructions refer to them. The
+// imports of the module are resolved again unless the module was compiled
+// into the file of this compilation, and it returns nil if the imported
+// modules have changed.
+func (c *Compiler) linkModule(
+	cached *cachedModule,
+	isFile bool,
+	modFile *parser.SourceFile,
+) *compiledModule {
+	imports := cached.constants
+	if modFile == nil {
+		modFile = c.file.Set().AddFile(cached.fileName, -1, cached.fileSize)
+		modFile.Lines = append([]int(nil), cached.fileLines...)
+
+		// resolve the imports in the context of the module
+		moduleCompiler := c.fork(modFile, cached.path, nil, isFile)
+		imports = make([]Object, len(cached.constants))
+		for idx, imp := range cached.imports {
+			imported, err := moduleCompiler.resolveImport(
+				&parser.ImportExpr{ModuleName: imp.name, Token: token.Import})
+			if err != nil || imported.signature() != imp.signature {
+				return nil
+			}
+			imports[idx] = imported.value()
+		}
+	}
+	delta := modFile.Base - cached.fileBase
+
+	// the constants are added to the root compiler as the other compilers
+	// of the cached modules use their own constants.
+	root := c
+	for root.parent != nil {
+		root = root.parent
+	}
+	indexMap := make(map[int]int)
+	var fns []*CompiledFunction
+	for idx, constant := range cached.constants {
+		if _, ok := cached.imports[idx]; ok {
+			constant = imports[idx]
+		} else if fn, ok := constant.(*CompiledFunction); ok {
+			fn = relocateFunction(fn, delta)
+			fns = append(fns, fn)
+			constant = fn
+		}
+		indexMap[idx] = root.addConstant(constant)
+	}
+
+	fn := relocateFunction(cached.fn, delta)
+	updateConstIndexes(fn.Instructions, indexMap)
+	for _, fn := range fns {
+		updateConstIndexes(fn.Instructions, indexMap)
+	}
+	return &compiledModule{fn: fn, exports: cached.exports}
 }
 
 func (c *Compiler) loadCompiledModule(
 	modulePath string,
-) (mod *CompiledFunction, ok bool) {
+) (mod *compiledModule, ok bool) {
 	if c.parent != nil {
 		return c.parent.loadCompiledModule(modulePath)
 	}
@@ -990,7 +1678,7 @@ func (c *Compiler) loadCompiledModule(
 
 func (c *Compiler) storeCompiledModule(
 	modulePath string,
-	module *CompiledFunction,
+	module *compiledModule,
 ) {
 	if c.parent != nil {
 		c.parent.storeCompiledModule(modulePath, module)
@@ -1044,6 +1732,70 @@ func (c *Compiler) enterScope() {
 	}
 }
 
+// enterBlock opens a new symbol table for the block in the current scope.
+func (c *Compiler) enterBlock() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.blocks = append(scope.blocks, len(scope.Locals))
+	c.symbolTable = c.symbolTable.Fork(true)
+}
+
+// leaveBlock closes the symbol table of the block, and ends the scopes of the
+// local variables defined in it at the current instruction.
+func (c *Compiler) leaveBlock() {
+	scope := &c.scopes[c.scopeIndex]
+	n := len(scope.blocks) - 1
+	end := len(scope.Instructions)
+	for i := scope.blocks[n]; i < len(scope.Locals); i++ {
+		if scope.Locals[i].End < 0 {
+			scope.Locals[i].End = end
+		}
+	}
+	scope.blocks = scope.blocks[:n]
+	c.symbolTable = c.symbolTable.Parent(false)
+}
+
+// defineSymbol defines the symbol in the current symbol table. The scope of a
+// local variable starts at the current instruction, or after its definition
+// (see defineLocal), and it ends with the block or the function.
+func (c *Compiler) defineSymbol(name string) *Symbol {
+	symbol := c.symbolTable.Define(name)
+	if symbol.Scope == ScopeLocal {
+		scope := &c.scopes[c.scopeIndex]
+		scope.Locals = append(scope.Locals, LocalVar{
+			Name:  name,
+			Index: symbol.Index,
+			Start: len(scope.Instructions),
+			End:   -1,
+		})
+	}
+	return symbol
+}
+
+// defineLocal emits the definition of the local variable, and moves the start
+// of its scope after the definition.
+func (c *Compiler) defineLocal(node parser.Node, symbol *Symbol) {
+	c.emit(node, parser.OpDefineLocal, symbol.Index)
+	scope := &c.scopes[c.scopeIndex]
+	for i := len(scope.Locals) - 1; i >= 0; i-- {
+		if l := &scope.Locals[i]; l.Index == symbol.Index && l.End < 0 {
+			l.Start = len(scope.Instructions)
+			return
+		}
+	}
+}
+
+// locals returns the local variables of the current scope. The scopes of the
+// variables defined outside of the blocks end with the instructions.
+func (c *Compiler) locals() []LocalVar {
+	scope := &c.scopes[c.scopeIndex]
+	for i := range scope.Locals {
+		if scope.Locals[i].End < 0 {
+			scope.Locals[i].End = len(scope.Instructions)
+		}
+	}
+	return scope.Locals
+}
+
 func (c *Compiler) leaveScope() (
 	instructions []byte,
 	sourceMap map[int]parser.Pos,
@@ -1070,12 +1822,63 @@ func (c *Compiler) fork(
 	child.parent = c              // parent to set to current compiler
 	child.allowFileImport = c.allowFileImport
 	child.importDir = c.importDir
-	if isFile && c.importDir != "" {
-		child.importDir = filepath.Dir(modulePath)
+	child.resolver = c.resolver
+	child.cache = c.cache
+	child.maxErrors = c.maxErrors
+	if c.cache != nil {
+		// module compilers use their own constants to be cached
+		child.cacheImports = make(map[int]moduleImport)
+	}
+	if isFile {
+		child.importer = modulePath
+		if c.resolver == nil && c.importDir != "" {
+			// the modules imported by a file module are resolved against
+			// the directory of the module (see SetImportDir)
+			child.importDir = filepath.Dir(modulePath)
+		}
 	}
 	return child
 }
 
+// reportf records a recoverable error, so the compilation continues to report
+// the following errors. It returns an error to stop the compilation if the
+// maximum number of the errors is reached.
+func (c *Compiler) reportf(
+	node parser.Node,
+	format string,
+	args ...interface{},
+) error {
+	c.errors = append(c.errors, c.errorf(node, format, args...).(*CompilerError))
+	if c.maxErrors > 0 && len(c.errors) >= c.maxErrors {
+		return errMaxErrors
+	}
+	return nil
+}
+
+// fileErrors returns the recoverable errors and the error that stopped the
+// compilation of the statement, sorted by their positions.
+func (c *Compiler) fileErrors(stmt parser.Node, err error) error {
+	var e *CompilerError
+	var list CompilerErrorList
+	switch {
+	case err == nil || err == errMaxErrors:
+	case errors.As(err, &list):
+		c.errors = append(c.errors, list...)
+	case errors.As(err, &e):
+		c.errors = append(c.errors, e)
+	case len(c.errors) == 0:
+		return err
+	default:
+		// e.g. the parse error of an imported module
+		c.errors = append(c.errors, c.error(stmt, err).(*CompilerError))
+	}
+	sort.Stable(c.errors)
+	if c.maxErrors > 0 && len(c.errors) > c.maxErrors {
+		c.errors = c.errors[:c.maxErrors]
+	}
+	return c.errors
+}
+
 func (c *Compiler) error(node parser.Node, err error) error {
 	return &CompilerError{
 		FileSet: c.file.Set(),
@@ -1096,8 +1899,23 @@ func (c *Compiler) errorf(
 	}
 }
 
+// addImportConstant adds the value of the imported module to the constants.
+// The compilers of the cached modules record it as an import of the module
+// that's resolved again when the module is reused.
+func (c *Compiler) addImportConstant(m *importedModule) int {
+	if c.cacheImports == nil {
+		return c.addConstant(m.value())
+	}
+	idx := c.addConstant(m.value())
+	c.cacheImports[idx] = moduleImport{
+		name:      m.name,
+		signature: m.signature(),
+	}
+	return idx
+}
+
 func (c *Compiler) addConstant(o Object) int {
-	if c.parent != nil {
+	if c.parent != nil && c.cacheImports == nil {
 		// module compilers will use their parent's constants array
 		return c.parent.addConstant(o)
 	}
@@ -1217,6 +2035,24 @@ func (c *Compiler) optimizeFunc(node parser.Node) {
 	c.scopes[c.scopeIndex].Instructions = newInsts
 	c.scopes[c.scopeIndex].SourceMap = newSourceMap
 
+	// pass 5. move the scopes of the local variables to the next
+	// instructions that are kept
+	newPos := func(pos int) int {
+		for ; pos < endPos; pos++ {
+			if newPos, ok := posMap[pos]; ok {
+				return newPos
+			}
+		}
+		return newEndPost
+	}
+	for i := range c.scopes[c.scopeIndex].Locals {
+		l := &c.scopes[c.scopeIndex].Locals[i]
+		l.Start = newPos(l.Start)
+		if l.End >= 0 {
+			l.End = newPos(l.End)
+		}
+	}
+
 	// append "return"
 	if appendReturn {
 		c.emit(node, parser.OpReturn, 0)
@@ -1,6 +1,7 @@
 package nanojs_test
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -1030,6 +1031,87 @@ func TestCompilerErrorReport(t *testing.T) {
 		"Compile Error: export not allowed inside function\n\tat test:1:10")
 }
 
+func TestCompilerErrorList(t *testing.T) {
+	src := `
+a = b
+break
+f = function() {
+	continue
+	c += 1
+	len = 2
+}
+return 1
+x, y = 1, 2
+[1][0] = 2
+d.e = 1
+`
+	compile := func(maxErrors int) error {
+		fileSet := parser.NewFileSet()
+		file := fileSet.AddFile("test", -1, len(src))
+		parsed, err := parser.NewParser(file, []byte(src), nil).ParseFile()
+		require.NoError(t, err)
+		c := nanojs.NewCompiler(file, nil, nil, nil, nil)
+		c.SetMaxErrors(maxErrors)
+		return c.Compile(parsed)
+	}
+
+	err := compile(0)
+	errs, ok := err.(nanojs.CompilerErrorList)
+	require.True(t, ok)
+	var actual []string
+	for _, e := range errs {
+		actual = append(actual, e.Error())
+	}
+	require.Equal(t, []string{
+		"Compile Error: unresolved reference 'b'\n\tat test:2:5",
+		"Compile Error: break not allowed outside loop\n\tat test:3:1",
+		"Compile Error: continue not allowed outside loop\n\tat test:5:2",
+		"Compile Error: unresolved reference 'c'\n\tat test:6:2",
+		"Compile Error: cannot assign to builtin function 'len'\n\tat test:7:2",
+		"Compile Error: return not allowed outside function\n\tat test:9:1",
+		"Compile Error: tuple assignment not allowed\n\tat test:10:1",
+		"Compile Error: cannot assign to [1][0]\n\tat test:11:1",
+		"Compile Error: unresolved reference 'd'\n\tat test:12:1",
+	}, actual)
+	require.Equal(t, "Compile Error: unresolved reference 'b'\n\tat test:2:5 "+
+		"(and 8 more errors)", err.Error())
+
+	// the compilation stops at the maximum number of errors
+	errs = compile(3).(nanojs.CompilerErrorList)
+	require.Equal(t, 3, len(errs))
+	require.Equal(t, "Compile Error: continue not allowed outside loop\n\tat "+
+		"test:5:2", errs[2].Error())
+
+	// the errors of the module are reported with the errors of the importer,
+	// and the compilation stops at the import
+	mods := nanojs.NewModuleMap()
+	mods.AddSourceModule("mod", []byte("a = b\nc = d"))
+	s := nanojs.NewScript([]byte(`x = y; m = import("mod"); z = w`))
+	s.SetImports(mods)
+	_, err = s.Compile()
+	errs, ok = err.(nanojs.CompilerErrorList)
+	require.True(t, ok)
+	actual = nil
+	for _, e := range errs {
+		actual = append(actual, e.Error())
+	}
+	require.Equal(t, []string{
+		"Compile Error: unresolved reference 'y'\n\tat (main):1:5",
+		"Compile Error: unresolved reference 'b'\n\tat mod:1:5",
+		"Compile Error: unresolved reference 'd'\n\tat mod:2:5",
+	}, actual)
+
+	s.SetMaxCompileErrors(1)
+	_, err = s.Compile()
+	require.Equal(t, 1, len(err.(nanojs.CompilerErrorList)))
+
+	// errors.As finds the first error of the list
+	var compilerErr *nanojs.CompilerError
+	require.True(t, errors.As(err, &compilerErr))
+	require.Equal(t, "Compile Error: unresolved reference 'y'\n\tat "+
+		"(main):1:5", compilerErr.Error())
+}
+
 func TestCompilerDeadCode(t *testing.T) {
 	expectCompile(t, `
 func() {
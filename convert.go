@@ -0,0 +1,634 @@
+package nanojs
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxConvertDepth is the maximum nesting depth of the values converted with
+// reflection, which stops the conversion of cyclic values.
+const maxConvertDepth = 1000
+
+var (
+	durationType            = reflect.TypeOf(time.Duration(0))
+	timeType                = reflect.TypeOf(time.Time{})
+	callableFuncType        = reflect.TypeOf(CallableFunc(nil))
+	callableContextFuncType = reflect.TypeOf(CallableContextFunc(nil))
+	textMarshalerType       = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType     = reflect.TypeOf(
+		(*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// fromValue converts the element of a value converted with reflection.
+func fromValue(rv reflect.Value, depth int) (Object, error) {
+	if rv.IsValid() && rv.CanInterface() {
+		return fromInterface(rv.Interface(), depth)
+	}
+	return fromReflect(rv, depth)
+}
+
+// fromReflect converts the value of a type that FromInterface doesn't handle
+// directly.
+func fromReflect(rv reflect.Value, depth int) (Object, error) {
+	if !rv.IsValid() {
+		return UndefinedValue, nil
+	}
+
+	// the types converted regardless of their kinds
+	if rv.CanInterface() {
+		switch v := rv.Interface().(type) {
+		case Object:
+			if rv.Kind() == reflect.Ptr && rv.IsNil() {
+				return UndefinedValue, nil
+			}
+			return v, nil
+		case time.Duration:
+			return &Int{Value: int64(v)}, nil
+		case time.Time:
+			return &Time{Value: v}, nil
+		case error:
+			if rv.Kind() == reflect.Ptr && rv.IsNil() {
+				return UndefinedValue, nil
+			}
+			return &Error{Value: &String{Value: v.Error()}}, nil
+		case encoding.TextMarshaler:
+			if rv.Kind() == reflect.Ptr && rv.IsNil() {
+				return UndefinedValue, nil
+			}
+			text, err := v.MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			return stringObject(string(text))
+		case fmt.Stringer:
+			if rv.Kind() == reflect.Ptr && rv.IsNil() {
+				return UndefinedValue, nil
+			}
+			return stringObject(v.String())
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return TrueValue, nil
+		}
+		return FalseValue, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return &Int{Value: rv.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert to object: %s: value %d "+
+				"overflows int64", rv.Type(), rv.Uint())
+		}
+		return &Int{Value: int64(rv.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: rv.Float()}, nil
+	case reflect.String:
+		return stringObject(rv.String())
+	case reflect.Slice:
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if rv.Len() > MaxBytesLen {
+				return nil, ErrBytesLimit
+			}
+			return &Bytes{Value: append([]byte{}, rv.Bytes()...)}, nil
+		}
+		return arrayFromReflect(rv, depth)
+	case reflect.Array:
+		return arrayFromReflect(rv, depth)
+	case reflect.Map:
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		m := make(map[string]Object, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := mapKeyString(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			value, err := fromValue(iter.Value(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return &Map{Value: m}, nil
+	case reflect.Struct:
+		m := make(map[string]Object)
+		for _, f := range cachedStructFields(rv.Type()) {
+			fv, ok := fieldByIndex(rv, f.index)
+			if !ok || f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			value, err := fromValue(fv, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = value
+		}
+		return &Map{Value: m}, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		return fromValue(rv.Elem(), depth+1)
+	case reflect.Func:
+		if rv.IsNil() {
+			return UndefinedValue, nil
+		}
+		if rv.Type().ConvertibleTo(callableFuncType) {
+			fn := rv.Convert(callableFuncType).Interface().(CallableFunc)
+			return &UserFunction{Value: fn}, nil
+		}
+		if rv.Type().ConvertibleTo(callableContextFuncType) {
+			fn := rv.Convert(callableContextFuncType).
+				Interface().(CallableContextFunc)
+			return NewContextFunction("", fn), nil
+		}
+		if rv.CanInterface() {
+			return WrapFunc(rv.Interface())
+		}
+	}
+	return nil, fmt.Errorf("cannot convert to object: %s", rv.Type())
+}
+
+func stringObject(s string) (Object, error) {
+	if len(s) > MaxStringLen {
+		return nil, ErrStringLimit
+	}
+	return &String{Value: s}, nil
+}
+
+func arrayFromReflect(rv reflect.Value, depth int) (Object, error) {
+	arr := make([]Object, rv.Len())
+	for i := range arr {
+		elem, err := fromValue(rv.Index(i), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = elem
+	}
+	return &Array{Value: arr}, nil
+}
+
+// mapKeyString returns the map key of the Go map key.
+func mapKeyString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	if key.Type().Implements(textMarshalerType) {
+		text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("cannot convert to object: unsupported map key "+
+		"type: %s", key.Type())
+}
+
+// structField is an exported field of a struct converted to a map entry.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	readOnly  bool // see GoObject
+}
+
+var structFieldsCache sync.Map // map[reflect.Type][]structField
+
+func cachedStructFields(t reflect.Type) []structField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]structField)
+	}
+	fields, _ := structFieldsCache.LoadOrStore(t, structFields(t, nil))
+	return fields.([]structField)
+}
+
+// structFields returns the fields of the struct named by their "nanojs" tags,
+// or by their Go names. The fields of the embedded structs without tags are
+// promoted, and the fields of the outer structs take precedence.
+func structFields(t reflect.Type, index []int) []structField {
+	var fields, promoted []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("nanojs")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		fieldIndex := append(append([]int{}, index...), i)
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			promoted = append(promoted, structFields(ft, fieldIndex)...)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: hasOption(opts[1:], "omitempty"),
+			readOnly:  hasOption(opts[1:], "readonly"),
+		})
+	}
+
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		names[f.name] = true
+	}
+	for _, f := range promoted {
+		if !names[f.name] {
+			names[f.name] = true
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func hasOption(opts []string, name string) bool {
+	for _, opt := range opts {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex returns the field of the struct, or false if it's in a nil
+// embedded struct pointer.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// settableField returns the field of the struct, allocating the nil embedded
+// struct pointers, or false if the field can't be set.
+func settableField(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, false
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, rv.CanSet()
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+// Decode stores the value of the object in the Go value pointed to by
+// target. It's the reverse of FromInterface: arrays are decoded into slices
+// and arrays, maps into maps and structs (matching the "nanojs" tags or the
+// field names), and the numbers into any numeric type if the value fits.
+// Undefined sets the target to its zero value.
+func Decode(o Object, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %T",
+			target)
+	}
+	return decodeValue(o, rv.Elem(), "", 0)
+}
+
+// DecodeError is the error of decoding an object into a Go value.
+type DecodeError struct {
+	Path   string // e.g. ".items[2].name", or empty for the top-level value
+	Object string // type name of the object
+	Type   reflect.Type
+	Err    error // the cause of the error, or nil if the types don't match
+}
+
+func (e *DecodeError) Error() string {
+	msg := fmt.Sprintf("cannot decode %s into %s", e.Object, e.Type)
+	if e.Path != "" {
+		msg += " at " + e.Path
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func decodeValue(o Object, rv reflect.Value, path string, depth int) error {
+	fail := func(err error) error {
+		return &DecodeError{Path: path, Object: o.TypeName(),
+			Type: rv.Type(), Err: err}
+	}
+	if depth > maxConvertDepth {
+		return fail(errors.New("nesting too deep"))
+	}
+
+	if o == UndefinedValue {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if g, ok := o.(*GoObject); ok {
+		if v := g.value; v.Type().AssignableTo(rv.Type()) {
+			rv.Set(v)
+			return nil
+		}
+		if v := g.value; v.CanAddr() &&
+			v.Addr().Type().AssignableTo(rv.Type()) {
+			rv.Set(v.Addr())
+			return nil
+		}
+	}
+
+	// the object itself
+	if reflect.TypeOf(o).AssignableTo(rv.Type()) &&
+		rv.Type() != reflect.TypeOf((*interface{})(nil)).Elem() {
+		rv.Set(reflect.ValueOf(o))
+		return nil
+	}
+
+	switch rv.Type() {
+	case durationType:
+		switch o := o.(type) {
+		case *Int:
+			rv.SetInt(o.Value)
+			return nil
+		case *String:
+			d, err := time.ParseDuration(o.Value)
+			if err != nil {
+				return fail(err)
+			}
+			rv.SetInt(int64(d))
+			return nil
+		}
+		return fail(nil)
+	case timeType:
+		t, ok := ToTime(o)
+		if !ok {
+			return fail(nil)
+		}
+		rv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	if s, ok := o.(*String); ok && rv.Kind() != reflect.String &&
+		reflect.PtrTo(rv.Type()).Implements(textUnmarshalerType) &&
+		rv.CanAddr() {
+		u := rv.Addr().Interface().(encoding.TextUnmarshaler)
+		if err := u.UnmarshalText([]byte(s.Value)); err != nil {
+			return fail(err)
+		}
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.NumMethod() == 0 {
+			rv.Set(reflect.ValueOf(ToInterface(o)))
+			return nil
+		}
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return decodeValue(o, rv.Elem(), path, depth+1)
+	case reflect.Bool:
+		if o, ok := o.(*Bool); ok {
+			rv.SetBool(!o.IsFalsy())
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		n, ok := decodeInt(o)
+		if !ok {
+			break
+		}
+		if rv.OverflowInt(n) {
+			return fail(fmt.Errorf("value %d overflows", n))
+		}
+		rv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		n, ok := decodeInt(o)
+		if !ok {
+			break
+		}
+		if n < 0 || rv.OverflowUint(uint64(n)) {
+			return fail(fmt.Errorf("value %d overflows", n))
+		}
+		rv.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch o := o.(type) {
+		case *Float:
+			rv.SetFloat(o.Value)
+			return nil
+		case *Int:
+			rv.SetFloat(float64(o.Value))
+			return nil
+		}
+	case reflect.String:
+		switch o := o.(type) {
+		case *String:
+			rv.SetString(o.Value)
+			return nil
+		case *Char:
+			rv.SetString(string(o.Value))
+			return nil
+		}
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			switch o := o.(type) {
+			case *Bytes:
+				rv.SetBytes(append([]byte{}, o.Value...))
+				return nil
+			case *String:
+				rv.SetBytes([]byte(o.Value))
+				return nil
+			}
+		}
+		elems, ok := arrayElements(o)
+		if !ok {
+			break
+		}
+		s := reflect.MakeSlice(rv.Type(), len(elems), len(elems))
+		for i, elem := range elems {
+			err := decodeValue(elem, s.Index(i),
+				fmt.Sprintf("%s[%d]", path, i), depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+		return nil
+	case reflect.Array:
+		elems, ok := arrayElements(o)
+		if !ok {
+			break
+		}
+		if len(elems) > rv.Len() {
+			return fail(fmt.Errorf("%d elements don't fit", len(elems)))
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if i >= len(elems) {
+				rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+				continue
+			}
+			err := decodeValue(elems[i], rv.Index(i),
+				fmt.Sprintf("%s[%d]", path, i), depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		entries, ok := mapEntries(o)
+		if !ok {
+			break
+		}
+		m := reflect.MakeMapWithSize(rv.Type(), len(entries))
+		for key, value := range entries {
+			k := reflect.New(rv.Type().Key()).Elem()
+			err := decodeValue(&String{Value: key}, k, path, depth+1)
+			if err != nil {
+				n, convErr := strconv.ParseInt(key, 10, 64)
+				if convErr != nil {
+					return err
+				}
+				err = decodeValue(&Int{Value: n}, k, path, depth+1)
+				if err != nil {
+					return err
+				}
+			}
+			v := reflect.New(rv.Type().Elem()).Elem()
+			err = decodeValue(value, v, path+"."+key, depth+1)
+			if err != nil {
+				return err
+			}
+			m.SetMapIndex(k, v)
+		}
+		rv.Set(m)
+		return nil
+	case reflect.Struct:
+		entries, ok := mapEntries(o)
+		if !ok {
+			break
+		}
+		for _, f := range cachedStructFields(rv.Type()) {
+			value, ok := entries[f.name]
+			if !ok {
+				continue
+			}
+			fv, ok := settableField(rv, f.index)
+			if !ok {
+				continue
+			}
+			err := decodeValue(value, fv, path+"."+f.name, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fail(nil)
+}
+
+// decodeInt returns the integer value of Int, Char, or Float with no
+// fractional part.
+func decodeInt(o Object) (int64, bool) {
+	switch o := o.(type) {
+	case *Int:
+		return o.Value, true
+	case *Char:
+		return int64(o.Value), true
+	case *Float:
+		if o.Value == math.Trunc(o.Value) && o.Value >= math.MinInt64 &&
+			o.Value < math.MaxInt64 {
+			return int64(o.Value), true
+		}
+	}
+	return 0, false
+}
+
+func arrayElements(o Object) ([]Object, bool) {
+	switch o := o.(type) {
+	case *Array:
+		return o.Value, true
+	case *ImmutableArray:
+		return o.Value, true
+	}
+	return nil, false
+}
+
+func mapEntries(o Object) (map[string]Object, bool) {
+	switch o := o.(type) {
+	case *Map:
+		return o.Value, true
+	case *ImmutableMap:
+		return o.Value, true
+	}
+	return nil, false
+}
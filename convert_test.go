@@ -0,0 +1,259 @@
+package nanojs_test
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+type convertBase struct {
+	ID      int64 `nanojs:"id"`
+	Comment string
+}
+
+type convertItem struct {
+	convertBase
+	Name     string            `nanojs:"name"`
+	Tags     []string          `nanojs:"tags,omitempty"`
+	Counts   map[string]int    `nanojs:"counts,omitempty"`
+	Ratio    float32           `nanojs:"ratio"`
+	Timeout  time.Duration     `nanojs:"timeout"`
+	Parent   *convertItem      `nanojs:"parent,omitempty"`
+	IP       net.IP            `nanojs:"ip,omitempty"`
+	Extra    map[int]uint16    `nanojs:"extra,omitempty"`
+	Skipped  string            `nanojs:"-"`
+	Labels   [2]string         `nanojs:"labels"`
+	Any      interface{}       `nanojs:"any"`
+	Raw      []byte            `nanojs:"raw,omitempty"`
+	Children []*convertItem    `nanojs:"children,omitempty"`
+	Meta     map[string]string `nanojs:"meta,omitempty"`
+	hidden   int
+}
+
+type convertLevel int
+
+func (l convertLevel) String() string {
+	return strings.Repeat("*", int(l))
+}
+
+func TestFromInterface_Reflect(t *testing.T) {
+	expectFromInterface(t, int32(-5), &nanojs.Char{Value: -5})
+	expectFromInterface(t, int8(-5), &nanojs.Int{Value: -5})
+	expectFromInterface(t, uint64(42), &nanojs.Int{Value: 42})
+	expectFromInterface(t, float32(0.5), &nanojs.Float{Value: 0.5})
+	expectFromInterface(t, []string{"a", "b"}, arr(str("a"), str("b")))
+	expectFromInterface(t, [2]int{1, 2}, arr(num(1), num(2)))
+	expectFromInterface(t, map[string]int{"a": 1}, obj("a", num(1)))
+	expectFromInterface(t, map[int]bool{1: true},
+		obj("1", nanojs.TrueValue))
+	expectFromInterface(t, 2*time.Second,
+		&nanojs.Int{Value: int64(2 * time.Second)})
+	expectFromInterface(t, convertLevel(3), str("***"))
+	expectFromInterface(t, net.IPv4(127, 0, 0, 1), str("127.0.0.1"))
+	expectFromInterface(t, (*convertItem)(nil), nanojs.UndefinedValue)
+	expectFromInterface(t, []uint8{1, 2}, &nanojs.Bytes{Value: []byte{1, 2}})
+
+	n := 7
+	expectFromInterface(t, &n, num(7))
+	expectFromInterface(t, map[string]interface{}{"a": []int64{1}},
+		obj("a", arr(num(1))))
+
+	item := &convertItem{
+		convertBase: convertBase{ID: 1, Comment: "c"},
+		Name:        "x",
+		Ratio:       0.25,
+		Timeout:     time.Millisecond,
+		Skipped:     "skipped",
+		Labels:      [2]string{"l"},
+		Children:    []*convertItem{{Name: "y"}},
+		hidden:      1,
+	}
+	o, err := nanojs.FromInterface(item)
+	require.NoError(t, err)
+	m, ok := o.(*nanojs.Map)
+	require.True(t, ok)
+	require.Equal(t, []string{"Comment", "any", "children", "id", "labels",
+		"name", "ratio", "timeout"}, sortedKeys(m))
+	require.Equal(t, num(1), m.Value["id"])
+	require.Equal(t, str("c"), m.Value["Comment"])
+	require.Equal(t, str("x"), m.Value["name"])
+	require.Equal(t, &nanojs.Float{Value: 0.25}, m.Value["ratio"])
+	require.Equal(t, num(int64(time.Millisecond)), m.Value["timeout"])
+	require.Equal(t, arr(str("l"), str("")), m.Value["labels"])
+	require.Equal(t, nanojs.UndefinedValue, m.Value["any"])
+	children, ok := m.Value["children"].(*nanojs.Array)
+	require.True(t, ok)
+	require.Equal(t, 1, len(children.Value))
+
+	_, err = nanojs.FromInterface(uint64(1 << 63))
+	require.Error(t, err)
+	_, err = nanojs.FromInterface(make(chan int))
+	require.Error(t, err)
+	_, err = nanojs.FromInterface(map[float64]int{1: 1})
+	require.Error(t, err)
+
+	// cyclic values
+	cyclic := &convertItem{}
+	cyclic.Parent = cyclic
+	_, err = nanojs.FromInterface(cyclic)
+	require.Error(t, err)
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["a"] = cyclicMap
+	_, err = nanojs.FromInterface(cyclicMap)
+	require.Error(t, err)
+}
+
+func TestDecode(t *testing.T) {
+	var i8 int8
+	require.NoError(t, nanojs.Decode(num(-3), &i8))
+	require.Equal(t, int64(-3), int64(i8))
+	require.Error(t, nanojs.Decode(num(300), &i8))
+	var u uint
+	require.NoError(t, nanojs.Decode(&nanojs.Float{Value: 2}, &u))
+	require.Equal(t, int64(2), int64(u))
+	require.Error(t, nanojs.Decode(num(-1), &u))
+	require.Error(t, nanojs.Decode(&nanojs.Float{Value: 2.5}, &u))
+	var f32 float32
+	require.NoError(t, nanojs.Decode(num(2), &f32))
+	require.Equal(t, 2.0, float64(f32))
+	var s string
+	require.NoError(t, nanojs.Decode(str("abc"), &s))
+	require.Equal(t, "abc", s)
+	require.NoError(t, nanojs.Decode(nanojs.UndefinedValue, &s))
+	require.Equal(t, "", s)
+	var d time.Duration
+	require.NoError(t, nanojs.Decode(str("1m"), &d))
+	require.Equal(t, int64(time.Minute), int64(d))
+	var tm time.Time
+	require.NoError(t, nanojs.Decode(num(10), &tm))
+	require.Equal(t, int64(10), tm.Unix())
+	var ip net.IP
+	require.NoError(t, nanojs.Decode(str("10.0.0.1"), &ip))
+	require.Equal(t, "10.0.0.1", ip.String())
+	var b bool
+	require.NoError(t, nanojs.Decode(nanojs.TrueValue, &b))
+	require.True(t, b)
+	decoded := &nanojs.Bool{} // not the TrueValue singleton
+	require.NoError(t, decoded.GobDecode([]byte{1}))
+	b = false
+	require.NoError(t, nanojs.Decode(decoded, &b))
+	require.True(t, b)
+	var raw []byte
+	require.NoError(t, nanojs.Decode(str("ab"), &raw))
+	require.Equal(t, []byte("ab"), raw)
+	var strs []string
+	require.NoError(t, nanojs.Decode(arr(str("a"), str("b")), &strs))
+	require.Equal(t, []string{"a", "b"}, strs)
+	var v interface{}
+	require.NoError(t, nanojs.Decode(obj("a", num(1)), &v))
+	require.Equal(t, int64(1), v.(map[string]interface{})["a"])
+	var o nanojs.Object
+	require.NoError(t, nanojs.Decode(num(1), &o))
+	require.Equal(t, num(1), o)
+	var ints map[int]uint16
+	require.NoError(t, nanojs.Decode(obj("1", num(2)), &ints))
+	require.Equal(t, 1, len(ints))
+	require.Equal(t, int64(2), int64(ints[1]))
+
+	var item convertItem
+	err := nanojs.Decode(&nanojs.ImmutableMap{Value: map[string]nanojs.Object{
+		"id":      num(3),
+		"Comment": str("c"),
+		"name":    str("x"),
+		"tags":    &nanojs.ImmutableArray{Value: []nanojs.Object{str("t")}},
+		"counts":  obj("a", num(1)),
+		"timeout": num(5),
+		"parent":  obj("name", str("p")),
+		"labels":  arr(str("l")),
+		"any":     arr(num(1)),
+		"Skipped": str("skipped"),
+		"unknown": str("unknown"),
+	}}, &item)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), item.ID)
+	require.Equal(t, "c", item.Comment)
+	require.Equal(t, "x", item.Name)
+	require.Equal(t, []string{"t"}, item.Tags)
+	require.Equal(t, 1, item.Counts["a"])
+	require.Equal(t, int64(5), int64(item.Timeout))
+	require.Equal(t, "p", item.Parent.Name)
+	require.Equal(t, []string{"l", ""}, item.Labels[:])
+	require.Equal(t, int64(1), item.Any.([]interface{})[0])
+	require.Equal(t, "", item.Skipped)
+
+	err = nanojs.Decode(obj("children", arr(obj("ratio", str("x")))), &item)
+	require.Error(t, err)
+	require.Equal(t, "cannot decode string into float32 at "+
+		".children[0].ratio", err.Error())
+	require.Error(t, nanojs.Decode(num(1), item))
+	require.Error(t, nanojs.Decode(num(1), nil))
+}
+
+func TestCompiled_GetInto(t *testing.T) {
+	s := nanojs.NewScript([]byte(`
+out = {name: "x", tags: ["a", "b"], ratio: 0.5, timeout: "2s"}
+`))
+	c, err := s.Run()
+	require.NoError(t, err)
+
+	var out struct {
+		Name    string        `nanojs:"name"`
+		Tags    []string      `nanojs:"tags"`
+		Ratio   float32       `nanojs:"ratio"`
+		Timeout time.Duration `nanojs:"timeout"`
+	}
+	require.NoError(t, c.GetInto("out", &out))
+	require.Equal(t, "x", out.Name)
+	require.Equal(t, []string{"a", "b"}, out.Tags)
+	require.Equal(t, 0.5, float64(out.Ratio))
+	require.Equal(t, int64(2*time.Second), int64(out.Timeout))
+
+	var tags []string
+	require.NoError(t, c.Get("out").Decode(&out))
+	require.Error(t, c.GetInto("out", &tags))
+	require.Error(t, c.GetInto("unknown", &tags))
+}
+
+func expectFromInterface(
+	t *testing.T,
+	v interface{},
+	expected nanojs.Object,
+) {
+	o, err := nanojs.FromInterface(v)
+	require.NoError(t, err)
+	require.Equal(t, expected, o)
+}
+
+func num(v int64) nanojs.Object {
+	return &nanojs.Int{Value: v}
+}
+
+func str(v string) nanojs.Object {
+	return &nanojs.String{Value: v}
+}
+
+func arr(elems ...nanojs.Object) nanojs.Object {
+	return &nanojs.Array{Value: elems}
+}
+
+func obj(kv ...interface{}) nanojs.Object {
+	m := make(map[string]nanojs.Object)
+	for i := 0; i < len(kv); i += 2 {
+		m[kv[i].(string)] = kv[i+1].(nanojs.Object)
+	}
+	return &nanojs.Map{Value: m}
+}
+
+func sortedKeys(m *nanojs.Map) []string {
+	var keys []string
+	for k := range m.Value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,60 @@
+package nanojs
+
+// CostTable represents the gas costs charged by the VM while executing
+// instructions. Every executed opcode is charged Opcodes[op], every call to a
+// builtin function is additionally charged Builtins[name], and every call to
+// a function of a builtin module, e.g. a stdlib module, is additionally
+// charged Funcs["module.name"], e.g. Funcs["text.repeat"]. Use NewCostTable
+// to create a cost table with the default costs.
+type CostTable struct {
+	Opcodes  [256]int64
+	Builtins map[string]int64
+	Funcs    map[string]int64
+}
+
+// NewCostTable creates a cost table where every opcode costs 1 and function
+// calls have no additional cost.
+func NewCostTable() *CostTable {
+	t := &CostTable{
+		Builtins: make(map[string]int64),
+		Funcs:    make(map[string]int64),
+	}
+	for op := range t.Opcodes {
+		t.Opcodes[op] = 1
+	}
+	return t
+}
+
+// Copy creates a copy of the cost table.
+func (t *CostTable) Copy() *CostTable {
+	c := &CostTable{
+		Opcodes:  t.Opcodes,
+		Builtins: make(map[string]int64, len(t.Builtins)),
+		Funcs:    make(map[string]int64, len(t.Funcs)),
+	}
+	for name, cost := range t.Builtins {
+		c.Builtins[name] = cost
+	}
+	for name, cost := range t.Funcs {
+		c.Funcs[name] = cost
+	}
+	return c
+}
+
+// callCost returns the additional cost of the call to the callable object.
+func (t *CostTable) callCost(fn Object) int64 {
+	switch fn := fn.(type) {
+	case *BuiltinFunction:
+		return t.Builtins[fn.Name]
+	case *UserFunction:
+		if fn.moduleAttr == "" {
+			return 0
+		}
+		return t.Funcs[fn.moduleAttr]
+	}
+	return 0
+}
+
+// defaultCostTable is used by the VMs without a custom cost table. It must
+// not be modified.
+var defaultCostTable = NewCostTable()
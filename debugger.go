@@ -0,0 +1,205 @@
+package nanojs
+
+import (
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+// DebugEvent represents an event reported to the debug hook of the VM.
+type DebugEvent int
+
+// List of debug events
+const (
+	// DebugLine is reported before executing the first instruction of a new
+	// source line, or of the same line again after a backward jump (e.g.
+	// the next iteration of a loop).
+	DebugLine DebugEvent = iota
+
+	// DebugCall is reported after entering a compiled function.
+	DebugCall
+
+	// DebugReturn is reported before returning from a compiled function.
+	DebugReturn
+)
+
+// DebugHook is a function that the VM calls on debug events. The execution
+// of the VM is blocked until the hook returns.
+type DebugHook func(v *VM, event DebugEvent)
+
+type stepMode int
+
+const (
+	stepContinue stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// Debugger is a step debugger for the VM. The execution stops at the
+// breakpoints or after a step, and the stop handler is called where the state
+// of the VM can be inspected. The execution resumes when the stop handler
+// returns: it runs until the next breakpoint unless one of the step functions
+// was called.
+type Debugger struct {
+	vm          *VM
+	globals     map[string]int
+	onStop      func(d *Debugger)
+	breakpoints map[string]map[int]bool
+	mode        stepMode
+	depth       int
+}
+
+// NewDebugger creates a Debugger and sets it as the debug hook of the VM.
+// globals maps the names of global variables to their indexes and can be nil.
+func NewDebugger(
+	v *VM,
+	globals map[string]int,
+	onStop func(d *Debugger),
+) *Debugger {
+	d := &Debugger{
+		vm:          v,
+		globals:     globals,
+		onStop:      onStop,
+		breakpoints: make(map[string]map[int]bool),
+	}
+	v.SetDebugHook(d.hook)
+	return d
+}
+
+// SetBreakpoint sets a breakpoint at the line of the file.
+func (d *Debugger) SetBreakpoint(file string, line int) {
+	lines, ok := d.breakpoints[file]
+	if !ok {
+		lines = make(map[int]bool)
+		d.breakpoints[file] = lines
+	}
+	lines[line] = true
+}
+
+// ClearBreakpoint removes the breakpoint at the line of the file.
+func (d *Debugger) ClearBreakpoint(file string, line int) {
+	delete(d.breakpoints[file], line)
+}
+
+// Continue resumes the execution until the next breakpoint.
+func (d *Debugger) Continue() {
+	d.mode = stepContinue
+}
+
+// StepIn resumes the execution and stops at the next line, including the
+// lines of the called functions.
+func (d *Debugger) StepIn() {
+	d.mode = stepIn
+}
+
+// StepOver resumes the execution and stops at the next line of the current
+// function, or of its caller if the function returns.
+func (d *Debugger) StepOver() {
+	d.mode = stepOver
+}
+
+// StepOut resumes the execution and stops at the next line of the caller of
+// the current function.
+func (d *Debugger) StepOut() {
+	d.mode = stepOut
+}
+
+// Position returns the source position where the execution stopped.
+func (d *Debugger) Position() parser.SourceFilePos {
+	v := d.vm
+	return v.fileSet.Position(v.curFrame.fn.SourcePos(v.ip))
+}
+
+// Frames returns the call frames, innermost first.
+func (d *Debugger) Frames() []Frame {
+	return d.vm.callFrames(d.vm.ip)
+}
+
+// Locals returns the local and free variables of the current function.
+func (d *Debugger) Locals() map[string]Object {
+	v := d.vm
+	res := make(map[string]Object)
+	for _, l := range v.curFrame.fn.Locals {
+		if l.Start > v.ip || v.ip >= l.End {
+			continue
+		}
+		if val := d.local(l.Index); val != nil {
+			res[l.Name] = val
+		}
+	}
+	for idx, name := range v.curFrame.fn.FreeNames {
+		if idx < len(v.curFrame.freeVars) {
+			res[name] = *v.curFrame.freeVars[idx].Value
+		}
+	}
+	return res
+}
+
+// Globals returns the global variables.
+func (d *Debugger) Globals() map[string]Object {
+	res := make(map[string]Object)
+	for name, idx := range d.globals {
+		if val := d.vm.globals[idx]; val != nil {
+			res[name] = val
+		}
+	}
+	return res
+}
+
+// Lookup returns the value of the variable identified by the name. Local and
+// free variables of the current function take precedence over the global
+// variables.
+func (d *Debugger) Lookup(name string) (Object, bool) {
+	if val, ok := d.Locals()[name]; ok {
+		return val, true
+	}
+	if idx, ok := d.globals[name]; ok {
+		if val := d.vm.globals[idx]; val != nil {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+func (d *Debugger) local(idx int) Object {
+	v := d.vm
+	sp := v.curFrame.basePointer + idx
+	if v.curFrame == &v.frames[0] || sp >= v.sp {
+		// the main function has no locals, and the locals above the stack
+		// pointer are not initialized yet.
+		return nil
+	}
+	val := v.stack[sp]
+	if ptr, ok := val.(*ObjectPtr); ok {
+		val = *ptr.Value
+	}
+	return val
+}
+
+func (d *Debugger) hook(v *VM, event DebugEvent) {
+	if event != DebugLine {
+		return
+	}
+
+	var stop bool
+	switch d.mode {
+	case stepIn:
+		stop = true
+	case stepOver:
+		stop = v.framesIndex <= d.depth
+	case stepOut:
+		stop = v.framesIndex < d.depth
+	}
+	if !stop {
+		pos := d.Position()
+		stop = d.breakpoints[pos.Filename][pos.Line]
+	}
+	if !stop {
+		return
+	}
+
+	d.mode = stepContinue
+	d.depth = v.framesIndex
+	if d.onStop != nil {
+		d.onStop(d)
+	}
+}
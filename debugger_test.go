@@ -0,0 +1,167 @@
+package nanojs_test
+
+import (
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/parser"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+const debuggerTestSrc = `
+add = function(a, b) {
+	c = a + b
+	return c
+}
+x = 1
+y = add(x, 2)
+for (i = 0; i < 2; i++) { x += i }
+z = add(y, x)
+`
+
+func TestDebugger(t *testing.T) {
+	// step in
+	lines := debuggerRun(t, func(d *nanojs.Debugger) { d.StepIn() })
+	require.Equal(t, []int{2, 6, 7, 3, 4, 8, 8, 8, 9, 3, 4}, lines)
+
+	// step over
+	lines = debuggerRun(t, func(d *nanojs.Debugger) { d.StepOver() })
+	require.Equal(t, []int{2, 6, 7, 8, 8, 8, 9}, lines)
+
+	// breakpoint and step out
+	var locals []nanojs.Object
+	var frames [][]nanojs.Frame
+	lines = debuggerRun(t, func(d *nanojs.Debugger) {
+		if d.Position().Line == 4 {
+			locals = append(locals, &nanojs.Map{Value: d.Locals()})
+			frames = append(frames, d.Frames())
+			d.StepOut()
+		}
+	}, 4)
+	require.Equal(t, []int{2, 4, 8, 4}, lines)
+	require.Equal(t, []nanojs.Object{
+		&nanojs.Map{Value: map[string]nanojs.Object{
+			"a": &nanojs.Int{Value: 1},
+			"b": &nanojs.Int{Value: 2},
+			"c": &nanojs.Int{Value: 3},
+		}},
+		&nanojs.Map{Value: map[string]nanojs.Object{
+			"a": &nanojs.Int{Value: 3},
+			"b": &nanojs.Int{Value: 2},
+			"c": &nanojs.Int{Value: 5},
+		}},
+	}, locals)
+	require.Equal(t, 2, len(frames[0]))
+	require.Equal(t, "add", frames[0][0].Name)
+	require.Equal(t, 4, frames[0][0].Pos.Line)
+	require.Equal(t, "", frames[0][1].Name)
+	require.Equal(t, 7, frames[0][1].Pos.Line)
+
+	// lookup
+	var values []nanojs.Object
+	debuggerRun(t, func(d *nanojs.Debugger) {
+		if d.Position().Line == 3 {
+			a, _ := d.Lookup("a")
+			x, _ := d.Lookup("x")
+			_, ok := d.Lookup("unknown")
+			require.False(t, ok)
+			values = append(values, a, x)
+			require.Equal(t, x, d.Globals()["x"])
+		}
+	}, 3)
+	require.Equal(t, []nanojs.Object{
+		&nanojs.Int{Value: 1}, &nanojs.Int{Value: 1},
+		&nanojs.Int{Value: 3}, &nanojs.Int{Value: 2},
+	}, values)
+}
+
+func TestDebugger_SiblingBlocks(t *testing.T) {
+	// the sibling blocks share the index of their locals
+	src := `
+f = function() {
+	if (true) {
+		a = 1
+		x = a
+	}
+	if (true) {
+		b = 2
+		x = b
+	}
+}
+f()
+`
+	var locals []nanojs.Object
+	debuggerRunSource(t, src, func(d *nanojs.Debugger) {
+		switch d.Position().Line {
+		case 5:
+			a, ok := d.Lookup("a")
+			require.True(t, ok)
+			require.Equal(t, &nanojs.Int{Value: 1}, a)
+			_, ok = d.Lookup("b")
+			require.False(t, ok)
+		case 9:
+			_, ok := d.Lookup("a")
+			require.False(t, ok)
+		default:
+			return
+		}
+		locals = append(locals, &nanojs.Map{Value: d.Locals()})
+	}, 5, 9)
+	require.Equal(t, []nanojs.Object{
+		&nanojs.Map{Value: map[string]nanojs.Object{
+			"a": &nanojs.Int{Value: 1},
+		}},
+		&nanojs.Map{Value: map[string]nanojs.Object{
+			"b": &nanojs.Int{Value: 2},
+		}},
+	}, locals)
+}
+
+// debuggerRun runs debuggerTestSrc with the breakpoints on the lines and
+// returns the lines where the execution stopped. The execution stops at the
+// first line.
+func debuggerRun(
+	t *testing.T,
+	onStop func(d *nanojs.Debugger),
+	breakpoints ...int,
+) (lines []int) {
+	return debuggerRunSource(t, debuggerTestSrc, onStop, breakpoints...)
+}
+
+// debuggerRunSource is like debuggerRun but runs the source.
+func debuggerRunSource(
+	t *testing.T,
+	src string,
+	onStop func(d *nanojs.Debugger),
+	breakpoints ...int,
+) (lines []int) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("test", -1, len(src))
+	p := parser.NewParser(srcFile, []byte(src), nil)
+	file, err := p.ParseFile()
+	require.NoError(t, err)
+
+	symbolTable := nanojs.NewSymbolTable()
+	c := nanojs.NewCompiler(srcFile, symbolTable, nil, nil, nil)
+	require.NoError(t, c.Compile(file))
+
+	globals := make(map[string]int)
+	for _, name := range symbolTable.Names() {
+		symbol, _, _ := symbolTable.Resolve(name)
+		if symbol.Scope == nanojs.ScopeGlobal {
+			globals[name] = symbol.Index
+		}
+	}
+
+	v := nanojs.NewVM(c.Bytecode(), nil, -1)
+	d := nanojs.NewDebugger(v, globals, func(d *nanojs.Debugger) {
+		lines = append(lines, d.Position().Line)
+		onStop(d)
+	})
+	for _, line := range breakpoints {
+		d.SetBreakpoint("test", line)
+	}
+	d.StepIn()
+	require.NoError(t, v.Run())
+	return
+}
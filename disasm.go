@@ -0,0 +1,348 @@
+package nanojs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
+	"github.com/zeaphoo/nanojs/v2/token"
+)
+
+// maxDisasmValueLen is the maximum length of the constant values shown in the
+// disassembly.
+const maxDisasmValueLen = 48
+
+// Disassembly is a human and machine readable listing of the compiled
+// functions of a bytecode.
+type Disassembly struct {
+	Functions []*DisasmFunction `json:"functions"`
+}
+
+// DisasmFunction is a disassembled compiled function.
+type DisasmFunction struct {
+	// Name is "main" for the main function, or the name of the function.
+	Name string `json:"name"`
+	// Constant is the index of the function in the constants, or -1 for the
+	// main function.
+	Constant      int                  `json:"constant"`
+	File          string               `json:"file,omitempty"`
+	NumLocals     int                  `json:"numLocals"`
+	NumParameters int                  `json:"numParameters"`
+	VarArgs       bool                 `json:"varArgs"`
+	Locals        []DisasmLocal        `json:"locals,omitempty"`
+	FreeNames     []string             `json:"freeNames,omitempty"`
+	Instructions  []*DisasmInstruction `json:"instructions"`
+}
+
+// DisasmLocal is a local variable of a disassembled function, which is in
+// scope from the instruction at the Start offset up to the End offset.
+type DisasmLocal struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// DisasmInstruction is a disassembled instruction.
+type DisasmInstruction struct {
+	Offset   int    `json:"offset"`
+	Opcode   string `json:"opcode"`
+	Operands []int  `json:"operands"`
+	// Label is the label of the instruction if it's a jump target.
+	Label string `json:"label,omitempty"`
+	// Comment describes the operands: the constant value, the variable name,
+	// the operator or the label of the jump target.
+	Comment string `json:"comment,omitempty"`
+	// Position is the source position of the instruction.
+	Position string `json:"position,omitempty"`
+	// Source is the source line of the instruction if the instruction starts
+	// a new line.
+	Source string `json:"source,omitempty"`
+
+	op parser.Opcode
+}
+
+// Disassemble disassembles the main function and the compiled functions in
+// the constants of the bytecode, which include the closures and the imported
+// source modules. The source code of the files (by the file names of the
+// FileSet) are used to show the source lines of the instructions, and the
+// global variable indexes by name are used to annotate the globals. Both can
+// be nil. The globals sharing an index, such as the globals of different
+// blocks, are not annotated as the name depends on the block.
+func Disassemble(
+	b *Bytecode,
+	sources map[string][]byte,
+	globals map[string]int,
+) *Disassembly {
+	d := &disassembler{
+		bytecode: b,
+		sources:  make(map[string][][]byte),
+		globals:  make(map[int]string),
+	}
+	for name, src := range sources {
+		d.sources[name] = bytes.Split(src, []byte("\n"))
+	}
+	ambiguous := make(map[int]bool)
+	for name, idx := range globals {
+		if prev, ok := d.globals[idx]; ok && prev != name {
+			ambiguous[idx] = true
+		}
+		d.globals[idx] = name
+	}
+	for idx := range ambiguous {
+		delete(d.globals, idx)
+	}
+
+	res := &Disassembly{}
+	res.Functions = append(res.Functions,
+		d.function(b.MainFunction, "main", -1))
+	for i, c := range b.Constants {
+		if fn, ok := c.(*CompiledFunction); ok {
+			res.Functions = append(res.Functions, d.function(fn, fn.Name, i))
+		}
+	}
+	return res
+}
+
+// WriteTo writes the text listing of the disassembly to the writer.
+func (d *Disassembly) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for i, fn := range d.Functions {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		name := fn.Name
+		if name == "" {
+			name = "(anonymous)"
+		}
+		if fn.Constant >= 0 {
+			fmt.Fprintf(&buf, "function %s [constant %d]", name,
+				fn.Constant)
+		} else {
+			fmt.Fprintf(&buf, "function %s", name)
+		}
+		if fn.File != "" {
+			fmt.Fprintf(&buf, " (%s)", fn.File)
+		}
+		fmt.Fprintf(&buf, "\n  locals: %d, parameters: %d", fn.NumLocals,
+			fn.NumParameters)
+		if fn.VarArgs {
+			buf.WriteString(" (variadic)")
+		}
+		if len(fn.FreeNames) > 0 {
+			fmt.Fprintf(&buf, ", free: %s", strings.Join(fn.FreeNames, ", "))
+		}
+		buf.WriteString("\n")
+
+		for _, inst := range fn.Instructions {
+			if inst.Source != "" {
+				fmt.Fprintf(&buf, "  ; %s\t%s\n", inst.Position,
+					strings.TrimSpace(inst.Source))
+			}
+			if inst.Label != "" {
+				fmt.Fprintf(&buf, "%s:\n", inst.Label)
+			}
+			operands := make([]string, len(inst.Operands))
+			for j, o := range inst.Operands {
+				operands[j] = fmt.Sprint(o)
+			}
+			line := fmt.Sprintf("  %04d %-8s %s", inst.Offset, inst.Opcode,
+				strings.Join(operands, " "))
+			if inst.Comment != "" {
+				line = fmt.Sprintf("%-30s ; %s", line, inst.Comment)
+			}
+			buf.WriteString(strings.TrimRight(line, " "))
+			buf.WriteString("\n")
+		}
+	}
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// disassembler disassembles the compiled functions of a bytecode.
+type disassembler struct {
+	bytecode *Bytecode
+	sources  map[string][][]byte // source lines by file name
+	globals  map[int]string
+}
+
+func (d *disassembler) function(
+	fn *CompiledFunction,
+	name string,
+	constIdx int,
+) *DisasmFunction {
+	res := &DisasmFunction{
+		Name:          name,
+		Constant:      constIdx,
+		NumLocals:     fn.NumLocals,
+		NumParameters: fn.NumParameters,
+		VarArgs:       fn.VarArgs,
+		FreeNames:     fn.FreeNames,
+	}
+	for _, l := range fn.Locals {
+		res.Locals = append(res.Locals, DisasmLocal(l))
+	}
+
+	// decode the instructions and label the jump targets
+	insts := fn.Instructions
+	var targets []int
+	for ip := 0; ip < len(insts); {
+		op := insts[ip]
+		if int(op) >= len(parser.OpcodeOperands) {
+			res.Instructions = append(res.Instructions, &DisasmInstruction{
+				Offset: ip,
+				Opcode: fmt.Sprintf("?%d", op),
+			})
+			break
+		}
+		operands, read := readOperands(parser.OpcodeOperands[op],
+			insts[ip+1:])
+		if operands == nil {
+			operands = []int{}
+		}
+		res.Instructions = append(res.Instructions, &DisasmInstruction{
+			Offset:   ip,
+			Opcode:   parser.OpcodeNames[op],
+			Operands: operands,
+			op:       op,
+		})
+		switch op {
+		case parser.OpJump, parser.OpJumpFalsy, parser.OpAndJump,
+			parser.OpOrJump:
+			if len(operands) > 0 {
+				targets = append(targets, operands[0])
+			}
+		}
+		ip += 1 + read
+	}
+	sort.Ints(targets)
+	labels := make(map[int]string)
+	for _, target := range targets {
+		if _, ok := labels[target]; !ok {
+			labels[target] = fmt.Sprintf("L%d", len(labels)+1)
+		}
+	}
+
+	var lastFile string
+	var lastLine int
+	for _, inst := range res.Instructions {
+		inst.Label = labels[inst.Offset]
+		inst.Comment = d.comment(fn, inst, labels)
+
+		pos := fn.SourceMap[inst.Offset]
+		if pos == parser.NoPos || d.bytecode.FileSet == nil {
+			continue
+		}
+		filePos := d.bytecode.FileSet.Position(pos)
+		if !filePos.IsValid() {
+			continue
+		}
+		inst.Position = filePos.String()
+		if res.File == "" {
+			res.File = filePos.Filename
+		}
+		if filePos.Filename != lastFile || filePos.Line != lastLine {
+			lastFile, lastLine = filePos.Filename, filePos.Line
+			lines := d.sources[filePos.Filename]
+			if filePos.Line <= len(lines) {
+				inst.Source = string(lines[filePos.Line-1])
+			}
+		}
+	}
+	return res
+}
+
+// comment describes the operands of the instruction.
+func (d *disassembler) comment(
+	fn *CompiledFunction,
+	inst *DisasmInstruction,
+	labels map[int]string,
+) string {
+	if len(inst.Operands) == 0 {
+		return ""
+	}
+	idx := inst.Operands[0]
+	switch inst.op {
+	case parser.OpConstant, parser.OpClosure:
+		if idx < len(d.bytecode.Constants) {
+			return d.constant(idx)
+		}
+	case parser.OpGetGlobal, parser.OpSetGlobal, parser.OpSetSelGlobal:
+		return d.globals[idx]
+	case parser.OpGetLocal, parser.OpSetLocal, parser.OpDefineLocal,
+		parser.OpSetSelLocal, parser.OpGetLocalPtr:
+		if inst.op == parser.OpDefineLocal {
+			// the scope of the variable starts after its definition, and
+			// it's empty if the block ends there
+			next := inst.Offset + 1 + parser.OpcodeOperands[inst.op][0]
+			for _, l := range fn.Locals {
+				if l.Index == idx && l.Start == next {
+					return l.Name
+				}
+			}
+			return ""
+		}
+		return fn.LocalName(idx, inst.Offset)
+	case parser.OpGetFree, parser.OpSetFree, parser.OpSetSelFree,
+		parser.OpGetFreePtr:
+		if idx < len(fn.FreeNames) {
+			return fn.FreeNames[idx]
+		}
+	case parser.OpGetBuiltin:
+		if idx < len(builtinFuncs) {
+			return builtinFuncs[idx].Name
+		}
+	case parser.OpBinaryOp:
+		return token.Token(idx).String()
+	case parser.OpJump, parser.OpJumpFalsy, parser.OpAndJump,
+		parser.OpOrJump:
+		return "-> " + labels[idx]
+	}
+	return ""
+}
+
+// constant returns the short description of the constant.
+func (d *disassembler) constant(idx int) string {
+	switch c := d.bytecode.Constants[idx].(type) {
+	case *CompiledFunction:
+		if c.Name != "" {
+			return fmt.Sprintf("<function %s [constant %d]>", c.Name, idx)
+		}
+		return fmt.Sprintf("<function [constant %d]>", idx)
+	case *ImmutableMap:
+		if name := inferModuleName(c); name != "" {
+			return fmt.Sprintf("<module %s>", name)
+		}
+		return shortValue(c.String())
+	default:
+		return shortValue(c.String())
+	}
+}
+
+// readOperands reads the operands like parser.ReadOperands does, but stops at
+// the end of the truncated instructions.
+func readOperands(widths []int, ins []byte) (operands []int, offset int) {
+	for _, width := range widths {
+		if offset+width > len(ins) {
+			return operands, len(ins)
+		}
+		switch width {
+		case 1:
+			operands = append(operands, int(ins[offset]))
+		case 2:
+			operands = append(operands, int(ins[offset+1])|int(ins[offset])<<8)
+		}
+		offset += width
+	}
+	return
+}
+
+func shortValue(s string) string {
+	if r := []rune(s); len(r) > maxDisasmValueLen {
+		return string(r[:maxDisasmValueLen-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,135 @@
+package nanojs_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/parser"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+const disasmTestSrc = `f = function(a) {
+	b = a || 1
+	return function() { return b }
+}
+x = f(len("abc"))`
+
+func TestDisassemble(t *testing.T) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("test", -1, len(disasmTestSrc))
+	p := parser.NewParser(srcFile, []byte(disasmTestSrc), nil)
+	file, err := p.ParseFile()
+	require.NoError(t, err)
+
+	symbolTable := nanojs.NewSymbolTable()
+	c := nanojs.NewCompiler(srcFile, symbolTable, nil, nil, nil)
+	require.NoError(t, c.Compile(file))
+	globals := map[string]int{"f": 0, "x": 1}
+
+	disasm := nanojs.Disassemble(c.Bytecode(),
+		map[string][]byte{"test": []byte(disasmTestSrc)}, globals)
+	var buf bytes.Buffer
+	_, err = disasm.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, `function main (test)
+  locals: 0, parameters: 0
+  ; test:1:5	f = function(a) {
+  0000 CONST    2              ; <function f [constant 2]>
+  0003 SETG     0              ; f
+  ; test:5:5	x = f(len("abc"))
+  0006 GETG     0              ; f
+  0009 BUILTIN  0              ; len
+  0011 CONST    3              ; "abc"
+  0014 CALL     1 0
+  0017 CALL     1 0
+  0020 SETG     1              ; x
+  0023 SUSPEND
+
+function (anonymous) [constant 1] (test)
+  locals: 0, parameters: 0, free: b
+  ; test:3:29	return function() { return b }
+  0000 GETF     0              ; b
+  0002 RET      1
+
+function f [constant 2] (test)
+  locals: 2, parameters: 1
+  ; test:2:6	b = a || 1
+  0000 GETL     0              ; a
+  0002 ORJMP    8              ; -> L1
+  0005 CONST    0              ; 1
+L1:
+  0008 DEFL     1              ; b
+  ; test:3:9	return function() { return b }
+  0010 GETLP    1              ; b
+  0012 CLOSURE  1 1            ; <function [constant 1]>
+  0016 RET      1
+`, buf.String())
+
+	data, err := json.Marshal(disasm)
+	require.NoError(t, err)
+	var decoded nanojs.Disassembly
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, 3, len(decoded.Functions))
+	fn := decoded.Functions[2]
+	require.Equal(t, "f", fn.Name)
+	require.Equal(t, 2, fn.Constant)
+	require.Equal(t, 2, len(fn.Locals))
+	require.True(t, fn.Locals[0] == nanojs.DisasmLocal{
+		Name: "a", Index: 0, Start: 0, End: 18}, fn.Locals[0])
+	require.True(t, fn.Locals[1] == nanojs.DisasmLocal{
+		Name: "b", Index: 1, Start: 10, End: 18}, fn.Locals[1])
+	inst := fn.Instructions[3]
+	require.Equal(t, "DEFL", inst.Opcode)
+	require.Equal(t, []int{1}, inst.Operands)
+	require.Equal(t, "L1", inst.Label)
+	require.Equal(t, "b", inst.Comment)
+	require.Equal(t, "test:2:2", inst.Position)
+	require.Equal(t, "-> L1", fn.Instructions[1].Comment)
+	require.Equal(t, "\tb = a || 1", fn.Instructions[0].Source)
+}
+
+func TestDisassemble_SharedGlobals(t *testing.T) {
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("test", -1, len(disasmTestSrc))
+	p := parser.NewParser(srcFile, []byte(disasmTestSrc), nil)
+	file, err := p.ParseFile()
+	require.NoError(t, err)
+	c := nanojs.NewCompiler(srcFile, nil, nil, nil, nil)
+	require.NoError(t, c.Compile(file))
+
+	// the globals sharing an index are not annotated
+	disasm := nanojs.Disassemble(c.Bytecode(), nil,
+		map[string]int{"f": 0, "g": 0, "x": 1})
+	main := disasm.Functions[0]
+	require.Equal(t, "SETG", main.Instructions[1].Opcode)
+	require.Equal(t, "", main.Instructions[1].Comment)
+	require.Equal(t, "SETG", main.Instructions[7].Opcode)
+	require.Equal(t, "x", main.Instructions[7].Comment)
+}
+
+func TestDisassemble_SiblingBlocks(t *testing.T) {
+	src := `f = function() {
+	if (true) { a = 1; x = a }
+	if (true) { b = 2 }
+}`
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("test", -1, len(src))
+	p := parser.NewParser(srcFile, []byte(src), nil)
+	file, err := p.ParseFile()
+	require.NoError(t, err)
+	c := nanojs.NewCompiler(srcFile, nil, nil, nil, nil)
+	require.NoError(t, c.Compile(file))
+
+	// the locals sharing an index are annotated with the names in scope
+	disasm := nanojs.Disassemble(c.Bytecode(), nil, nil)
+	var comments []string
+	for _, inst := range disasm.Functions[1].Instructions {
+		switch inst.Opcode {
+		case "DEFL", "GETL":
+			comments = append(comments, inst.Comment)
+		}
+	}
+	require.Equal(t, []string{"a", "a", "x", "b"}, comments)
+}
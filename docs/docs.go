@@ -0,0 +1,11 @@
+// Package docs embeds the Nanojs documentation, so the tools like the
+// language server can show the descriptions of the builtin functions and the
+// standard library modules.
+package docs
+
+import "embed"
+
+// Files are the markdown documents.
+//
+//go:embed *.md
+var Files embed.FS
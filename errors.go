@@ -3,6 +3,9 @@ package nanojs
 import (
 	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
 )
 
 var (
@@ -12,6 +15,12 @@ var (
 	// ErrObjectAllocLimit is an objects allocation limit error.
 	ErrObjectAllocLimit = errors.New("object allocation limit exceeded")
 
+	// ErrInstructionLimit is an instruction (gas) limit error.
+	ErrInstructionLimit = errors.New("instruction limit exceeded")
+
+	// ErrMemoryLimit is a memory (bytes) limit error.
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+
 	// ErrIndexOutOfBounds is an error where a given index is out of the
 	// bounds.
 	ErrIndexOutOfBounds = errors.New("index out of bounds")
@@ -49,6 +58,22 @@ var (
 	// ErrNotImplemented is an error where an Object has not implemented a
 	// required method.
 	ErrNotImplemented = errors.New("not implemented")
+
+	// ErrInvalidBytecode is an error where the encoded bytecode is malformed.
+	ErrInvalidBytecode = errors.New("invalid bytecode")
+
+	// ErrBytecodeVersion is an error where the encoded bytecode was produced
+	// for an unsupported format or opcode set version.
+	ErrBytecodeVersion = errors.New("unsupported bytecode version")
+
+	// ErrSuspend is returned by a function called by the script to suspend
+	// the execution at the call. The VM returns ErrSuspend, and the execution
+	// can be snapshotted with VM.Snapshot and continued with VM.Resume.
+	ErrSuspend = errors.New("execution suspended")
+
+	// ErrInvalidSnapshot is an error where the VM snapshot is malformed, or
+	// it was taken for a different bytecode.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
 )
 
 // ErrInvalidArgumentType represents an invalid argument value type error.
@@ -62,3 +87,48 @@ func (e ErrInvalidArgumentType) Error() string {
 	return fmt.Sprintf("invalid type for argument '%s': expected %s, found %s",
 		e.Name, e.Expected, e.Found)
 }
+
+// Frame represents a function call frame of a RuntimeError.
+type Frame struct {
+	// Name is the name of the function, or empty for anonymous functions and
+	// the main function.
+	Name string
+
+	// Pos is the source position where the frame was executing.
+	Pos parser.SourceFilePos
+}
+
+func (f Frame) String() string {
+	if f.Name == "" {
+		return f.Pos.String()
+	}
+	return fmt.Sprintf("%s (%s)", f.Name, f.Pos)
+}
+
+// RuntimeError represents an error that occurred while running the script.
+type RuntimeError struct {
+	// Err is the underlying error.
+	Err error
+
+	// Value is the error as a script error value.
+	Value Object
+
+	// Frames is the call stack at the time of the error, innermost first.
+	Frames []Frame
+}
+
+func (e *RuntimeError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("Runtime Error: ")
+	sb.WriteString(e.Err.Error())
+	for _, f := range e.Frames {
+		sb.WriteString("\n\tat ")
+		sb.WriteString(f.String())
+	}
+	return sb.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,549 @@
+// Package format implements the canonical formatting of the Nanojs source
+// code.
+//
+// The formatted code is indented with tabs, has a single space around the
+// binary operators and after the commas, and has one statement per line
+// without semicolons. The blank lines between the statements are kept (but
+// at most one in a row), as well as the line breaks between the elements of
+// the array literals, map literals and call arguments, and after the binary
+// operators. The comments are kept on their own lines or at the end of the
+// lines. Formatting the formatted code again doesn't change it.
+package format
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
+	"github.com/zeaphoo/nanojs/v2/token"
+)
+
+// Source formats the Nanojs source code. It returns the parse errors if the
+// source code is invalid. The "#!" line at the beginning of the source code
+// is kept.
+func Source(src []byte) ([]byte, error) {
+	shebang := len(src) > 1 && string(src[:2]) == "#!"
+	if shebang {
+		src = append([]byte("//"), src[2:]...)
+	}
+
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile("", -1, len(src))
+	p := parser.NewParser(srcFile, src, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	res := File(file)
+	if shebang {
+		copy(res, "#!")
+	}
+	return res, nil
+}
+
+// File formats the parsed file including its comments.
+func File(file *parser.File) []byte {
+	p := &printer{file: file.InputFile}
+	for _, group := range file.Comments {
+		p.comments = append(p.comments, group.List...)
+	}
+	p.stmtList(file.Stmts, file.End())
+	return p.buf.Bytes()
+}
+
+// printer prints the AST nodes and interleaves the comments by their
+// positions. The comments inside a statement that are not at the line breaks
+// of a block or a list are moved to the end of the statement.
+type printer struct {
+	file          *parser.SourceFile
+	buf           bytes.Buffer
+	indent        int
+	pendingIndent bool
+	comments      []*parser.Comment
+	next          int // index of the next comment to print
+	line          int // source line of the last printed node, 0 if none
+}
+
+func (p *printer) write(s string) {
+	if p.pendingIndent {
+		p.buf.WriteString(strings.Repeat("\t", p.indent))
+		p.pendingIndent = false
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.pendingIndent = true
+}
+
+func (p *printer) lineOf(pos parser.Pos) int {
+	return p.file.Position(pos).Line
+}
+
+// blankLine writes a blank line if the source line is separated from the
+// last printed node by blank lines.
+func (p *printer) blankLine(line int) {
+	if p.line > 0 && line > p.line+1 {
+		p.buf.WriteByte('\n')
+	}
+}
+
+func (p *printer) hasComment(before parser.Pos) bool {
+	return p.next < len(p.comments) && p.comments[p.next].Pos() < before
+}
+
+func (p *printer) comment(c *parser.Comment) {
+	text := c.Text
+	if strings.HasPrefix(text, "//") {
+		text = strings.TrimRight(text, " \t")
+	}
+	p.write(text)
+	p.next++
+	if line := p.lineOf(c.End()); line > p.line {
+		p.line = line
+	}
+}
+
+// leadingComments prints the comments before the position on their own
+// lines.
+func (p *printer) leadingComments(before parser.Pos) {
+	for p.hasComment(before) {
+		c := p.comments[p.next]
+		p.blankLine(p.lineOf(c.Pos()))
+		p.comment(c)
+		p.newline()
+	}
+}
+
+// trailingComments prints the comments before the end position, and the
+// comments on the line of the end position before the limit, at the end of
+// the current line.
+func (p *printer) trailingComments(end, limit parser.Pos) {
+	endLine := p.lineOf(end)
+	lineComment := false
+	for p.next < len(p.comments) {
+		c := p.comments[p.next]
+		if c.Pos() >= end &&
+			(c.Pos() >= limit || p.lineOf(c.Pos()) != endLine) {
+			break
+		}
+		if lineComment {
+			// nothing can follow a //-style comment on the same line
+			p.newline()
+		} else if !p.pendingIndent {
+			p.write(" ")
+		}
+		p.comment(c)
+		lineComment = strings.HasPrefix(c.Text, "//")
+	}
+}
+
+func (p *printer) stmtList(stmts []parser.Stmt, end parser.Pos) {
+	var list []parser.Stmt
+	for _, s := range stmts {
+		if _, ok := s.(*parser.EmptyStmt); !ok {
+			list = append(list, s)
+		}
+	}
+	for i, s := range list {
+		p.leadingComments(s.Pos())
+		p.blankLine(p.lineOf(s.Pos()))
+		p.stmt(s)
+		limit := end
+		if i+1 < len(list) {
+			limit = list[i+1].Pos()
+		}
+		p.line = p.lineOf(s.End())
+		p.trailingComments(s.End(), limit)
+		p.newline()
+	}
+	p.leadingComments(end)
+}
+
+func (p *printer) block(b *parser.BlockStmt) {
+	p.write("{")
+	if !p.hasComment(b.RBrace) && !hasStmts(b.Stmts) {
+		p.write("}")
+		return
+	}
+	first := b.RBrace
+	for _, s := range b.Stmts {
+		if _, ok := s.(*parser.EmptyStmt); !ok {
+			first = s.Pos()
+			break
+		}
+	}
+	p.trailingComments(b.LBrace+1, first)
+	p.indent++
+	p.newline()
+	p.line = 0
+	p.stmtList(b.Stmts, b.RBrace)
+	p.indent--
+	p.write("}")
+	p.line = p.lineOf(b.RBrace)
+}
+
+func hasStmts(stmts []parser.Stmt) bool {
+	for _, s := range stmts {
+		if _, ok := s.(*parser.EmptyStmt); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) stmt(s parser.Stmt) {
+	switch s := s.(type) {
+	case *parser.AssignStmt:
+		p.exprList(s.LHS)
+		p.write(" " + s.Token.String() + " ")
+		p.exprList(s.RHS)
+	case *parser.ExprStmt:
+		p.expr(s.Expr)
+	case *parser.IncDecStmt:
+		p.expr(s.Expr)
+		p.write(s.Token.String())
+	case *parser.ReturnStmt:
+		p.write("return")
+		if s.Result != nil {
+			p.write(" ")
+			p.expr(s.Result)
+		}
+	case *parser.BranchStmt:
+		p.write(s.Token.String())
+		if s.Label != nil {
+			p.write(" " + s.Label.Name)
+		}
+	case *parser.IfStmt:
+		p.write("if ")
+		if s.Init != nil {
+			p.stmt(s.Init)
+			p.write("; ")
+			p.expr(s.Cond)
+		} else {
+			p.parenExpr(s.Cond)
+		}
+		p.write(" ")
+		p.block(s.Body)
+		if s.Else != nil {
+			p.write(" else ")
+			if b, ok := s.Else.(*parser.BlockStmt); ok {
+				p.block(b)
+			} else {
+				p.stmt(s.Else)
+			}
+		}
+	case *parser.ForStmt:
+		p.write("for ")
+		if s.Init != nil || s.Post != nil {
+			p.write("(")
+			if s.Init != nil {
+				p.stmt(s.Init)
+			}
+			p.write(";")
+			if s.Cond != nil {
+				p.write(" ")
+				p.expr(s.Cond)
+			}
+			p.write(";")
+			if s.Post != nil {
+				p.write(" ")
+				p.stmt(s.Post)
+			}
+			p.write(") ")
+		} else if s.Cond != nil {
+			p.parenExpr(s.Cond)
+			p.write(" ")
+		}
+		p.block(s.Body)
+	case *parser.ForInStmt:
+		p.write("for (" + s.Key.Name + " in ")
+		p.expr(s.Iterable)
+		p.write(") ")
+		p.block(s.Body)
+	case *parser.ForOfStmt:
+		p.write("for (" + s.Key.Name + " of ")
+		p.expr(s.Iterable)
+		p.write(") ")
+		p.block(s.Body)
+	case *parser.ExportStmt:
+		p.write("export ")
+		p.expr(s.Result)
+	case *parser.ExportDecl:
+		p.exportDecl(s)
+	case *parser.ImportDecl:
+		p.importDecl(s)
+	case *parser.BlockStmt:
+		p.block(s)
+	default:
+		p.write(s.String())
+	}
+}
+
+func (p *printer) exportDecl(s *parser.ExportDecl) {
+	switch {
+	case s.Default != nil:
+		p.write("export default ")
+		p.expr(s.Default)
+	case s.Value != nil:
+		if fn, ok := s.Value.(*parser.FuncLit); ok {
+			p.write("export function " + s.Name.Name)
+			p.params(fn.Type.Params)
+			p.write(" ")
+			p.block(fn.Body)
+			return
+		}
+		p.write("export const " + s.Name.Name + " = ")
+		p.expr(s.Value)
+	default:
+		var specs []string
+		for _, spec := range s.Specs {
+			specs = append(specs, spec.String())
+		}
+		p.write("export {" + strings.Join(specs, ", ") + "}")
+	}
+}
+
+func (p *printer) importDecl(s *parser.ImportDecl) {
+	var names []string
+	if s.Default != nil {
+		names = append(names, s.Default.Name)
+	}
+	if s.Namespace != nil {
+		names = append(names, "* as "+s.Namespace.Name)
+	}
+	if s.LBrace.IsValid() {
+		var specs []string
+		for _, spec := range s.Specs {
+			specs = append(specs, spec.String())
+		}
+		names = append(names, "{"+strings.Join(specs, ", ")+"}")
+	}
+	p.write("import " + strings.Join(names, ", ") + " from " +
+		s.Module.Literal)
+}
+
+// parenExpr prints the condition of the if and for statements in
+// parentheses.
+func (p *printer) parenExpr(x parser.Expr) {
+	if _, ok := x.(*parser.ParenExpr); ok {
+		p.expr(x)
+		return
+	}
+	p.write("(")
+	p.expr(x)
+	p.write(")")
+}
+
+func (p *printer) exprList(list []parser.Expr) {
+	for i, x := range list {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) expr(x parser.Expr) {
+	switch x := x.(type) {
+	case *parser.Ident:
+		p.write(x.Name)
+	case *parser.IntLit:
+		p.write(x.Literal)
+	case *parser.FloatLit:
+		p.write(x.Literal)
+	case *parser.CharLit:
+		p.write(x.Literal)
+	case *parser.StringLit:
+		p.write(x.Literal)
+	case *parser.BoolLit:
+		p.write(x.Literal)
+	case *parser.UndefinedLit:
+		p.write("undefined")
+	case *parser.BinaryExpr:
+		p.expr(x.LHS)
+		p.write(" " + x.Token.String())
+		if p.lineOf(x.RHS.Pos()) > p.lineOf(x.TokenPos) {
+			// keep the line break after the operator
+			p.indent++
+			p.newline()
+			p.expr(x.RHS)
+			p.indent--
+			return
+		}
+		p.write(" ")
+		p.expr(x.RHS)
+	case *parser.UnaryExpr:
+		p.write(x.Token.String())
+		if y, ok := x.Expr.(*parser.UnaryExpr); ok &&
+			(x.Token == token.Add || x.Token == token.Sub) &&
+			(y.Token == token.Add || y.Token == token.Sub) {
+			// separate "- -x" so it's not scanned as "--"
+			p.write(" ")
+		}
+		p.expr(x.Expr)
+	case *parser.ParenExpr:
+		p.write("(")
+		p.expr(x.Expr)
+		p.write(")")
+	case *parser.CondExpr:
+		p.expr(x.Cond)
+		p.write(" ? ")
+		p.expr(x.True)
+		p.write(" : ")
+		p.expr(x.False)
+	case *parser.SelectorExpr:
+		p.expr(x.Expr)
+		p.write(".")
+		if sel, ok := x.Sel.(*parser.StringLit); ok {
+			p.write(sel.Value)
+		} else {
+			p.expr(x.Sel)
+		}
+	case *parser.IndexExpr:
+		p.expr(x.Expr)
+		p.write("[")
+		p.expr(x.Index)
+		p.write("]")
+	case *parser.SliceExpr:
+		p.expr(x.Expr)
+		p.write("[")
+		if x.Low != nil {
+			p.expr(x.Low)
+		}
+		p.write(":")
+		if x.High != nil {
+			p.expr(x.High)
+		}
+		p.write("]")
+	case *parser.CallExpr:
+		p.expr(x.Func)
+		p.list("(", x.LParen, len(x.Args), func(i int) parser.Node {
+			return x.Args[i]
+		}, func(i int) {
+			p.expr(x.Args[i])
+			if i == len(x.Args)-1 && x.Ellipsis.IsValid() {
+				p.write("...")
+			}
+		}, ")", x.RParen)
+	case *parser.ArrayLit:
+		p.list("[", x.LBrack, len(x.Elements), func(i int) parser.Node {
+			return x.Elements[i]
+		}, func(i int) {
+			p.expr(x.Elements[i])
+		}, "]", x.RBrack)
+	case *parser.MapLit:
+		p.list("{", x.LBrace, len(x.Elements), func(i int) parser.Node {
+			return x.Elements[i]
+		}, func(i int) {
+			p.write(mapKey(x.Elements[i].Key) + ": ")
+			p.expr(x.Elements[i].Value)
+		}, "}", x.RBrace)
+	case *parser.FuncLit:
+		p.write("function")
+		p.params(x.Type.Params)
+		p.write(" ")
+		p.block(x.Body)
+	case *parser.ErrorExpr:
+		p.write("error(")
+		p.expr(x.Expr)
+		p.write(")")
+	case *parser.ImmutableExpr:
+		p.write("immutable(")
+		p.expr(x.Expr)
+		p.write(")")
+	case *parser.ImportExpr:
+		p.write("import(" + strconv.Quote(x.ModuleName) + ")")
+	default:
+		p.write(x.String())
+	}
+}
+
+func (p *printer) params(params *parser.IdentList) {
+	p.write("(")
+	for i, param := range params.List {
+		if i > 0 {
+			p.write(", ")
+		}
+		if params.VarArgs && i == len(params.List)-1 {
+			p.write("...")
+		}
+		p.write(param.Name)
+	}
+	p.write(")")
+}
+
+// list prints the elements between the opening and the closing tokens. The
+// elements are printed on their own lines if there's a line break between
+// any of them in the source code.
+func (p *printer) list(
+	open string,
+	openPos parser.Pos,
+	n int,
+	node func(i int) parser.Node,
+	elem func(i int),
+	close string,
+	closePos parser.Pos,
+) {
+	multiline := false
+	prevLine := p.lineOf(openPos)
+	for i := 0; i < n; i++ {
+		if p.lineOf(node(i).Pos()) > prevLine {
+			multiline = true
+			break
+		}
+		prevLine = p.lineOf(node(i).End())
+	}
+
+	p.write(open)
+	if !multiline {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				p.write(", ")
+			}
+			elem(i)
+		}
+		p.write(close)
+		return
+	}
+
+	p.trailingComments(openPos+1, node(0).Pos())
+	p.indent++
+	p.newline()
+	p.line = 0
+	for i := 0; i < n; i++ {
+		p.leadingComments(node(i).Pos())
+		p.blankLine(p.lineOf(node(i).Pos()))
+		elem(i)
+		limit := closePos
+		if i+1 < n {
+			p.write(",")
+			limit = node(i + 1).Pos()
+		}
+		p.line = p.lineOf(node(i).End())
+		p.trailingComments(node(i).End(), limit)
+		p.newline()
+	}
+	p.leadingComments(closePos)
+	p.indent--
+	p.write(close)
+	p.line = p.lineOf(closePos)
+}
+
+// mapKey returns the map key as an identifier if possible, or a quoted
+// string otherwise.
+func mapKey(key string) string {
+	if key == "" || token.Lookup(key) != token.Ident {
+		return strconv.Quote(key)
+	}
+	for i, r := range key {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
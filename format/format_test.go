@@ -0,0 +1,94 @@
+package format_test
+
+import (
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2/format"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+func TestSource(t *testing.T) {
+	// statements and spacing
+	expectFormat(t, "x=1;y=2\n", "x = 1\ny = 2\n")
+	expectFormat(t, "a,b = b,a", "a, b = b, a\n")
+	expectFormat(t, "x+=-y*(z-1)", "x += -y * (z - 1)\n")
+	expectFormat(t, "x = - -y", "x = - -y\n")
+	expectFormat(t, "x++;y--", "x++\ny--\n")
+	expectFormat(t, "c=a?b:!d", "c = a ? b : !d\n")
+	expectFormat(t, `x=a.b[1][1:][:2]["c"]`, "x = a.b[1][1:][:2][\"c\"]\n")
+	expectFormat(t, "f(a,b...)", "f(a, b...)\n")
+	expectFormat(t, "x=[1,2,[ ]]", "x = [1, 2, []]\n")
+	expectFormat(t, `m={a:1,"b":2,"b c":3,"if":4}`,
+		"m = {a: 1, b: 2, \"b c\": 3, \"if\": 4}\n")
+	expectFormat(t, `e=error("x");i=immutable([1]);u=undefined`,
+		"e = error(\"x\")\ni = immutable([1])\nu = undefined\n")
+	expectFormat(t, `fmt=import("fmt")`, "fmt = import(\"fmt\")\n")
+	expectFormat(t, "", "")
+
+	// blocks
+	expectFormat(t, "f=function(a,...b){return a}",
+		"f = function(a, ...b) {\n\treturn a\n}\n")
+	expectFormat(t, "f=function(){}", "f = function() {}\n")
+	expectFormat(t, "if a>0{x=1}else if (a<0) {x=2} else {x=3}",
+		"if (a > 0) {\n\tx = 1\n} else if (a < 0) {\n\tx = 2\n} else {\n"+
+			"\tx = 3\n}\n")
+	expectFormat(t, "if x=f();x>0{}", "if x = f(); x > 0 {}\n")
+	expectFormat(t, "for(i=0;i<3;i++){continue}",
+		"for (i = 0; i < 3; i++) {\n\tcontinue\n}\n")
+	expectFormat(t, "for(;;){break}", "for {\n\tbreak\n}\n")
+	expectFormat(t, "for(x<3){x++}", "for (x < 3) {\n\tx++\n}\n")
+	expectFormat(t, "for(k in m){}", "for (k in m) {}\n")
+
+	// imports and exports
+	expectFormat(t, `import a,{b,c as d} from "./lib"`,
+		"import a, {b, c as d} from \"./lib\"\n")
+	expectFormat(t, `import * as lib from "./lib"`,
+		"import * as lib from \"./lib\"\n")
+	expectFormat(t, "export function add(a,b){return a+b}",
+		"export function add(a, b) {\n\treturn a + b\n}\n")
+	expectFormat(t, "export const k=1;export default {a:1}",
+		"export const k = 1\nexport default {a: 1}\n")
+	expectFormat(t, "export {a,b as c}", "export {a, b as c}\n")
+	expectFormat(t, "export a+1", "export a + 1\n")
+
+	// blank lines and line breaks
+	expectFormat(t, "\n\nx=1\n\n\n\ny=2\n\n", "x = 1\n\ny = 2\n")
+	expectFormat(t, "f=function(){\n\n  x=1\n\n}",
+		"f = function() {\n\tx = 1\n}\n")
+	expectFormat(t, "x=[1,\n2]\nm={\na:1}\nf(\na,\nb)",
+		"x = [\n\t1,\n\t2\n]\nm = {\n\ta: 1\n}\nf(\n\ta,\n\tb\n)\n")
+	expectFormat(t, "f(function(){\nreturn\n},1)",
+		"f(function() {\n\treturn\n}, 1)\n")
+	expectFormat(t, "x=a&&\nb||\n  c", "x = a &&\n\tb ||\n\tc\n")
+
+	// comments
+	expectFormat(t, "// a\n\n\n// b\nx=1 // c\n/* d */",
+		"// a\n\n// b\nx = 1 // c\n/* d */\n")
+	expectFormat(t, "if x { // a\ny=1 // b\n// c\n}",
+		"if (x) { // a\n\ty = 1 // b\n\t// c\n}\n")
+	expectFormat(t, "x=[ // a\n1, // b\n\n// c\n2 /* d */\n]",
+		"x = [ // a\n\t1, // b\n\n\t// c\n\t2 /* d */\n]\n")
+	expectFormat(t, "x=a+/* a */b // b", "x = a + b /* a */ // b\n")
+	expectFormat(t, "x=a&&// a\nb", "x = a &&\n\tb // a\n")
+	expectFormat(t, "x=1 // a // b\n", "x = 1 // a // b\n")
+	expectFormat(t, "x=1 /* a */ // b  \t\n", "x = 1 /* a */ // b\n")
+	expectFormat(t, "#!/usr/bin/env nanojs\nx=1",
+		"#!/usr/bin/env nanojs\nx = 1\n")
+
+	// parse error
+	_, err := format.Source([]byte("x = ("))
+	require.Error(t, err)
+	_, err = format.Source([]byte("for (k, v in b) {}"))
+	require.Error(t, err)
+}
+
+func expectFormat(t *testing.T, input, expected string) {
+	actual, err := format.Source([]byte(input))
+	require.NoError(t, err)
+	require.Equal(t, expected, string(actual), input)
+
+	// formatting is idempotent
+	again, err := format.Source(actual)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(again), input)
+}
@@ -1,3 +1,3 @@
 module github.com/zeaphoo/nanojs/v2
 
-go 1.13
+go 1.16
@@ -0,0 +1,453 @@
+package nanojs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GoObject is a Go struct, slice, array or map that scripts access in place:
+// reading a member returns the current value of the Go value, and assigning
+// a member changes the Go value.
+//
+// The members of a struct are its exported fields, named as FromInterface
+// names them, and its exported methods, named by the Go names with the first
+// letter in lower case. The fields with the "readonly" tag option, e.g.
+// `nanojs:"total,readonly"`, can't be assigned. The members that are
+// structs, pointers to structs, slices, arrays or maps are GoObjects too, and
+// the other members are converted as FromInterface converts them. The struct
+// elements of the maps are copies, as they can't be changed in place.
+type GoObject struct {
+	ObjectImpl
+	value  reflect.Value
+	filter memberFilter
+}
+
+// NewGoObject creates a GoObject of v, which is usually a pointer to a
+// struct. A struct that is not passed by a pointer is copied. If the members
+// are given, the scripts can only see those members, and the members of the
+// nested objects are given in the dotted form, e.g. "customer.name", which
+// also apply to the elements of the slices, arrays and maps.
+func NewGoObject(v interface{}, members ...string) (*GoObject, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() &&
+		rv.Elem().Kind() == reflect.Struct {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		if !rv.CanAddr() {
+			ptr := reflect.New(rv.Type())
+			ptr.Elem().Set(rv)
+			rv = ptr.Elem()
+		}
+	case reflect.Slice, reflect.Map:
+		if rv.IsNil() {
+			return nil, fmt.Errorf("cannot create go-object of nil %s",
+				rv.Type())
+		}
+	case reflect.Array:
+	default:
+		return nil, fmt.Errorf("cannot create go-object of %T", v)
+	}
+	return &GoObject{value: rv, filter: newMemberFilter(members)}, nil
+}
+
+// Value returns the Go value of the object, which is a pointer for a
+// struct.
+func (o *GoObject) Value() interface{} {
+	if o.value.Kind() == reflect.Struct {
+		return o.value.Addr().Interface()
+	}
+	return o.value.Interface()
+}
+
+// TypeName returns the name of the type.
+func (o *GoObject) TypeName() string {
+	return "go-object:" + o.value.Type().String()
+}
+
+func (o *GoObject) String() string {
+	converted, err := fromReflect(o.visible(), 0)
+	if err != nil {
+		return "<go-object>"
+	}
+	return converted.String()
+}
+
+// Copy returns a GoObject of the same Go value.
+func (o *GoObject) Copy() Object {
+	return &GoObject{value: o.value, filter: o.filter}
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *GoObject) Equals(x Object) bool {
+	t, ok := x.(*GoObject)
+	if !ok || o.value.Type() != t.value.Type() {
+		return false
+	}
+	switch o.value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return o.value.Pointer() == t.value.Pointer() &&
+			o.value.Len() == t.value.Len()
+	}
+	return o.value.CanAddr() && t.value.CanAddr() &&
+		o.value.UnsafeAddr() == t.value.UnsafeAddr()
+}
+
+// IndexGet returns the member of a struct, or the element of a slice, an
+// array or a map.
+func (o *GoObject) IndexGet(index Object) (Object, error) {
+	switch o.value.Kind() {
+	case reflect.Struct:
+		name, ok := index.(*String)
+		if !ok {
+			return nil, ErrInvalidIndexType
+		}
+		if !o.filter.allows(name.Value) {
+			return UndefinedValue, nil
+		}
+		info := cachedGoTypeInfo(o.value.Type())
+		if f, ok := info.fields[name.Value]; ok {
+			fv, ok := fieldByIndex(o.value, f.index)
+			if !ok {
+				return UndefinedValue, nil
+			}
+			return goValue(fv, o.filter.sub(name.Value))
+		}
+		if m, ok := info.methods[name.Value]; ok {
+			fn, err := newGoFunction(o.value.Addr().Method(m),
+				o.value.Type().Name()+"."+name.Value)
+			if err != nil {
+				return nil, err
+			}
+			return fn, nil
+		}
+		return UndefinedValue, nil
+	case reflect.Slice, reflect.Array:
+		idx, ok := index.(*Int)
+		if !ok {
+			return nil, ErrInvalidIndexType
+		}
+		if idx.Value < 0 || idx.Value >= int64(o.value.Len()) {
+			return nil, ErrIndexOutOfBounds
+		}
+		return goValue(o.value.Index(int(idx.Value)), o.filter)
+	case reflect.Map:
+		key := reflect.New(o.value.Type().Key()).Elem()
+		if err := decodeValue(index, key, "", 0); err != nil {
+			return nil, ErrInvalidIndexType
+		}
+		value := o.value.MapIndex(key)
+		if !value.IsValid() {
+			return UndefinedValue, nil
+		}
+		return goValue(value, o.filter)
+	}
+	return nil, ErrNotIndexable
+}
+
+// IndexSet sets the field of a struct, or the element of a slice, an array
+// or a map. The value is decoded into the Go type as Decode does.
+func (o *GoObject) IndexSet(index, value Object) error {
+	switch o.value.Kind() {
+	case reflect.Struct:
+		name, ok := index.(*String)
+		if !ok {
+			return ErrInvalidIndexType
+		}
+		info := cachedGoTypeInfo(o.value.Type())
+		f, ok := info.fields[name.Value]
+		if !ok || !o.filter.allows(name.Value) {
+			return fmt.Errorf("cannot assign to unknown member '%s' of %s",
+				name.Value, o.TypeName())
+		}
+		if f.readOnly {
+			return fmt.Errorf("cannot assign to read-only member '%s' of %s",
+				name.Value, o.TypeName())
+		}
+		fv, ok := settableField(o.value, f.index)
+		if !ok {
+			return ErrNotIndexAssignable
+		}
+		return setGoValue(fv, value, name.Value)
+	case reflect.Slice, reflect.Array:
+		idx, ok := index.(*Int)
+		if !ok {
+			return ErrInvalidIndexType
+		}
+		if idx.Value < 0 || idx.Value >= int64(o.value.Len()) {
+			return ErrIndexOutOfBounds
+		}
+		elem := o.value.Index(int(idx.Value))
+		if !elem.CanSet() {
+			return ErrNotIndexAssignable
+		}
+		return setGoValue(elem, value, fmt.Sprintf("[%d]", idx.Value))
+	case reflect.Map:
+		key := reflect.New(o.value.Type().Key()).Elem()
+		if err := decodeValue(index, key, "", 0); err != nil {
+			return ErrInvalidIndexType
+		}
+		elem := reflect.New(o.value.Type().Elem()).Elem()
+		if err := setGoValue(elem, value, index.String()); err != nil {
+			return err
+		}
+		o.value.SetMapIndex(key, elem)
+		return nil
+	}
+	return ErrNotIndexAssignable
+}
+
+// Iterate returns an iterator of the members of a struct, or the elements
+// of a slice, an array or a map.
+func (o *GoObject) Iterate() Iterator {
+	var keys []Object
+	switch o.value.Kind() {
+	case reflect.Struct:
+		for _, f := range cachedGoTypeInfo(o.value.Type()).fieldNames {
+			if o.filter.allows(f) {
+				keys = append(keys, &String{Value: f})
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < o.value.Len(); i++ {
+			keys = append(keys, &Int{Value: int64(i)})
+		}
+	case reflect.Map:
+		for _, key := range o.value.MapKeys() {
+			k, err := fromValue(key, 0)
+			if err == nil {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	}
+	return &goObjectIterator{o: o, keys: keys}
+}
+
+// CanIterate returns whether the Object can be Iterated.
+func (o *GoObject) CanIterate() bool {
+	return true
+}
+
+// len returns the number of the elements of a slice, an array or a map.
+func (o *GoObject) len() (int, bool) {
+	switch o.value.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return o.value.Len(), true
+	}
+	return 0, false
+}
+
+// visible returns the value of the object with the visible members only.
+func (o *GoObject) visible() reflect.Value {
+	if o.filter == nil {
+		return o.value
+	}
+	m := make(map[string]interface{})
+	it := o.Iterate()
+	for it.Next() {
+		if value := it.Value(); value != UndefinedValue {
+			m[it.Key().String()] = value
+		}
+	}
+	return reflect.ValueOf(m)
+}
+
+// goValue returns the member of a GoObject.
+func goValue(v reflect.Value, filter memberFilter) (Object, error) {
+	if v.Type() == timeType || v.Type() == durationType ||
+		v.Type().Implements(textMarshalerType) {
+		return fromValue(v, 0)
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return UndefinedValue, nil
+		}
+		if v.Elem().Kind() == reflect.Struct {
+			return goValue(v.Elem(), filter)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return UndefinedValue, nil
+		}
+		return goValue(v.Elem(), filter)
+	case reflect.Struct:
+		if !v.CanAddr() {
+			// the struct is an element of a map
+			ptr := reflect.New(v.Type())
+			ptr.Elem().Set(v)
+			v = ptr.Elem()
+		}
+		return &GoObject{value: v, filter: filter}, nil
+	case reflect.Array:
+		return &GoObject{value: v, filter: filter}, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return UndefinedValue, nil
+		}
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return &GoObject{value: v, filter: filter}, nil
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return UndefinedValue, nil
+		}
+		return &GoObject{value: v, filter: filter}, nil
+	}
+	return fromValue(v, 0)
+}
+
+func setGoValue(v reflect.Value, value Object, path string) error {
+	tmp := reflect.New(v.Type()).Elem()
+	if err := decodeValue(value, tmp, path, 0); err != nil {
+		return err
+	}
+	v.Set(tmp)
+	return nil
+}
+
+// goTypeInfo is the members of a struct type.
+type goTypeInfo struct {
+	fields     map[string]structField
+	fieldNames []string
+	methods    map[string]int // method indexes of the pointer type
+}
+
+var goTypeInfos sync.Map // map[reflect.Type]*goTypeInfo
+
+func cachedGoTypeInfo(t reflect.Type) *goTypeInfo {
+	if info, ok := goTypeInfos.Load(t); ok {
+		return info.(*goTypeInfo)
+	}
+	info := &goTypeInfo{
+		fields:  make(map[string]structField),
+		methods: make(map[string]int),
+	}
+	for _, f := range cachedStructFields(t) {
+		info.fields[f.name] = f
+		info.fieldNames = append(info.fieldNames, f.name)
+	}
+	ptr := reflect.PtrTo(t)
+	for i := 0; i < ptr.NumMethod(); i++ {
+		name := lowerFirst(ptr.Method(i).Name)
+		if _, ok := info.fields[name]; !ok {
+			info.methods[name] = i
+		}
+	}
+	v, _ := goTypeInfos.LoadOrStore(t, info)
+	return v.(*goTypeInfo)
+}
+
+func lowerFirst(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(r)) + s[size:]
+}
+
+// memberFilter is the members visible to the scripts, and the filters of
+// their members. A nil filter allows all the members.
+type memberFilter map[string]memberFilter
+
+func newMemberFilter(members []string) memberFilter {
+	if len(members) == 0 {
+		return nil
+	}
+	f := make(memberFilter)
+	for _, member := range members {
+		f.add(strings.Split(member, "."))
+	}
+	return f
+}
+
+func (f memberFilter) add(path []string) {
+	sub, ok := f[path[0]]
+	if len(path) == 1 {
+		f[path[0]] = nil
+		return
+	}
+	if ok && sub == nil {
+		return // all the members are allowed
+	}
+	if !ok {
+		sub = make(memberFilter)
+		f[path[0]] = sub
+	}
+	sub.add(path[1:])
+}
+
+func (f memberFilter) allows(name string) bool {
+	if f == nil {
+		return true
+	}
+	_, ok := f[name]
+	return ok
+}
+
+func (f memberFilter) sub(name string) memberFilter {
+	if f == nil {
+		return nil
+	}
+	return f[name]
+}
+
+// goObjectIterator is an iterator for a GoObject.
+type goObjectIterator struct {
+	ObjectImpl
+	o    *GoObject
+	keys []Object
+	i    int
+}
+
+// TypeName returns the name of the type.
+func (i *goObjectIterator) TypeName() string {
+	return "go-object-iterator"
+}
+
+func (i *goObjectIterator) String() string {
+	return "<go-object-iterator>"
+}
+
+// IsFalsy returns true if the value of the type is falsy.
+func (i *goObjectIterator) IsFalsy() bool {
+	return true
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (i *goObjectIterator) Equals(Object) bool {
+	return false
+}
+
+// Copy returns a copy of the type.
+func (i *goObjectIterator) Copy() Object {
+	return &goObjectIterator{o: i.o, keys: i.keys, i: i.i}
+}
+
+// Next returns true if there are more elements to iterate.
+func (i *goObjectIterator) Next() bool {
+	i.i++
+	return i.i <= len(i.keys)
+}
+
+// Key returns the key or index value of the current element.
+func (i *goObjectIterator) Key() Object {
+	return i.keys[i.i-1]
+}
+
+// Value returns the value of the current element.
+func (i *goObjectIterator) Value() Object {
+	value, err := i.o.IndexGet(i.keys[i.i-1])
+	if err != nil {
+		return UndefinedValue
+	}
+	return value
+}
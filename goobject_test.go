@@ -0,0 +1,207 @@
+package nanojs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+type orderCustomer struct {
+	Name  string `nanojs:"name"`
+	Email string `nanojs:"email"`
+}
+
+type orderItem struct {
+	SKU   string  `nanojs:"sku"`
+	Qty   int     `nanojs:"qty"`
+	Price float64 `nanojs:"price"`
+}
+
+type order struct {
+	ID       int64             `nanojs:"id,readonly"`
+	Status   string            `nanojs:"status"`
+	Customer *orderCustomer    `nanojs:"customer"`
+	Items    []orderItem       `nanojs:"items"`
+	Meta     map[string]string `nanojs:"meta"`
+	Created  time.Time         `nanojs:"created"`
+	secret   string
+}
+
+func (o *order) Total() float64 {
+	var total float64
+	for _, item := range o.Items {
+		total += float64(item.Qty) * item.Price
+	}
+	return total
+}
+
+func (o *order) AddItem(item orderItem) int {
+	o.Items = append(o.Items, item)
+	return len(o.Items)
+}
+
+func (o *order) Cancel(reason string) error {
+	if o.Status == "paid" {
+		return errors.New("order is paid")
+	}
+	o.Status = "cancelled: " + reason
+	return nil
+}
+
+func newTestOrder() *order {
+	return &order{
+		ID:       7,
+		Status:   "new",
+		Customer: &orderCustomer{Name: "Ann", Email: "ann@example.com"},
+		Items:    []orderItem{{SKU: "a", Qty: 2, Price: 1.5}},
+		Meta:     map[string]string{"source": "web"},
+		Created:  time.Unix(100, 0),
+		secret:   "secret",
+	}
+}
+
+func TestGoObject(t *testing.T) {
+	o := newTestOrder()
+	c := runGoObject(t, o, nil, `
+id = order.id
+total = order.total()
+n = order.addItem({sku: "b", qty: 1, price: 4})
+total2 = order.total()
+order.status = "paid"
+order.customer.name = "Bob"
+order.items[0].qty = 3
+order.meta.source = "api"
+order.meta.ref = "x"
+cancelled = order.cancel("test")
+created = order.created
+skus = []
+for (i in order.items) {
+	skus = append(skus, order.items[i].sku)
+}
+count = len(order.items)
+secret = order.secret
+`)
+	require.Equal(t, int64(7), c.Get("id").Value())
+	require.Equal(t, 3.0, c.Get("total").Value())
+	require.Equal(t, int64(2), c.Get("n").Value())
+	require.Equal(t, 7.0, c.Get("total2").Value())
+	require.Equal(t, `error: "order is paid"`,
+		c.Get("cancelled").Error().Error())
+	require.Equal(t, int64(100), c.Get("created").Value().(time.Time).Unix())
+	require.Equal(t, "[a b]", fmt.Sprint(c.Get("skus").Value()))
+	require.Equal(t, int64(2), c.Get("count").Value())
+	require.True(t, c.Get("secret").IsUndefined())
+
+	require.Equal(t, "paid", o.Status)
+	require.Equal(t, "Bob", o.Customer.Name)
+	require.Equal(t, 3, o.Items[0].Qty)
+	require.Equal(t, 2, len(o.Meta))
+	require.Equal(t, "api", o.Meta["source"])
+	require.Equal(t, "x", o.Meta["ref"])
+}
+
+func TestGoObject_Errors(t *testing.T) {
+	expectGoObjectError(t, `order.id = 1`,
+		"cannot assign to read-only member 'id' of "+
+			"go-object:nanojs_test.order")
+	expectGoObjectError(t, `order.unknown = 1`,
+		"cannot assign to unknown member 'unknown' of "+
+			"go-object:nanojs_test.order")
+	expectGoObjectError(t, `order.status = 1`,
+		"cannot decode int into string at status")
+	expectGoObjectError(t, `order.items[5].qty = 1`, "index out of bounds")
+	expectGoObjectError(t, `order.addItem(1)`,
+		"invalid type for argument 'first' in call to "+
+			"'go-function:order.addItem': expected map, found int")
+
+	_, err := nanojs.NewGoObject(1)
+	require.Error(t, err)
+	_, err = nanojs.NewGoObject((*order)(nil))
+	require.Error(t, err)
+}
+
+func TestGoObject_Members(t *testing.T) {
+	o := newTestOrder()
+	c := runGoObject(t, o, []string{"status", "total", "customer.name",
+		"items.sku"}, `
+status = order.status
+total = order.total()
+name = order.customer.name
+email = order.customer.email
+sku = order.items[0].sku
+qty = order.items[0].qty
+addItem = order.addItem
+keys = []
+for (k in order) {
+	keys = append(keys, k)
+}
+str = string(order.customer)
+`)
+	require.Equal(t, "new", c.Get("status").Value())
+	require.Equal(t, 3.0, c.Get("total").Value())
+	require.Equal(t, "Ann", c.Get("name").Value())
+	require.True(t, c.Get("email").IsUndefined())
+	require.Equal(t, "a", c.Get("sku").Value())
+	require.True(t, c.Get("qty").IsUndefined())
+	require.True(t, c.Get("addItem").IsUndefined())
+	require.Equal(t, "[status customer items]",
+		fmt.Sprint(c.Get("keys").Value()))
+	require.Equal(t, `{"name": "Ann"}`, c.Get("str").Value())
+
+	g, err := nanojs.NewGoObject(o, "status")
+	require.NoError(t, err)
+	require.Error(t, g.IndexSet(&nanojs.String{Value: "meta"},
+		&nanojs.String{Value: "x"}))
+}
+
+func TestGoObject_Decode(t *testing.T) {
+	o := newTestOrder()
+	g, err := nanojs.NewGoObject(o)
+	require.NoError(t, err)
+	require.True(t, g.Value().(*order) == o)
+	require.True(t, nanojs.ToInterface(g).(*order) == o)
+
+	var p *order
+	require.NoError(t, nanojs.Decode(g, &p))
+	require.True(t, p == o)
+	var v order
+	require.NoError(t, nanojs.Decode(g, &v))
+	require.Equal(t, "new", v.Status)
+
+	customer, err := g.IndexGet(&nanojs.String{Value: "customer"})
+	require.NoError(t, err)
+	again, err := g.IndexGet(&nanojs.String{Value: "customer"})
+	require.NoError(t, err)
+	require.True(t, customer.Equals(again))
+	require.False(t, customer.Equals(g))
+}
+
+func runGoObject(
+	t *testing.T,
+	o *order,
+	members []string,
+	src string,
+) *nanojs.Compiled {
+	g, err := nanojs.NewGoObject(o, members...)
+	require.NoError(t, err)
+	s := nanojs.NewScript([]byte(src))
+	require.NoError(t, s.Add("order", g))
+	c, err := s.Run()
+	require.NoError(t, err)
+	return c
+}
+
+func expectGoObjectError(t *testing.T, src, expected string) {
+	g, err := nanojs.NewGoObject(newTestOrder())
+	require.NoError(t, err)
+	s := nanojs.NewScript([]byte(src))
+	require.NoError(t, s.Add("order", g))
+	_, err = s.Run()
+	var rerr *nanojs.RuntimeError
+	require.True(t, errors.As(err, &rerr))
+	require.Equal(t, expected, rerr.Err.Error())
+}
@@ -0,0 +1,283 @@
+package nanojs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Hooks are called by the VM on the runtime events, e.g. to collect the
+// metrics of the runs. The hooks turn on the metering of the instructions (see
+// VM.GasUsed), and they are called synchronously, so they should return
+// quickly. Embed HooksImpl to implement only some of the hooks.
+type Hooks interface {
+	// OnCall is called after entering a compiled function. A tail call
+	// reuses the frame of the caller, and the function returns once.
+	OnCall(v *VM, fn *CompiledFunction)
+
+	// OnReturn is called before returning from a compiled function.
+	OnReturn(v *VM, fn *CompiledFunction)
+
+	// OnHostCall is called after a call of a callable object that is not a
+	// compiled function, e.g. a builtin function or a function of a module,
+	// with the duration and the error of the call.
+	OnHostCall(v *VM, fn Object, d time.Duration, err error)
+
+	// OnAlloc is called after an object counted by the allocation limit is
+	// allocated (see Script.SetMaxAllocs).
+	OnAlloc(v *VM, obj Object)
+
+	// OnError is called when the execution fails with the error returned by
+	// the run.
+	OnError(v *VM, err error)
+
+	// OnExit is called when the execution ends or it's suspended, with the
+	// error returned by the run.
+	OnExit(v *VM, err error)
+}
+
+// HooksImpl implements Hooks with the hooks doing nothing.
+type HooksImpl struct{}
+
+// OnCall does nothing.
+func (HooksImpl) OnCall(*VM, *CompiledFunction) {}
+
+// OnReturn does nothing.
+func (HooksImpl) OnReturn(*VM, *CompiledFunction) {}
+
+// OnHostCall does nothing.
+func (HooksImpl) OnHostCall(*VM, Object, time.Duration, error) {}
+
+// OnAlloc does nothing.
+func (HooksImpl) OnAlloc(*VM, Object) {}
+
+// OnError does nothing.
+func (HooksImpl) OnError(*VM, error) {}
+
+// OnExit does nothing.
+func (HooksImpl) OnExit(*VM, error) {}
+
+// FuncStats are the statistics of the calls of a function.
+type FuncStats struct {
+	Name   string
+	Host   bool  // the function is not a compiled function
+	Calls  int64 // the number of the calls
+	Errors int64 // the number of the host calls that returned an error
+
+	// Duration is the total duration of the host calls, and MaxDuration is
+	// the longest one.
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// RunStats are the statistics collected by a StatsCollector.
+type RunStats struct {
+	Runs         int64            // the number of the runs and the resumes
+	Instructions int64            // the number of the instructions executed
+	Allocs       map[string]int64 // the allocations by the type name
+	Errors       map[string]int64 // the errors by the kind, see ErrorKind
+	Funcs        []FuncStats      // sorted by the names
+}
+
+// StatsCollector is the Hooks that collects the statistics of the runs and
+// the calls, aggregated by the function names. It's safe for concurrent use,
+// and it can be shared by multiple VMs.
+//
+//	stats := nanojs.NewStatsCollector()
+//	script.SetHooks(stats)
+//	// ...
+//	for _, f := range stats.Stats().Funcs {
+//		callsMetric.WithLabelValues(f.Name).Add(float64(f.Calls))
+//	}
+type StatsCollector struct {
+	lock  sync.Mutex
+	stats RunStats
+	funcs map[funcKey]*FuncStats
+}
+
+type funcKey struct {
+	name string
+	host bool
+}
+
+// NewStatsCollector creates a StatsCollector.
+func NewStatsCollector() *StatsCollector {
+	c := &StatsCollector{}
+	c.Reset()
+	return c
+}
+
+// Stats returns a copy of the statistics collected.
+func (c *StatsCollector) Stats() RunStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stats := c.stats
+	stats.Allocs = make(map[string]int64, len(c.stats.Allocs))
+	for k, n := range c.stats.Allocs {
+		stats.Allocs[k] = n
+	}
+	stats.Errors = make(map[string]int64, len(c.stats.Errors))
+	for k, n := range c.stats.Errors {
+		stats.Errors[k] = n
+	}
+	stats.Funcs = make([]FuncStats, 0, len(c.funcs))
+	for _, f := range c.funcs {
+		stats.Funcs = append(stats.Funcs, *f)
+	}
+	sort.Slice(stats.Funcs, func(i, j int) bool {
+		if stats.Funcs[i].Name != stats.Funcs[j].Name {
+			return stats.Funcs[i].Name < stats.Funcs[j].Name
+		}
+		return !stats.Funcs[i].Host
+	})
+	return stats
+}
+
+// Reset discards the statistics collected.
+func (c *StatsCollector) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stats = RunStats{
+		Allocs: make(map[string]int64),
+		Errors: make(map[string]int64),
+	}
+	c.funcs = make(map[funcKey]*FuncStats)
+}
+
+// funcStats returns the statistics of the function. The lock must be held.
+func (c *StatsCollector) funcStats(name string, host bool) *FuncStats {
+	key := funcKey{name: name, host: host}
+	f := c.funcs[key]
+	if f == nil {
+		f = &FuncStats{Name: name, Host: host}
+		c.funcs[key] = f
+	}
+	return f
+}
+
+// OnCall counts the call of the compiled function.
+func (c *StatsCollector) OnCall(_ *VM, fn *CompiledFunction) {
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	c.lock.Lock()
+	c.funcStats(name, false).Calls++
+	c.lock.Unlock()
+}
+
+// OnReturn does nothing.
+func (c *StatsCollector) OnReturn(*VM, *CompiledFunction) {}
+
+// OnHostCall counts the call of the function, its duration and error. A
+// function suspending the run with ErrSuspend doesn't count as an error.
+func (c *StatsCollector) OnHostCall(
+	_ *VM,
+	fn Object,
+	d time.Duration,
+	err error,
+) {
+	var name string
+	switch fn := fn.(type) {
+	case *BuiltinFunction:
+		name = fn.Name
+	case *UserFunction:
+		name = fn.Name
+	case *GoFunction:
+		name = fn.Name
+	}
+	if name == "" {
+		name = "<" + fn.TypeName() + ">"
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	f := c.funcStats(name, true)
+	f.Calls++
+	if err != nil && !errors.Is(err, ErrSuspend) {
+		f.Errors++
+	}
+	f.Duration += d
+	if d > f.MaxDuration {
+		f.MaxDuration = d
+	}
+}
+
+// OnAlloc counts the allocation by the type name of the object.
+func (c *StatsCollector) OnAlloc(_ *VM, obj Object) {
+	c.lock.Lock()
+	c.stats.Allocs[obj.TypeName()]++
+	c.lock.Unlock()
+}
+
+// OnError counts the error by its kind.
+func (c *StatsCollector) OnError(_ *VM, err error) {
+	c.lock.Lock()
+	c.stats.Errors[ErrorKind(err)]++
+	c.lock.Unlock()
+}
+
+// OnExit counts the run and the instructions executed.
+func (c *StatsCollector) OnExit(v *VM, _ error) {
+	c.lock.Lock()
+	c.stats.Runs++
+	c.stats.Instructions += v.Instructions()
+	c.lock.Unlock()
+}
+
+// errorKinds are the kinds of the errors by the errors.
+var errorKinds = []struct {
+	err  error
+	kind string
+}{
+	{context.Canceled, "canceled"},
+	{context.DeadlineExceeded, "deadline exceeded"},
+	{ErrInstructionLimit, "instruction limit"},
+	{ErrObjectAllocLimit, "object allocation limit"},
+	{ErrMemoryLimit, "memory limit"},
+	{ErrStackOverflow, "stack overflow"},
+	{ErrStringLimit, "string limit"},
+	{ErrBytesLimit, "bytes limit"},
+	{ErrIndexOutOfBounds, "index out of bounds"},
+	{ErrNotIndexable, "not indexable"},
+	{ErrNotIndexAssignable, "not index-assignable"},
+	{ErrInvalidIndexType, "invalid index type"},
+	{ErrInvalidIndexValueType, "invalid index value type"},
+}
+
+// ErrorKind returns the kind of the error returned by a run, which has a
+// small number of values, e.g. to label the metrics: "instruction limit",
+// "stack overflow", "deadline exceeded", etc., the Go type of a custom error
+// of a host function, or "runtime error".
+func ErrorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		err = rerr.Err
+	}
+	if _, ok := err.(ErrInvalidArgumentType); ok {
+		return "invalid argument type"
+	}
+	for {
+		unwrapped := errors.Unwrap(err)
+		if unwrapped == nil {
+			break
+		}
+		err = unwrapped
+	}
+	switch kind := fmt.Sprintf("%T", err); kind {
+	case "*errors.errorString", "*fmt.wrapError":
+		return "runtime error"
+	default:
+		return kind
+	}
+}
@@ -0,0 +1,122 @@
+package nanojs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+const hooksSrc = `
+fib = function(n) {
+	if (n < 2) {
+		return n
+	}
+	return fib(n - 1) + fib(n - 2)
+}
+out = [fib(5), len("abc"), [1, 2]]
+`
+
+func TestCompiled_Hooks(t *testing.T) {
+	stats := nanojs.NewStatsCollector()
+	s := nanojs.NewScript([]byte(hooksSrc))
+	s.SetHooks(stats)
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.NoError(t, c.Run())
+
+	st := stats.Stats()
+	require.Equal(t, int64(1), st.Runs)
+	require.True(t, st.Instructions > 0)
+	require.Equal(t, 0, len(st.Errors))
+	require.Equal(t, int64(2), st.Allocs["array"])
+	require.Equal(t, 2, len(st.Funcs))
+	require.Equal(t, "fib", st.Funcs[0].Name)
+	require.False(t, st.Funcs[0].Host)
+	require.Equal(t, int64(15), st.Funcs[0].Calls)
+	require.Equal(t, "len", st.Funcs[1].Name)
+	require.True(t, st.Funcs[1].Host)
+	require.Equal(t, int64(1), st.Funcs[1].Calls)
+	require.True(t, st.Funcs[1].Duration >= st.Funcs[1].MaxDuration)
+
+	// the clones and the runners share the hooks
+	require.NoError(t, c.Clone().Run())
+	r := c.NewRunner()
+	require.NoError(t, r.Run())
+	st = stats.Stats()
+	require.Equal(t, int64(3), st.Runs)
+	require.Equal(t, int64(45), st.Funcs[0].Calls)
+
+	stats.Reset()
+	st = stats.Stats()
+	require.Equal(t, int64(0), st.Runs)
+	require.Equal(t, 0, len(st.Funcs))
+}
+
+func TestCompiled_Hooks_Errors(t *testing.T) {
+	stats := nanojs.NewStatsCollector()
+	s := nanojs.NewScript([]byte(`
+fail = function() { return fail_host() }
+fail()
+`))
+	s.SetHooks(stats)
+	require.NoError(t, s.Add("fail_host", &nanojs.UserFunction{
+		Name: "fail_host",
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			return nil, nanojs.ErrWrongNumArguments
+		},
+	}))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.Error(t, c.Run())
+
+	st := stats.Stats()
+	require.Equal(t, int64(1), st.Errors["runtime error"])
+	require.Equal(t, "fail", st.Funcs[0].Name)
+	require.Equal(t, int64(1), st.Funcs[0].Calls)
+	require.Equal(t, "fail_host", st.Funcs[1].Name)
+	require.Equal(t, int64(1), st.Funcs[1].Errors)
+
+	stats.Reset()
+	s = nanojs.NewScript([]byte(`for (;;) {}`))
+	s.SetHooks(stats)
+	s.SetMaxGas(100)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.Error(t, c.Run())
+	st = stats.Stats()
+	require.Equal(t, int64(1), st.Errors["instruction limit"])
+
+	stats.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	c, err = nanojs.NewScript([]byte(`for (;;) {}`)).Compile()
+	require.NoError(t, err)
+	c.SetHooks(stats)
+	require.Error(t, c.RunContext(ctx))
+	st = stats.Stats()
+	require.Equal(t, int64(1), st.Errors["deadline exceeded"])
+
+	// suspending the run is not an error
+	stats.Reset()
+	s = nanojs.NewScript([]byte(`out = wait()`))
+	s.SetHooks(stats)
+	require.NoError(t, s.Add("wait", &nanojs.UserFunction{
+		Name: "wait",
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			return nil, nanojs.ErrSuspend
+		},
+	}))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.Equal(t, nanojs.ErrSuspend, c.Run())
+	require.NoError(t, c.Resume(1))
+	st = stats.Stats()
+	require.Equal(t, 0, len(st.Errors))
+	require.Equal(t, "wait", st.Funcs[0].Name)
+	require.Equal(t, int64(1), st.Funcs[0].Calls)
+	require.Equal(t, int64(0), st.Funcs[0].Errors)
+}
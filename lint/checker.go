@@ -0,0 +1,481 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/parser"
+	"github.com/zeaphoo/nanojs/v2/token"
+)
+
+// variable kinds
+const (
+	kindVar = iota
+	kindParam
+	kindKey
+	kindImport
+	kindHost
+)
+
+// variable is the information of a defined symbol.
+type variable struct {
+	name    string
+	pos     parser.Pos
+	kind    int
+	global  bool
+	used    bool
+	pending bool // the variable is used in its own definition
+	depth   int  // depth of the function the variable is defined in
+}
+
+// checker walks the AST resolving the names with the symbol tables like the
+// compiler does.
+type checker struct {
+	srcFile     *parser.SourceFile
+	table       *nanojs.SymbolTable
+	functions   []*nanojs.SymbolTable // symbol tables of the functions
+	variables   map[*nanojs.Symbol]*variable
+	order       []*variable
+	exports     []*parser.Ident
+	codeLines   map[int]parser.Pos // first position of the code by line
+	diagnostics []*Diagnostic
+}
+
+func newChecker(srcFile *parser.SourceFile, globals []string) *checker {
+	c := &checker{
+		srcFile:   srcFile,
+		table:     nanojs.NewSymbolTable(),
+		variables: make(map[*nanojs.Symbol]*variable),
+		codeLines: make(map[int]parser.Pos),
+	}
+	c.functions = []*nanojs.SymbolTable{c.table}
+	for idx, fn := range nanojs.GetAllBuiltinFunctions() {
+		c.table.DefineBuiltin(idx, fn.Name)
+	}
+	for _, name := range globals {
+		c.define(name, parser.NoPos, kindHost)
+	}
+	return c
+}
+
+func (c *checker) position(pos parser.Pos) parser.SourceFilePos {
+	return c.srcFile.Position(pos)
+}
+
+func (c *checker) report(pos parser.Pos, rule, format string,
+	args ...interface{}) {
+	filePos := c.position(pos)
+	c.diagnostics = append(c.diagnostics, &Diagnostic{
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+		File:    filePos.Filename,
+		Line:    filePos.Line,
+		Column:  filePos.Column,
+		pos:     pos,
+	})
+}
+
+// mark records the positions of the node so the directives following the
+// code on the same line can be told apart from the ones on their own lines.
+func (c *checker) mark(node parser.Node) {
+	if !node.Pos().IsValid() || node.End() <= node.Pos() {
+		return
+	}
+	for _, pos := range []parser.Pos{node.Pos(), node.End() - 1} {
+		line := c.position(pos).Line
+		if first, ok := c.codeLines[line]; !ok || pos < first {
+			c.codeLines[line] = pos
+		}
+	}
+}
+
+func (c *checker) depth() int {
+	return len(c.functions) - 1
+}
+
+func (c *checker) enterBlock() {
+	c.table = c.table.Fork(true)
+}
+
+func (c *checker) leaveBlock() {
+	c.table = c.table.Parent(false)
+}
+
+// origin returns the original symbol of the free variable.
+func (c *checker) origin(symbol *nanojs.Symbol, depth int) *nanojs.Symbol {
+	for symbol.Scope == nanojs.ScopeFree && depth > 0 {
+		symbol = c.functions[depth].FreeSymbols()[symbol.Index]
+		depth--
+	}
+	return symbol
+}
+
+// resolve resolves the name and returns the variable, or nil if the name is
+// undefined or a builtin function.
+func (c *checker) resolve(name string) (*variable, bool) {
+	symbol, _, ok := c.table.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+	return c.variables[c.origin(symbol, c.depth())], true
+}
+
+func (c *checker) define(name string, pos parser.Pos, kind int) *variable {
+	symbol := c.table.Define(name)
+	v := &variable{
+		name:   name,
+		pos:    pos,
+		kind:   kind,
+		global: symbol.Scope == nanojs.ScopeGlobal,
+		depth:  c.depth(),
+	}
+	c.variables[symbol] = v
+	c.order = append(c.order, v)
+	return v
+}
+
+// defineShadow defines the parameter or the for-in key, which shadows the
+// variable of the same name in the outer scopes.
+func (c *checker) defineShadow(ident *parser.Ident, kind int) {
+	if v, ok := c.resolve(ident.Name); ok && ident.Name != "_" {
+		if v == nil {
+			c.report(ident.Pos(), RuleShadow,
+				"'%s' shadows the builtin function", ident.Name)
+		} else {
+			c.report(ident.Pos(), RuleShadow,
+				"'%s' shadows the variable declared at %s", ident.Name,
+				c.declaredAt(v))
+		}
+	}
+	c.define(ident.Name, ident.Pos(), kind)
+}
+
+func (c *checker) declaredAt(v *variable) string {
+	if !v.pos.IsValid() {
+		return "the host application"
+	}
+	pos := c.position(v.pos)
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// use marks the variable as used, and reports it if it's undefined.
+func (c *checker) use(ident *parser.Ident) {
+	if v := c.read(ident); v != nil {
+		v.used = true
+	}
+}
+
+// read reports the variable if it's undefined or used in its own definition.
+// It returns nil if the variable is undefined or a builtin function.
+func (c *checker) read(ident *parser.Ident) *variable {
+	v, ok := c.resolve(ident.Name)
+	if !ok {
+		c.report(ident.Pos(), RuleUndefined, "undefined: %s", ident.Name)
+		return nil
+	}
+	if v != nil && v.pending && v.depth == c.depth() {
+		c.report(ident.Pos(), RuleUndefined,
+			"'%s' is used in its own definition", ident.Name)
+	}
+	return v
+}
+
+// assign resolves the variable, defining it if it doesn't exist.
+func (c *checker) assign(ident *parser.Ident, kind int) *variable {
+	v, ok := c.resolve(ident.Name)
+	if !ok {
+		return c.define(ident.Name, ident.Pos(), kind)
+	}
+	return v
+}
+
+func (c *checker) file(file *parser.File) {
+	c.stmtList(file.Stmts)
+
+	// the export list refers to the top-level variables
+	for _, ident := range c.exports {
+		c.use(ident)
+	}
+
+	for _, v := range c.order {
+		if v.used || strings.HasPrefix(v.name, "_") {
+			continue
+		}
+		switch v.kind {
+		case kindImport:
+			c.report(v.pos, RuleUnusedImport, "'%s' is imported but not used",
+				v.name)
+		case kindKey:
+			c.report(v.pos, RuleUnused, "for-in key '%s' is not used",
+				v.name)
+		case kindVar:
+			if !v.global {
+				c.report(v.pos, RuleUnused,
+					"'%s' is assigned but never used", v.name)
+			}
+		}
+	}
+}
+
+func (c *checker) stmtList(stmts []parser.Stmt) {
+	reported := false
+	terminated := false
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*parser.EmptyStmt); ok {
+			continue
+		}
+		if terminated && !reported {
+			c.report(stmt.Pos(), RuleUnreachable, "unreachable code")
+			reported = true
+		}
+		c.stmt(stmt)
+		terminated = terminated || terminates(stmt)
+	}
+}
+
+// terminates returns true if the statement never continues to the next
+// statement.
+func terminates(stmt parser.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *parser.ReturnStmt, *parser.BranchStmt:
+		return true
+	case *parser.BlockStmt:
+		for i := len(stmt.Stmts) - 1; i >= 0; i-- {
+			if _, ok := stmt.Stmts[i].(*parser.EmptyStmt); !ok {
+				return terminates(stmt.Stmts[i])
+			}
+		}
+	case *parser.IfStmt:
+		return stmt.Else != nil && terminates(stmt.Body) &&
+			terminates(stmt.Else)
+	}
+	return false
+}
+
+func (c *checker) stmt(stmt parser.Stmt) {
+	c.mark(stmt)
+	switch stmt := stmt.(type) {
+	case *parser.ExprStmt:
+		if x, ok := stmt.Expr.(*parser.ImportExpr); ok {
+			c.report(x.Pos(), RuleUnusedImport,
+				"result of import(\"%s\") is not used", x.ModuleName)
+		}
+		c.expr(stmt.Expr)
+	case *parser.IncDecStmt:
+		c.assignStmt(stmt.Expr, nil, stmt.Token)
+	case *parser.AssignStmt:
+		if len(stmt.LHS) != 1 || len(stmt.RHS) != 1 {
+			// tuple assignments are rejected by the compiler
+			for _, x := range stmt.LHS {
+				c.expr(x)
+			}
+			for _, x := range stmt.RHS {
+				c.expr(x)
+			}
+			return
+		}
+		c.assignStmt(stmt.LHS[0], stmt.RHS[0], stmt.Token)
+	case *parser.IfStmt:
+		c.enterBlock()
+		if stmt.Init != nil {
+			c.stmt(stmt.Init)
+		}
+		c.expr(stmt.Cond)
+		c.stmt(stmt.Body)
+		if stmt.Else != nil {
+			c.stmt(stmt.Else)
+		}
+		c.leaveBlock()
+	case *parser.ForStmt:
+		c.enterBlock()
+		if stmt.Init != nil {
+			c.stmt(stmt.Init)
+		}
+		if stmt.Cond != nil {
+			c.expr(stmt.Cond)
+		}
+		if stmt.Post != nil {
+			c.stmt(stmt.Post)
+		}
+		c.stmt(stmt.Body)
+		c.leaveBlock()
+	case *parser.ForInStmt:
+		c.enterBlock()
+		c.expr(stmt.Iterable)
+		if stmt.Key.Name != "_" {
+			c.defineShadow(stmt.Key, kindKey)
+		}
+		c.stmt(stmt.Body)
+		c.leaveBlock()
+	case *parser.BlockStmt:
+		if len(stmt.Stmts) == 0 {
+			return
+		}
+		c.enterBlock()
+		c.stmtList(stmt.Stmts)
+		c.leaveBlock()
+	case *parser.ReturnStmt:
+		if stmt.Result != nil {
+			c.expr(stmt.Result)
+		}
+	case *parser.ImportDecl:
+		if stmt.Namespace != nil {
+			c.assign(stmt.Namespace, kindImport)
+		}
+		if stmt.Default != nil {
+			c.assign(stmt.Default, kindImport)
+		}
+		for _, spec := range stmt.Specs {
+			c.assign(&parser.Ident{
+				Name:    spec.LocalName(),
+				NamePos: spec.Pos(),
+			}, kindImport)
+		}
+	case *parser.ExportDecl:
+		switch {
+		case stmt.Default != nil:
+			c.expr(stmt.Default)
+		case stmt.Value != nil:
+			v := c.assign(stmt.Name, kindVar)
+			v.used = true
+			c.expr(stmt.Value)
+		default:
+			for _, spec := range stmt.Specs {
+				c.exports = append(c.exports, spec.Name)
+			}
+		}
+	case *parser.ExportStmt:
+		c.expr(stmt.Result)
+	}
+}
+
+// assignStmt checks the assignment to the variable or to the element of the
+// variable. The value is nil for the increment and decrement statements.
+func (c *checker) assignStmt(lhs, value parser.Expr, op token.Token) {
+	ident, selectors := splitAssignLHS(lhs)
+	if ident == nil {
+		c.expr(lhs)
+		if value != nil {
+			c.expr(value)
+		}
+		return
+	}
+
+	switch {
+	case len(selectors) > 0:
+		// the element of the variable is assigned
+		c.use(ident)
+	case op != token.Assign:
+		// the variable is read and assigned, which doesn't count as a use
+		c.read(ident)
+	}
+	if op == token.Assign && len(selectors) == 0 {
+		kind := kindVar
+		if _, ok := value.(*parser.ImportExpr); ok {
+			kind = kindImport
+		}
+		v, ok := c.resolve(ident.Name)
+		if !ok {
+			v = c.define(ident.Name, ident.Pos(), kind)
+			v.pending = true
+			defer func() { v.pending = false }()
+		} else if v == nil {
+			c.report(ident.Pos(), RuleShadow,
+				"assignment to the builtin function '%s'", ident.Name)
+		}
+	}
+	if value != nil {
+		c.expr(value)
+	}
+	for _, sel := range selectors {
+		c.expr(sel)
+	}
+}
+
+// splitAssignLHS returns the variable and the selectors of the left-hand side
+// of the assignment.
+func splitAssignLHS(expr parser.Expr) (*parser.Ident, []parser.Expr) {
+	switch expr := expr.(type) {
+	case *parser.SelectorExpr:
+		ident, selectors := splitAssignLHS(expr.Expr)
+		return ident, selectors
+	case *parser.IndexExpr:
+		ident, selectors := splitAssignLHS(expr.Expr)
+		return ident, append(selectors, expr.Index)
+	case *parser.Ident:
+		return expr, nil
+	}
+	return nil, nil
+}
+
+func (c *checker) expr(x parser.Expr) {
+	c.mark(x)
+	switch x := x.(type) {
+	case *parser.Ident:
+		c.use(x)
+	case *parser.BinaryExpr:
+		if x.Token == token.Equal || x.Token == token.NotEqual {
+			_, lhs := x.LHS.(*parser.UndefinedLit)
+			_, rhs := x.RHS.(*parser.UndefinedLit)
+			if lhs != rhs {
+				other := x.LHS
+				if lhs {
+					other = x.RHS
+				}
+				c.report(x.TokenPos, RuleUndefinedCompare,
+					"comparison with undefined, use is_undefined(%s)",
+					other.String())
+			}
+		}
+		c.expr(x.LHS)
+		c.expr(x.RHS)
+	case *parser.UnaryExpr:
+		c.expr(x.Expr)
+	case *parser.ParenExpr:
+		c.expr(x.Expr)
+	case *parser.CondExpr:
+		c.expr(x.Cond)
+		c.expr(x.True)
+		c.expr(x.False)
+	case *parser.SelectorExpr:
+		c.expr(x.Expr)
+	case *parser.IndexExpr:
+		c.expr(x.Expr)
+		c.expr(x.Index)
+	case *parser.SliceExpr:
+		c.expr(x.Expr)
+		if x.Low != nil {
+			c.expr(x.Low)
+		}
+		if x.High != nil {
+			c.expr(x.High)
+		}
+	case *parser.CallExpr:
+		c.expr(x.Func)
+		for _, arg := range x.Args {
+			c.expr(arg)
+		}
+	case *parser.ArrayLit:
+		for _, elem := range x.Elements {
+			c.expr(elem)
+		}
+	case *parser.MapLit:
+		for _, elem := range x.Elements {
+			c.expr(elem.Value)
+		}
+	case *parser.ErrorExpr:
+		c.expr(x.Expr)
+	case *parser.ImmutableExpr:
+		c.expr(x.Expr)
+	case *parser.FuncLit:
+		c.table = c.table.Fork(false)
+		c.functions = append(c.functions, c.table)
+		for _, param := range x.Type.Params.List {
+			c.defineShadow(param, kindParam)
+		}
+		c.stmt(x.Body)
+		c.functions = c.functions[:len(c.functions)-1]
+		c.table = c.table.Parent(false)
+	}
+}
@@ -0,0 +1,205 @@
+// Package lint implements a static checker of the Nanojs source code. It
+// reports the mistakes that only surface at runtime or never, like the use of
+// undefined variables in the rarely run branches or the unused imports.
+//
+// The rules can be enabled and disabled individually, and the diagnostics on
+// a line can be suppressed with a "// nanojs-ignore" comment on the line or
+// on the line before, optionally followed by the names of the rules to
+// suppress:
+//
+//	x = y == undefined // nanojs-ignore undefined-compare
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+// List of the rules.
+const (
+	// RuleUndefined reports the variables that are used but not defined, or
+	// used in their own definition.
+	RuleUndefined = "undefined"
+	// RuleUnused reports the local variables and the for-in keys that are
+	// assigned but never used.
+	RuleUnused = "unused"
+	// RuleShadow reports the function parameters and the for-in keys that
+	// shadow the variables or the builtin functions of the outer scopes.
+	RuleShadow = "shadow"
+	// RuleUnreachable reports the statements after a return, break or
+	// continue statement.
+	RuleUnreachable = "unreachable"
+	// RuleUndefinedCompare reports the comparisons with undefined using "=="
+	// or "!=" instead of the is_undefined builtin function.
+	RuleUndefinedCompare = "undefined-compare"
+	// RuleUnusedImport reports the imported modules and names that are never
+	// used.
+	RuleUnusedImport = "unused-import"
+)
+
+// Rules is the list of all the rules.
+var Rules = []string{
+	RuleUndefined,
+	RuleUnused,
+	RuleShadow,
+	RuleUnreachable,
+	RuleUndefinedCompare,
+	RuleUnusedImport,
+}
+
+// ignoreDirective is the comment that suppresses the diagnostics.
+const ignoreDirective = "nanojs-ignore"
+
+// Diagnostic is a problem reported by a rule.
+type Diagnostic struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+
+	pos parser.Pos
+}
+
+func (d *Diagnostic) String() string {
+	pos := parser.SourceFilePos{Filename: d.File, Line: d.Line,
+		Column: d.Column}
+	return fmt.Sprintf("%s: %s (%s)", pos, d.Message, d.Rule)
+}
+
+// Linter checks the parsed source files with the enabled rules.
+type Linter struct {
+	enabled map[string]bool
+	globals []string
+}
+
+// New creates a Linter with all the rules enabled.
+func New() *Linter {
+	l := &Linter{enabled: make(map[string]bool)}
+	for _, rule := range Rules {
+		l.enabled[rule] = true
+	}
+	return l
+}
+
+// Enable enables the rules. It returns an error if a rule doesn't exist.
+func (l *Linter) Enable(rules ...string) error {
+	return l.setEnabled(rules, true)
+}
+
+// Disable disables the rules. It returns an error if a rule doesn't exist.
+func (l *Linter) Disable(rules ...string) error {
+	return l.setEnabled(rules, false)
+}
+
+func (l *Linter) setEnabled(rules []string, enabled bool) error {
+	for _, rule := range rules {
+		if _, ok := l.enabled[rule]; !ok {
+			return fmt.Errorf("unknown rule '%s'", rule)
+		}
+	}
+	for _, rule := range rules {
+		l.enabled[rule] = enabled
+	}
+	return nil
+}
+
+// Enabled returns true if the rule is enabled.
+func (l *Linter) Enabled(rule string) bool {
+	return l.enabled[rule]
+}
+
+// SetGlobals sets the names of the global variables that are defined by the
+// host application (e.g. Script.Add), so they are not reported as undefined.
+func (l *Linter) SetGlobals(names ...string) {
+	l.globals = append([]string{}, names...)
+}
+
+// Source parses and checks the source code. It returns the parse errors if
+// the source code is invalid.
+func (l *Linter) Source(filename string, src []byte) ([]*Diagnostic, error) {
+	if len(src) > 1 && string(src[:2]) == "#!" {
+		src = append([]byte("//"), src[2:]...)
+	}
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(filename, -1, len(src))
+	p := parser.NewParser(srcFile, src, nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+	return l.File(file), nil
+}
+
+// File checks the parsed file and returns the diagnostics sorted by their
+// positions.
+func (l *Linter) File(file *parser.File) []*Diagnostic {
+	c := newChecker(file.InputFile, l.globals)
+	c.file(file)
+
+	ignored := c.ignoredLines(file.Comments)
+	var res []*Diagnostic
+	for _, d := range c.diagnostics {
+		if !l.enabled[d.Rule] || ignored.has(d) {
+			continue
+		}
+		res = append(res, d)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].pos < res[j].pos
+	})
+	return res
+}
+
+// ignoreSet maps the lines to the rules suppressed by the directives. A nil
+// list of rules suppresses all the rules.
+type ignoreSet map[int][]string
+
+func (s ignoreSet) has(d *Diagnostic) bool {
+	rules, ok := s[d.Line]
+	if !ok {
+		return false
+	}
+	if rules == nil {
+		return true
+	}
+	for _, rule := range rules {
+		if rule == d.Rule {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredLines returns the lines suppressed by the directives. The directive
+// applies to its own line if it follows code, or to the next line otherwise.
+func (c *checker) ignoredLines(groups []*parser.CommentGroup) ignoreSet {
+	res := make(ignoreSet)
+	for _, g := range groups {
+		for _, comment := range g.List {
+			text := strings.TrimPrefix(comment.Text, "//")
+			if strings.HasPrefix(comment.Text, "/*") {
+				text = strings.TrimSuffix(comment.Text[2:], "*/")
+			}
+			fields := strings.FieldsFunc(text, func(r rune) bool {
+				return r == ' ' || r == '\t' || r == ','
+			})
+			if len(fields) == 0 || fields[0] != ignoreDirective {
+				continue
+			}
+			var rules []string
+			if len(fields) > 1 {
+				rules = fields[1:]
+			}
+			line := c.position(comment.Pos()).Line
+			if first, ok := c.codeLines[line]; !ok || first > comment.Pos() {
+				line = c.position(comment.End()).Line + 1
+			}
+			res[line] = rules
+		}
+	}
+	return res
+}
@@ -0,0 +1,206 @@
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2/lint"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+func TestUndefined(t *testing.T) {
+	expectLint(t, `
+a = 1
+if (a > 0) {
+	b = c
+}
+f = function() {
+	return a + d + len([])
+}
+x = x + 1
+g = function(n) {
+	return n > 0 ? g(n - 1) : 0
+}
+e++
+u = undefined_var.field
+`,
+		"4:6: undefined: c (undefined)",
+		"7:13: undefined: d (undefined)",
+		"9:5: 'x' is used in its own definition (undefined)",
+		"13:1: undefined: e (undefined)",
+		"14:5: undefined: undefined_var (undefined)")
+
+	// export list
+	expectLint(t, "a = 1\nexport {a, b as c}",
+		"2:12: undefined: b (undefined)")
+
+	// host globals
+	l := lint.New()
+	l.SetGlobals("host")
+	expectLinter(t, l, "x = host.value")
+}
+
+func TestUnused(t *testing.T) {
+	expectLint(t, `
+g = 1
+f = function(p, q) {
+	a = 1
+	b = 2
+	c = 0
+	c += 1
+	_d = 3
+	return b
+}
+for (k in [1]) {}
+for (k in [1]) {
+	g = k
+}
+for (_ in [1]) {}
+h = function() {
+	counter = 0
+	return function() {
+		counter++
+		return counter
+	}
+}
+`,
+		"4:2: 'a' is assigned but never used (unused)",
+		"6:2: 'c' is assigned but never used (unused)",
+		"11:6: for-in key 'k' is not used (unused)")
+}
+
+func TestShadow(t *testing.T) {
+	expectLint(t, `
+a = 1
+f = function(a, len) {
+	return a + len
+}
+for (a in [1]) {
+	b = a
+}
+g = function(x) {
+	return function(x) {
+		return x
+	}
+}
+format = 1
+`,
+		"3:14: 'a' shadows the variable declared at 2:1 (shadow)",
+		"3:17: 'len' shadows the builtin function (shadow)",
+		"6:6: 'a' shadows the variable declared at 2:1 (shadow)",
+		"10:18: 'x' shadows the variable declared at 9:14 (shadow)",
+		"14:1: assignment to the builtin function 'format' (shadow)")
+}
+
+func TestUnreachable(t *testing.T) {
+	expectLint(t, `
+f = function(x) {
+	if (x) {
+		return 1
+	} else {
+		return 2
+	}
+	x = 1
+	return x
+}
+for (i = 0; i < 3; i++) {
+	if (i == 1) {
+		continue
+		i = 2
+	}
+	break
+	;
+	i = 3
+}
+`,
+		"8:2: unreachable code (unreachable)",
+		"14:3: unreachable code (unreachable)",
+		"18:2: unreachable code (unreachable)")
+}
+
+func TestUndefinedCompare(t *testing.T) {
+	expectLint(t, `
+a = 1
+b = a == undefined
+c = undefined != a.b
+d = undefined == undefined
+e = is_undefined(a)
+`,
+		"3:7: comparison with undefined, use is_undefined(a) "+
+			"(undefined-compare)",
+		"4:15: comparison with undefined, use is_undefined(a.b) "+
+			"(undefined-compare)")
+}
+
+func TestUnusedImport(t *testing.T) {
+	expectLint(t, `
+fmt = import("fmt")
+text = import("text")
+import("math")
+import lib, {a, b as c} from "./lib"
+import * as ns from "./ns"
+fmt.println(c)
+f = function() {
+	times = import("times")
+}
+`,
+		"3:1: 'text' is imported but not used (unused-import)",
+		"4:1: result of import(\"math\") is not used (unused-import)",
+		"5:8: 'lib' is imported but not used (unused-import)",
+		"5:14: 'a' is imported but not used (unused-import)",
+		"6:13: 'ns' is imported but not used (unused-import)",
+		"9:2: 'times' is imported but not used (unused-import)")
+}
+
+func TestIgnore(t *testing.T) {
+	expectLint(t, `
+a = b // nanojs-ignore
+// nanojs-ignore
+c = d
+e = f // nanojs-ignore unused
+// nanojs-ignore unused, undefined
+g = h
+i = undefined == j /* nanojs-ignore undefined-compare */
+`,
+		"5:5: undefined: f (undefined)",
+		"8:18: undefined: j (undefined)")
+}
+
+func TestRules(t *testing.T) {
+	src := "import(\"fmt\")\nx = y"
+	l := lint.New()
+	require.NoError(t, l.Disable(lint.RuleUnusedImport))
+	require.False(t, l.Enabled(lint.RuleUnusedImport))
+	expectLinter(t, l, src, "2:5: undefined: y (undefined)")
+
+	require.NoError(t, l.Disable(lint.Rules...))
+	require.NoError(t, l.Enable(lint.RuleUnusedImport))
+	expectLinter(t, l, src,
+		"1:1: result of import(\"fmt\") is not used (unused-import)")
+
+	require.Error(t, l.Enable("unknown"))
+	require.Error(t, l.Disable(lint.RuleUndefined, "unknown"))
+	require.False(t, l.Enabled(lint.RuleUndefined))
+
+	// parse error
+	_, err := lint.New().Source("test", []byte("x = ("))
+	require.Error(t, err)
+}
+
+func expectLint(t *testing.T, src string, expected ...string) {
+	expectLinter(t, lint.New(), src, expected...)
+}
+
+func expectLinter(
+	t *testing.T,
+	l *lint.Linter,
+	src string,
+	expected ...string,
+) {
+	res, err := l.Source("", []byte(src))
+	require.NoError(t, err)
+	var actual []string
+	for _, d := range res {
+		actual = append(actual, d.String())
+	}
+	require.Equal(t, expected, actual, src)
+}
@@ -0,0 +1,112 @@
+package lsp
+
+import (
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2/docs"
+)
+
+// funcDoc is the documentation of a builtin function or a module member.
+type funcDoc struct {
+	Signature string // e.g. "abs(x float) => float", or the name
+	Text      string
+}
+
+// docIndex is the documentation from the docs tables.
+type docIndex struct {
+	builtins map[string]*funcDoc
+	modules  map[string]map[string]*funcDoc
+}
+
+var (
+	moduleTitle = regexp.MustCompile(`^# Module - "(\w+)"`)
+	memberItem  = regexp.MustCompile("^- `(\\w+)([^`]*)`:?\\s*(.*)$")
+)
+
+// loadDocs parses the documents of the builtin functions and the standard
+// library modules.
+func loadDocs(files fs.FS) *docIndex {
+	d := &docIndex{
+		builtins: make(map[string]*funcDoc),
+		modules:  make(map[string]map[string]*funcDoc),
+	}
+	if data, err := fs.ReadFile(files, "builtins.md"); err == nil {
+		d.parseBuiltins(string(data))
+	}
+	names, _ := fs.Glob(files, "stdlib-*.md")
+	for _, name := range names {
+		if data, err := fs.ReadFile(files, name); err == nil {
+			d.parseModule(string(data))
+		}
+	}
+	return d
+}
+
+// parseBuiltins reads the sections of the builtin functions. The text of a
+// function is its description before the first code block.
+func (d *docIndex) parseBuiltins(text string) {
+	var cur *funcDoc
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			name := strings.TrimSpace(line[3:])
+			cur = &funcDoc{Signature: name}
+			d.builtins[name] = cur
+			inCode = false
+		case cur == nil:
+		case strings.HasPrefix(line, "```"):
+			inCode = !inCode
+			if inCode && cur.Text != "" {
+				cur.Text = strings.TrimSpace(cur.Text)
+				cur = nil
+			}
+		case !inCode:
+			cur.Text += line + "\n"
+		}
+	}
+	for _, doc := range d.builtins {
+		doc.Text = strings.TrimSpace(doc.Text)
+	}
+}
+
+// parseModule reads the list items of the functions and the constants of
+// the module. The sections of the object types are skipped.
+func (d *docIndex) parseModule(text string) {
+	var members map[string]*funcDoc
+	var cur *funcDoc
+	inList := false
+	for _, line := range strings.Split(text, "\n") {
+		if m := moduleTitle.FindStringSubmatch(line); m != nil {
+			members = make(map[string]*funcDoc)
+			d.modules[m[1]] = members
+			continue
+		}
+		if members == nil {
+			continue
+		}
+		if strings.HasPrefix(line, "## ") {
+			section := strings.TrimSpace(line[3:])
+			inList = section == "Functions" || section == "Constants"
+			cur = nil
+			continue
+		}
+		if !inList {
+			continue
+		}
+		if m := memberItem.FindStringSubmatch(line); m != nil {
+			cur = &funcDoc{Signature: m[1] + m[2], Text: m[3]}
+			members[m[1]] = cur
+			continue
+		}
+		if cur != nil && strings.HasPrefix(line, "  ") {
+			cur.Text += " " + strings.TrimSpace(line)
+			continue
+		}
+		cur = nil
+	}
+}
+
+var defaultDocs = loadDocs(docs.Files)
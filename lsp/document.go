@@ -0,0 +1,221 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+// text is the content of a document with its line offsets.
+type text struct {
+	src   string
+	lines []int // offsets of the first characters of the lines
+}
+
+func newText(src string) *text {
+	t := &text{src: src, lines: []int{0}}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			t.lines = append(t.lines, i+1)
+		}
+	}
+	return t
+}
+
+// offset converts the position into the byte offset.
+func (t *text) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(t.lines) {
+		return len(t.src)
+	}
+	offset := t.lines[pos.Line]
+	for n := 0; n < pos.Character && offset < len(t.src); {
+		r, size := utf8.DecodeRuneInString(t.src[offset:])
+		if r == '\n' {
+			break
+		}
+		n += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// position converts the byte offset into the position.
+func (t *text) position(offset int) Position {
+	if offset > len(t.src) {
+		offset = len(t.src)
+	}
+	line := sort.Search(len(t.lines), func(i int) bool {
+		return t.lines[i] > offset
+	}) - 1
+	n := 0
+	for _, r := range t.src[t.lines[line]:offset] {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return Position{Line: line, Character: n}
+}
+
+func (t *text) rangeOf(start, end int) Range {
+	return Range{Start: t.position(start), End: t.position(end)}
+}
+
+// analysis is the result of parsing and indexing a version of the document.
+type analysis struct {
+	*text
+	srcFile *parser.SourceFile
+	file    *parser.File
+	index   *index
+}
+
+// pos converts the byte offset into the position in the parsed file.
+func (a *analysis) pos(offset int) parser.Pos {
+	return parser.Pos(a.srcFile.Base + offset)
+}
+
+// offsetOf converts the position in the parsed file into the byte offset.
+func (a *analysis) offsetOf(pos parser.Pos) int {
+	offset := int(pos) - a.srcFile.Base
+	if offset < 0 {
+		return 0
+	}
+	if offset > len(a.src) {
+		return len(a.src)
+	}
+	return offset
+}
+
+func (a *analysis) rangeOfNode(pos, end parser.Pos) Range {
+	return a.rangeOf(a.offsetOf(pos), a.offsetOf(end))
+}
+
+// document is an open text document.
+type document struct {
+	uri  string
+	text *text
+	// last is the last analysis of the document that was parsed without
+	// errors, which is used while the document is being edited.
+	last        *analysis
+	diagnostics []*Diagnostic
+}
+
+// path returns the file path of the document URI, or an empty string if it's
+// not a file URI.
+func (d *document) path() string {
+	u, err := url.Parse(d.uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// update sets the content of the document, parses it, and compiles it if it
+// is parsed without errors. A panic of the analysis is reported as a
+// diagnostic.
+func (d *document) update(src string, modules *nanojs.ModuleMap) {
+	d.text = newText(src)
+	d.diagnostics = nil
+	defer func() {
+		if r := recover(); r != nil {
+			d.diagnostics = append(d.diagnostics, &Diagnostic{
+				Severity: severityError,
+				Source:   "nanojs",
+				Message:  fmt.Sprintf("internal error: %v", r),
+			})
+		}
+	}()
+
+	fileSet := parser.NewFileSet()
+	srcFile := fileSet.AddFile(filepath.Base(d.path()), -1, len(src))
+	p := parser.NewParser(srcFile, []byte(scriptSource(src)), nil)
+	file, err := p.ParseFile()
+	if err != nil {
+		var errs parser.ErrorList
+		if errors.As(err, &errs) {
+			for _, e := range errs {
+				d.diagnostics = append(d.diagnostics, d.parseError(e))
+			}
+		}
+		return
+	}
+	d.last = &analysis{
+		text:    d.text,
+		srcFile: srcFile,
+		file:    file,
+		index:   newIndex(file),
+	}
+
+	c := nanojs.NewCompiler(srcFile, nil, nil, modules, nil)
+	c.SetMaxErrors(0)
+	if path := d.path(); path != "" {
+		c.EnableFileImport(true)
+		c.SetImportDir(filepath.Dir(path))
+	}
+	if err := c.Compile(file); err != nil {
+		d.diagnostics = append(d.diagnostics, d.compileErrors(err)...)
+	}
+}
+
+// scriptSource comments out the shebang line, which the parser rejects.
+func scriptSource(src string) string {
+	if strings.HasPrefix(src, "#!") {
+		return "//" + src[2:]
+	}
+	return src
+}
+
+func (d *document) parseError(e *parser.Error) *Diagnostic {
+	start := len(d.text.src)
+	if line := e.Pos.Line - 1; line >= 0 && line < len(d.text.lines) {
+		start = d.text.lines[line] + e.Pos.Column - 1
+	}
+	if start > len(d.text.src) {
+		start = len(d.text.src)
+	}
+	end := start
+	if end < len(d.text.src) && d.text.src[end] != '\n' {
+		end++
+	}
+	return &Diagnostic{
+		Range:    d.text.rangeOf(start, end),
+		Severity: severityError,
+		Source:   "nanojs",
+		Message:  e.Msg,
+	}
+}
+
+func (d *document) compileErrors(err error) []*Diagnostic {
+	var list nanojs.CompilerErrorList
+	if !errors.As(err, &list) {
+		return []*Diagnostic{{
+			Severity: severityError,
+			Source:   "nanojs",
+			Message:  err.Error(),
+		}}
+	}
+	var res []*Diagnostic
+	for _, e := range list {
+		diag := &Diagnostic{
+			Severity: severityError,
+			Source:   "nanojs",
+			Message:  e.Err.Error(),
+		}
+		if e.FileSet.File(e.Node.Pos()) == d.last.srcFile {
+			diag.Range = d.last.rangeOfNode(e.Node.Pos(), e.Node.End())
+		} else {
+			// the error is in an imported module
+			diag.Message = e.Error()
+		}
+		res = append(res, diag)
+	}
+	return res
+}
@@ -0,0 +1,376 @@
+package lsp
+
+import (
+	"sort"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+// symbol kinds
+const (
+	kindVar = iota
+	kindParam
+	kindKey
+	kindImport
+)
+
+// symbol is a variable defined in the source file.
+type symbol struct {
+	name     string
+	pos      parser.Pos // position of the defining identifier
+	scopeEnd parser.Pos // end of the scope the variable is visible in
+	kind     int
+	module   string          // name of the imported module
+	member   string          // name of the imported member of the module
+	fn       *parser.FuncLit // function assigned at the definition
+}
+
+// reference is an occurrence of a name: a variable, a builtin function, or
+// a member of an imported module.
+type reference struct {
+	pos, end parser.Pos
+	name     string
+	sym      *symbol // the variable, or the module of the member
+	builtin  bool
+	member   bool
+}
+
+// index resolves the names of a source file with the symbol tables like the
+// compiler does.
+type index struct {
+	symbols []*symbol
+	refs    []*reference // sorted by position
+
+	table     *nanojs.SymbolTable
+	functions []*nanojs.SymbolTable // symbol tables of the functions
+	defined   map[*nanojs.Symbol]*symbol
+	scopeEnds []parser.Pos
+	exports   []*parser.Ident
+}
+
+func newIndex(file *parser.File) *index {
+	x := &index{
+		table:   nanojs.NewSymbolTable(),
+		defined: make(map[*nanojs.Symbol]*symbol),
+	}
+	x.functions = []*nanojs.SymbolTable{x.table}
+	for idx, fn := range nanojs.GetAllBuiltinFunctions() {
+		x.table.DefineBuiltin(idx, fn.Name)
+	}
+	// the top-level variables are visible to the end of the file
+	x.scopeEnds = []parser.Pos{
+		parser.Pos(file.InputFile.Base + file.InputFile.Size),
+	}
+
+	x.stmtList(file.Stmts)
+	for _, ident := range x.exports {
+		x.use(ident)
+	}
+	sort.SliceStable(x.refs, func(i, j int) bool {
+		return x.refs[i].pos < x.refs[j].pos
+	})
+	return x
+}
+
+// at returns the reference at the position.
+func (x *index) at(pos parser.Pos) *reference {
+	i := sort.Search(len(x.refs), func(i int) bool {
+		return x.refs[i].end >= pos
+	})
+	if i < len(x.refs) && x.refs[i].pos <= pos {
+		return x.refs[i]
+	}
+	return nil
+}
+
+// visible returns the variables visible at the position, the innermost
+// first.
+func (x *index) visible(pos parser.Pos) []*symbol {
+	var res []*symbol
+	seen := make(map[string]bool)
+	for i := len(x.symbols) - 1; i >= 0; i-- {
+		s := x.symbols[i]
+		if s.pos > pos || pos > s.scopeEnd || seen[s.name] {
+			continue
+		}
+		seen[s.name] = true
+		res = append(res, s)
+	}
+	return res
+}
+
+// references returns the references to the variable.
+func (x *index) references(s *symbol) []*reference {
+	var res []*reference
+	for _, ref := range x.refs {
+		if ref.sym == s && !ref.member {
+			res = append(res, ref)
+		}
+	}
+	return res
+}
+
+func (x *index) enterScope(end parser.Pos, block bool) {
+	x.table = x.table.Fork(block)
+	x.scopeEnds = append(x.scopeEnds, end)
+}
+
+func (x *index) leaveScope() {
+	x.table = x.table.Parent(false)
+	x.scopeEnds = x.scopeEnds[:len(x.scopeEnds)-1]
+}
+
+// resolve returns the variable of the name, or nil if the name is undefined
+// or a builtin function.
+func (x *index) resolve(name string) (*symbol, bool) {
+	s, _, ok := x.table.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+	for depth := len(x.functions) - 1; s.Scope == nanojs.ScopeFree &&
+		depth > 0; depth-- {
+		s = x.functions[depth].FreeSymbols()[s.Index]
+	}
+	return x.defined[s], true
+}
+
+func (x *index) define(ident *parser.Ident, kind int) *symbol {
+	s := &symbol{
+		name:     ident.Name,
+		pos:      ident.Pos(),
+		scopeEnd: x.scopeEnds[len(x.scopeEnds)-1],
+		kind:     kind,
+	}
+	x.defined[x.table.Define(ident.Name)] = s
+	x.symbols = append(x.symbols, s)
+	x.addRef(ident, s)
+	return s
+}
+
+func (x *index) addRef(ident *parser.Ident, s *symbol) {
+	x.refs = append(x.refs, &reference{
+		pos:  ident.Pos(),
+		end:  ident.End(),
+		name: ident.Name,
+		sym:  s,
+	})
+}
+
+// use adds the reference to the variable or the builtin function.
+func (x *index) use(ident *parser.Ident) {
+	s, ok := x.resolve(ident.Name)
+	if !ok {
+		return
+	}
+	x.addRef(ident, s)
+	if s == nil {
+		x.refs[len(x.refs)-1].builtin = true
+	}
+}
+
+// assign resolves the variable, defining it if it doesn't exist.
+func (x *index) assign(ident *parser.Ident, kind int) *symbol {
+	if _, ok := x.resolve(ident.Name); ok {
+		x.use(ident)
+		return nil
+	}
+	return x.define(ident, kind)
+}
+
+func (x *index) stmtList(stmts []parser.Stmt) {
+	for _, stmt := range stmts {
+		x.stmt(stmt)
+	}
+}
+
+func (x *index) stmt(stmt parser.Stmt) {
+	switch stmt := stmt.(type) {
+	case *parser.ExprStmt:
+		x.expr(stmt.Expr)
+	case *parser.IncDecStmt:
+		x.expr(stmt.Expr)
+	case *parser.AssignStmt:
+		if len(stmt.LHS) != 1 || len(stmt.RHS) != 1 {
+			for _, e := range stmt.LHS {
+				x.expr(e)
+			}
+			for _, e := range stmt.RHS {
+				x.expr(e)
+			}
+			return
+		}
+		ident, ok := stmt.LHS[0].(*parser.Ident)
+		if !ok {
+			x.expr(stmt.RHS[0])
+			x.expr(stmt.LHS[0])
+			return
+		}
+		if _, ok := x.resolve(ident.Name); ok {
+			x.use(ident)
+			x.expr(stmt.RHS[0])
+			return
+		}
+		// the new variable is defined before its value is compiled
+		s := x.define(ident, kindVar)
+		switch value := stmt.RHS[0].(type) {
+		case *parser.ImportExpr:
+			s.kind = kindImport
+			s.module = value.ModuleName
+		case *parser.FuncLit:
+			s.fn = value
+		}
+		x.expr(stmt.RHS[0])
+	case *parser.IfStmt:
+		x.enterScope(stmt.End(), true)
+		if stmt.Init != nil {
+			x.stmt(stmt.Init)
+		}
+		x.expr(stmt.Cond)
+		x.stmt(stmt.Body)
+		if stmt.Else != nil {
+			x.stmt(stmt.Else)
+		}
+		x.leaveScope()
+	case *parser.ForStmt:
+		x.enterScope(stmt.End(), true)
+		if stmt.Init != nil {
+			x.stmt(stmt.Init)
+		}
+		if stmt.Cond != nil {
+			x.expr(stmt.Cond)
+		}
+		if stmt.Post != nil {
+			x.stmt(stmt.Post)
+		}
+		x.stmt(stmt.Body)
+		x.leaveScope()
+	case *parser.ForInStmt:
+		x.enterScope(stmt.End(), true)
+		x.expr(stmt.Iterable)
+		if stmt.Key.Name != "_" {
+			x.define(stmt.Key, kindKey)
+		}
+		x.stmt(stmt.Body)
+		x.leaveScope()
+	case *parser.BlockStmt:
+		if len(stmt.Stmts) == 0 {
+			return
+		}
+		x.enterScope(stmt.End(), true)
+		x.stmtList(stmt.Stmts)
+		x.leaveScope()
+	case *parser.ReturnStmt:
+		if stmt.Result != nil {
+			x.expr(stmt.Result)
+		}
+	case *parser.ImportDecl:
+		module := stmt.Module.Value
+		if stmt.Namespace != nil {
+			if s := x.assign(stmt.Namespace, kindImport); s != nil {
+				s.module = module
+			}
+		}
+		if stmt.Default != nil {
+			x.assign(stmt.Default, kindImport)
+		}
+		for _, spec := range stmt.Specs {
+			local := spec.Name
+			if spec.Alias != nil {
+				local = spec.Alias
+			}
+			if s := x.assign(local, kindImport); s != nil {
+				s.module = module
+				s.member = spec.Name.Name
+			}
+		}
+	case *parser.ExportDecl:
+		switch {
+		case stmt.Default != nil:
+			x.expr(stmt.Default)
+		case stmt.Value != nil:
+			if s := x.assign(stmt.Name, kindVar); s != nil {
+				s.fn, _ = stmt.Value.(*parser.FuncLit)
+			}
+			x.expr(stmt.Value)
+		default:
+			for _, spec := range stmt.Specs {
+				x.exports = append(x.exports, spec.Name)
+			}
+		}
+	case *parser.ExportStmt:
+		x.expr(stmt.Result)
+	}
+}
+
+func (x *index) expr(e parser.Expr) {
+	switch e := e.(type) {
+	case *parser.Ident:
+		x.use(e)
+	case *parser.BinaryExpr:
+		x.expr(e.LHS)
+		x.expr(e.RHS)
+	case *parser.UnaryExpr:
+		x.expr(e.Expr)
+	case *parser.ParenExpr:
+		x.expr(e.Expr)
+	case *parser.CondExpr:
+		x.expr(e.Cond)
+		x.expr(e.True)
+		x.expr(e.False)
+	case *parser.SelectorExpr:
+		x.expr(e.Expr)
+		ident, ok := e.Expr.(*parser.Ident)
+		sel, isName := e.Sel.(*parser.StringLit)
+		if !ok || !isName {
+			return
+		}
+		if s, _ := x.resolve(ident.Name); s != nil && s.module != "" &&
+			s.member == "" {
+			x.refs = append(x.refs, &reference{
+				pos:    sel.Pos(),
+				end:    sel.End(),
+				name:   sel.Value,
+				sym:    s,
+				member: true,
+			})
+		}
+	case *parser.IndexExpr:
+		x.expr(e.Expr)
+		x.expr(e.Index)
+	case *parser.SliceExpr:
+		x.expr(e.Expr)
+		if e.Low != nil {
+			x.expr(e.Low)
+		}
+		if e.High != nil {
+			x.expr(e.High)
+		}
+	case *parser.CallExpr:
+		x.expr(e.Func)
+		for _, arg := range e.Args {
+			x.expr(arg)
+		}
+	case *parser.ArrayLit:
+		for _, elem := range e.Elements {
+			x.expr(elem)
+		}
+	case *parser.MapLit:
+		for _, elem := range e.Elements {
+			x.expr(elem.Value)
+		}
+	case *parser.ErrorExpr:
+		x.expr(e.Expr)
+	case *parser.ImmutableExpr:
+		x.expr(e.Expr)
+	case *parser.FuncLit:
+		x.enterScope(e.End(), false)
+		x.functions = append(x.functions, x.table)
+		for _, param := range e.Type.Params.List {
+			x.define(param, kindParam)
+		}
+		x.stmt(e.Body)
+		x.functions = x.functions[:len(x.functions)-1]
+		x.leaveScope()
+	}
+}
@@ -0,0 +1,500 @@
+// Package lsp implements a Language Server Protocol server for Nanojs. It
+// reports the parse and compile errors, and provides the go-to-definition,
+// the references, the hover information, the completion and the document
+// formatting.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/format"
+	"github.com/zeaphoo/nanojs/v2/parser"
+	"github.com/zeaphoo/nanojs/v2/stdlib"
+)
+
+// Server is a language server communicating over a stream, like the
+// standard input and output.
+type Server struct {
+	modules   *nanojs.ModuleMap
+	docs      *docIndex
+	documents map[string]*document
+	out       io.Writer
+	shutdown  bool
+}
+
+// NewServer creates a Server. The modules are used to compile the documents.
+func NewServer(modules *nanojs.ModuleMap) *Server {
+	if modules == nil {
+		modules = nanojs.NewModuleMap()
+	}
+	return &Server{
+		modules:   modules,
+		docs:      defaultDocs,
+		documents: make(map[string]*document),
+	}
+}
+
+// Serve reads the requests from r and writes the responses to w until the
+// exit notification or the end of the input.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.out = w
+	in := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var e *responseError
+			if !errors.As(err, &e) {
+				return err
+			}
+			if err := s.reply(nil, nil, e); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(
+	id *json.RawMessage,
+	result interface{},
+	err *responseError,
+) error {
+	if id == nil && err == nil {
+		return nil
+	}
+	if result == nil && err == nil {
+		result = json.RawMessage("null")
+	}
+	return writeMessage(s.out, &message{ID: id, Result: result, Error: err})
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: method, Params: data})
+}
+
+// handle dispatches the message to its handler and sends the response if the
+// message is a request. A handler that panics fails the request rather than
+// the server.
+func (s *Server) handle(msg *message) (retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = s.reply(msg.ID, nil, &responseError{
+				Code:    codeInternalError,
+				Message: fmt.Sprintf("internal error: %v", r),
+			})
+		}
+	}()
+
+	var result interface{}
+	var err error
+	switch msg.Method {
+	case "initialize":
+		result = s.initialize()
+	case "initialized":
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			err = s.didOpen(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			err = s.didChange(&params)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			err = s.didClose(params.TextDocument.URI)
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.definition(&params)
+		}
+	case "textDocument/references":
+		var params referenceParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.references(&params)
+		}
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.hover(&params)
+		}
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.completion(&params)
+		}
+	case "textDocument/formatting":
+		var params documentFormattingParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.formatting(params.TextDocument.URI)
+		}
+	default:
+		if msg.ID == nil {
+			// unsupported notification
+			return nil
+		}
+		return s.reply(msg.ID, nil, &responseError{
+			Code:    codeMethodNotFound,
+			Message: fmt.Sprintf("method not found: %s", msg.Method),
+		})
+	}
+
+	var jsonErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &jsonErr) || errors.As(err, &syntaxErr) {
+		return s.reply(msg.ID, nil, &responseError{
+			Code:    codeInvalidParams,
+			Message: err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return s.reply(msg.ID, result, nil)
+}
+
+func (s *Server) initialize() interface{} {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":           1, // full content on change
+			"definitionProvider":         true,
+			"referencesProvider":         true,
+			"hoverProvider":              true,
+			"documentFormattingProvider": true,
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{"."},
+			},
+		},
+		"serverInfo": map[string]string{"name": "nanojs"},
+	}
+}
+
+func (s *Server) didOpen(uri, src string) error {
+	doc := &document{uri: uri}
+	s.documents[uri] = doc
+	return s.update(doc, src)
+}
+
+func (s *Server) didChange(params *didChangeParams) error {
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil || len(params.ContentChanges) == 0 {
+		return nil
+	}
+	// the server asks for the full content of the document on change
+	changes := params.ContentChanges
+	return s.update(doc, changes[len(changes)-1].Text)
+}
+
+func (s *Server) didClose(uri string) error {
+	delete(s.documents, uri)
+	return s.notify("textDocument/publishDiagnostics",
+		&publishDiagnosticsParams{URI: uri, Diagnostics: []*Diagnostic{}})
+}
+
+func (s *Server) update(doc *document, src string) error {
+	doc.update(src, s.modules)
+	diagnostics := doc.diagnostics
+	if diagnostics == nil {
+		diagnostics = []*Diagnostic{}
+	}
+	return s.notify("textDocument/publishDiagnostics",
+		&publishDiagnosticsParams{URI: doc.uri, Diagnostics: diagnostics})
+}
+
+// lookup returns the analysis of the document and the reference at the
+// position.
+func (s *Server) lookup(
+	params *textDocumentPositionParams,
+) (*analysis, *reference) {
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil || doc.last == nil {
+		return nil, nil
+	}
+	a := doc.last
+	return a, a.index.at(a.pos(a.offset(params.Position)))
+}
+
+func (s *Server) definition(params *textDocumentPositionParams) interface{} {
+	a, ref := s.lookup(params)
+	if ref == nil || ref.sym == nil || ref.member {
+		return nil
+	}
+	return &Location{
+		URI: params.TextDocument.URI,
+		Range: a.rangeOfNode(ref.sym.pos,
+			ref.sym.pos+parser.Pos(len(ref.sym.name))),
+	}
+}
+
+func (s *Server) references(params *referenceParams) interface{} {
+	a, ref := s.lookup(&params.textDocumentPositionParams)
+	if ref == nil || ref.sym == nil || ref.member {
+		return nil
+	}
+	res := []*Location{}
+	for _, r := range a.index.references(ref.sym) {
+		if r.pos == ref.sym.pos && !params.Context.IncludeDeclaration {
+			continue
+		}
+		res = append(res, &Location{
+			URI:   params.TextDocument.URI,
+			Range: a.rangeOfNode(r.pos, r.end),
+		})
+	}
+	return res
+}
+
+func (s *Server) hover(params *textDocumentPositionParams) interface{} {
+	a, ref := s.lookup(params)
+	if ref == nil {
+		return nil
+	}
+	var text string
+	switch {
+	case ref.builtin:
+		text = s.builtinHover(ref.name)
+	case ref.member:
+		text = s.memberHover(ref.sym.module, ref.name)
+	case ref.sym.member != "":
+		text = s.memberHover(ref.sym.module, ref.sym.member)
+	default:
+		text = symbolHover(ref.sym)
+	}
+	if text == "" {
+		return nil
+	}
+	r := a.rangeOfNode(ref.pos, ref.end)
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: text},
+		Range:    &r,
+	}
+}
+
+func (s *Server) builtinHover(name string) string {
+	doc := s.docs.builtins[name]
+	if doc == nil {
+		return codeBlock("builtin function " + name)
+	}
+	return codeBlock("builtin function "+name) + "\n" + doc.Text
+}
+
+func (s *Server) memberHover(module, name string) string {
+	doc := s.docs.modules[module][name]
+	if doc == nil {
+		if sig := s.signature(module, name); sig != nil {
+			return codeBlock(module+"."+sig.String()) + "\n" + sig.Doc
+		}
+		return ""
+	}
+	res := codeBlock(module + "." + doc.Signature)
+	if doc.Text != "" {
+		res += "\n" + doc.Text
+	}
+	return res
+}
+
+func symbolHover(sym *symbol) string {
+	switch sym.kind {
+	case kindParam:
+		return codeBlock("parameter " + sym.name)
+	case kindKey:
+		return codeBlock("for-in key " + sym.name)
+	case kindImport:
+		if sym.module != "" {
+			return codeBlock(fmt.Sprintf("%s = import(\"%s\")", sym.name,
+				sym.module))
+		}
+	}
+	if sym.fn != nil {
+		return codeBlock(sym.name + " = " + "function" + sym.fn.Type.Params.String())
+	}
+	return codeBlock(sym.name)
+}
+
+func codeBlock(code string) string {
+	return "```js\n" + code + "\n```\n"
+}
+
+func (s *Server) completion(params *textDocumentPositionParams) interface{} {
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil {
+		return nil
+	}
+	res := &CompletionList{Items: []*CompletionItem{}}
+
+	// the completion follows "name." or "name.prefix" in the current text
+	offset := doc.text.offset(params.Position)
+	start := offset
+	for start > 0 && isIdentChar(doc.text.src[start-1]) {
+		start--
+	}
+	if start > 0 && doc.text.src[start-1] == '.' {
+		end := start - 1
+		begin := end
+		for begin > 0 && isIdentChar(doc.text.src[begin-1]) {
+			begin--
+		}
+		if module := s.moduleOf(doc, doc.text.src[begin:end],
+			params.Position); module != "" {
+			res.Items = s.memberItems(module)
+		}
+		return res
+	}
+
+	if doc.last != nil {
+		a := doc.last
+		pos := a.pos(a.offset(params.Position))
+		for _, sym := range a.index.visible(pos) {
+			item := &CompletionItem{Label: sym.name, Kind: completionVariable}
+			switch {
+			case sym.module != "" && sym.member == "":
+				item.Kind = completionModule
+				item.Detail = fmt.Sprintf("import(\"%s\")", sym.module)
+			case sym.fn != nil:
+				item.Kind = completionFunction
+				item.Detail = "function" + sym.fn.Type.Params.String()
+			}
+			res.Items = append(res.Items, item)
+		}
+	}
+	for _, fn := range nanojs.GetAllBuiltinFunctions() {
+		item := &CompletionItem{
+			Label:  fn.Name,
+			Kind:   completionFunction,
+			Detail: "builtin function",
+		}
+		if doc := s.docs.builtins[fn.Name]; doc != nil {
+			item.Documentation = &MarkupContent{
+				Kind:  "markdown",
+				Value: doc.Text,
+			}
+		}
+		res.Items = append(res.Items, item)
+	}
+	return res
+}
+
+// moduleOf returns the name of the module imported to the variable visible
+// at the position.
+func (s *Server) moduleOf(doc *document, name string, at Position) string {
+	if doc.last == nil || name == "" {
+		return ""
+	}
+	a := doc.last
+	for _, sym := range a.index.visible(a.pos(a.offset(at))) {
+		if sym.name == name {
+			if sym.member == "" {
+				return sym.module
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// memberItems returns the completion items of the members of the module.
+func (s *Server) memberItems(module string) []*CompletionItem {
+	names := make(map[string]bool)
+	for name := range stdlib.BuiltinModules[module] {
+		names[name] = true
+	}
+	if mod := s.modules.GetBuiltinModule(module); mod != nil {
+		for name := range mod.Attrs {
+			names[name] = true
+		}
+	}
+	for name := range s.docs.modules[module] {
+		names[name] = true
+	}
+	var items []*CompletionItem
+	for name := range names {
+		item := &CompletionItem{Label: name, Kind: completionConstant}
+		obj := stdlib.BuiltinModules[module][name]
+		if obj != nil && obj.CanCall() {
+			item.Kind = completionFunction
+		}
+		if sig := s.signature(module, name); sig != nil {
+			item.Kind = completionFunction
+			item.Detail = sig.String()
+			item.Documentation = &MarkupContent{
+				Kind:  "markdown",
+				Value: sig.Doc,
+			}
+		} else if doc := s.docs.modules[module][name]; doc != nil {
+			if strings.Contains(doc.Signature, "(") {
+				item.Kind = completionFunction
+			}
+			item.Detail = doc.Signature
+			item.Documentation = &MarkupContent{
+				Kind:  "markdown",
+				Value: doc.Text,
+			}
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+// signature returns the signature of the function of the builtin module,
+// declared with nanojs.ModuleBuilder.
+func (s *Server) signature(module, name string) *nanojs.FuncSignature {
+	mod := s.modules.GetBuiltinModule(module)
+	if mod == nil {
+		return nil
+	}
+	if fn, ok := mod.Attrs[name].(*nanojs.UserFunction); ok {
+		return fn.Signature
+	}
+	return nil
+}
+
+func (s *Server) formatting(uri string) interface{} {
+	doc := s.documents[uri]
+	if doc == nil {
+		return nil
+	}
+	res, err := format.Source([]byte(doc.text.src))
+	if err != nil || string(res) == doc.text.src {
+		return []*TextEdit{}
+	}
+	return []*TextEdit{{
+		Range:   doc.text.rangeOf(0, len(doc.text.src)),
+		NewText: string(res),
+	}}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9'
+}
@@ -0,0 +1,358 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2/lsp"
+	"github.com/zeaphoo/nanojs/v2/require"
+	"github.com/zeaphoo/nanojs/v2/stdlib"
+)
+
+const testURI = "file:///tmp/test.js"
+
+const testSource = `fmt = import("fmt")
+a = 1
+f = function(x, y) {
+	return x + a + len(y)
+}
+fmt.println(f(a, "é"))
+`
+
+func TestServer_Diagnostics(t *testing.T) {
+	s := newTestSession()
+	s.open(testSource)
+	s.open("x = (")
+	s.open("x = y")
+	s.change("x = 1")
+	s.change("for (k, v in b) {}")
+	s.close()
+	res := s.run(t)
+
+	var diagnostics []struct {
+		URI         string           `json:"uri"`
+		Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+	}
+	for _, msg := range res {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params struct {
+				URI         string           `json:"uri"`
+				Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+			}
+			require.NoError(t, json.Unmarshal(msg.Params, &params))
+			diagnostics = append(diagnostics, params)
+		}
+	}
+	require.Equal(t, 6, len(diagnostics))
+	for _, d := range diagnostics {
+		require.Equal(t, testURI, d.URI)
+	}
+	require.Equal(t, 0, len(diagnostics[0].Diagnostics))
+
+	// parse error
+	require.Equal(t, 1, len(diagnostics[1].Diagnostics))
+	require.Equal(t, "expected operand, found 'EOF'",
+		diagnostics[1].Diagnostics[0].Message)
+	require.Equal(t, "0:5-0:5",
+		rangeString(diagnostics[1].Diagnostics[0].Range))
+
+	// compile error
+	require.Equal(t, 1, len(diagnostics[2].Diagnostics))
+	require.Equal(t, "unresolved reference 'y'",
+		diagnostics[2].Diagnostics[0].Message)
+	require.Equal(t, "0:4-0:5",
+		rangeString(diagnostics[2].Diagnostics[0].Range))
+
+	require.Equal(t, 0, len(diagnostics[3].Diagnostics))
+
+	// incomplete for-in statement
+	require.Equal(t, 1, len(diagnostics[4].Diagnostics))
+	require.Equal(t, "expected identifier",
+		diagnostics[4].Diagnostics[0].Message)
+	require.Equal(t, 0, len(diagnostics[5].Diagnostics)) // closed
+}
+
+func TestServer_Definition(t *testing.T) {
+	s := newTestSession()
+	s.open(testSource)
+	s.request("textDocument/definition", position(3, 12)) // a
+	s.request("textDocument/definition", position(3, 8))  // x
+	s.request("textDocument/definition", position(5, 15)) // a
+	s.request("textDocument/definition", position(3, 17)) // len
+	s.request("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     lsp.Position{Line: 1, Character: 0},
+		"context":      map[string]bool{"includeDeclaration": true},
+	})
+	s.request("textDocument/references", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     lsp.Position{Line: 2, Character: 13},
+		"context":      map[string]bool{"includeDeclaration": false},
+	})
+	res := responses(s.run(t))
+
+	var loc lsp.Location
+	require.NoError(t, json.Unmarshal(res[1], &loc))
+	require.Equal(t, testURI, loc.URI)
+	require.Equal(t, "1:0-1:1", rangeString(loc.Range))
+	require.NoError(t, json.Unmarshal(res[2], &loc))
+	require.Equal(t, "2:13-2:14", rangeString(loc.Range))
+	require.NoError(t, json.Unmarshal(res[3], &loc))
+	require.Equal(t, "1:0-1:1", rangeString(loc.Range))
+	require.Equal(t, "null", string(res[4]))
+
+	var locs []lsp.Location
+	require.NoError(t, json.Unmarshal(res[5], &locs))
+	require.Equal(t, []string{"1:0-1:1", "3:12-3:13", "5:14-5:15"},
+		locationRanges(locs))
+	require.NoError(t, json.Unmarshal(res[6], &locs))
+	require.Equal(t, []string{"3:8-3:9"}, locationRanges(locs))
+}
+
+func TestServer_Hover(t *testing.T) {
+	s := newTestSession()
+	s.open(testSource)
+	s.request("textDocument/hover", position(3, 17)) // len
+	s.request("textDocument/hover", position(5, 5))  // println
+	s.request("textDocument/hover", position(5, 12)) // f
+	s.request("textDocument/hover", position(3, 8))  // x
+	s.request("textDocument/hover", position(1, 4))  // 1
+	res := responses(s.run(t))
+
+	var hover lsp.Hover
+	require.NoError(t, json.Unmarshal(res[1], &hover))
+	require.Equal(t, "markdown", hover.Contents.Kind)
+	requireContains(t, hover.Contents.Value, "builtin function len")
+	requireContains(t, hover.Contents.Value, "Returns the number of elements")
+	require.Equal(t, "3:16-3:19", rangeString(*hover.Range))
+
+	require.NoError(t, json.Unmarshal(res[2], &hover))
+	requireContains(t, hover.Contents.Value, "fmt.println(args...)")
+	requireContains(t, hover.Contents.Value, "with a newline appended")
+
+	require.NoError(t, json.Unmarshal(res[3], &hover))
+	require.Equal(t, "```js\nf = function(x, y)\n```\n",
+		hover.Contents.Value)
+	require.NoError(t, json.Unmarshal(res[4], &hover))
+	require.Equal(t, "```js\nparameter x\n```\n", hover.Contents.Value)
+	require.Equal(t, "null", string(res[5]))
+}
+
+func TestServer_Completion(t *testing.T) {
+	s := newTestSession()
+	s.open(testSource)
+	s.request("textDocument/completion", position(3, 1))
+	// the document is being edited and can't be parsed
+	s.change(testSource + "fmt.pr")
+	s.request("textDocument/completion", position(6, 6))
+	res := responses(s.run(t))
+
+	labels := completionLabels(t, res[1])
+	for _, name := range []string{"x", "y", "a", "f", "fmt", "len", "format"} {
+		require.True(t, labels[name], name)
+	}
+	require.False(t, labels["println"])
+
+	labels = completionLabels(t, res[2])
+	for _, name := range []string{"print", "printf", "println", "sprintf"} {
+		require.True(t, labels[name], name)
+	}
+	require.False(t, labels["len"])
+}
+
+func TestServer_Formatting(t *testing.T) {
+	s := newTestSession()
+	s.open("x=1\r")
+	s.request("textDocument/formatting", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+	})
+	s.change("x = 1\n")
+	s.request("textDocument/formatting", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+	})
+	res := responses(s.run(t))
+
+	var edits []lsp.TextEdit
+	require.NoError(t, json.Unmarshal(res[1], &edits))
+	require.Equal(t, 1, len(edits))
+	require.Equal(t, "0:0-0:4", rangeString(edits[0].Range))
+	require.Equal(t, "x = 1\n", edits[0].NewText)
+	require.NoError(t, json.Unmarshal(res[2], &edits))
+	require.Equal(t, 0, len(edits))
+}
+
+func TestServer_Protocol(t *testing.T) {
+	s := newTestSession()
+	s.request("unknown/method", nil)
+	s.notify("unknown/notification", nil)
+	res := s.run(t)
+	require.Equal(t, 3, len(res))
+	require.Equal(t, "2.0", res[1].JSONRPC)
+	require.Equal(t, -32601, res[1].Error.Code)
+	require.Equal(t, "method not found: unknown/method", res[1].Error.Message)
+
+	// exit without shutdown
+	var in bytes.Buffer
+	writeTestMessage(&in, map[string]interface{}{"method": "exit"})
+	err := lsp.NewServer(nil).Serve(&in, io.Discard)
+	require.Error(t, err)
+}
+
+type testMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int            `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// testSession is the messages sent by a client.
+type testSession struct {
+	in     bytes.Buffer
+	nextID int
+}
+
+func newTestSession() *testSession {
+	s := &testSession{}
+	s.request("initialize", map[string]interface{}{})
+	s.notify("initialized", map[string]interface{}{})
+	return s
+}
+
+func (s *testSession) request(method string, params interface{}) {
+	writeTestMessage(&s.in, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      s.nextID,
+		"method":  method,
+		"params":  params,
+	})
+	s.nextID++
+}
+
+func (s *testSession) notify(method string, params interface{}) {
+	writeTestMessage(&s.in, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+}
+
+func (s *testSession) open(src string) {
+	s.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        testURI,
+			"languageId": "javascript",
+			"version":    1,
+			"text":       src,
+		},
+	})
+}
+
+func (s *testSession) change(src string) {
+	s.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":     testURI,
+			"version": 2,
+		},
+		"contentChanges": []map[string]string{{"text": src}},
+	})
+}
+
+func (s *testSession) close() {
+	s.notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+	})
+}
+
+// run serves the session and returns the messages sent by the server.
+func (s *testSession) run(t *testing.T) []*testMessage {
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	var out bytes.Buffer
+	server := lsp.NewServer(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	require.NoError(t, server.Serve(&s.in, &out))
+
+	var res []*testMessage
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		require.NoError(t, err)
+		data := make([]byte, length)
+		_, err = io.ReadFull(r, data)
+		require.NoError(t, err)
+		msg := &testMessage{}
+		require.NoError(t, json.Unmarshal(data, msg))
+		res = append(res, msg)
+	}
+	return res
+}
+
+func writeTestMessage(w io.Writer, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	_, _ = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+// responses returns the results of the responses by the request IDs.
+func responses(messages []*testMessage) map[int]json.RawMessage {
+	res := make(map[int]json.RawMessage)
+	for _, msg := range messages {
+		if msg.ID != nil {
+			res[*msg.ID] = msg.Result
+		}
+	}
+	return res
+}
+
+func position(line, character int) interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     lsp.Position{Line: line, Character: character},
+	}
+}
+
+func rangeString(r lsp.Range) string {
+	return fmt.Sprintf("%d:%d-%d:%d", r.Start.Line, r.Start.Character,
+		r.End.Line, r.End.Character)
+}
+
+func locationRanges(locs []lsp.Location) []string {
+	var res []string
+	for _, loc := range locs {
+		res = append(res, rangeString(loc.Range))
+	}
+	return res
+}
+
+func requireContains(t *testing.T, s, substr string) {
+	require.True(t, strings.Contains(s, substr), s)
+}
+
+func completionLabels(t *testing.T, data json.RawMessage) map[string]bool {
+	var list lsp.CompletionList
+	require.NoError(t, json.Unmarshal(data, &list))
+	res := make(map[string]bool)
+	for _, item := range list.Items {
+		res[item.Label] = true
+	}
+	return res
+}
@@ -0,0 +1,25 @@
+package nanojs
+
+import "context"
+
+// memoryKey is the context key of the VM of a run with a memory limit.
+type memoryKey struct{}
+
+// CheckMemory returns ErrMemoryLimit if allocating n more bytes would exceed
+// the memory limit of the run of the context (see Script.SetMaxMemory), or
+// nil otherwise. The functions called by the script check it before they
+// allocate a value larger than their arguments, e.g. text.repeat, as the
+// value is accounted only after the function returns.
+func CheckMemory(ctx context.Context, n int64) error {
+	if ctx == nil {
+		return nil
+	}
+	v, _ := ctx.Value(memoryKey{}).(*VM)
+	if v == nil || v.maxMemory < 0 {
+		return nil
+	}
+	if n > v.maxMemory-v.memory {
+		return ErrMemoryLimit
+	}
+	return nil
+}
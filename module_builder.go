@@ -0,0 +1,311 @@
+package nanojs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParamType is the type of a function parameter declared in FuncSignature.
+type ParamType int
+
+// List of parameter types
+const (
+	ParamAny ParamType = iota
+	ParamInt
+	ParamFloat // int or float
+	ParamBool
+	ParamString
+	ParamArray
+	ParamMap
+	ParamCallable
+)
+
+var paramTypeNames = [...]string{
+	ParamAny:      "any",
+	ParamInt:      "int",
+	ParamFloat:    "float",
+	ParamBool:     "bool",
+	ParamString:   "string",
+	ParamArray:    "array",
+	ParamMap:      "map",
+	ParamCallable: "callable",
+}
+
+func (t ParamType) String() string {
+	if t >= 0 && int(t) < len(paramTypeNames) {
+		return paramTypeNames[t]
+	}
+	return fmt.Sprintf("ParamType(%d)", int(t))
+}
+
+// accepts returns true if the argument is of the type.
+func (t ParamType) accepts(arg Object) bool {
+	switch t {
+	case ParamInt:
+		_, ok := arg.(*Int)
+		return ok
+	case ParamFloat:
+		switch arg.(type) {
+		case *Int, *Float:
+			return true
+		}
+		return false
+	case ParamBool:
+		_, ok := arg.(*Bool)
+		return ok
+	case ParamString:
+		_, ok := arg.(*String)
+		return ok
+	case ParamArray:
+		switch arg.(type) {
+		case *Array, *ImmutableArray:
+			return true
+		}
+		return false
+	case ParamMap:
+		switch arg.(type) {
+		case *Map, *ImmutableMap:
+			return true
+		}
+		return false
+	case ParamCallable:
+		return arg.CanCall()
+	}
+	return true
+}
+
+// Param is a parameter of a function declared in FuncSignature.
+type Param struct {
+	Name     string
+	Type     ParamType
+	Optional bool // the argument can be omitted
+	Variadic bool // the last parameter takes the rest of the arguments
+}
+
+func (p Param) String() string {
+	switch {
+	case p.Variadic:
+		return p.Name + " ..." + p.Type.String()
+	case p.Optional:
+		return p.Name + "? " + p.Type.String()
+	}
+	return p.Name + " " + p.Type.String()
+}
+
+// FuncSignature is the declaration of a function of a builtin module, which
+// the runtime validates the arguments against, and the compiler checks the
+// number of the arguments of the direct calls against.
+type FuncSignature struct {
+	Name    string
+	Params  []Param
+	Returns string // description of the return value
+	Doc     string
+}
+
+// String returns the signature in the form of
+// "name(a int, b? string, c ...any) -> returns".
+func (s *FuncSignature) String() string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = p.String()
+	}
+	sig := s.Name + "(" + strings.Join(params, ", ") + ")"
+	if s.Returns != "" {
+		sig += " -> " + s.Returns
+	}
+	return sig
+}
+
+// NumArgs returns the minimum and the maximum numbers of the arguments. The
+// maximum is -1 if the function is variadic.
+func (s *FuncSignature) NumArgs() (min, max int) {
+	for _, p := range s.Params {
+		switch {
+		case p.Variadic:
+			return min, -1
+		case !p.Optional:
+			min++
+		}
+		max++
+	}
+	return min, max
+}
+
+// CheckArgs returns an error if the arguments don't match the parameters.
+func (s *FuncSignature) CheckArgs(args []Object) error {
+	if err := s.checkNumArgs(len(args)); err != nil {
+		return err
+	}
+	for i, arg := range args {
+		p := s.Params[len(s.Params)-1]
+		if i < len(s.Params) {
+			p = s.Params[i]
+		}
+		if !p.Type.accepts(arg) {
+			return ErrInvalidArgumentType{
+				Name:     p.Name,
+				Expected: p.Type.String(),
+				Found:    arg.TypeName(),
+			}
+		}
+	}
+	return nil
+}
+
+func (s *FuncSignature) checkNumArgs(n int) error {
+	min, max := s.NumArgs()
+	var want string
+	switch {
+	case max < 0 && n < min:
+		want = fmt.Sprintf(">=%d", min)
+	case max < 0:
+		return nil
+	case n < min || n > max:
+		want = fmt.Sprintf("=%d", min)
+		if min != max {
+			want = fmt.Sprintf("=%d..%d", min, max)
+		}
+	default:
+		return nil
+	}
+	return fmt.Errorf("wrong number of arguments in call to '%s': "+
+		"want%s, got=%d", s.Name, want, n)
+}
+
+// validate returns an error if the parameters are not well-formed.
+func (s *FuncSignature) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("function name is empty")
+	}
+	optional := false
+	for i, p := range s.Params {
+		switch {
+		case p.Name == "":
+			return fmt.Errorf("%s: parameter %d has no name", s.Name, i+1)
+		case p.Variadic && i != len(s.Params)-1:
+			return fmt.Errorf("%s: variadic parameter '%s' is not the last",
+				s.Name, p.Name)
+		case p.Variadic && p.Optional:
+			return fmt.Errorf("%s: variadic parameter '%s' is optional",
+				s.Name, p.Name)
+		case p.Optional:
+			optional = true
+		case optional && !p.Variadic:
+			return fmt.Errorf("%s: required parameter '%s' follows "+
+				"optional parameters", s.Name, p.Name)
+		}
+	}
+	return nil
+}
+
+// ModuleBuilder builds a builtin module of the functions declared with
+// their signatures.
+//
+//	mod, err := nanojs.NewModuleBuilder().
+//		Doc("String utilities.").
+//		Func(nanojs.FuncSignature{
+//			Name: "repeat",
+//			Params: []nanojs.Param{
+//				{Name: "s", Type: nanojs.ParamString},
+//				{Name: "n", Type: nanojs.ParamInt, Optional: true},
+//			},
+//			Returns: "string",
+//			Doc:     "Returns s repeated n times.",
+//		}, repeat).
+//		Build()
+type ModuleBuilder struct {
+	doc   string
+	attrs map[string]Object
+	err   error
+}
+
+// NewModuleBuilder creates a ModuleBuilder.
+func NewModuleBuilder() *ModuleBuilder {
+	return &ModuleBuilder{attrs: make(map[string]Object)}
+}
+
+// Doc sets the documentation of the module.
+func (b *ModuleBuilder) Doc(doc string) *ModuleBuilder {
+	b.doc = doc
+	return b
+}
+
+// Func adds a function. The arguments are validated against the signature
+// before fn is called, so fn can assume their types.
+func (b *ModuleBuilder) Func(
+	sig FuncSignature,
+	fn CallableFunc,
+) *ModuleBuilder {
+	if err := sig.validate(); err != nil {
+		b.setErr(err)
+		return b
+	}
+	b.add(sig.Name, &UserFunction{Name: sig.Name, Value: fn, Signature: &sig})
+	return b
+}
+
+// FuncContext is like Func but fn receives the context of the run.
+func (b *ModuleBuilder) FuncContext(
+	sig FuncSignature,
+	fn CallableContextFunc,
+) *ModuleBuilder {
+	if err := sig.validate(); err != nil {
+		b.setErr(err)
+		return b
+	}
+	b.add(sig.Name, &UserFunction{
+		Name:         sig.Name,
+		Value:        backgroundFunc(fn),
+		ContextValue: fn,
+		Signature:    &sig,
+	})
+	return b
+}
+
+// Value adds a value, e.g. a constant.
+func (b *ModuleBuilder) Value(name string, value Object) *ModuleBuilder {
+	b.add(name, value)
+	return b
+}
+
+func (b *ModuleBuilder) add(name string, value Object) {
+	if _, ok := b.attrs[name]; ok {
+		b.setErr(fmt.Errorf("duplicate module member '%s'", name))
+		return
+	}
+	b.attrs[name] = value
+}
+
+func (b *ModuleBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Build returns the module, or the first error of the declarations.
+func (b *ModuleBuilder) Build() (*BuiltinModule, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	attrs := make(map[string]Object, len(b.attrs))
+	for name, value := range b.attrs {
+		attrs[name] = value
+	}
+	return &BuiltinModule{Attrs: attrs, Doc: b.doc}, nil
+}
+
+// Signatures returns the signatures of the functions of the module, sorted by
+// their names.
+func (m *BuiltinModule) Signatures() []*FuncSignature {
+	var sigs []*FuncSignature
+	for _, value := range m.Attrs {
+		if fn, ok := value.(*UserFunction); ok && fn.Signature != nil {
+			sigs = append(sigs, fn.Signature)
+		}
+	}
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].Name < sigs[j].Name
+	})
+	return sigs
+}
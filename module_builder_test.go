@@ -0,0 +1,194 @@
+package nanojs_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+func newTextModule(t *testing.T) *nanojs.BuiltinModule {
+	mod, err := nanojs.NewModuleBuilder().
+		Doc("Text utilities.").
+		Func(nanojs.FuncSignature{
+			Name: "repeat",
+			Params: []nanojs.Param{
+				{Name: "s", Type: nanojs.ParamString},
+				{Name: "n", Type: nanojs.ParamInt, Optional: true},
+			},
+			Returns: "string",
+			Doc:     "Returns s repeated n times.",
+		}, func(args ...nanojs.Object) (nanojs.Object, error) {
+			n := int64(2)
+			if len(args) > 1 {
+				n = args[1].(*nanojs.Int).Value
+			}
+			s := args[0].(*nanojs.String).Value
+			return &nanojs.String{Value: strings.Repeat(s, int(n))}, nil
+		}).
+		Func(nanojs.FuncSignature{
+			Name: "join",
+			Params: []nanojs.Param{
+				{Name: "sep", Type: nanojs.ParamString},
+				{Name: "parts", Type: nanojs.ParamString, Variadic: true},
+			},
+			Returns: "string",
+		}, func(args ...nanojs.Object) (nanojs.Object, error) {
+			var parts []string
+			for _, arg := range args[1:] {
+				parts = append(parts, arg.(*nanojs.String).Value)
+			}
+			sep := args[0].(*nanojs.String).Value
+			return &nanojs.String{Value: strings.Join(parts, sep)}, nil
+		}).
+		Value("version", &nanojs.String{Value: "1.0"}).
+		Build()
+	require.NoError(t, err)
+	return mod
+}
+
+func TestModuleBuilder(t *testing.T) {
+	mod := newTextModule(t)
+	require.Equal(t, "Text utilities.", mod.Doc)
+
+	var sigs []string
+	for _, sig := range mod.Signatures() {
+		sigs = append(sigs, sig.String())
+	}
+	require.Equal(t, []string{
+		"join(sep string, parts ...string) -> string",
+		"repeat(s string, n? int) -> string",
+	}, sigs)
+
+	c := runTextModule(t, `
+text = import("text")
+a = text.repeat("ab")
+b = text.repeat("ab", 3)
+c = text.join("-", "x", "y")
+d = text.version
+`)
+	require.Equal(t, "abab", c.Get("a").Value())
+	require.Equal(t, "ababab", c.Get("b").Value())
+	require.Equal(t, "x-y", c.Get("c").Value())
+	require.Equal(t, "1.0", c.Get("d").Value())
+
+	_, err := nanojs.NewModuleBuilder().
+		Func(nanojs.FuncSignature{}, nil).
+		Build()
+	require.Error(t, err)
+	_, err = nanojs.NewModuleBuilder().
+		Func(nanojs.FuncSignature{Name: "f", Params: []nanojs.Param{
+			{Name: "a", Optional: true},
+			{Name: "b"},
+		}}, nil).
+		Build()
+	require.Error(t, err)
+	_, err = nanojs.NewModuleBuilder().
+		Func(nanojs.FuncSignature{Name: "f", Params: []nanojs.Param{
+			{Name: "a", Variadic: true},
+			{Name: "b"},
+		}}, nil).
+		Build()
+	require.Error(t, err)
+	_, err = nanojs.NewModuleBuilder().
+		Value("a", nanojs.UndefinedValue).
+		Value("a", nanojs.UndefinedValue).
+		Build()
+	require.Error(t, err)
+}
+
+func TestModuleBuilder_RuntimeErrors(t *testing.T) {
+	expectTextModuleError(t, `f = import("text").repeat; f(1)`,
+		"invalid type for argument 's' in call to "+
+			"'user-function:repeat': expected string, found int")
+	expectTextModuleError(t, `f = import("text").join; f("-", "a", 1)`,
+		"invalid type for argument 'parts' in call to "+
+			"'user-function:join': expected string, found int")
+	expectTextModuleError(t, `f = import("text").repeat; f()`,
+		"wrong number of arguments in call to 'repeat': want=1..2, got=0")
+	expectTextModuleError(t, `f = import("text").join; f()`,
+		"wrong number of arguments in call to 'join': want>=1, got=0")
+}
+
+func TestModuleBuilder_CompileErrors(t *testing.T) {
+	expectTextModuleCompileError(t, `
+text = import("text")
+text.repeat()
+text.repeat("a", 1, 2)
+text.join()
+text["repeat"]("a", 1, 2)
+`,
+		"wrong number of arguments in call to 'repeat': want=1..2, got=0",
+		"wrong number of arguments in call to 'repeat': want=1..2, got=3",
+		"wrong number of arguments in call to 'join': want>=1, got=0",
+		"wrong number of arguments in call to 'repeat': want=1..2, got=3")
+	expectTextModuleCompileError(t, `
+import {repeat} from "text"
+r = repeat
+f = function() { return r("a", 1, 2) }
+`,
+		"wrong number of arguments in call to 'repeat': want=1..2, got=3")
+	expectTextModuleCompileError(t, `
+import * as text from "text"
+text.repeat()
+`,
+		"wrong number of arguments in call to 'repeat': want=1..2, got=0")
+
+	// the variables that are assigned again are not checked
+	c := runTextModule(t, `
+text = import("text")
+f = text.repeat
+out = f("a", 1)
+f = function(a, b, c) { return a + b + c }
+out2 = f(1, 2, 3)
+`)
+	require.Equal(t, "a", c.Get("out").Value())
+	require.Equal(t, int64(6), c.Get("out2").Value())
+	runTextModule(t, `
+text = import("text")
+text = {repeat: function() { return 1 }}
+out = text.repeat()
+`)
+}
+
+func runTextModule(t *testing.T, src string) *nanojs.Compiled {
+	s := nanojs.NewScript([]byte(src))
+	mods := nanojs.NewModuleMap()
+	mods.Add("text", newTextModule(t))
+	s.SetImports(mods)
+	c, err := s.Run()
+	require.NoError(t, err)
+	return c
+}
+
+func expectTextModuleError(t *testing.T, src, expected string) {
+	s := nanojs.NewScript([]byte(src))
+	mods := nanojs.NewModuleMap()
+	mods.Add("text", newTextModule(t))
+	s.SetImports(mods)
+	_, err := s.Run()
+	var rerr *nanojs.RuntimeError
+	require.True(t, errors.As(err, &rerr))
+	require.Equal(t, expected, rerr.Err.Error())
+}
+
+func expectTextModuleCompileError(
+	t *testing.T,
+	src string,
+	expected ...string,
+) {
+	s := nanojs.NewScript([]byte(src))
+	mods := nanojs.NewModuleMap()
+	mods.Add("text", newTextModule(t))
+	s.SetImports(mods)
+	_, err := s.Compile()
+	var errs nanojs.CompilerErrorList
+	require.True(t, errors.As(err, &errs))
+	var actual []string
+	for _, e := range errs {
+		actual = append(actual, e.Err.Error())
+	}
+	require.Equal(t, expected, actual)
+}
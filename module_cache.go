@@ -0,0 +1,233 @@
+package nanojs
+
+import (
+	"crypto/sha256"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
+	"github.com/zeaphoo/nanojs/v2/token"
+)
+
+// ModuleCache is a cache of the compiled source modules that can be shared
+// by the compilers to avoid parsing and compiling the same modules again. The
+// modules are identified by their canonical paths and the hashes of their
+// source code. The imports of a cached module are resolved again whenever
+// the module is reused, and the module is compiled again if one of them has
+// changed its exports. It's safe for concurrent use.
+type ModuleCache struct {
+	lock    sync.RWMutex
+	modules map[string]map[[sha256.Size]byte]*cachedModule
+}
+
+// NewModuleCache creates a ModuleCache.
+func NewModuleCache() *ModuleCache {
+	return &ModuleCache{
+		modules: make(map[string]map[[sha256.Size]byte]*cachedModule),
+	}
+}
+
+// Len returns the number of the cached modules.
+func (mc *ModuleCache) Len() int {
+	mc.lock.RLock()
+	defer mc.lock.RUnlock()
+	var n int
+	for _, versions := range mc.modules {
+		n += len(versions)
+	}
+	return n
+}
+
+// Remove removes the cached modules of the path.
+func (mc *ModuleCache) Remove(path string) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	delete(mc.modules, path)
+}
+
+// Clear removes all the cached modules.
+func (mc *ModuleCache) Clear() {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.modules = make(map[string]map[[sha256.Size]byte]*cachedModule)
+}
+
+// Prune removes the cached file modules whose files were modified or removed
+// since they were cached, and returns the number of the removed modules.
+// Modules loaded by FileResolver and FSResolver are file modules.
+func (mc *ModuleCache) Prune() int {
+	var stale []*cachedModule
+	mc.lock.RLock()
+	for _, versions := range mc.modules {
+		for _, m := range versions {
+			if !m.isFresh() {
+				stale = append(stale, m)
+			}
+		}
+	}
+	mc.lock.RUnlock()
+
+	for _, m := range stale {
+		mc.remove(m)
+	}
+	return len(stale)
+}
+
+// Prewarm compiles the module files (*.js) in the directory and its
+// subdirectories, and adds them to the cache. The modules are resolved the
+// same way as FileResolver of the directory does, and the other modules are
+// imported from the module map.
+func (mc *ModuleCache) Prewarm(dir string, modules *ModuleMap) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(dir,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || filepath.Ext(path) != ".js" {
+				return nil
+			}
+			name, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			fileSet := parser.NewFileSet()
+			srcFile := fileSet.AddFile("(prewarm)", -1, 0)
+			c := NewCompiler(srcFile, nil, nil, modules, nil)
+			c.SetModuleResolver(NewFileResolver(dir))
+			c.SetModuleCache(mc)
+			return c.Compile(&parser.ImportExpr{
+				ModuleName: filepath.ToSlash(name),
+				Token:      token.Import,
+			})
+		})
+}
+
+func (mc *ModuleCache) get(
+	path string,
+	hash [sha256.Size]byte,
+) *cachedModule {
+	mc.lock.RLock()
+	m := mc.modules[path][hash]
+	mc.lock.RUnlock()
+	if m != nil && !m.isFresh() {
+		mc.remove(m)
+		return nil
+	}
+	return m
+}
+
+func (mc *ModuleCache) put(m *cachedModule) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	versions := mc.modules[m.path]
+	if versions == nil || m.modTime != nil {
+		// a file has only one version
+		versions = make(map[[sha256.Size]byte]*cachedModule)
+		mc.modules[m.path] = versions
+	}
+	versions[m.hash] = m
+}
+
+func (mc *ModuleCache) remove(m *cachedModule) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if versions := mc.modules[m.path]; versions[m.hash] == m {
+		delete(versions, m.hash)
+		if len(versions) == 0 {
+			delete(mc.modules, m.path)
+		}
+	}
+}
+
+// cachedModule is a compiled module that can be linked into any compilation.
+// Its instructions refer to its own constants, and the source positions are
+// relative to the base of its source file.
+type cachedModule struct {
+	path      string
+	hash      [sha256.Size]byte
+	modTime   func() (time.Time, error) // nil if not a file module
+	mtime     time.Time
+	fileName  string
+	fileBase  int
+	fileSize  int
+	fileLines []int
+	fn        *CompiledFunction
+	constants []Object
+	imports   map[int]moduleImport // imported modules by constant index
+	exports   []string
+}
+
+// isFresh returns false if the file of the module was modified.
+func (m *cachedModule) isFresh() bool {
+	if m.modTime == nil {
+		return true
+	}
+	mtime, err := m.modTime()
+	return err == nil && mtime.Equal(m.mtime)
+}
+
+// moduleImport is a module imported by a cached module.
+type moduleImport struct {
+	name      string
+	signature string
+}
+
+// modTimer is implemented by the module resolvers that can report the
+// modification time of the modules.
+type modTimer interface {
+	modTime(path string) (time.Time, error)
+}
+
+func (r *FileResolver) modTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func (r *FSResolver) modTime(path string) (time.Time, error) {
+	fi, err := fs.Stat(r.FS, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func sortedKeys(m map[string]Object) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// relocateFunction returns a copy of the compiled function of a cached module
+// whose source positions are moved by the delta.
+func relocateFunction(fn *CompiledFunction, delta int) *CompiledFunction {
+	fn = fn.Copy().(*CompiledFunction)
+	sourceMap := make(map[int]parser.Pos, len(fn.SourceMap))
+	for ip, pos := range fn.SourceMap {
+		sourceMap[ip] = relocatePos(pos, delta)
+	}
+	fn.SourceMap = sourceMap
+	return fn
+}
+
+// relocatePos moves the source position of a cached module to its new file.
+func relocatePos(pos parser.Pos, delta int) parser.Pos {
+	if pos == parser.NoPos {
+		return pos
+	}
+	return parser.Pos(int(pos) + delta)
+}
@@ -0,0 +1,143 @@
+package nanojs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+func TestModuleCache(t *testing.T) {
+	cache := nanojs.NewModuleCache()
+	mods := nanojs.NewModuleMap()
+	mods.AddSourceModule("util", []byte(`
+base = 10
+export function add(a, b) { return a + b + base }
+export function fail() { return 1 + undefined }
+`))
+	mods.AddSourceModule("lib", []byte(`
+import { add } from "util"
+export const sum = add(1, 2)
+export default function() { return import("util").add(3, 4) }
+`))
+
+	run := func(src string) (*nanojs.Compiled, error) {
+		s := nanojs.NewScript([]byte(src))
+		s.SetImports(mods)
+		s.SetModuleCache(cache)
+		return s.Run()
+	}
+
+	c, err := run(`import f, { sum } from "lib"; out = sum + f()`)
+	require.NoError(t, err)
+	require.Equal(t, int64(30), c.Get("out").Value())
+	require.Equal(t, 2, cache.Len())
+
+	// reused modules
+	c, err = run(`x = 1; import f, { sum } from "lib"; out = sum * f()`)
+	require.NoError(t, err)
+	require.Equal(t, int64(221), c.Get("out").Value())
+	require.Equal(t, 2, cache.Len())
+
+	// source positions of the reused modules
+	_, err = run(`a = 1; b = 2; import * as util from "util"; util.fail()`)
+	require.Error(t, err)
+	require.Equal(t, "Runtime Error: invalid operation: int + undefined"+
+		"\n\tat fail (util:4:33)\n\tat (main):1:45", err.Error())
+
+	// a new version of the module
+	mods.AddSourceModule("util", []byte(`
+export function add(a, b) { return a + b }
+`))
+	c, err = run(`import f, { sum } from "lib"; out = sum + f()`)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), c.Get("out").Value())
+	require.Equal(t, 3, cache.Len())
+
+	// exports of the imported module changed
+	mods.AddSourceModule("util", []byte(`export const x = 1`))
+	_, err = run(`import f, { sum } from "lib"`)
+	require.Error(t, err)
+	require.True(t, strings.Contains(err.Error(),
+		"module 'util' has no export 'add'"), err.Error())
+
+	cache.Remove("util")
+	require.Equal(t, 1, cache.Len())
+	cache.Clear()
+	require.Equal(t, 0, cache.Len())
+}
+
+func TestModuleCache_Concurrency(t *testing.T) {
+	cache := nanojs.NewModuleCache()
+	resolver := nanojs.MapResolver{
+		"lib/a.js": []byte(`import { b } from "./b"; export const a = b * 2`),
+		"lib/b.js": []byte(`export const b = 21`),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := nanojs.NewScript([]byte(`import { a } from "lib/a"; out = a`))
+			s.SetModuleResolver(resolver)
+			s.SetModuleCache(cache)
+			c, err := s.Run()
+			require.NoError(t, err)
+			require.Equal(t, int64(42), c.Get("out").Value())
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, cache.Len())
+}
+
+func TestModuleCache_Files(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nanojs")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	write := func(name, src string, mtime time.Time) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(src), 0644))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	mtime := time.Now().Add(-time.Hour)
+	write("lib/a.js", `import { b } from "./b"; export const a = b * 2`, mtime)
+	write("lib/b.js", `export const b = 21`, mtime)
+	write("c.js", `export 3`, mtime)
+
+	cache := nanojs.NewModuleCache()
+	require.NoError(t, cache.Prewarm(dir, nil))
+	require.Equal(t, 3, cache.Len())
+	require.Equal(t, 0, cache.Prune())
+
+	s := nanojs.NewScript([]byte(`import { a } from "lib/a"; out = a`))
+	s.SetModuleResolver(nanojs.NewFileResolver(dir))
+	s.SetModuleCache(cache)
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, int64(42), c.Get("out").Value())
+	require.Equal(t, 3, cache.Len())
+
+	// modified files
+	write("lib/b.js", `export const b = 1`, time.Now())
+	require.NoError(t, os.Remove(filepath.Join(dir, "c.js")))
+	require.Equal(t, 2, cache.Prune())
+	require.Equal(t, 1, cache.Len())
+
+	c, err = s.Run()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), c.Get("out").Value())
+	require.Equal(t, 2, cache.Len())
+
+	// compile error
+	write("d.js", `export a`, mtime)
+	require.Error(t, cache.Prewarm(dir, nil))
+}
@@ -82,6 +82,29 @@ func (m *ModuleMap) AddMap(o *ModuleMap) {
 	}
 }
 
+// ModulePolicy restricts the functions of the builtin modules, e.g. the
+// stdlib.Policy.
+type ModulePolicy interface {
+	// Module returns the attributes of the builtin module imported by the
+	// name, with the functions restricted by the policy replaced.
+	Module(name string, attrs map[string]Object) map[string]Object
+}
+
+// Restrict returns a copy of the module map with the builtin modules
+// restricted by the policy.
+func (m *ModuleMap) Restrict(p ModulePolicy) *ModuleMap {
+	c := m.Copy()
+	for name, mod := range c.m {
+		if mod, ok := mod.(*BuiltinModule); ok {
+			c.m[name] = &BuiltinModule{
+				Attrs: p.Module(name, mod.Attrs),
+				Doc:   mod.Doc,
+			}
+		}
+	}
+	return c
+}
+
 // SourceModule is an importable module that's written in Nanojs.
 type SourceModule struct {
 	Src []byte
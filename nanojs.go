@@ -1,8 +1,10 @@
 package nanojs
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"reflect"
 	"strconv"
 	"time"
 )
@@ -26,11 +28,22 @@ const (
 
 	// MaxFrames is the maximum number of function frames for a VM.
 	MaxFrames = 1024
+
+	// elementSize is the approximate number of bytes used by each element
+	// slot of array values and each entry of map values (excluding the key).
+	elementSize = 16
 )
 
 // CallableFunc is a function signature for the callable functions.
 type CallableFunc = func(args ...Object) (ret Object, err error)
 
+// CallableContextFunc is a function signature for the callable functions that
+// receive the context of the run.
+type CallableContextFunc = func(
+	ctx context.Context,
+	args ...Object,
+) (ret Object, err error)
+
 // CountObjects returns the number of objects that a given object o contains.
 // For scalar value types, it will always be 1. For compound value types,
 // this will include its elements and all of their elements recursively.
@@ -59,6 +72,106 @@ func CountObjects(o Object) (c int) {
 	return
 }
 
+// ObjectSize returns the approximate number of bytes that the value of a given
+// object o occupies. Only strings, bytes, arrays and maps are accounted; other
+// value types are considered to have no size. For compound value types, this
+// will include the size of all of their elements recursively, and the objects
+// referenced more than once, e.g. by a cycle, are counted once.
+func ObjectSize(o Object) int64 {
+	return deepObjectSize(o, make(map[Object]struct{}))
+}
+
+// allocatedSize returns the size of the value returned by a call of a
+// function that is not shared with the arguments of the call, which is the
+// size allocated by the call. A string or bytes value is shared only if it's
+// one of the arguments.
+func allocatedSize(ret Object, args []Object) int64 {
+	switch ret.(type) {
+	case *String, *Bytes:
+		for _, arg := range args {
+			if arg == ret {
+				return 0
+			}
+		}
+		return objectSize(ret)
+	case *Array, *ImmutableArray, *Map, *ImmutableMap, *Error:
+		seen := make(map[Object]struct{})
+		for _, arg := range args {
+			deepObjectSize(arg, seen)
+		}
+		return deepObjectSize(ret, seen)
+	}
+	return 0
+}
+
+// deepObjectSize returns the size of the object and its elements that are not
+// in seen, and adds them to seen.
+func deepObjectSize(o Object, seen map[Object]struct{}) int64 {
+	switch o.(type) {
+	case *String, *Bytes, *Array, *ImmutableArray, *Map, *ImmutableMap,
+		*Error:
+		if _, ok := seen[o]; ok {
+			return 0
+		}
+		seen[o] = struct{}{}
+	default:
+		return 0
+	}
+	n := objectSize(o)
+	switch o := o.(type) {
+	case *Array:
+		for _, e := range o.Value {
+			n += deepObjectSize(e, seen)
+		}
+	case *ImmutableArray:
+		for _, e := range o.Value {
+			n += deepObjectSize(e, seen)
+		}
+	case *Map:
+		for _, e := range o.Value {
+			n += deepObjectSize(e, seen)
+		}
+	case *ImmutableMap:
+		for _, e := range o.Value {
+			n += deepObjectSize(e, seen)
+		}
+	case *Error:
+		n += deepObjectSize(o.Value, seen)
+	}
+	return n
+}
+
+// objectSize returns the size of the object without its elements.
+func objectSize(o Object) (n int64) {
+	switch o := o.(type) {
+	case *String:
+		n = int64(len(o.Value))
+	case *Bytes:
+		n = int64(len(o.Value))
+	case *Array:
+		n = arraySize(o.Value)
+	case *ImmutableArray:
+		n = arraySize(o.Value)
+	case *Map:
+		n = mapSize(o.Value)
+	case *ImmutableMap:
+		n = mapSize(o.Value)
+	}
+	return
+}
+
+func arraySize(elements []Object) int64 {
+	return int64(len(elements)) * elementSize
+}
+
+func mapSize(entries map[string]Object) int64 {
+	var n int64
+	for k := range entries {
+		n += int64(len(k)) + elementSize
+	}
+	return n
+}
+
 // ToString will try to convert object o to string value.
 func ToString(o Object) (v string, ok bool) {
 	if o == UndefinedValue {
@@ -233,14 +346,29 @@ func ToInterface(o Object) (res interface{}) {
 		res = errors.New(o.String())
 	case *Undefined:
 		res = nil
+	case *GoObject:
+		res = o.Value()
 	case Object:
 		return o
 	}
 	return
 }
 
-// FromInterface will attempt to convert an interface{} v to a Nanojs Object
+// FromInterface will attempt to convert an interface{} v to a Nanojs Object.
+// The values of the other types than listed in the conversion table are
+// converted with reflection: all the numeric kinds to Int or Float, slices
+// and arrays to Array, maps and structs to Map, and pointers to the values
+// they point to. Struct fields are named by their "nanojs" tags, e.g.
+// `nanojs:"name,omitempty"`, or "-" to skip the field.
 func FromInterface(v interface{}) (Object, error) {
+	return fromInterface(v, 0)
+}
+
+func fromInterface(v interface{}, depth int) (Object, error) {
+	if depth > maxConvertDepth {
+		return nil, fmt.Errorf("cannot convert to object: %T: nesting too "+
+			"deep", v)
+	}
 	switch v := v.(type) {
 	case nil:
 		return UndefinedValue, nil
@@ -276,7 +404,7 @@ func FromInterface(v interface{}) (Object, error) {
 	case map[string]interface{}:
 		kv := make(map[string]Object)
 		for vk, vv := range v {
-			vo, err := FromInterface(vv)
+			vo, err := fromInterface(vv, depth+1)
 			if err != nil {
 				return nil, err
 			}
@@ -288,7 +416,7 @@ func FromInterface(v interface{}) (Object, error) {
 	case []interface{}:
 		arr := make([]Object, len(v))
 		for i, e := range v {
-			vo, err := FromInterface(e)
+			vo, err := fromInterface(e, depth+1)
 			if err != nil {
 				return nil, err
 			}
@@ -301,6 +429,8 @@ func FromInterface(v interface{}) (Object, error) {
 		return v, nil
 	case CallableFunc:
 		return &UserFunction{Value: v}, nil
+	case CallableContextFunc:
+		return NewContextFunction("", v), nil
 	}
-	return nil, fmt.Errorf("cannot convert to object: %T", v)
+	return fromReflect(reflect.ValueOf(v), depth)
 }
@@ -113,6 +113,38 @@ func testCountObjects(t *testing.T, o nanojs.Object, expected int) {
 	require.Equal(t, expected, nanojs.CountObjects(o))
 }
 
+func TestObjectSize(t *testing.T) {
+	testObjectSize(t, nanojs.UndefinedValue, 0)
+	testObjectSize(t, &nanojs.Int{Value: 1984}, 0)
+	testObjectSize(t, &nanojs.String{Value: "foo bar"}, 7)
+	testObjectSize(t, &nanojs.Bytes{Value: []byte("foobar")}, 6)
+	testObjectSize(t, &nanojs.Array{Value: []nanojs.Object{
+		&nanojs.Int{Value: 1},
+		&nanojs.String{Value: "foo"},
+		&nanojs.Array{Value: []nanojs.Object{
+			&nanojs.Int{Value: 3},
+		}},
+	}}, 3*16+3+16)
+	testObjectSize(t, &nanojs.ImmutableMap{
+		Value: map[string]nanojs.Object{
+			"k1": &nanojs.Int{Value: 1},
+			"k2": &nanojs.Bytes{Value: []byte("foo")},
+		}}, 2*(2+16)+3)
+	testObjectSize(t, &nanojs.Error{Value: &nanojs.String{Value: "oops"}}, 4)
+
+	// cycles and shared objects are counted once
+	m := &nanojs.Map{Value: map[string]nanojs.Object{}}
+	m.Value["m"] = m
+	testObjectSize(t, m, 1+16)
+	str := &nanojs.String{Value: "foo"}
+	testObjectSize(t, &nanojs.Array{Value: []nanojs.Object{str, str}},
+		2*16+3)
+}
+
+func testObjectSize(t *testing.T, o nanojs.Object, expected int64) {
+	require.Equal(t, expected, nanojs.ObjectSize(o))
+}
+
 func assertInstructionString(
 	t *testing.T,
 	instructions [][]byte,
@@ -2,6 +2,7 @@ package nanojs
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"strconv"
@@ -79,6 +80,16 @@ type Object interface {
 	CanCall() bool
 }
 
+// ContextCaller is implemented by the callable objects that receive the
+// context of the run. The VM calls CallContext instead of Call with the
+// context passed to Compiled.RunContext, or context.Background().
+type ContextCaller interface {
+	Object
+
+	// CallContext is like Call but takes the context of the run.
+	CallContext(ctx context.Context, args ...Object) (ret Object, err error)
+}
+
 // ObjectImpl represents a default Object Implementation. To defined a new
 // value type, one can embed ObjectImpl in their type declarations to avoid
 // implementing all non-significant methods. TypeName() and String() methods
@@ -322,6 +333,9 @@ type BuiltinFunction struct {
 	ObjectImpl
 	Name  string
 	Value CallableFunc
+	// ContextValue is called instead of Value with the context of the run
+	// if it's not nil.
+	ContextValue CallableContextFunc
 }
 
 // TypeName returns the name of the type.
@@ -335,7 +349,7 @@ func (o *BuiltinFunction) String() string {
 
 // Copy returns a copy of the type.
 func (o *BuiltinFunction) Copy() Object {
-	return &BuiltinFunction{Value: o.Value}
+	return &BuiltinFunction{Value: o.Value, ContextValue: o.ContextValue}
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -344,8 +358,20 @@ func (o *BuiltinFunction) Equals(_ Object) bool {
 	return false
 }
 
-// Call executes a builtin function.
+// Call executes a builtin function. ContextValue is called with
+// context.Background().
 func (o *BuiltinFunction) Call(args ...Object) (Object, error) {
+	return o.CallContext(context.Background(), args...)
+}
+
+// CallContext executes a builtin function with the context of the run.
+func (o *BuiltinFunction) CallContext(
+	ctx context.Context,
+	args ...Object,
+) (Object, error) {
+	if o.ContextValue != nil {
+		return o.ContextValue(ctx, args...)
+	}
 	return o.Value(args...)
 }
 
@@ -357,6 +383,7 @@ func (o *BuiltinFunction) CanCall() bool {
 // BuiltinModule is an importable module that's written in Go.
 type BuiltinModule struct {
 	Attrs map[string]Object
+	Doc   string // documentation of the module, see ModuleBuilder
 }
 
 // Import returns an immutable map for the module.
@@ -369,6 +396,9 @@ func (m *BuiltinModule) AsImmutableMap(moduleName string) *ImmutableMap {
 	attrs := make(map[string]Object, len(m.Attrs))
 	for k, v := range m.Attrs {
 		attrs[k] = v.Copy()
+		if fn, ok := attrs[k].(*UserFunction); ok {
+			fn.moduleAttr = moduleName + "." + k
+		}
 	}
 	attrs["__module_name__"] = &String{Value: moduleName}
 	return &ImmutableMap{Value: attrs}
@@ -567,15 +597,43 @@ func (o *Char) Equals(x Object) bool {
 	return o.Value == t.Value
 }
 
+// LocalVar is a local variable of a compiled function, which is in scope from
+// the instruction at Start up to the instruction at End. The variables of
+// different blocks can share an index, but their scopes don't overlap.
+type LocalVar struct {
+	Name  string
+	Index int
+	Start int
+	End   int
+}
+
 // CompiledFunction represents a compiled function.
 type CompiledFunction struct {
 	ObjectImpl
+	Name          string
 	Instructions  []byte
 	NumLocals     int // number of local variables (including function parameters)
 	NumParameters int
 	VarArgs       bool
 	SourceMap     map[int]parser.Pos
+	Locals        []LocalVar // local variables with their scopes
+	FreeNames     []string   // names of free variables by index
 	Free          []*ObjectPtr
+
+	// maxStack is the maximum height of the stack above the locals, which
+	// is checked before the function is called, or 0 if it's unknown.
+	maxStack int
+}
+
+// LocalName returns the name of the local variable at the index that is in
+// scope at the instruction pointer, or "" if there's none.
+func (o *CompiledFunction) LocalName(index, ip int) string {
+	for _, l := range o.Locals {
+		if l.Index == index && l.Start <= ip && ip < l.End {
+			return l.Name
+		}
+	}
+	return ""
 }
 
 // TypeName returns the name of the type.
@@ -590,11 +648,16 @@ func (o *CompiledFunction) String() string {
 // Copy returns a copy of the type.
 func (o *CompiledFunction) Copy() Object {
 	return &CompiledFunction{
+		Name:          o.Name,
 		Instructions:  append([]byte{}, o.Instructions...),
 		NumLocals:     o.NumLocals,
 		NumParameters: o.NumParameters,
 		VarArgs:       o.VarArgs,
+		SourceMap:     o.SourceMap,
+		Locals:        o.Locals,
+		FreeNames:     o.FreeNames,
 		Free:          append([]*ObjectPtr{}, o.Free...), // DO NOT Copy() of elements; these are variable pointers
+		maxStack:      o.maxStack,
 	}
 }
 
@@ -1580,6 +1643,35 @@ type UserFunction struct {
 	Name       string
 	Value      CallableFunc
 	EncodingID string
+	// ContextValue is called instead of Value with the context of the run
+	// if it's not nil. See Compiled.RunContext and NewContextFunction.
+	ContextValue CallableContextFunc
+	// Signature is the declaration of the function that the arguments are
+	// validated against, or nil. See ModuleBuilder.
+	Signature *FuncSignature
+
+	// moduleAttr is the "module.name" of the function imported from a
+	// builtin module, which is the key of its cost in CostTable.Funcs.
+	moduleAttr string
+}
+
+// NewContextFunction returns a user function that calls fn with the context
+// of the run. Its Value calls fn with context.Background(), so the callers of
+// Value can call it too.
+func NewContextFunction(name string, fn CallableContextFunc) *UserFunction {
+	return &UserFunction{
+		Name:         name,
+		Value:        backgroundFunc(fn),
+		ContextValue: fn,
+	}
+}
+
+// backgroundFunc returns the function that calls fn with
+// context.Background().
+func backgroundFunc(fn CallableContextFunc) CallableFunc {
+	return func(args ...Object) (Object, error) {
+		return fn(context.Background(), args...)
+	}
 }
 
 // TypeName returns the name of the type.
@@ -1593,7 +1685,13 @@ func (o *UserFunction) String() string {
 
 // Copy returns a copy of the type.
 func (o *UserFunction) Copy() Object {
-	return &UserFunction{Value: o.Value}
+	return &UserFunction{
+		Name:         o.Name,
+		Value:        o.Value,
+		ContextValue: o.ContextValue,
+		Signature:    o.Signature,
+		moduleAttr:   o.moduleAttr,
+	}
 }
 
 // Equals returns true if the value of the type is equal to the value of
@@ -1602,8 +1700,25 @@ func (o *UserFunction) Equals(_ Object) bool {
 	return false
 }
 
-// Call invokes a user function.
+// Call invokes a user function. ContextValue is called with
+// context.Background().
 func (o *UserFunction) Call(args ...Object) (Object, error) {
+	return o.CallContext(context.Background(), args...)
+}
+
+// CallContext invokes a user function with the context of the run.
+func (o *UserFunction) CallContext(
+	ctx context.Context,
+	args ...Object,
+) (Object, error) {
+	if o.Signature != nil {
+		if err := o.Signature.CheckArgs(args); err != nil {
+			return nil, err
+		}
+	}
+	if o.ContextValue != nil {
+		return o.ContextValue(ctx, args...)
+	}
 	return o.Value(args...)
 }
 
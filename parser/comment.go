@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Comment represents a single //-style or /*-style comment.
+type Comment struct {
+	Slash Pos    // position of "/" starting the comment
+	Text  string // comment text (excluding '\n' for //-style comments)
+}
+
+// Pos returns the position of first character belonging to the node.
+func (c *Comment) Pos() Pos {
+	return c.Slash
+}
+
+// End returns the position of first character immediately after the node.
+func (c *Comment) End() Pos {
+	return Pos(int(c.Slash) + len(c.Text))
+}
+
+func (c *Comment) String() string {
+	return c.Text
+}
+
+// CommentGroup represents a sequence of comments with no other tokens and
+// no empty lines between them.
+type CommentGroup struct {
+	List []*Comment
+}
+
+// Pos returns the position of first character belonging to the node.
+func (g *CommentGroup) Pos() Pos {
+	return g.List[0].Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (g *CommentGroup) End() Pos {
+	return g.List[len(g.List)-1].End()
+}
+
+func (g *CommentGroup) String() string {
+	var list []string
+	for _, c := range g.List {
+		list = append(list, c.String())
+	}
+	return strings.Join(list, "\n")
+}
@@ -8,6 +8,7 @@ import (
 type File struct {
 	InputFile *SourceFile
 	Stmts     []Stmt
+	Comments  []*CommentGroup // list of all comments in the source file
 }
 
 // Pos returns the position of first character belonging to the node.
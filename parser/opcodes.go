@@ -3,6 +3,11 @@ package parser
 // Opcode represents a single byte operation code.
 type Opcode = byte
 
+// OpcodeVersion is the version of the opcode set. It must be incremented
+// whenever the opcodes or their operands are changed, so that the compiled
+// bytecode of the previous versions is rejected.
+const OpcodeVersion = 1
+
 // List of opcodes
 const (
 	OpConstant      Opcode = iota // Load constant
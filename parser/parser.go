@@ -104,6 +104,7 @@ type Parser struct {
 	trace     bool
 	indent    int
 	traceOut  io.Writer
+	comments  []*CommentGroup
 }
 
 // NewParser creates a Parser.
@@ -116,7 +117,7 @@ func NewParser(file *SourceFile, src []byte, trace io.Writer) *Parser {
 	p.scanner = NewScanner(p.file, src,
 		func(pos SourceFilePos, msg string) {
 			p.errors.Add(pos, msg)
-		}, 0)
+		}, ScanComments)
 	p.next()
 	return p
 }
@@ -150,6 +151,7 @@ func (p *Parser) ParseFile() (file *File, err error) {
 	file = &File{
 		InputFile: p.file,
 		Stmts:     stmts,
+		Comments:  p.comments,
 	}
 	return
 }
@@ -670,12 +672,19 @@ func (p *Parser) parseStmt() (stmt Stmt) {
 	case // simple statements
 		token.Func, token.Error, token.Immutable, token.Ident, token.Int,
 		token.Float, token.Char, token.String, token.True, token.False,
-		token.Undefined, token.Import, token.LParen, token.LBrace,
+		token.Undefined, token.LParen, token.LBrace,
 		token.LBrack, token.Add, token.Sub, token.Mul, token.And, token.Xor,
 		token.Not:
 		s := p.parseSimpleStmt(false)
 		p.expectSemi()
 		return s
+	case token.Import:
+		if p.peek(1) != token.LParen {
+			return p.parseImportDecl()
+		}
+		s := p.parseSimpleStmt(false)
+		p.expectSemi()
+		return s
 	case token.Return:
 		return p.parseReturnStmt()
 	case token.Export:
@@ -922,6 +931,27 @@ func (p *Parser) parseExportStmt() Stmt {
 
 	pos := p.pos
 	p.expect(token.Export)
+
+	switch {
+	case p.token == token.Func && p.peek(1) == token.Ident:
+		return p.parseExportFunc(pos)
+	case p.isKeyword("const") && p.peek(1) == token.Ident:
+		p.next()
+		name := p.parseIdent()
+		p.expect(token.Assign)
+		value := p.parseExpr()
+		p.expectSemi()
+		return &ExportDecl{ExportPos: pos, Name: name, Value: value}
+	case p.isKeyword("default") && !p.peekExprEnd():
+		p.next()
+		x := p.parseExpr()
+		p.expectSemi()
+		return &ExportDecl{ExportPos: pos, Default: x}
+	case p.token == token.LBrace && p.peek(1) == token.Ident &&
+		p.peek(2) != token.Colon:
+		return p.parseExportList(pos)
+	}
+
 	x := p.parseExpr()
 	p.expectSemi()
 	return &ExportStmt{
@@ -930,6 +960,122 @@ func (p *Parser) parseExportStmt() Stmt {
 	}
 }
 
+func (p *Parser) parseExportFunc(pos Pos) Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "ExportFunc"))
+	}
+
+	funcPos := p.expect(token.Func)
+	name := p.parseIdent()
+	params := p.parseIdentList()
+	p.exprLevel++
+	body := p.parseBody()
+	p.exprLevel--
+	p.expectSemi()
+	return &ExportDecl{
+		ExportPos: pos,
+		Name:      name,
+		Value: &FuncLit{
+			Type: &FuncType{FuncPos: funcPos, Params: params},
+			Body: body,
+		},
+	}
+}
+
+func (p *Parser) parseExportList(pos Pos) Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "ExportList"))
+	}
+
+	lbrace := p.expect(token.LBrace)
+	var specs []*ExportSpec
+	for p.token != token.RBrace && p.token != token.EOF {
+		spec := &ExportSpec{Name: p.parseIdent()}
+		if p.isKeyword("as") {
+			p.next()
+			spec.Alias = p.parseIdent()
+		}
+		specs = append(specs, spec)
+
+		if !p.expectComma(token.RBrace, "export name") {
+			break
+		}
+	}
+	rbrace := p.expect(token.RBrace)
+	p.expectSemi()
+	return &ExportDecl{
+		ExportPos: pos,
+		LBrace:    lbrace,
+		Specs:     specs,
+		RBrace:    rbrace,
+	}
+}
+
+func (p *Parser) parseImportDecl() Stmt {
+	if p.trace {
+		defer untracep(tracep(p, "ImportDecl"))
+	}
+
+	pos := p.expect(token.Import)
+	decl := &ImportDecl{ImportPos: pos}
+
+	if p.token == token.Ident && !p.isKeyword("from") {
+		decl.Default = p.parseIdent()
+		if p.token != token.Comma {
+			return p.parseImportFrom(decl)
+		}
+		p.next()
+	}
+
+	switch p.token {
+	case token.Mul:
+		p.next()
+		p.expectKeyword("as")
+		decl.Namespace = p.parseIdent()
+	case token.LBrace:
+		decl.LBrace = p.expect(token.LBrace)
+		for p.token != token.RBrace && p.token != token.EOF {
+			spec := &ImportSpec{Name: p.parseIdent()}
+			if p.isKeyword("as") {
+				p.next()
+				spec.Alias = p.parseIdent()
+			}
+			decl.Specs = append(decl.Specs, spec)
+
+			if !p.expectComma(token.RBrace, "import name") {
+				break
+			}
+		}
+		decl.RBrace = p.expect(token.RBrace)
+	default:
+		pos := p.pos
+		p.errorExpected(pos, "import name")
+		p.advance(stmtStart)
+		return &BadStmt{From: pos, To: p.pos}
+	}
+	return p.parseImportFrom(decl)
+}
+
+func (p *Parser) parseImportFrom(decl *ImportDecl) Stmt {
+	p.expectKeyword("from")
+	if p.token != token.String {
+		pos := p.pos
+		p.errorExpected(pos, "module name")
+		p.advance(stmtStart)
+		return &BadStmt{From: decl.ImportPos, To: p.pos}
+	}
+
+	moduleName, _ := strconv.Unquote(p.tokenLit)
+	decl.Module = &StringLit{
+		Value:    moduleName,
+		ValuePos: p.pos,
+		Literal:  p.tokenLit,
+	}
+	p.next()
+	p.expectSemi()
+	return decl
+}
+
 func (p *Parser) parseSimpleStmt(forIn bool) Stmt {
 	if p.trace {
 		defer untracep(tracep(p, "SimpleStmt"))
@@ -953,15 +1099,10 @@ func (p *Parser) parseSimpleStmt(forIn bool) Stmt {
 			p.next()
 			y := p.parseExpr()
 
-			var key *Ident
-			var ok bool
-			switch len(x) {
-			case 1:
-				key, ok = x[0].(*Ident)
-				if !ok {
-					p.errorExpected(x[0].Pos(), "identifier")
-					key = &Ident{Name: "_", NamePos: x[0].Pos()}
-				}
+			key, ok := x[0].(*Ident)
+			if !ok || len(x) > 1 {
+				p.errorExpected(x[0].Pos(), "identifier")
+				key = &Ident{Name: "_", NamePos: x[0].Pos()}
 			}
 			return &ForInStmt{
 				Key:      key,
@@ -1072,6 +1213,21 @@ func (p *Parser) expect(token token.Token) Pos {
 	return pos
 }
 
+// isKeyword returns true if the current token is the identifier that's used
+// as a keyword in the context (e.g. "from" of the import declaration).
+func (p *Parser) isKeyword(lit string) bool {
+	return p.token == token.Ident && p.tokenLit == lit
+}
+
+func (p *Parser) expectKeyword(lit string) Pos {
+	pos := p.pos
+	if !p.isKeyword(lit) {
+		p.errorExpected(pos, "'"+lit+"'")
+	}
+	p.next()
+	return pos
+}
+
 func (p *Parser) expectSemi() {
 	switch p.token {
 	case token.RParen, token.RBrace:
@@ -1148,6 +1304,48 @@ func (p *Parser) next() {
 		}
 	}
 	p.token, p.tokenLit, p.pos = p.scanner.Scan()
+
+	// collect the comments, grouping the ones on the adjacent lines
+	var group *CommentGroup
+	endLine := 0
+	for p.token == token.Comment {
+		comment := &Comment{Slash: p.pos, Text: p.tokenLit}
+		if group == nil || p.file.Position(p.pos).Line > endLine+1 {
+			group = &CommentGroup{}
+			p.comments = append(p.comments, group)
+		}
+		group.List = append(group.List, comment)
+		endLine = p.file.Position(comment.End()).Line
+		p.token, p.tokenLit, p.pos = p.scanner.Scan()
+	}
+}
+
+// peek returns the n-th token after the current token without advancing the
+// parser.
+func (p *Parser) peek(n int) token.Token {
+	s := *p.scanner
+	s.errorHandler = nil
+	tok := p.token
+	for i := 0; i < n && tok != token.EOF; i++ {
+		tok, _, _ = s.Scan()
+		for tok == token.Comment {
+			tok, _, _ = s.Scan()
+		}
+	}
+	return tok
+}
+
+// peekExprEnd returns true if the next token ends or continues the expression
+// of the current token instead of starting a new one.
+func (p *Parser) peekExprEnd() bool {
+	switch tok := p.peek(1); tok {
+	case token.Semicolon, token.EOF, token.RBrace, token.RParen,
+		token.RBrack, token.Comma, token.Period, token.LBrack,
+		token.LParen, token.Question, token.Assign:
+		return true
+	default:
+		return tok.Precedence() > token.LowestPrec
+	}
 }
 
 func (p *Parser) printTrace(a ...interface{}) {
@@ -573,6 +573,9 @@ func TestParseForIn(t *testing.T) {
 				blockStmt(p(1, 25), p(1, 26)),
 				p(1, 1)))
 	})
+
+	expectParseError(t, `for (k, v in b) {}`)
+	expectParseError(t, `for (a.b in c) {}`)
 }
 
 func TestParseFor(t *testing.T) {
@@ -1007,6 +1010,50 @@ func TestParseImport(t *testing.T) {
 	})
 }
 
+func TestParseImportDecl(t *testing.T) {
+	expectParseString(t, `import a from "mod1"`, `import a from "mod1"`)
+	expectParseString(t, `import { a, b as c } from "mod1"`,
+		`import {a, b as c} from "mod1"`)
+	expectParseString(t, `import * as m from "mod1"`,
+		`import * as m from "mod1"`)
+	expectParseString(t, `import a, { b } from "mod1"`,
+		`import a, {b} from "mod1"`)
+	expectParseString(t, `import a, * as m from "mod1"`,
+		`import a, * as m from "mod1"`)
+	expectParseString(t, `import {} from "mod1"; import("mod2")`,
+		`import {} from "mod1"; import("mod2")"`)
+
+	expectParseError(t, `import a "mod1"`)
+	expectParseError(t, `import { a, } from "mod1"`)
+	expectParseError(t, `import { a } from mod1`)
+	expectParseError(t, `import * from "mod1"`)
+	expectParseError(t, `import a, b from "mod1"`)
+	expectParseError(t, `import from "mod1"`)
+}
+
+func TestParseExportDecl(t *testing.T) {
+	expectParseString(t, `export function f(a, b) { return a + b }`,
+		`export func f(a, b) {return (a + b)}`)
+	expectParseString(t, `export const a = 1 + 2`,
+		`export const a = (1 + 2)`)
+	expectParseString(t, `export { a, b as c }`, `export {a, b as c}`)
+	expectParseString(t, `export default function(a) {}`,
+		`export default func(a) {}`)
+	expectParseString(t, `export default 5`, `export default 5`)
+
+	// export statements
+	expectParseString(t, `export function(a) {}`, `export func(a) {}`)
+	expectParseString(t, `export {a: 1}`, `export {a: 1}`)
+	expectParseString(t, `export {}`, `export {}`)
+	expectParseString(t, `export default`, `export default`)
+	expectParseString(t, `export default + 1`, `export (default + 1)`)
+	expectParseString(t, `export const`, `export const`)
+
+	expectParseError(t, `export const a`)
+	expectParseError(t, `export { a as }`)
+	expectParseError(t, `export function f {}`)
+}
+
 func TestParseIndex(t *testing.T) {
 	expectParse(t, "[1, 2, 3][1]", func(p pfn) []Stmt {
 		return stmts(
@@ -1457,6 +1504,51 @@ func TestParseSemicolon(t *testing.T) {
 	})
 }
 
+func TestParseComments(t *testing.T) {
+	src := `// a
+// b
+x = 1 /* c */
+
+// d
+y = function() {
+	return // e
+}
+`
+	fileSet := NewFileSet()
+	testFile := fileSet.AddFile("test", -1, len(src))
+	p := NewParser(testFile, []byte(src), nil)
+	file, err := p.ParseFile()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(file.Stmts))
+
+	var groups []string
+	for _, g := range file.Comments {
+		groups = append(groups, g.String())
+	}
+	require.Equal(t, []string{"// a\n// b", "/* c */", "// d", "// e"},
+		groups)
+	c := file.Comments[3].List[0]
+	require.Equal(t, Pos(int(testFile.LineStart(7))+8), c.Pos())
+	require.Equal(t, c.Pos()+4, c.End())
+
+	// comments don't affect the automatic semicolons
+	expectParse(t, "a = 1 // x\nb = 2 /* y */\nc()", func(p pfn) []Stmt {
+		return stmts(
+			assignStmt(
+				exprs(ident("a", p(1, 1))),
+				exprs(intLit(1, p(1, 5))),
+				token.Assign,
+				p(1, 3)),
+			assignStmt(
+				exprs(ident("b", p(2, 1))),
+				exprs(intLit(2, p(2, 5))),
+				token.Assign,
+				p(2, 3)),
+			exprStmt(
+				callExpr(ident("c", p(3, 1)), p(3, 2), p(3, 3), NoPos)))
+	})
+}
+
 func TestParseString(t *testing.T) {
 	expectParse(t, `a = "foo\nbar"`, func(p pfn) []Stmt {
 		return stmts(
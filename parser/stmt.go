@@ -149,6 +149,92 @@ func (s *EmptyStmt) String() string {
 	return ";"
 }
 
+// ExportDecl represents a named or default export declaration.
+//
+//	export func name(params) { body }
+//	export const name = value
+//	export { name, name as alias }
+//	export default value
+type ExportDecl struct {
+	ExportPos Pos
+	Default   Expr          // default export value, or nil
+	Name      *Ident        // name of the exported function or constant
+	Value     Expr          // value of the exported function or constant
+	LBrace    Pos           // position of "{" of the export list
+	Specs     []*ExportSpec // export list
+	RBrace    Pos           // position of "}" of the export list
+}
+
+func (s *ExportDecl) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *ExportDecl) Pos() Pos {
+	return s.ExportPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *ExportDecl) End() Pos {
+	switch {
+	case s.Default != nil:
+		return s.Default.End()
+	case s.Value != nil:
+		return s.Value.End()
+	}
+	return s.RBrace + 1
+}
+
+func (s *ExportDecl) String() string {
+	switch {
+	case s.Default != nil:
+		return "export default " + s.Default.String()
+	case s.Value != nil:
+		if fn, ok := s.Value.(*FuncLit); ok {
+			return "export func " + s.Name.String() +
+				fn.Type.Params.String() + " " + fn.Body.String()
+		}
+		return "export const " + s.Name.String() + " = " + s.Value.String()
+	}
+	var specs []string
+	for _, spec := range s.Specs {
+		specs = append(specs, spec.String())
+	}
+	return "export {" + strings.Join(specs, ", ") + "}"
+}
+
+// ExportSpec represents a name in the export list.
+type ExportSpec struct {
+	Name  *Ident // local name
+	Alias *Ident // exported name, or nil if it's the local name
+}
+
+// Pos returns the position of first character belonging to the node.
+func (s *ExportSpec) Pos() Pos {
+	return s.Name.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (s *ExportSpec) End() Pos {
+	if s.Alias != nil {
+		return s.Alias.End()
+	}
+	return s.Name.End()
+}
+
+func (s *ExportSpec) String() string {
+	if s.Alias != nil {
+		return s.Name.String() + " as " + s.Alias.String()
+	}
+	return s.Name.String()
+}
+
+// ExportedName returns the name the value is exported as.
+func (s *ExportSpec) ExportedName() string {
+	if s.Alias != nil {
+		return s.Alias.Name
+	}
+	return s.Name.Name
+}
+
 // ExportStmt represents an export statement.
 type ExportStmt struct {
 	ExportPos Pos
@@ -317,6 +403,88 @@ func (s *IfStmt) String() string {
 		s.Body.String() + elseStmt
 }
 
+// ImportDecl represents a static import declaration.
+//
+//	import name from "module"
+//	import { name, name as alias } from "module"
+//	import * as alias from "module"
+//	import name, { name } from "module"
+//	import name, * as alias from "module"
+type ImportDecl struct {
+	ImportPos Pos
+	Default   *Ident        // default import, or nil
+	Namespace *Ident        // namespace import, or nil
+	LBrace    Pos           // position of "{" of the import list
+	Specs     []*ImportSpec // import list
+	RBrace    Pos           // position of "}" of the import list
+	Module    *StringLit
+}
+
+func (s *ImportDecl) stmtNode() {}
+
+// Pos returns the position of first character belonging to the node.
+func (s *ImportDecl) Pos() Pos {
+	return s.ImportPos
+}
+
+// End returns the position of first character immediately after the node.
+func (s *ImportDecl) End() Pos {
+	return s.Module.End()
+}
+
+func (s *ImportDecl) String() string {
+	var names []string
+	if s.Default != nil {
+		names = append(names, s.Default.String())
+	}
+	if s.Namespace != nil {
+		names = append(names, "* as "+s.Namespace.String())
+	}
+	if s.LBrace.IsValid() {
+		var specs []string
+		for _, spec := range s.Specs {
+			specs = append(specs, spec.String())
+		}
+		names = append(names, "{"+strings.Join(specs, ", ")+"}")
+	}
+	return "import " + strings.Join(names, ", ") + " from " +
+		s.Module.String()
+}
+
+// ImportSpec represents a name in the import list.
+type ImportSpec struct {
+	Name  *Ident // exported name
+	Alias *Ident // local name, or nil if it's the exported name
+}
+
+// Pos returns the position of first character belonging to the node.
+func (s *ImportSpec) Pos() Pos {
+	return s.Name.Pos()
+}
+
+// End returns the position of first character immediately after the node.
+func (s *ImportSpec) End() Pos {
+	if s.Alias != nil {
+		return s.Alias.End()
+	}
+	return s.Name.End()
+}
+
+func (s *ImportSpec) String() string {
+	if s.Alias != nil {
+		return s.Name.String() + " as " + s.Alias.String()
+	}
+	return s.Name.String()
+}
+
+// LocalName returns the name the value is imported as.
+func (s *ImportSpec) LocalName() string {
+	if s.Alias != nil {
+		return s.Alias.Name
+	}
+	return s.Name.Name
+}
+
 // IncDecStmt represents increment or decrement statement.
 type IncDecStmt struct {
 	Expr     Expr
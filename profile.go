@@ -0,0 +1,346 @@
+package nanojs
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2/parser"
+)
+
+// DefaultProfilePeriod is the sampling period of a Profiler created with no
+// period, which is the period of the CPU profiles of Go.
+const DefaultProfilePeriod = 10 * time.Millisecond
+
+// Profiler is a sampling profiler of the scripts, which records the call
+// stacks of the compiled functions being executed, with the source lines of
+// the frames. The profile is written in the pprof format, e.g. to view the
+// flame graph of the scripts with "go tool pprof -http=: script.pprof".
+//
+// The runs of the VMs using the profiler are sampled between Start and Stop,
+// and the profiler is safe for concurrent use. The stacks are sampled between
+// the instructions, so the time of a call of a builtin or Go function is
+// counted at the line of the call after it returns. The samples are of the
+// wall-clock time, which includes the time blocked in the calls, e.g. of
+// times.sleep.
+type Profiler struct {
+	period    time.Duration
+	lock      sync.Mutex
+	active    map[*VM]struct{}
+	stop      chan struct{} // closed by Stop
+	start     time.Time     // the first start
+	since     time.Time     // the last start
+	duration  time.Duration // the total time between the starts and stops
+	funcs     map[profileFunc]uint64
+	locations map[profileLocation]uint64
+	samples   map[string]*profileSample
+	order     []*profileSample // the samples in the order of recording
+}
+
+type profileFunc struct {
+	name      string
+	filename  string
+	startLine int // tells apart the anonymous functions of a file
+}
+
+type profileLocation struct {
+	fn   uint64
+	line int
+}
+
+type profileSample struct {
+	locations []uint64 // the leaf is the first
+	count     int64
+}
+
+// NewProfiler creates a Profiler sampling the runs every period, or every
+// DefaultProfilePeriod if the period is not positive.
+//
+//	prof := nanojs.NewProfiler(0)
+//	script.SetProfiler(prof)
+//	prof.Start()
+//	// ... runs
+//	prof.Stop()
+//	err := prof.WriteProfile(file)
+func NewProfiler(period time.Duration) *Profiler {
+	if period <= 0 {
+		period = DefaultProfilePeriod
+	}
+	return &Profiler{
+		period:    period,
+		active:    make(map[*VM]struct{}),
+		funcs:     make(map[profileFunc]uint64),
+		locations: make(map[profileLocation]uint64),
+		samples:   make(map[string]*profileSample),
+	}
+}
+
+// Start starts sampling the runs. The samples are added to the samples of
+// the previous starts.
+func (p *Profiler) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stop != nil {
+		return
+	}
+	p.since = time.Now()
+	if p.start.IsZero() {
+		p.start = p.since
+	}
+	p.stop = make(chan struct{})
+	go p.tick(p.stop)
+}
+
+// Stop stops sampling the runs.
+func (p *Profiler) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stop == nil {
+		return
+	}
+	p.duration += time.Since(p.since)
+	close(p.stop)
+	p.stop = nil
+}
+
+// begin adds the VM to the running VMs, which are sampled.
+func (p *Profiler) begin(v *VM) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	atomic.StoreInt64(&v.profileTicks, 0)
+	p.active[v] = struct{}{}
+}
+
+// end removes the VM from the running VMs.
+func (p *Profiler) end(v *VM) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.active, v)
+}
+
+// tick requests a sample from each VM running every period, until stop is
+// closed.
+func (p *Profiler) tick(stop chan struct{}) {
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		p.lock.Lock()
+		if p.stop != stop {
+			p.lock.Unlock()
+			return
+		}
+		for v := range p.active {
+			atomic.AddInt64(&v.profileTicks, 1)
+		}
+		p.lock.Unlock()
+	}
+}
+
+// sample records the call stack of the VM for the periods elapsed since the
+// last sample. It's called by the VM between the instructions.
+func (p *Profiler) sample(v *VM) {
+	n := atomic.SwapInt64(&v.profileTicks, 0)
+	if n == 0 {
+		return
+	}
+	frames := v.callFrames(v.ip)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	locations := make([]uint64, len(frames))
+	key := make([]byte, 0, len(frames)*binary.MaxVarintLen64)
+	for i, f := range frames {
+		name := f.Name
+		if i == len(frames)-1 {
+			name = "[main]"
+		} else if name == "" {
+			name = "[anonymous]"
+		}
+		pos := funcPos(v, v.frames[len(frames)-1-i].fn)
+		fn := profileFunc{
+			name:      name,
+			filename:  pos.Filename,
+			startLine: pos.Line,
+		}
+		fnID, ok := p.funcs[fn]
+		if !ok {
+			fnID = uint64(len(p.funcs) + 1)
+			p.funcs[fn] = fnID
+		}
+		loc := profileLocation{fn: fnID, line: f.Pos.Line}
+		locID, ok := p.locations[loc]
+		if !ok {
+			locID = uint64(len(p.locations) + 1)
+			p.locations[loc] = locID
+		}
+		locations[i] = locID
+		key = appendVarint(key, locID)
+	}
+	s, ok := p.samples[string(key)]
+	if !ok {
+		s = &profileSample{locations: locations}
+		p.samples[string(key)] = s
+		p.order = append(p.order, s)
+	}
+	s.count += n
+}
+
+// funcPos returns the position of the first instruction of the function in
+// the source, which tells apart the anonymous functions of a file.
+func funcPos(v *VM, fn *CompiledFunction) parser.SourceFilePos {
+	first := parser.NoPos
+	for _, pos := range fn.SourceMap {
+		if pos != parser.NoPos && (first == parser.NoPos || pos < first) {
+			first = pos
+		}
+	}
+	return v.fileSet.Position(first)
+}
+
+// WriteProfile writes the profile of the runs in the gzip-compressed pprof
+// format. It should be called after Stop, or the profile doesn't include the
+// samples of the current periods of the runs.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.lock.Lock()
+	data := p.encode()
+	p.lock.Unlock()
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// encode returns the profile encoded as the Profile message of
+// github.com/google/pprof/proto/profile.proto. The lock must be held.
+func (p *Profiler) encode() []byte {
+	strs := map[string]int64{"": 0}
+	table := []string{""}
+	str := func(s string) int64 {
+		idx, ok := strs[s]
+		if !ok {
+			idx = int64(len(table))
+			strs[s] = idx
+			table = append(table, s)
+		}
+		return idx
+	}
+	valueType := func(typ, unit string) []byte {
+		var b protoBuffer
+		b.int64(1, str(typ))
+		b.int64(2, str(unit))
+		return b.data
+	}
+
+	var b protoBuffer
+	// sample_type
+	b.bytes(1, valueType("samples", "count"))
+	b.bytes(1, valueType("wall", "nanoseconds"))
+	// sample
+	period := int64(p.period)
+	for _, s := range p.order {
+		var sb protoBuffer
+		sb.packed(1, s.locations)
+		sb.packed(2, []uint64{uint64(s.count), uint64(s.count * period)})
+		b.bytes(2, sb.data)
+	}
+	// location
+	locations := make([]profileLocation, len(p.locations))
+	for loc, id := range p.locations {
+		locations[id-1] = loc
+	}
+	for i, loc := range locations {
+		var line protoBuffer
+		line.uint64(1, loc.fn)
+		line.int64(2, int64(loc.line))
+		var lb protoBuffer
+		lb.uint64(1, uint64(i+1))
+		lb.bytes(4, line.data)
+		b.bytes(4, lb.data)
+	}
+	// function
+	funcs := make([]profileFunc, len(p.funcs))
+	for fn, id := range p.funcs {
+		funcs[id-1] = fn
+	}
+	for i, fn := range funcs {
+		var fb protoBuffer
+		fb.uint64(1, uint64(i+1))
+		fb.int64(2, str(fn.name))
+		fb.int64(3, str(fn.name))
+		fb.int64(4, str(fn.filename))
+		fb.int64(5, int64(fn.startLine))
+		b.bytes(5, fb.data)
+	}
+	// time_nanos, duration_nanos, period_type and period
+	if !p.start.IsZero() {
+		b.int64(9, p.start.UnixNano())
+	}
+	duration := p.duration
+	if p.stop != nil {
+		duration += time.Since(p.since)
+	}
+	b.int64(10, int64(duration))
+	b.bytes(11, valueType("wall", "nanoseconds"))
+	b.int64(12, period)
+	// string_table
+	for _, s := range table {
+		b.bytes(6, []byte(s))
+	}
+	return b.data
+}
+
+// protoBuffer encodes the fields of a protocol buffers message.
+type protoBuffer struct {
+	data []byte
+}
+
+func appendVarint(b []byte, x uint64) []byte {
+	for x >= 0x80 {
+		b = append(b, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(b, byte(x))
+}
+
+func (b *protoBuffer) key(field int, wireType uint64) {
+	b.data = appendVarint(b.data, uint64(field)<<3|wireType)
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	b.key(field, 0)
+	b.data = appendVarint(b.data, x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.key(field, 2)
+	b.data = appendVarint(b.data, uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) packed(field int, xs []uint64) {
+	var data []byte
+	for _, x := range xs {
+		data = appendVarint(data, x)
+	}
+	b.bytes(field, data)
+}
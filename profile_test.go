@@ -0,0 +1,113 @@
+package nanojs_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+const profileSrc = `
+fib = function(n) {
+	if (n < 2) {
+		return n
+	}
+	return fib(n - 1) + fib(n - 2)
+}
+out = fib(22)
+`
+
+func TestCompiled_Profiler(t *testing.T) {
+	prof := nanojs.NewProfiler(time.Millisecond)
+	s := nanojs.NewScript([]byte(profileSrc))
+	s.SetProfiler(prof)
+	c, err := s.Compile()
+	require.NoError(t, err)
+	prof.Start()
+	// run long enough to be sampled
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+		require.NoError(t, c.Clone().Run())
+	}
+	require.NoError(t, c.Run())
+	prof.Stop()
+	require.Equal(t, int64(17711), c.Get("out").Int64())
+
+	var buf bytes.Buffer
+	require.NoError(t, prof.WriteProfile(&buf))
+	zr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	for _, s := range []string{"samples", "wall", "nanoseconds", "fib",
+		"[main]"} {
+		require.True(t, bytes.Contains(data, []byte(s)), s)
+	}
+
+	// the anonymous functions are told apart by their lines
+	prof = nanojs.NewProfiler(time.Millisecond)
+	s = nanojs.NewScript([]byte(`
+f = [
+	function() { for (i = 0; i < 20000; i++) {} },
+	function() { for (i = 0; i < 20000; i++) {} }
+]
+for (j = 0; j < 5; j++) { f[0](); f[1]() }
+`))
+	s.SetProfiler(prof)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	// run until both functions are sampled: [main] and two [anonymous]
+	funcs := 0
+	prof.Start()
+	for start := time.Now(); funcs < 3 && time.Since(start) < 10*time.Second; {
+		require.NoError(t, c.Clone().Run())
+		buf.Reset()
+		require.NoError(t, prof.WriteProfile(&buf))
+		zr, err = gzip.NewReader(&buf)
+		require.NoError(t, err)
+		data, err = ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		funcs = countProtoFields(t, data, 5)
+	}
+	prof.Stop()
+	require.Equal(t, 3, funcs)
+
+	// an empty profile
+	buf.Reset()
+	require.NoError(t, nanojs.NewProfiler(0).WriteProfile(&buf))
+	zr, err = gzip.NewReader(&buf)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(zr)
+	require.NoError(t, err)
+}
+
+// countProtoFields returns the number of the fields of the number in the
+// protocol buffers message.
+func countProtoFields(t *testing.T, data []byte, field uint64) int {
+	n := 0
+	for len(data) > 0 {
+		key, k := binary.Uvarint(data)
+		require.True(t, k > 0)
+		data = data[k:]
+		switch key & 7 {
+		case 0: // varint
+			_, k = binary.Uvarint(data)
+			require.True(t, k > 0)
+			data = data[k:]
+		case 2: // length-delimited
+			size, k := binary.Uvarint(data)
+			require.True(t, k > 0)
+			data = data[k+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		if key>>3 == field {
+			n++
+		}
+	}
+	return n
+}
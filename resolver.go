@@ -0,0 +1,142 @@
+package nanojs
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ModuleResolver resolves the modules that are not found in the module map.
+type ModuleResolver interface {
+	// Resolve resolves the module name imported by the module at the importer
+	// path. The importer is empty for the main script and for the modules
+	// that were not resolved by the resolver. It returns the canonical path
+	// of the module, which identifies the module in the compiler, and the
+	// module value: the source code ([]byte) of a module written in Nanojs or
+	// an Object of a builtin module. It should return an error wrapping
+	// os.ErrNotExist if the module does not exist.
+	Resolve(importer, name string) (path string, mod interface{}, err error)
+}
+
+// FileResolver resolves the modules from the files of the OS filesystem.
+type FileResolver struct {
+	// Dir is the directory that the module names are resolved against. The
+	// relative names ("./util", "../lib/x") imported by a file module are
+	// resolved against the directory of the module instead.
+	Dir string
+}
+
+// NewFileResolver creates a FileResolver that resolves the module names
+// against the directory.
+func NewFileResolver(dir string) *FileResolver {
+	return &FileResolver{Dir: dir}
+}
+
+// Resolve resolves the module name to the absolute path of the module file
+// and returns its source code.
+func (r *FileResolver) Resolve(
+	importer, name string,
+) (string, interface{}, error) {
+	dir := r.Dir
+	if importer != "" && isRelativeImport(name) {
+		dir = filepath.Dir(importer)
+	}
+	modulePath, err := filepath.Abs(
+		filepath.Join(dir, filepath.FromSlash(moduleFileName(name))))
+	if err != nil {
+		return "", nil, err
+	}
+	src, err := ioutil.ReadFile(modulePath)
+	if err != nil {
+		return "", nil, err
+	}
+	return modulePath, src, nil
+}
+
+// FSResolver resolves the modules from the files of a fs.FS. The module
+// names are resolved against the root of the file system.
+type FSResolver struct {
+	FS fs.FS
+}
+
+// NewFSResolver creates a FSResolver.
+func NewFSResolver(fsys fs.FS) *FSResolver {
+	return &FSResolver{FS: fsys}
+}
+
+// Resolve resolves the module name to the slash-separated path of the module
+// file in the file system and returns its source code.
+func (r *FSResolver) Resolve(
+	importer, name string,
+) (string, interface{}, error) {
+	modulePath, err := resolveModulePath(importer, name)
+	if err != nil {
+		return "", nil, err
+	}
+	src, err := fs.ReadFile(r.FS, modulePath)
+	if err != nil {
+		return "", nil, err
+	}
+	return modulePath, src, nil
+}
+
+// MapResolver resolves the modules from an in-memory map of slash-separated
+// module paths (e.g. "lib/util.js") to their source code. The module names
+// are resolved the same way as FSResolver does.
+type MapResolver map[string][]byte
+
+// Resolve resolves the module name to the module path and returns its source
+// code.
+func (r MapResolver) Resolve(
+	importer, name string,
+) (string, interface{}, error) {
+	modulePath, err := resolveModulePath(importer, name)
+	if err != nil {
+		return "", nil, err
+	}
+	src, ok := r[modulePath]
+	if !ok {
+		return "", nil, &os.PathError{
+			Op:   "open",
+			Path: modulePath,
+			Err:  os.ErrNotExist,
+		}
+	}
+	return modulePath, src, nil
+}
+
+// resolveModulePath returns the slash-separated module path of the name
+// imported by the importer. The path must not go above the root.
+func resolveModulePath(importer, name string) (string, error) {
+	dir := "."
+	if importer != "" && isRelativeImport(name) {
+		dir = path.Dir(importer)
+	}
+	modulePath := path.Join(dir, moduleFileName(name))
+	if !fs.ValidPath(modulePath) {
+		return "", &os.PathError{
+			Op:   "open",
+			Path: modulePath,
+			Err:  os.ErrNotExist,
+		}
+	}
+	return modulePath, nil
+}
+
+// moduleFileName returns the module name with ".js" extension.
+func moduleFileName(name string) string {
+	if !strings.HasSuffix(name, ".js") {
+		name += ".js"
+	}
+	return name
+}
+
+// isRelativeImport returns true if the module name is relative to the
+// importing module.
+func isRelativeImport(name string) bool {
+	return name == "." || name == ".." ||
+		strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../")
+}
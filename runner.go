@@ -0,0 +1,245 @@
+package nanojs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// runnerTemplate is the snapshot of the globals and the settings of a
+// compiled script that the runners are created from and reset to.
+type runnerTemplate struct {
+	globalIndexes map[string]int
+	bytecode      *Bytecode
+	globals       []Object
+	mutable       []int // indexes of the globals copied by each reset
+	maxAllocs     int64
+	maxGas        int64
+	costs         *CostTable
+	maxMemory     int64
+	streams       *Streams
+	hooks         Hooks
+	profiler      *Profiler
+}
+
+func (c *Compiled) newRunnerTemplate() *runnerTemplate {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	t := &runnerTemplate{
+		globalIndexes: c.globalIndexes,
+		bytecode:      c.bytecode,
+		globals:       make([]Object, len(c.globals)),
+		maxAllocs:     c.maxAllocs,
+		maxGas:        c.maxGas,
+		costs:         c.costs,
+		maxMemory:     c.maxMemory,
+		streams:       c.streams,
+		hooks:         c.hooks,
+		profiler:      c.profiler,
+	}
+	for idx, g := range c.globals {
+		t.globals[idx] = g
+		if g == nil || sharedGlobal(g) {
+			continue
+		}
+		// the runs must not see the changes of the previous runs, except
+		// for the objects that cannot be copied
+		if cp := g.Copy(); cp != nil {
+			t.globals[idx] = cp
+			t.mutable = append(t.mutable, idx)
+		}
+	}
+	return t
+}
+
+// sharedGlobal returns true if the runners can share the global because the
+// scripts cannot change it. The GoObjects expose the Go values in place, so
+// their copies share the values anyway.
+func sharedGlobal(o Object) bool {
+	switch o.(type) {
+	case *Int, *Float, *String, *Bool, *Char, *Bytes, *Time, *Undefined,
+		*Error, *ImmutableArray, *ImmutableMap, *CompiledFunction,
+		*BuiltinFunction, *UserFunction, *GoFunction, *GoObject:
+		return true
+	}
+	return false
+}
+
+// Runner runs a compiled script repeatedly with a VM and globals of its own,
+// which are reused across the runs, so that a run doesn't allocate them.
+// Reset resets the globals to the values of the compiled script at the time
+// the runner was created.
+//
+// A Runner is not safe for concurrent use, and it needs no locking. Use a
+// Runner for each goroutine, or a CompiledPool.
+type Runner struct {
+	template *runnerTemplate
+	globals  []Object
+	vm       *VM
+}
+
+// NewRunner creates a Runner of the compiled script.
+func (c *Compiled) NewRunner() *Runner {
+	return newRunner(c.newRunnerTemplate())
+}
+
+func newRunner(t *runnerTemplate) *Runner {
+	r := &Runner{
+		template: t,
+		globals:  make([]Object, len(t.globals)),
+	}
+	r.vm = NewVM(t.bytecode, r.globals, t.maxAllocs)
+	r.vm.SetMaxGas(t.maxGas)
+	r.vm.SetCostTable(t.costs)
+	r.vm.SetMaxMemory(t.maxMemory)
+	r.vm.SetStreams(t.streams)
+	r.vm.SetHooks(t.hooks)
+	r.vm.SetProfiler(t.profiler)
+	r.Reset()
+	return r
+}
+
+// Reset resets the globals to the values of the compiled script, discarding
+// the values set or assigned since the last reset, and the streams to the
+// streams of the compiled script.
+func (r *Runner) Reset() {
+	r.vm.SetStreams(r.template.streams)
+	copy(r.globals, r.template.globals)
+	for _, idx := range r.template.mutable {
+		r.globals[idx] = r.template.globals[idx].Copy()
+	}
+	// release the objects referenced by the previous run
+	r.vm.stack = [StackSize]Object{}
+}
+
+// Run executes the compiled script with the current values of the globals.
+func (r *Runner) Run() error {
+	return r.RunContext(context.Background())
+}
+
+// RunContext is like Run but includes a context. See Compiled.RunContext.
+func (r *Runner) RunContext(ctx context.Context) error {
+	return r.vm.RunContext(ctx)
+}
+
+// SetStdin sets the standard input of the next runs until the reset. See
+// Script.SetStdin.
+func (r *Runner) SetStdin(rd io.Reader) {
+	s := copyStreams(r.vm.streams)
+	s.Stdin = rd
+	r.vm.SetStreams(s)
+}
+
+// SetStdout sets the standard output of the next runs until the reset. See
+// Script.SetStdout.
+func (r *Runner) SetStdout(w io.Writer) {
+	s := copyStreams(r.vm.streams)
+	s.Stdout = w
+	r.vm.SetStreams(s)
+}
+
+// SetStderr sets the standard error of the next runs until the reset. See
+// Script.SetStderr.
+func (r *Runner) SetStderr(w io.Writer) {
+	s := copyStreams(r.vm.streams)
+	s.Stderr = w
+	r.vm.SetStreams(s)
+}
+
+// Set replaces the value of a global variable identified by the name for
+// the next run. An error will be returned if the name was not defined during
+// compilation.
+func (r *Runner) Set(name string, value interface{}) error {
+	obj, err := FromInterface(value)
+	if err != nil {
+		return err
+	}
+	idx, ok := r.template.globalIndexes[name]
+	if !ok {
+		return fmt.Errorf("'%s' is not defined", name)
+	}
+	r.globals[idx] = obj
+	return nil
+}
+
+// Get returns a variable identified by the name.
+func (r *Runner) Get(name string) *Variable {
+	value := UndefinedValue
+	if idx, ok := r.template.globalIndexes[name]; ok {
+		value = r.globals[idx]
+		if value == nil {
+			value = UndefinedValue
+		}
+	}
+	return &Variable{
+		name:  name,
+		value: value,
+	}
+}
+
+// GetInto stores the value of a global variable identified by the name in the
+// Go value pointed to by target. See Compiled.GetInto.
+func (r *Runner) GetInto(name string, target interface{}) error {
+	idx, ok := r.template.globalIndexes[name]
+	if !ok {
+		return fmt.Errorf("'%s' is not defined", name)
+	}
+	value := r.globals[idx]
+	if value == nil {
+		value = UndefinedValue
+	}
+	return Decode(value, target)
+}
+
+// GasUsed returns the amount of gas consumed by the last run.
+func (r *Runner) GasUsed() int64 {
+	return r.vm.GasUsed()
+}
+
+// MemoryUsed returns the number of bytes allocated by the last run.
+func (r *Runner) MemoryUsed() int64 {
+	return r.vm.MemoryUsed()
+}
+
+// CompiledPool is a pool of the Runners of a compiled script, which is safe
+// for concurrent use by multiple goroutines.
+//
+//	pool := nanojs.NewCompiledPool(compiled)
+//	r := pool.Get()
+//	defer pool.Put(r)
+//	_ = r.Set("input", input)
+//	if err := r.Run(); err != nil {
+//		return err
+//	}
+//	return r.Get("output").Value()
+type CompiledPool struct {
+	pool sync.Pool
+}
+
+// NewCompiledPool creates a CompiledPool of the compiled script. The runners
+// are reset to the values of the globals at the time the pool was created.
+// The globals that cannot be copied, i.e. the GoObjects and the objects whose
+// Copy returns nil, are shared by the runners, so they must be safe for
+// concurrent use, or be set for each run with Runner.Set.
+func NewCompiledPool(c *Compiled) *CompiledPool {
+	t := c.newRunnerTemplate()
+	p := &CompiledPool{}
+	p.pool.New = func() interface{} {
+		return newRunner(t)
+	}
+	return p
+}
+
+// Get returns a Runner with the globals reset.
+func (p *CompiledPool) Get() *Runner {
+	return p.pool.Get().(*Runner)
+}
+
+// Put resets the Runner and returns it to the pool. The Runner must have
+// been returned by Get of the pool, and it must not be used after Put.
+func (p *CompiledPool) Put(r *Runner) {
+	r.Reset()
+	p.pool.Put(r)
+}
@@ -0,0 +1,237 @@
+package nanojs_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+const runnerSrc = `
+hits = append(hits, amount)
+reason = ""
+out = amount > limit && country == "NL"
+if (out) {
+	reason = "limit"
+}
+`
+
+func compileRunner() *nanojs.Compiled {
+	s := nanojs.NewScript([]byte(runnerSrc))
+	_ = s.Add("amount", 0)
+	_ = s.Add("limit", 100)
+	_ = s.Add("country", "")
+	_ = s.Add("hits", []interface{}{})
+	s.SetMaxGas(10000)
+	c, err := s.Compile()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestRunner(t *testing.T) {
+	c := compileRunner()
+	r := c.NewRunner()
+
+	require.NoError(t, r.Set("amount", 150))
+	require.NoError(t, r.Set("country", "NL"))
+	require.NoError(t, r.Run())
+	require.Equal(t, true, r.Get("out").Value())
+	require.Equal(t, "limit", r.Get("reason").Value())
+	require.Equal(t, "[150]", fmt.Sprint(r.Get("hits").Value()))
+	var hits []int
+	require.NoError(t, r.GetInto("hits", &hits))
+	require.Equal(t, []int{150}, hits)
+	require.True(t, r.GasUsed() > 0)
+
+	// the globals are kept until the reset
+	require.NoError(t, r.Set("amount", 50))
+	require.NoError(t, r.Run())
+	require.Equal(t, false, r.Get("out").Value())
+	require.Equal(t, "", r.Get("reason").Value())
+	require.Equal(t, "[150 50]", fmt.Sprint(r.Get("hits").Value()))
+
+	r.Reset()
+	require.Equal(t, int64(0), r.Get("amount").Value())
+	require.True(t, r.Get("reason").IsUndefined())
+	require.NoError(t, r.Run())
+	require.Equal(t, "[0]", fmt.Sprint(r.Get("hits").Value()))
+
+	// the compiled script is not changed
+	require.Equal(t, "[]", fmt.Sprint(c.Get("hits").Value()))
+	require.True(t, c.Get("out").IsUndefined())
+
+	// a runtime error doesn't affect the next run
+	require.NoError(t, r.Set("amount", "x"))
+	require.Error(t, r.Run())
+	r.Reset()
+	require.NoError(t, r.Run())
+
+	require.Error(t, r.Set("unknown", 1))
+	require.Error(t, r.GetInto("unknown", new(int)))
+	require.True(t, r.Get("unknown").IsUndefined())
+
+	// the context
+	r = compile(t, `for {}`, nil).NewRunner()
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, r.RunContext(ctx))
+}
+
+func TestRunner_Allocs(t *testing.T) {
+	s := nanojs.NewScript([]byte(`
+reason = ""
+out = amount > limit && country == "NL"
+if (out) {
+	reason = "limit"
+}
+`))
+	require.NoError(t, s.Add("amount", 150))
+	require.NoError(t, s.Add("limit", 100))
+	require.NoError(t, s.Add("country", "NL"))
+	c, err := s.Compile()
+	require.NoError(t, err)
+
+	r := c.NewRunner()
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := r.Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	require.Equal(t, 0.0, allocs)
+}
+
+// runnerCounter is a custom object with a counter that the scripts change.
+type runnerCounter struct {
+	nanojs.ObjectImpl
+	n int64
+}
+
+func (o *runnerCounter) TypeName() string { return "counter" }
+
+func (o *runnerCounter) String() string { return fmt.Sprint(o.n) }
+
+func (o *runnerCounter) Copy() nanojs.Object {
+	return &runnerCounter{n: o.n}
+}
+
+func (o *runnerCounter) IndexGet(_ nanojs.Object) (nanojs.Object, error) {
+	return &nanojs.Int{Value: o.n}, nil
+}
+
+func (o *runnerCounter) IndexSet(_, value nanojs.Object) error {
+	o.n, _ = nanojs.ToInt64(value)
+	return nil
+}
+
+func TestRunner_CustomObject(t *testing.T) {
+	s := nanojs.NewScript([]byte(`counter.n += 1; out = counter.n`))
+	require.NoError(t, s.Add("counter", &runnerCounter{}))
+	require.NoError(t, s.Add("out", 0))
+	c, err := s.Compile()
+	require.NoError(t, err)
+
+	// the custom objects are copied for each runner and by each reset
+	r1, r2 := c.NewRunner(), c.NewRunner()
+	require.NoError(t, r1.Run())
+	require.NoError(t, r1.Run())
+	require.Equal(t, int64(2), r1.Get("out").Value())
+	require.NoError(t, r2.Run())
+	require.Equal(t, int64(1), r2.Get("out").Value())
+	r1.Reset()
+	require.NoError(t, r1.Run())
+	require.Equal(t, int64(1), r1.Get("out").Value())
+	require.Equal(t, "0", c.Get("counter").String())
+}
+
+func TestCompiledPool(t *testing.T) {
+	pool := nanojs.NewCompiledPool(compileRunner())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				errs <- poolRun(pool, i*100+j)
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func poolRun(pool *nanojs.CompiledPool, amount int) error {
+	r := pool.Get()
+	defer pool.Put(r)
+	if err := r.Set("amount", amount); err != nil {
+		return err
+	}
+	if err := r.Set("country", "NL"); err != nil {
+		return err
+	}
+	if err := r.Run(); err != nil {
+		return err
+	}
+	if out := r.Get("out").Bool(); out != (amount > 100) {
+		return fmt.Errorf("amount %d: out=%v", amount, out)
+	}
+	if hits := r.Get("hits").Array(); len(hits) != 1 {
+		return fmt.Errorf("amount %d: hits=%v", amount, hits)
+	}
+	return nil
+}
+
+func BenchmarkCompiled_Run(b *testing.B) {
+	c := compileRunner()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.Set("amount", i); err != nil {
+			b.Fatal(err)
+		}
+		if err := c.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRunner_Run(b *testing.B) {
+	r := compileRunner().NewRunner()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset()
+		if err := r.Set("amount", i); err != nil {
+			b.Fatal(err)
+		}
+		if err := r.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledPool(b *testing.B) {
+	pool := nanojs.NewCompiledPool(compileRunner())
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if err := poolRun(pool, i); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
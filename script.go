@@ -2,7 +2,9 @@ package nanojs
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
 	"sync"
 
@@ -15,18 +17,31 @@ type Script struct {
 	modules          *ModuleMap
 	input            []byte
 	maxAllocs        int64
+	maxGas           int64
+	costs            *CostTable
+	maxMemory        int64
 	maxConstObjects  int
+	maxCompileErrors int
 	enableFileImport bool
 	importDir        string
+	resolver         ModuleResolver
+	cache            *ModuleCache
+	policy           ModulePolicy
+	streams          *Streams
+	hooks            Hooks
+	profiler         *Profiler
 }
 
 // NewScript creates a Script instance with an input script.
 func NewScript(input []byte) *Script {
 	return &Script{
-		variables:       make(map[string]*Variable),
-		input:           input,
-		maxAllocs:       -1,
-		maxConstObjects: -1,
+		variables:        make(map[string]*Variable),
+		input:            input,
+		maxAllocs:        -1,
+		maxGas:           -1,
+		maxMemory:        -1,
+		maxConstObjects:  -1,
+		maxCompileErrors: defaultMaxErrors,
 	}
 }
 
@@ -58,7 +73,15 @@ func (s *Script) SetImports(modules *ModuleMap) {
 	s.modules = modules
 }
 
-// SetImportDir sets the initial import directory for script files.
+// SetModulePolicy sets the policy restricting the functions of the builtin
+// modules of the import modules, e.g. a stdlib.Policy.
+func (s *Script) SetModulePolicy(p ModulePolicy) {
+	s.policy = p
+}
+
+// SetImportDir sets the initial import directory for script files. The
+// modules imported by a script file are resolved against the directory of
+// the file.
 func (s *Script) SetImportDir(dir string) error {
 	dir, err := filepath.Abs(dir)
 	if err != nil {
@@ -68,6 +91,18 @@ func (s *Script) SetImportDir(dir string) error {
 	return nil
 }
 
+// SetModuleResolver sets the resolver of the modules that are not found in
+// the import modules. It takes precedence over the file imports.
+func (s *Script) SetModuleResolver(resolver ModuleResolver) {
+	s.resolver = resolver
+}
+
+// SetModuleCache sets the cache of the compiled source modules, which can be
+// shared by multiple scripts.
+func (s *Script) SetModuleCache(cache *ModuleCache) {
+	s.cache = cache
+}
+
 // SetMaxAllocs sets the maximum number of objects allocations during the run
 // time. Compiled script will return ErrObjectAllocLimit error if it
 // exceeds this limit.
@@ -75,12 +110,74 @@ func (s *Script) SetMaxAllocs(n int64) {
 	s.maxAllocs = n
 }
 
+// SetMaxGas sets the maximum amount of gas a single run can consume. Every
+// executed instruction and builtin function call is charged according to the
+// cost table. Compiled script will return ErrInstructionLimit error if it
+// exceeds this limit.
+func (s *Script) SetMaxGas(n int64) {
+	s.maxGas = n
+}
+
+// SetCostTable sets the cost table used to charge gas during the run time. If
+// it's not set, every instruction costs 1.
+func (s *Script) SetCostTable(t *CostTable) {
+	s.costs = t
+}
+
+// SetMaxMemory sets the maximum number of bytes that can be allocated for
+// strings, bytes, arrays and maps during the run time. This is a cumulative
+// metric that includes the results of builtin and stdlib functions. Compiled
+// script will return ErrMemoryLimit error if it exceeds this limit.
+func (s *Script) SetMaxMemory(n int64) {
+	s.maxMemory = n
+}
+
+// SetStdin sets the standard input of the runs of the compiled script,
+// which the stdlib modules read instead of os.Stdin. See Stdin.
+func (s *Script) SetStdin(r io.Reader) {
+	s.streams = copyStreams(s.streams)
+	s.streams.Stdin = r
+}
+
+// SetStdout sets the standard output of the runs of the compiled script,
+// e.g. of fmt.println, instead of os.Stdout. See Stdout.
+func (s *Script) SetStdout(w io.Writer) {
+	s.streams = copyStreams(s.streams)
+	s.streams.Stdout = w
+}
+
+// SetStderr sets the standard error of the runs of the compiled script
+// instead of os.Stderr. See Stderr.
+func (s *Script) SetStderr(w io.Writer) {
+	s.streams = copyStreams(s.streams)
+	s.streams.Stderr = w
+}
+
+// SetHooks sets the hooks called on the runtime events of the runs of the
+// compiled script, e.g. a StatsCollector. See VM.SetHooks.
+func (s *Script) SetHooks(hooks Hooks) {
+	s.hooks = hooks
+}
+
+// SetProfiler sets the profiler sampling the runs of the compiled script.
+// See Profiler.
+func (s *Script) SetProfiler(p *Profiler) {
+	s.profiler = p
+}
+
 // SetMaxConstObjects sets the maximum number of objects in the compiled
 // constants.
 func (s *Script) SetMaxConstObjects(n int) {
 	s.maxConstObjects = n
 }
 
+// SetMaxCompileErrors sets the maximum number of the compile errors reported
+// by Compile, which returns them as CompilerErrorList. The default is 10, and
+// 0 or a negative number means no limit.
+func (s *Script) SetMaxCompileErrors(n int) {
+	s.maxCompileErrors = n
+}
+
 // EnableFileImport enables or disables module loading from local files. Local
 // file modules are disabled by default.
 func (s *Script) EnableFileImport(enable bool) {
@@ -103,9 +200,16 @@ func (s *Script) Compile() (*Compiled, error) {
 		return nil, err
 	}
 
-	c := NewCompiler(srcFile, symbolTable, nil, s.modules, nil)
+	modules := s.modules
+	if modules != nil && s.policy != nil {
+		modules = modules.Restrict(s.policy)
+	}
+	c := NewCompiler(srcFile, symbolTable, nil, modules, nil)
 	c.EnableFileImport(s.enableFileImport)
 	c.SetImportDir(s.importDir)
+	c.SetModuleResolver(s.resolver)
+	c.SetModuleCache(s.cache)
+	c.SetMaxErrors(s.maxCompileErrors)
 	if err := c.Compile(file); err != nil {
 		return nil, err
 	}
@@ -133,11 +237,23 @@ func (s *Script) Compile() (*Compiled, error) {
 			return nil, fmt.Errorf("exceeding constant objects limit: %d", cnt)
 		}
 	}
+	// the variables are referenced by their names in the snapshots
+	externals := make(map[string]Object, len(s.variables))
+	for name, v := range s.variables {
+		externals[name] = v.value
+	}
 	return &Compiled{
 		globalIndexes: globalIndexes,
 		bytecode:      bytecode,
 		globals:       globals,
+		externals:     externals,
 		maxAllocs:     s.maxAllocs,
+		maxGas:        s.maxGas,
+		costs:         s.costs,
+		maxMemory:     s.maxMemory,
+		streams:       s.streams,
+		hooks:         s.hooks,
+		profiler:      s.profiler,
 	}, nil
 }
 
@@ -199,6 +315,16 @@ type Compiled struct {
 	bytecode      *Bytecode
 	globals       []Object
 	maxAllocs     int64
+	maxGas        int64
+	costs         *CostTable
+	gasUsed       int64
+	maxMemory     int64
+	memoryUsed    int64
+	streams       *Streams
+	hooks         Hooks
+	profiler      *Profiler
+	externals     map[string]Object // the variables added to the script
+	suspended     *VM               // the suspended run
 	lock          sync.RWMutex
 }
 
@@ -207,31 +333,134 @@ func (c *Compiled) Run() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	v := NewVM(c.bytecode, c.globals, c.maxAllocs)
-	return v.Run()
+	v := c.newVM()
+	err := v.Run()
+	c.finishRun(v, err)
+	return err
 }
 
-// RunContext is like Run but includes a context.
+// RunContext is like Run but includes a context. The execution stops with
+// the error of the context when the context is done, and the context is
+// passed to the functions called by the script (see ContextCaller).
 func (c *Compiled) RunContext(ctx context.Context) (err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	v := NewVM(c.bytecode, c.globals, c.maxAllocs)
-	ch := make(chan error, 1)
-	go func() {
-		ch <- v.Run()
-	}()
-
-	select {
-	case <-ctx.Done():
-		v.Abort()
-		<-ch
-		err = ctx.Err()
-	case err = <-ch:
-	}
+	v := c.newVM()
+	err = v.RunContext(ctx)
+	c.finishRun(v, err)
 	return
 }
 
+// finishRun records the results of the run by the VM.
+func (c *Compiled) finishRun(v *VM, err error) {
+	c.gasUsed = v.GasUsed()
+	c.memoryUsed = v.MemoryUsed()
+	c.suspended = nil
+	if err == ErrSuspend {
+		c.suspended = v
+	}
+}
+
+// Suspended returns true if the last run was suspended by ErrSuspend, or
+// restored from a snapshot, and it can be resumed.
+func (c *Compiled) Suspended() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.suspended != nil
+}
+
+// Resume continues the suspended run. The result is converted with
+// FromInterface, and it's the return value of the call that suspended the
+// run.
+func (c *Compiled) Resume(result interface{}) error {
+	return c.ResumeContext(context.Background(), result)
+}
+
+// ResumeContext is like Resume but includes a context. See RunContext.
+func (c *Compiled) ResumeContext(
+	ctx context.Context,
+	result interface{},
+) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.suspended == nil {
+		return errors.New("no suspended run")
+	}
+	obj, err := FromInterface(result)
+	if err != nil {
+		return err
+	}
+	v := c.suspended
+	err = v.ResumeContext(ctx, obj)
+	c.finishRun(v, err)
+	return err
+}
+
+// Snapshot serializes the state of the suspended run. The variables added to
+// the script are referenced by their names. See VM.Snapshot.
+func (c *Compiled) Snapshot() ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.suspended == nil {
+		return nil, errors.New("no suspended run")
+	}
+	names := make(map[int]string, len(c.globalIndexes))
+	for name, idx := range c.globalIndexes {
+		names[idx] = name
+	}
+	return c.suspended.snapshot(c.externals, names)
+}
+
+// Restore restores the suspended run serialized by Snapshot of the compiled
+// script of the same source code and variables, e.g. in another process. The
+// run is continued with Resume.
+func (c *Compiled) Restore(data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	v := c.newVM()
+	if err := v.Restore(data, c.externals); err != nil {
+		return err
+	}
+	c.gasUsed = v.GasUsed()
+	c.memoryUsed = v.MemoryUsed()
+	c.suspended = v
+	return nil
+}
+
+// GasUsed returns the amount of gas consumed by the last run. It's 0 if the
+// script has neither a gas limit nor a cost table, hooks or a profiler, and it
+// was not run with a context that can be done.
+func (c *Compiled) GasUsed() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.gasUsed
+}
+
+// MemoryUsed returns the number of bytes allocated by the last run.
+func (c *Compiled) MemoryUsed() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.memoryUsed
+}
+
+func (c *Compiled) newVM() *VM {
+	v := NewVM(c.bytecode, c.globals, c.maxAllocs)
+	v.SetMaxGas(c.maxGas)
+	v.SetCostTable(c.costs)
+	v.SetMaxMemory(c.maxMemory)
+	v.SetStreams(c.streams)
+	v.SetHooks(c.hooks)
+	v.SetProfiler(c.profiler)
+	return v
+}
+
 // Clone creates a new copy of Compiled. Cloned copies are safe for concurrent
 // use by multiple goroutines.
 func (c *Compiled) Clone() *Compiled {
@@ -243,6 +472,13 @@ func (c *Compiled) Clone() *Compiled {
 		bytecode:      c.bytecode,
 		globals:       make([]Object, len(c.globals)),
 		maxAllocs:     c.maxAllocs,
+		maxGas:        c.maxGas,
+		costs:         c.costs,
+		maxMemory:     c.maxMemory,
+		streams:       c.streams,
+		hooks:         c.hooks,
+		profiler:      c.profiler,
+		externals:     c.externals,
 	}
 	// copy global objects
 	for idx, g := range c.globals {
@@ -288,6 +524,24 @@ func (c *Compiled) Get(name string) *Variable {
 	}
 }
 
+// GetInto stores the value of a global variable identified by the name in the
+// Go value pointed to by target. An error will be returned if the name was
+// not defined during compilation, or the value can't be decoded into target.
+func (c *Compiled) GetInto(name string, target interface{}) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	idx, ok := c.globalIndexes[name]
+	if !ok {
+		return fmt.Errorf("'%s' is not defined", name)
+	}
+	value := c.globals[idx]
+	if value == nil {
+		value = UndefinedValue
+	}
+	return Decode(value, target)
+}
+
 // GetAll returns all the variables that are defined by the compiled script.
 func (c *Compiled) GetAll() []*Variable {
 	c.lock.RLock()
@@ -307,6 +561,51 @@ func (c *Compiled) GetAll() []*Variable {
 	return vars
 }
 
+// SetStdin sets the standard input of the next runs. See Script.SetStdin.
+func (c *Compiled) SetStdin(r io.Reader) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.streams = copyStreams(c.streams)
+	c.streams.Stdin = r
+}
+
+// SetStdout sets the standard output of the next runs. See
+// Script.SetStdout.
+func (c *Compiled) SetStdout(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.streams = copyStreams(c.streams)
+	c.streams.Stdout = w
+}
+
+// SetStderr sets the standard error of the next runs. See
+// Script.SetStderr.
+func (c *Compiled) SetStderr(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.streams = copyStreams(c.streams)
+	c.streams.Stderr = w
+}
+
+// SetHooks sets the hooks of the next runs. See Script.SetHooks.
+func (c *Compiled) SetHooks(hooks Hooks) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.hooks = hooks
+}
+
+// SetProfiler sets the profiler of the next runs. See Script.SetProfiler.
+func (c *Compiled) SetProfiler(p *Profiler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.profiler = p
+}
+
 // Set replaces the value of a global variable identified by the name. An error
 // will be returned if the name was not defined during compilation.
 func (c *Compiled) Set(name string, value interface{}) error {
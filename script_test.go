@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/zeaphoo/nanojs/v2"
@@ -365,6 +369,95 @@ func TestScriptSourceModule(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestScript_SetModuleResolver(t *testing.T) {
+	modules := map[string][]byte{
+		"main.js":      []byte(`util = import("./lib/util"); export util`),
+		"lib/util.js":  []byte(`x = import("../x"); export x + 1`),
+		"x.js":         []byte(`export 41`),
+		"lib/cycle.js": []byte(`export import("./cycle")`),
+	}
+	testResolver := func(resolver nanojs.ModuleResolver) {
+		scr := nanojs.NewScript([]byte(`out = import("main")`))
+		scr.SetModuleResolver(resolver)
+		c, err := scr.Run()
+		require.NoError(t, err)
+		require.Equal(t, int64(42), c.Get("out").Value())
+
+		scr = nanojs.NewScript([]byte(`out = import("./lib/util.js")`))
+		scr.SetModuleResolver(resolver)
+		c, err = scr.Run()
+		require.NoError(t, err)
+		require.Equal(t, int64(42), c.Get("out").Value())
+
+		scr = nanojs.NewScript([]byte(`out = import("lib/x")`))
+		scr.SetModuleResolver(resolver)
+		_, err = scr.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(),
+			"module 'lib/x' not found"), err.Error())
+
+		scr = nanojs.NewScript([]byte(`out = import("lib/cycle")`))
+		scr.SetModuleResolver(resolver)
+		_, err = scr.Run()
+		require.Error(t, err)
+		require.True(t, strings.Contains(err.Error(),
+			"cyclic module import"), err.Error())
+	}
+
+	testResolver(nanojs.MapResolver(modules))
+
+	fsys := fstest.MapFS{}
+	for name, src := range modules {
+		fsys[name] = &fstest.MapFile{Data: src}
+	}
+	testResolver(nanojs.NewFSResolver(fsys))
+
+	dir, err := ioutil.TempDir("", "nanojs")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	for name, src := range modules {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, src, 0644))
+	}
+	testResolver(nanojs.NewFileResolver(dir))
+
+	// module map takes precedence over the resolver
+	scr := nanojs.NewScript([]byte(`out = import("x")`))
+	mods := nanojs.NewModuleMap()
+	mods.AddSourceModule("x", []byte(`export 5`))
+	scr.SetImports(mods)
+	scr.SetModuleResolver(nanojs.MapResolver(modules))
+	c, err := scr.Run()
+	require.NoError(t, err)
+	require.Equal(t, int64(5), c.Get("out").Value())
+}
+
+func TestScript_SetImportDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nanojs")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	modules := map[string]string{
+		"lib/util.js": `export import("x") + import("./y")`,
+		"lib/x.js":    `export 40`,
+		"lib/y.js":    `export 2`,
+		"x.js":        `export 0`,
+	}
+	for name, src := range modules {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(src), 0644))
+	}
+
+	// the names imported by a file module are resolved against its directory
+	scr := nanojs.NewScript([]byte(`out = import("lib/util") + import("x")`))
+	scr.EnableFileImport(true)
+	require.NoError(t, scr.SetImportDir(dir))
+	c, err := scr.Run()
+	require.NoError(t, err)
+	require.Equal(t, int64(42), c.Get("out").Value())
+}
+
 func BenchmarkArrayIndex(b *testing.B) {
 	bench(b.N, `a := [1, 2, 3, 4, 5, 6, 7, 8, 9];
         for i := 0; i < 1000; i++ {
@@ -381,6 +474,18 @@ func BenchmarkArrayIndexCompare(b *testing.B) {
     `)
 }
 
+func BenchmarkFib(b *testing.B) {
+	bench(b.N, `
+fib = function(n) {
+	if (n < 2) {
+		return n
+	}
+	return fib(n - 1) + fib(n - 2)
+}
+out = fib(22)
+`)
+}
+
 func bench(n int, input string) {
 	s := nanojs.NewScript([]byte(input))
 	c, err := s.Compile()
@@ -479,6 +584,259 @@ func TestCompiled_RunContext(t *testing.T) {
 	require.Equal(t, context.DeadlineExceeded, err)
 }
 
+func TestCompiled_RunContext_Calls(t *testing.T) {
+	// the context is passed to the functions
+	s := nanojs.NewScript([]byte(`out = f("!")`))
+	require.NoError(t, s.Add("f", &nanojs.UserFunction{
+		Name: "f",
+		ContextValue: func(
+			ctx context.Context,
+			args ...nanojs.Object,
+		) (nanojs.Object, error) {
+			v, _ := ctx.Value(ctxKey{}).(string)
+			_, ok := ctx.Deadline()
+			return &nanojs.String{
+				Value: fmt.Sprintf("%s%s%t", v, args[0], ok),
+			}, nil
+		},
+	}))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "ctx")
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	require.NoError(t, c.RunContext(ctx))
+	compiledGet(t, c, "out", `ctx"!"true`)
+	require.NoError(t, c.Run())
+	compiledGet(t, c, "out", `"!"false`)
+
+	// the blocking calls stop when the context is done
+	s = nanojs.NewScript([]byte(`
+times = import("times")
+times.sleep(60 * 1000000000)
+`))
+	s.SetImports(stdlib.GetModuleMap("times"))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = c.RunContext(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.True(t, time.Since(start) < 10*time.Second)
+
+	// canceled while running the loop
+	c = compile(t, `for {}`, nil)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	require.Equal(t, context.Canceled, c.RunContext(ctx))
+
+	// the errors are kept when the context is done by the end of the run
+	s = nanojs.NewScript([]byte(`f()`))
+	require.NoError(t, s.Add("f", &nanojs.UserFunction{
+		Name: "f",
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			cancel()
+			return nil, errors.New("f failed")
+		},
+	}))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	err = c.RunContext(ctx)
+	var rerr *nanojs.RuntimeError
+	require.True(t, errors.As(err, &rerr), err)
+	require.Equal(t, "f failed", rerr.Err.Error())
+	require.Equal(t, 1, len(rerr.Frames))
+}
+
+func TestCompiled_MaxGas(t *testing.T) {
+	// infinite loop without allocations
+	s := nanojs.NewScript([]byte(`for {}`))
+	s.SetMaxGas(1000)
+	c, err := s.Compile()
+	require.NoError(t, err)
+	err = c.Run()
+	require.True(t, errors.Is(err, nanojs.ErrInstructionLimit))
+	require.Equal(t, int64(1001), c.GasUsed())
+
+	// the gas is not counted without a limit or a cost table
+	s = nanojs.NewScript([]byte(`a = 0; for (i = 0; i < 10; i++) { a += i }`))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, int64(0), c.GasUsed())
+
+	// gas used without limit
+	s.SetCostTable(nanojs.NewCostTable())
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	used := c.GasUsed()
+	require.True(t, used > 0)
+
+	// limit equal to the gas used
+	s.SetMaxGas(used)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, used, c.GasUsed())
+	s.SetMaxGas(used - 1)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrInstructionLimit))
+
+	// custom opcode and builtin costs
+	costs := nanojs.NewCostTable()
+	costs.Builtins["len"] = 100
+	s = nanojs.NewScript([]byte(`a = len([1, 2, 3])`))
+	s.SetCostTable(nanojs.NewCostTable())
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	base := c.GasUsed()
+	s.SetCostTable(costs)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, base+100, c.GasUsed())
+	compiledGet(t, c, "a", int64(3))
+
+	// module function costs
+	modules := stdlib.GetModuleMap("text")
+	costs = nanojs.NewCostTable()
+	costs.Funcs["text.repeat"] = 1000
+	s = nanojs.NewScript([]byte(`a = import("text").repeat("x", 3)`))
+	s.SetImports(modules)
+	s.SetCostTable(nanojs.NewCostTable())
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	base = c.GasUsed()
+	s.SetCostTable(costs)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, base+1000, c.GasUsed())
+	s.SetMaxGas(base + 999)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrInstructionLimit))
+
+	costs = nanojs.NewCostTable()
+	for op := range costs.Opcodes {
+		costs.Opcodes[op] = 0
+	}
+	s = nanojs.NewScript([]byte(`for (i = 0; i < 100; i++) {}`))
+	s.SetCostTable(costs)
+	s.SetMaxGas(0)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, int64(0), c.GasUsed())
+}
+
+func TestCompiled_MaxMemory(t *testing.T) {
+	// string concatenation
+	s := nanojs.NewScript([]byte(`a = ""; for (i = 0; i < 100; i++) { a += "0123456789" }`))
+	s.SetMaxMemory(500)
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrMemoryLimit))
+
+	s.SetMaxMemory(-1)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, int64(10*(1+100)*100/2), c.MemoryUsed())
+
+	// builtin results
+	s = nanojs.NewScript([]byte(`a = bytes(1000)`))
+	s.SetMaxMemory(999)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrMemoryLimit))
+	s.SetMaxMemory(1000)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+
+	// append only charges the growth
+	s = nanojs.NewScript([]byte(`a = []; for (i = 0; i < 100; i++) { a = append(a, i) }`))
+	s.SetMaxMemory(100 * 16)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, int64(100*16), c.MemoryUsed())
+
+	// map growth
+	s = nanojs.NewScript([]byte(`a = {}; for (i = 0; i < 10; i++) { a[string(i)] = i; a["0"] = i }`))
+	// string(i) results and new entries
+	s.SetMaxMemory(10 * (1 + 1 + 16))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	s.SetMaxMemory(10*(1+1+16) - 1)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrMemoryLimit))
+
+	// the values shared with the arguments are not charged again, and the
+	// cycles are counted once
+	id := &nanojs.UserFunction{
+		Name: "id",
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			return args[0], nil
+		},
+	}
+	s = nanojs.NewScript([]byte(`a = {}; a.x = a; b = id(a); c = id("abc")`))
+	require.NoError(t, s.Add("id", id))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	s.SetMaxMemory(1 + 16)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	compiledRun(t, c)
+	require.Equal(t, int64(1+16), c.MemoryUsed())
+
+	// the functions check the limit before the allocation
+	s = nanojs.NewScript([]byte(`
+text = import("text")
+a = text.repeat("x", 1 << 30)`))
+	s.SetImports(stdlib.GetModuleMap("text"))
+	s.SetMaxMemory(1000)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrMemoryLimit))
+	s = nanojs.NewScript([]byte(`a = bytes(1 << 20)`))
+	s.SetMaxMemory(1000)
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrMemoryLimit))
+}
+
+func TestCompiled_StackOverflow(t *testing.T) {
+	// the frames of the recursive calls fill the stack
+	s := nanojs.NewScript([]byte(`
+f = function(n) { return f(n + 1) + [n, n, n, n, n, n, n, n, n, n][0] }
+f(0)`))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrStackOverflow))
+
+	// spread arguments
+	s = nanojs.NewScript([]byte(`
+a = []; for (i = 0; i < 3000; i++) { a = append(a, i) }
+f = function(...x) { return len(x) }
+f(a...)`))
+	c, err = s.Compile()
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Run(), nanojs.ErrStackOverflow))
+}
+
 func compile(t *testing.T, input string, vars M) *nanojs.Compiled {
 	s := nanojs.NewScript([]byte(input))
 	for vn, vv := range vars {
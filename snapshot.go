@@ -0,0 +1,861 @@
+package nanojs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// snapshotMagic is the header of the encoded VM snapshot.
+const snapshotMagic = "NJSS"
+
+// snapshotVersion is the version of the snapshot format.
+const snapshotVersion = 1
+
+// maxSnapshotDepth is the maximum nesting depth of the snapshot objects.
+const maxSnapshotDepth = 1000
+
+// object tags of the snapshot encoding
+const (
+	snapshotNil byte = iota + 1
+	snapshotUndefined
+	snapshotFalse
+	snapshotTrue
+	snapshotInt
+	snapshotFloat
+	snapshotChar
+	snapshotString
+	snapshotTime
+	snapshotRef // an object encoded before
+	snapshotBytes
+	snapshotArray
+	snapshotImmutableArray
+	snapshotMap
+	snapshotImmutableMap
+	snapshotError
+	snapshotFreeVar
+	snapshotMainFunction
+	snapshotFunction // a compiled function in the constants
+	snapshotClosure
+	snapshotBuiltinFunction
+	snapshotModule
+	snapshotModuleMember
+	snapshotExternal
+	snapshotArrayIterator
+	snapshotBytesIterator
+	snapshotMapIterator
+	snapshotStringIterator
+)
+
+// SnapshotError is returned by VM.Snapshot if an object that can't be
+// serialized is live, e.g. an open file or a Go-backed object.
+type SnapshotError struct {
+	Where  string // e.g. "global 'f'" or "local 'x' of function 'run'"
+	Object Object
+}
+
+func (e *SnapshotError) Error() string {
+	return fmt.Sprintf("cannot snapshot %s in %s", e.Object.TypeName(),
+		e.Where)
+}
+
+// snapshotMember is a member of a builtin module imported by the bytecode.
+type snapshotMember struct {
+	module string
+	key    string
+}
+
+// Snapshot serializes the state of the suspended execution: the frames, the
+// stack, the globals and the objects they reference, preserving the shared
+// references. The compiled functions and the imported builtin modules are
+// referenced in the bytecode, and the other objects that can't be serialized,
+// like the functions added by the host, are referenced by their names in
+// externals. Restore the snapshot with VM.Restore of a VM of the same
+// bytecode.
+func (v *VM) Snapshot(externals map[string]Object) ([]byte, error) {
+	return v.snapshot(externals, nil)
+}
+
+func (v *VM) snapshot(
+	externals map[string]Object,
+	globalNames map[int]string,
+) ([]byte, error) {
+	if !v.suspended {
+		return nil, fmt.Errorf("vm is not suspended")
+	}
+	bytecode := &Bytecode{
+		FileSet:      v.fileSet,
+		MainFunction: v.frames[0].fn,
+		Constants:    v.constants,
+	}
+	fingerprint, err := bytecodeFingerprint(bytecode)
+	if err != nil {
+		return nil, err
+	}
+
+	e := newSnapshotEncoder(bytecode, externals)
+	e.buf.WriteString(snapshotMagic)
+	e.uvarint(snapshotVersion)
+	e.bytes(fingerprint)
+	e.varint(v.gas)
+	e.varint(v.memory)
+	e.varint(v.maxAllocs + 1 - v.allocs)
+
+	e.uvarint(uint64(len(v.globals)))
+	for idx, g := range v.globals {
+		if name, ok := globalNames[idx]; ok {
+			e.where = fmt.Sprintf("global '%s'", name)
+		} else {
+			e.where = fmt.Sprintf("global #%d", idx)
+		}
+		if err := e.object(g, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	e.uvarint(uint64(v.framesIndex))
+	for i := 0; i < v.framesIndex; i++ {
+		f := &v.frames[i]
+		e.where = fmt.Sprintf("function '%s'", f.fn.Name)
+		if err := e.object(f.fn, 0); err != nil {
+			return nil, err
+		}
+		e.uvarint(uint64(len(f.freeVars)))
+		for _, freeVar := range f.freeVars {
+			if err := e.object(freeVar, 0); err != nil {
+				return nil, err
+			}
+		}
+		ip := f.ip
+		if i == v.framesIndex-1 {
+			ip = v.ip
+		}
+		e.varint(int64(ip))
+		e.uvarint(uint64(f.basePointer))
+		e.varint(int64(f.line))
+		e.varint(int64(f.lastIP))
+	}
+
+	e.uvarint(uint64(v.sp))
+	for sp := 0; sp < v.sp; sp++ {
+		e.where = v.stackSlotName(sp)
+		if err := e.object(v.stack[sp], 0); err != nil {
+			return nil, err
+		}
+	}
+	return e.buf.Bytes(), nil
+}
+
+// stackSlotName returns the description of the stack slot used in the
+// errors.
+func (v *VM) stackSlotName(sp int) string {
+	for i := v.framesIndex - 1; i > 0; i-- {
+		f := &v.frames[i]
+		if sp < f.basePointer {
+			continue
+		}
+		// the callers are at their call instructions
+		ip := f.ip - 1
+		if i == v.framesIndex-1 {
+			ip = v.ip
+		}
+		if name := f.fn.LocalName(sp-f.basePointer, ip); name != "" {
+			return fmt.Sprintf("local '%s' of function '%s'", name,
+				f.fn.Name)
+		}
+		return fmt.Sprintf("stack of function '%s'", f.fn.Name)
+	}
+	return "stack"
+}
+
+// Restore restores the state of the execution serialized by VM.Snapshot,
+// which must be taken for the same bytecode. The objects referenced by their
+// names in the snapshot are taken from externals. The restored execution is
+// continued with VM.Resume. The snapshot must come from a trusted source: it's
+// checked against the bytecode, but the restored state is not verified.
+func (v *VM) Restore(data []byte, externals map[string]Object) error {
+	bytecode := &Bytecode{
+		FileSet:      v.fileSet,
+		MainFunction: v.frames[0].fn,
+		Constants:    v.constants,
+	}
+	fingerprint, err := bytecodeFingerprint(bytecode)
+	if err != nil {
+		return err
+	}
+
+	d := newSnapshotDecoder(data, bytecode, externals)
+	if len(data) < len(snapshotMagic) ||
+		string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("%w: missing header", ErrInvalidSnapshot)
+	}
+	d.off = len(snapshotMagic)
+	if ver := d.uvarint(); d.err == nil && ver != snapshotVersion {
+		return fmt.Errorf("%w: version %d (supported: %d)",
+			ErrInvalidSnapshot, ver, snapshotVersion)
+	}
+	if fp := d.bytes(); d.err == nil && string(fp) != string(fingerprint) {
+		return fmt.Errorf("%w: taken for a different bytecode",
+			ErrInvalidSnapshot)
+	}
+	gas := d.varint()
+	memory := d.varint()
+	allocs := d.varint()
+
+	numGlobals := d.length()
+	if d.err == nil && numGlobals > len(v.globals) {
+		d.fail("%d globals (supported: %d)", numGlobals, len(v.globals))
+	}
+	globals := make([]Object, 0, numGlobals)
+	for i := 0; i < numGlobals && d.err == nil; i++ {
+		globals = append(globals, d.object(0))
+	}
+
+	var frames [MaxFrames]frame
+	numFrames := d.length()
+	if d.err == nil && (numFrames < 1 || numFrames > MaxFrames) {
+		d.fail("invalid number of frames %d", numFrames)
+	}
+	for i := 0; i < numFrames && d.err == nil; i++ {
+		f := &frames[i]
+		f.fn = d.function(0)
+		numFree := d.length()
+		for j := 0; j < numFree && d.err == nil; j++ {
+			f.freeVars = append(f.freeVars, d.freeVar(0))
+		}
+		f.ip = int(d.varint())
+		f.basePointer = d.int()
+		f.line = int(d.varint())
+		f.lastIP = int(d.varint())
+		if d.err == nil && (f.ip < -1 || f.ip >= len(f.fn.Instructions) ||
+			f.basePointer > StackSize) {
+			d.fail("invalid frame %d", i)
+		}
+	}
+	if d.err == nil && frames[0].fn != bytecode.MainFunction {
+		d.fail("invalid main frame")
+	}
+
+	sp := d.length()
+	if d.err == nil && (sp >= StackSize ||
+		sp < frames[numFrames-1].basePointer) {
+		d.fail("invalid stack size %d", sp)
+	}
+	stack := make([]Object, 0, sp)
+	for i := 0; i < sp && d.err == nil; i++ {
+		stack = append(stack, d.object(0))
+	}
+	if d.err == nil && d.off != len(d.data) {
+		d.fail("unexpected data after stack")
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	copy(v.globals, globals)
+	for i := len(globals); i < len(v.globals); i++ {
+		v.globals[i] = nil
+	}
+	v.frames = frames
+	v.framesIndex = numFrames
+	v.curFrame = &v.frames[numFrames-1]
+	v.curInsts = v.curFrame.fn.Instructions
+	v.ip = v.curFrame.ip
+	v.stack = [StackSize]Object{}
+	copy(v.stack[:], stack)
+	v.sp = sp
+	v.gas = gas
+	v.memory = memory
+	v.allocs = v.maxAllocs + 1 - allocs
+	v.err = nil
+	v.suspended = true
+	return nil
+}
+
+// bytecodeFingerprint returns the hash of the encoded bytecode, which
+// identifies the bytecode a snapshot was taken for.
+func bytecodeFingerprint(b *Bytecode) ([]byte, error) {
+	h := sha256.New()
+	if err := b.Encode(h); err != nil {
+		return nil, fmt.Errorf("cannot encode bytecode: %w", err)
+	}
+	return h.Sum(nil), nil
+}
+
+// moduleConstants returns the builtin modules imported by the bytecode by
+// their names.
+func moduleConstants(b *Bytecode) map[string]*ImmutableMap {
+	modules := make(map[string]*ImmutableMap)
+	for _, c := range b.Constants {
+		if m, ok := c.(*ImmutableMap); ok {
+			if name := inferModuleName(m); name != "" {
+				if _, ok := modules[name]; !ok {
+					modules[name] = m
+				}
+			}
+		}
+	}
+	return modules
+}
+
+// snapshotEncoder writes the VM snapshot.
+type snapshotEncoder struct {
+	bytecodeEncoder
+	main      *CompiledFunction
+	functions map[*CompiledFunction]int // constant index
+	protos    map[*byte]int             // constant index by instructions
+	members   map[Object]snapshotMember
+	externals map[Object]string
+	refs      map[Object]int
+	cells     map[*Object]int
+	where     string // the global or the stack slot being encoded
+}
+
+func newSnapshotEncoder(
+	b *Bytecode,
+	externals map[string]Object,
+) *snapshotEncoder {
+	e := &snapshotEncoder{
+		main:      b.MainFunction,
+		functions: make(map[*CompiledFunction]int),
+		protos:    make(map[*byte]int),
+		members:   make(map[Object]snapshotMember),
+		externals: make(map[Object]string),
+		refs:      make(map[Object]int),
+		cells:     make(map[*Object]int),
+	}
+	for idx, c := range b.Constants {
+		if fn, ok := c.(*CompiledFunction); ok {
+			e.functions[fn] = idx
+			if len(fn.Instructions) > 0 {
+				e.protos[&fn.Instructions[0]] = idx
+			}
+		}
+	}
+	for name, m := range moduleConstants(b) {
+		for _, key := range sortedKeys(m.Value) {
+			if attr := m.Value[key]; comparableObject(attr) {
+				e.members[attr] = snapshotMember{module: name, key: key}
+			}
+		}
+	}
+	names := make([]string, 0, len(externals))
+	for name := range externals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := externals[name]
+		if _, ok := e.externals[o]; !ok && comparableObject(o) {
+			e.externals[o] = name
+		}
+	}
+	return e
+}
+
+// comparableObject returns true if the object can be a map key.
+func comparableObject(o Object) bool {
+	return o != nil && reflect.TypeOf(o).Comparable()
+}
+
+func (e *snapshotEncoder) ref(o Object) {
+	e.refs[o] = len(e.refs) + len(e.cells)
+}
+
+func (e *snapshotEncoder) object(o Object, depth int) error {
+	if depth > maxSnapshotDepth {
+		return fmt.Errorf("%s: objects nested too deeply", e.where)
+	}
+	switch o := o.(type) {
+	case nil:
+		e.buf.WriteByte(snapshotNil)
+		return nil
+	case *Undefined:
+		e.buf.WriteByte(snapshotUndefined)
+		return nil
+	case *Bool:
+		if o.IsFalsy() {
+			e.buf.WriteByte(snapshotFalse)
+		} else {
+			e.buf.WriteByte(snapshotTrue)
+		}
+		return nil
+	case *Int:
+		e.buf.WriteByte(snapshotInt)
+		e.varint(o.Value)
+		return nil
+	case *Float:
+		e.buf.WriteByte(snapshotFloat)
+		e.uvarint(math.Float64bits(o.Value))
+		return nil
+	case *Char:
+		e.buf.WriteByte(snapshotChar)
+		e.varint(int64(o.Value))
+		return nil
+	case *String:
+		e.buf.WriteByte(snapshotString)
+		e.string(o.Value)
+		return nil
+	case *Time:
+		data, err := o.Value.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.buf.WriteByte(snapshotTime)
+		e.bytes(data)
+		return nil
+	case *BuiltinFunction:
+		e.buf.WriteByte(snapshotBuiltinFunction)
+		e.string(o.Name)
+		return nil
+	}
+	if comparableObject(o) {
+		if id, ok := e.refs[o]; ok {
+			e.buf.WriteByte(snapshotRef)
+			e.uvarint(uint64(id))
+			return nil
+		}
+	}
+
+	switch o := o.(type) {
+	case *Bytes:
+		e.ref(o)
+		e.buf.WriteByte(snapshotBytes)
+		e.bytes(o.Value)
+	case *Array:
+		e.ref(o)
+		e.buf.WriteByte(snapshotArray)
+		return e.objects(o.Value, depth)
+	case *ImmutableArray:
+		e.ref(o)
+		e.buf.WriteByte(snapshotImmutableArray)
+		return e.objects(o.Value, depth)
+	case *Map:
+		e.ref(o)
+		e.buf.WriteByte(snapshotMap)
+		return e.objectMap(o.Value, depth)
+	case *ImmutableMap:
+		if name := inferModuleName(o); name != "" {
+			e.buf.WriteByte(snapshotModule)
+			e.string(name)
+			return nil
+		}
+		e.ref(o)
+		e.buf.WriteByte(snapshotImmutableMap)
+		return e.objectMap(o.Value, depth)
+	case *Error:
+		e.ref(o)
+		e.buf.WriteByte(snapshotError)
+		return e.object(o.Value, depth+1)
+	case *ObjectPtr:
+		e.ref(o)
+		e.buf.WriteByte(snapshotFreeVar)
+		return e.cell(o.Value, depth)
+	case *CompiledFunction:
+		return e.function(o, depth)
+	case *ArrayIterator:
+		// the elements are shared, but the slice of the iterated array is
+		// copied
+		e.ref(o)
+		e.buf.WriteByte(snapshotArrayIterator)
+		if err := e.objects(o.v, depth); err != nil {
+			return err
+		}
+		e.uvarint(uint64(o.i))
+		e.uvarint(uint64(o.l))
+	case *BytesIterator:
+		e.ref(o)
+		e.buf.WriteByte(snapshotBytesIterator)
+		e.bytes(o.v)
+		e.uvarint(uint64(o.i))
+		e.uvarint(uint64(o.l))
+	case *MapIterator:
+		e.ref(o)
+		e.buf.WriteByte(snapshotMapIterator)
+		if err := e.objectMap(o.v, depth); err != nil {
+			return err
+		}
+		e.strings(o.k)
+		e.uvarint(uint64(o.i))
+		e.uvarint(uint64(o.l))
+	case *StringIterator:
+		e.ref(o)
+		e.buf.WriteByte(snapshotStringIterator)
+		e.uvarint(uint64(len(o.v)))
+		for _, r := range o.v {
+			e.varint(int64(r))
+		}
+		e.uvarint(uint64(o.i))
+		e.uvarint(uint64(o.l))
+	default:
+		if m, ok := e.members[o]; ok {
+			e.buf.WriteByte(snapshotModuleMember)
+			e.string(m.module)
+			e.string(m.key)
+			return nil
+		}
+		if name, ok := e.externals[o]; ok {
+			e.buf.WriteByte(snapshotExternal)
+			e.string(name)
+			return nil
+		}
+		return &SnapshotError{Where: e.where, Object: o}
+	}
+	return nil
+}
+
+func (e *snapshotEncoder) objects(v []Object, depth int) error {
+	e.uvarint(uint64(len(v)))
+	for _, elem := range v {
+		if err := e.object(elem, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *snapshotEncoder) objectMap(v map[string]Object, depth int) error {
+	e.uvarint(uint64(len(v)))
+	for _, key := range sortedKeys(v) {
+		e.string(key)
+		if err := e.object(v[key], depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cell writes the variable referenced by free variables, which is shared by
+// the free variables of the same variable.
+func (e *snapshotEncoder) cell(c *Object, depth int) error {
+	if id, ok := e.cells[c]; ok {
+		e.uvarint(uint64(id) + 1)
+		return nil
+	}
+	e.cells[c] = len(e.refs) + len(e.cells)
+	e.uvarint(0)
+	return e.object(*c, depth+1)
+}
+
+func (e *snapshotEncoder) function(fn *CompiledFunction, depth int) error {
+	if fn == e.main {
+		e.buf.WriteByte(snapshotMainFunction)
+		return nil
+	}
+	if idx, ok := e.functions[fn]; ok {
+		e.buf.WriteByte(snapshotFunction)
+		e.uvarint(uint64(idx))
+		return nil
+	}
+	var idx int
+	var ok bool
+	if len(fn.Instructions) > 0 {
+		idx, ok = e.protos[&fn.Instructions[0]]
+	}
+	if !ok {
+		return &SnapshotError{Where: e.where, Object: fn}
+	}
+	e.ref(fn)
+	e.buf.WriteByte(snapshotClosure)
+	e.uvarint(uint64(idx))
+	e.uvarint(uint64(len(fn.Free)))
+	for _, freeVar := range fn.Free {
+		if err := e.object(freeVar, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotDecoder reads the VM snapshot. The first error is kept and the
+// following reads return zero values.
+type snapshotDecoder struct {
+	bytecodeDecoder
+	bytecode  *Bytecode
+	modules   map[string]*ImmutableMap
+	externals map[string]Object
+	refs      []interface{} // objects and cells by their ids
+}
+
+func newSnapshotDecoder(
+	data []byte,
+	b *Bytecode,
+	externals map[string]Object,
+) *snapshotDecoder {
+	d := &snapshotDecoder{
+		bytecode:  b,
+		modules:   moduleConstants(b),
+		externals: externals,
+	}
+	d.data = data
+	d.invalid = ErrInvalidSnapshot
+	return d
+}
+
+func (d *snapshotDecoder) object(depth int) Object {
+	if depth > maxSnapshotDepth {
+		d.fail("objects nested too deeply")
+		return nil
+	}
+	switch tag := d.byte(); tag {
+	case snapshotNil:
+		return nil
+	case snapshotUndefined:
+		return UndefinedValue
+	case snapshotFalse:
+		return FalseValue
+	case snapshotTrue:
+		return TrueValue
+	case snapshotInt:
+		return &Int{Value: d.varint()}
+	case snapshotFloat:
+		return &Float{Value: math.Float64frombits(d.uvarint())}
+	case snapshotChar:
+		v := d.varint()
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			d.fail("char %d out of range", v)
+		}
+		return &Char{Value: rune(v)}
+	case snapshotString:
+		return &String{Value: d.string()}
+	case snapshotTime:
+		data := d.bytes()
+		t := &Time{}
+		if d.err == nil {
+			if err := t.Value.UnmarshalBinary(data); err != nil {
+				d.fail("invalid time: %s", err)
+			}
+		}
+		return t
+	case snapshotBuiltinFunction:
+		name := d.string()
+		for _, fn := range builtinFuncs {
+			if fn.Name == name {
+				return fn
+			}
+		}
+		d.fail("unknown builtin function '%s'", name)
+		return nil
+	case snapshotRef:
+		id := d.int()
+		if d.err == nil && id >= len(d.refs) {
+			d.fail("invalid reference %d", id)
+		}
+		if d.err != nil {
+			return nil
+		}
+		o, ok := d.refs[id].(Object)
+		if !ok {
+			d.fail("invalid reference %d", id)
+		}
+		return o
+	case snapshotBytes:
+		o := &Bytes{}
+		d.refs = append(d.refs, o)
+		o.Value = d.bytes()
+		return o
+	case snapshotArray:
+		o := &Array{}
+		d.refs = append(d.refs, o)
+		o.Value = d.objects(depth)
+		return o
+	case snapshotImmutableArray:
+		o := &ImmutableArray{}
+		d.refs = append(d.refs, o)
+		o.Value = d.objects(depth)
+		return o
+	case snapshotMap:
+		o := &Map{}
+		d.refs = append(d.refs, o)
+		o.Value = d.objectMap(depth)
+		return o
+	case snapshotImmutableMap:
+		o := &ImmutableMap{}
+		d.refs = append(d.refs, o)
+		o.Value = d.objectMap(depth)
+		return o
+	case snapshotModule:
+		name := d.string()
+		m, ok := d.modules[name]
+		if d.err == nil && !ok {
+			d.fail("module '%s' not imported by bytecode", name)
+		}
+		return m
+	case snapshotError:
+		o := &Error{}
+		d.refs = append(d.refs, o)
+		o.Value = d.object(depth + 1)
+		return o
+	case snapshotFreeVar:
+		o := &ObjectPtr{}
+		d.refs = append(d.refs, o)
+		o.Value = d.cell(depth)
+		return o
+	case snapshotMainFunction:
+		return d.bytecode.MainFunction
+	case snapshotFunction:
+		return d.constantFunction()
+	case snapshotClosure:
+		return d.closure(depth)
+	case snapshotArrayIterator:
+		o := &ArrayIterator{}
+		d.refs = append(d.refs, o)
+		o.v = d.objects(depth)
+		o.i, o.l = d.int(), d.int()
+		if d.err == nil && (o.l > len(o.v) || o.i > o.l+1) {
+			d.fail("invalid array iterator")
+		}
+		return o
+	case snapshotBytesIterator:
+		o := &BytesIterator{}
+		d.refs = append(d.refs, o)
+		o.v = d.bytes()
+		o.i, o.l = d.int(), d.int()
+		if d.err == nil && (o.l > len(o.v) || o.i > o.l+1) {
+			d.fail("invalid bytes iterator")
+		}
+		return o
+	case snapshotMapIterator:
+		o := &MapIterator{}
+		d.refs = append(d.refs, o)
+		o.v = d.objectMap(depth)
+		o.k = d.strings()
+		o.i, o.l = d.int(), d.int()
+		if d.err == nil && (o.l > len(o.k) || o.i > o.l+1) {
+			d.fail("invalid map iterator")
+		}
+		return o
+	case snapshotStringIterator:
+		o := &StringIterator{}
+		d.refs = append(d.refs, o)
+		n := d.length()
+		for i := 0; i < n && d.err == nil; i++ {
+			o.v = append(o.v, rune(d.varint()))
+		}
+		o.i, o.l = d.int(), d.int()
+		if d.err == nil && (o.l > len(o.v) || o.i > o.l+1) {
+			d.fail("invalid string iterator")
+		}
+		return o
+	case snapshotModuleMember:
+		module, key := d.string(), d.string()
+		if d.err != nil {
+			return nil
+		}
+		m, ok := d.modules[module]
+		if !ok {
+			d.fail("module '%s' not imported by bytecode", module)
+			return nil
+		}
+		o, ok := m.Value[key]
+		if !ok {
+			d.fail("module '%s' has no member '%s'", module, key)
+		}
+		return o
+	case snapshotExternal:
+		name := d.string()
+		if d.err != nil {
+			return nil
+		}
+		o, ok := d.externals[name]
+		if !ok {
+			d.fail("external object '%s' not found", name)
+		}
+		return o
+	default:
+		d.fail("unknown object tag %d", tag)
+		return nil
+	}
+}
+
+func (d *snapshotDecoder) objects(depth int) []Object {
+	n := d.length()
+	v := make([]Object, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		v = append(v, d.object(depth+1))
+	}
+	return v
+}
+
+func (d *snapshotDecoder) objectMap(depth int) map[string]Object {
+	n := d.length()
+	v := make(map[string]Object, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		key := d.string()
+		v[key] = d.object(depth + 1)
+	}
+	return v
+}
+
+func (d *snapshotDecoder) cell(depth int) *Object {
+	if id := d.int(); id > 0 {
+		id--
+		if d.err == nil && id >= len(d.refs) {
+			d.fail("invalid reference %d", id)
+		}
+		if d.err != nil {
+			return new(Object)
+		}
+		c, ok := d.refs[id].(*Object)
+		if !ok {
+			d.fail("invalid reference %d", id)
+			return new(Object)
+		}
+		return c
+	}
+	c := new(Object)
+	d.refs = append(d.refs, c)
+	*c = d.object(depth + 1)
+	return c
+}
+
+func (d *snapshotDecoder) freeVar(depth int) *ObjectPtr {
+	o := d.object(depth + 1)
+	freeVar, ok := o.(*ObjectPtr)
+	if d.err == nil && !ok {
+		d.fail("invalid free variable")
+	}
+	return freeVar
+}
+
+func (d *snapshotDecoder) function(depth int) *CompiledFunction {
+	o := d.object(depth)
+	fn, ok := o.(*CompiledFunction)
+	if d.err == nil && !ok {
+		d.fail("not a function")
+	}
+	return fn
+}
+
+func (d *snapshotDecoder) closure(depth int) *CompiledFunction {
+	cl := &CompiledFunction{}
+	d.refs = append(d.refs, cl)
+	fn := d.constantFunction()
+	if fn == nil {
+		return nil
+	}
+	numFree := d.length()
+	free := make([]*ObjectPtr, 0, numFree)
+	for i := 0; i < numFree && d.err == nil; i++ {
+		free = append(free, d.freeVar(depth))
+	}
+	*cl = *fn
+	cl.Free = free
+	return cl
+}
+
+func (d *snapshotDecoder) constantFunction() *CompiledFunction {
+	idx := d.int()
+	if d.err != nil {
+		return nil
+	}
+	if idx < len(d.bytecode.Constants) {
+		if fn, ok := d.bytecode.Constants[idx].(*CompiledFunction); ok {
+			return fn
+		}
+	}
+	d.fail("invalid function constant %d", idx)
+	return nil
+}
@@ -0,0 +1,129 @@
+package nanojs_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+	"github.com/zeaphoo/nanojs/v2/stdlib"
+)
+
+const workflowSrc = `
+times = import("times")
+text = import("text")
+
+counter = function() {
+	n = 0
+	return {
+		inc: function() { n += 1; return n },
+		get: function() { return n }
+	}
+}
+c = counter()
+state = {steps: []}
+state.self = state
+names = ["a", "b", "c"]
+result = ""
+for (k in names) {
+	c.inc()
+	answer = wait(names[k])
+	state.steps = append(state.steps, answer)
+	result += text.to_upper(answer)
+}
+total = c.get()
+unit = times.second
+`
+
+func compileWorkflow(t *testing.T) *nanojs.Compiled {
+	s := nanojs.NewScript([]byte(workflowSrc))
+	s.SetImports(stdlib.GetModuleMap("times", "text"))
+	require.NoError(t, s.Add("wait", &nanojs.UserFunction{
+		Name: "wait",
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			return nil, nanojs.ErrSuspend
+		},
+	}))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	return c
+}
+
+func TestCompiled_Suspend(t *testing.T) {
+	c := compileWorkflow(t)
+	require.False(t, c.Suspended())
+	require.Equal(t, nanojs.ErrSuspend, c.Run())
+	require.True(t, c.Suspended())
+
+	for _, answer := range []string{"x", "y"} {
+		// each step is resumed from a snapshot in a new compiled script
+		data, err := c.Snapshot()
+		require.NoError(t, err)
+		c = compileWorkflow(t)
+		require.NoError(t, c.Restore(data))
+		require.True(t, c.Suspended())
+		require.Equal(t, nanojs.ErrSuspend, c.Resume(answer))
+	}
+	require.NoError(t, c.Resume("z"))
+	require.False(t, c.Suspended())
+
+	require.Equal(t, "XYZ", c.Get("result").String())
+	require.Equal(t, 3, c.Get("total").Int())
+	require.Equal(t, int64(1000000000), c.Get("unit").Int64())
+
+	// the shared references are preserved
+	state := c.Get("state").Object().(*nanojs.Map)
+	require.True(t, state.Value["self"] == state)
+	var steps []string
+	require.NoError(t, nanojs.Decode(state.Value["steps"], &steps))
+	require.Equal(t, []string{"x", "y", "z"}, steps)
+
+	_, err := c.Snapshot()
+	require.Error(t, err)
+	require.Error(t, c.Resume(nil))
+}
+
+func TestCompiled_Snapshot_Errors(t *testing.T) {
+	s := nanojs.NewScript([]byte(`
+obj = make()
+wait()
+`))
+	require.NoError(t, s.Add("make", &nanojs.UserFunction{
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			return nanojs.NewGoObject(&struct{ N int }{})
+		},
+	}))
+	require.NoError(t, s.Add("wait", &nanojs.UserFunction{
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			return nil, nanojs.ErrSuspend
+		},
+	}))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.Equal(t, nanojs.ErrSuspend, c.Run())
+	_, err = c.Snapshot()
+	var snapErr *nanojs.SnapshotError
+	require.True(t, errors.As(err, &snapErr))
+	require.Equal(t, "global 'obj'", snapErr.Where)
+
+	// a snapshot of another script
+	data, err := compileWorkflowSnapshot(t)
+	require.NoError(t, err)
+	require.True(t, errors.Is(c.Restore(data), nanojs.ErrInvalidSnapshot))
+	// the suspended run is kept
+	require.True(t, c.Suspended())
+
+	// corrupted data
+	c = compileWorkflow(t)
+	for _, n := range []int{0, 4, len(data) / 2, len(data) - 1} {
+		err := c.Restore(data[:n])
+		require.True(t, errors.Is(err, nanojs.ErrInvalidSnapshot), err)
+	}
+	require.NoError(t, c.Restore(data))
+}
+
+func compileWorkflowSnapshot(t *testing.T) ([]byte, error) {
+	c := compileWorkflow(t)
+	require.Equal(t, nanojs.ErrSuspend, c.Run())
+	return c.Snapshot()
+}
@@ -1,28 +1,35 @@
 package stdlib
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/zeaphoo/nanojs/v2"
 )
 
 var fmtModule = map[string]nanojs.Object{
-	"print":   &nanojs.UserFunction{Name: "print", Value: fmtPrint},
-	"printf":  &nanojs.UserFunction{Name: "printf", Value: fmtPrintf},
-	"println": &nanojs.UserFunction{Name: "println", Value: fmtPrintln},
+	"print":   nanojs.NewContextFunction("print", fmtPrint),
+	"printf":  nanojs.NewContextFunction("printf", fmtPrintf),
+	"println": nanojs.NewContextFunction("println", fmtPrintln),
 	"sprintf": &nanojs.UserFunction{Name: "sprintf", Value: fmtSprintf},
 }
 
-func fmtPrint(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func fmtPrint(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	printArgs, err := getPrintArgs(args...)
 	if err != nil {
 		return nil, err
 	}
-	_, _ = fmt.Print(printArgs...)
+	_, _ = fmt.Fprint(nanojs.Stdout(ctx), printArgs...)
 	return nil, nil
 }
 
-func fmtPrintf(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func fmtPrintf(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	numArgs := len(args)
 	if numArgs == 0 {
 		return nil, nanojs.ErrWrongNumArguments
@@ -37,7 +44,7 @@ func fmtPrintf(args ...nanojs.Object) (ret nanojs.Object, err error) {
 		}
 	}
 	if numArgs == 1 {
-		fmt.Print(format)
+		_, _ = fmt.Fprint(nanojs.Stdout(ctx), format)
 		return nil, nil
 	}
 
@@ -45,17 +52,20 @@ func fmtPrintf(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	if err != nil {
 		return nil, err
 	}
-	fmt.Print(s)
+	_, _ = fmt.Fprint(nanojs.Stdout(ctx), s)
 	return nil, nil
 }
 
-func fmtPrintln(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func fmtPrintln(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	printArgs, err := getPrintArgs(args...)
 	if err != nil {
 		return nil, err
 	}
 	printArgs = append(printArgs, "\n")
-	_, _ = fmt.Print(printArgs...)
+	_, _ = fmt.Fprint(nanojs.Stdout(ctx), printArgs...)
 	return nil, nil
 }
 
@@ -1,9 +1,9 @@
 package stdlib
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 
@@ -39,6 +39,9 @@ var osModule = map[string]nanojs.Object{
 	"seek_set":            &nanojs.Int{Value: int64(io.SeekStart)},
 	"seek_cur":            &nanojs.Int{Value: int64(io.SeekCurrent)},
 	"seek_end":            &nanojs.Int{Value: int64(io.SeekEnd)},
+	"stdin":               makeOSReader(nanojs.Stdin),  // stdin.read(bytes)
+	"stdout":              makeOSWriter(nanojs.Stdout), // stdout.write(bytes)
+	"stderr":              makeOSWriter(nanojs.Stderr), // stderr.write(bytes)
 	"args": &nanojs.UserFunction{
 		Name:  "args",
 		Value: osArgs,
@@ -66,7 +69,7 @@ var osModule = map[string]nanojs.Object{
 	}, // exit(code int)
 	"expand_env": &nanojs.UserFunction{
 		Name:  "expand_env",
-		Value: osExpandEnv,
+		Value: makeOSExpandEnv(os.Getenv),
 	}, // expand_env(s string) => string
 	"getegid": &nanojs.UserFunction{
 		Name:  "getegid",
@@ -122,7 +125,7 @@ var osModule = map[string]nanojs.Object{
 	}, // link(oldname string, newname string) => error
 	"lookup_env": &nanojs.UserFunction{
 		Name:  "lookup_env",
-		Value: osLookupEnv,
+		Value: makeOSLookupEnv(os.LookupEnv),
 	}, // lookup_env(key string) => string/false
 	"mkdir":     osFuncASFmRE("mkdir", os.Mkdir),        // mkdir(name string, perm int) => error
 	"mkdir_all": osFuncASFmRE("mkdir_all", os.MkdirAll), // mkdir_all(name string, perm int) => error
@@ -186,21 +189,24 @@ var osModule = map[string]nanojs.Object{
 		Name:  "exec_look_path",
 		Value: FuncASRSE(exec.LookPath),
 	}, // exec_look_path(file) => string/error
-	"exec": &nanojs.UserFunction{
-		Name:  "exec",
-		Value: osExec,
-	}, // exec(name, args...) => command
+	"exec": nanojs.NewContextFunction(
+		"exec",
+		osExec,
+	), // exec(name, args...) => command
 	"stat": &nanojs.UserFunction{
 		Name:  "stat",
 		Value: osStat,
 	}, // stat(name) => imap(fileinfo)/error
-	"read_file": &nanojs.UserFunction{
-		Name:  "read_file",
-		Value: osReadFile,
-	}, // readfile(name) => array(byte)/error
+	"read_file": nanojs.NewContextFunction(
+		"read_file",
+		osReadFile,
+	), // readfile(name) => array(byte)/error
 }
 
-func osReadFile(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func osReadFile(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	if len(args) != 1 {
 		return nil, nanojs.ErrWrongNumArguments
 	}
@@ -212,16 +218,40 @@ func osReadFile(args ...nanojs.Object) (ret nanojs.Object, err error) {
 			Found:    args[0].TypeName(),
 		}
 	}
-	bytes, err := ioutil.ReadFile(fname)
+	file, err := os.Open(fname)
 	if err != nil {
 		return wrapError(err), nil
 	}
-	if len(bytes) > nanojs.MaxBytesLen {
-		return nil, nanojs.ErrBytesLimit
+	defer func() { _ = file.Close() }()
+
+	// the file is read in chunks to stop when the context is done
+	var bytes []byte
+	buf := make([]byte, readFileChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := file.Read(buf)
+		bytes = append(bytes, buf[:n]...)
+		if len(bytes) > nanojs.MaxBytesLen {
+			return nil, nanojs.ErrBytesLimit
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return wrapError(err), nil
+		}
+	}
+	if bytes == nil {
+		bytes = []byte{}
 	}
 	return &nanojs.Bytes{Value: bytes}, nil
 }
 
+// readFileChunkSize is the size of the chunks read_file reads at once.
+const readFileChunkSize = 32 * 1024
+
 func osStat(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	if len(args) != 1 {
 		return nil, nanojs.ErrWrongNumArguments
@@ -372,29 +402,44 @@ func osFuncASFmRE(
 	}
 }
 
-func osLookupEnv(args ...nanojs.Object) (nanojs.Object, error) {
-	if len(args) != 1 {
-		return nil, nanojs.ErrWrongNumArguments
-	}
-	s1, ok := nanojs.ToString(args[0])
-	if !ok {
-		return nil, nanojs.ErrInvalidArgumentType{
-			Name:     "first",
-			Expected: "string(compatible)",
-			Found:    args[0].TypeName(),
+// makeOSLookupEnv returns lookup_env of the environment looked up by lookup.
+func makeOSLookupEnv(
+	lookup func(key string) (string, bool),
+) nanojs.CallableFunc {
+	return func(args ...nanojs.Object) (nanojs.Object, error) {
+		if len(args) != 1 {
+			return nil, nanojs.ErrWrongNumArguments
 		}
+		s1, ok := nanojs.ToString(args[0])
+		if !ok {
+			return nil, nanojs.ErrInvalidArgumentType{
+				Name:     "first",
+				Expected: "string(compatible)",
+				Found:    args[0].TypeName(),
+			}
+		}
+		res, ok := lookup(s1)
+		if !ok {
+			return nanojs.FalseValue, nil
+		}
+		if len(res) > nanojs.MaxStringLen {
+			return nil, nanojs.ErrStringLimit
+		}
+		return &nanojs.String{Value: res}, nil
 	}
-	res, ok := os.LookupEnv(s1)
-	if !ok {
-		return nanojs.FalseValue, nil
-	}
-	if len(res) > nanojs.MaxStringLen {
-		return nil, nanojs.ErrStringLimit
+}
+
+// makeOSExpandEnv returns expand_env of the environment read by getenv.
+func makeOSExpandEnv(getenv func(key string) string) nanojs.CallableFunc {
+	return func(args ...nanojs.Object) (nanojs.Object, error) {
+		return osExpandEnv(getenv, args...)
 	}
-	return &nanojs.String{Value: res}, nil
 }
 
-func osExpandEnv(args ...nanojs.Object) (nanojs.Object, error) {
+func osExpandEnv(
+	getenv func(key string) string,
+	args ...nanojs.Object,
+) (nanojs.Object, error) {
 	if len(args) != 1 {
 		return nil, nanojs.ErrWrongNumArguments
 	}
@@ -412,7 +457,7 @@ func osExpandEnv(args ...nanojs.Object) (nanojs.Object, error) {
 		if failed {
 			return ""
 		}
-		v := os.Getenv(k)
+		v := getenv(k)
 
 		// this does not count the other texts that are not being replaced
 		// but the code checks the final length at the end
@@ -429,13 +474,27 @@ func osExpandEnv(args ...nanojs.Object) (nanojs.Object, error) {
 	return &nanojs.String{Value: s}, nil
 }
 
-func osExec(args ...nanojs.Object) (nanojs.Object, error) {
+func osExec(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (nanojs.Object, error) {
+	name, execArgs, err := osExecArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	// the process is killed when the context of the run is done
+	return makeOSExecCommand(exec.CommandContext(ctx, name, execArgs...)),
+		nil
+}
+
+// osExecArgs returns the command name and the arguments of exec.
+func osExecArgs(args []nanojs.Object) (string, []string, error) {
 	if len(args) == 0 {
-		return nil, nanojs.ErrWrongNumArguments
+		return "", nil, nanojs.ErrWrongNumArguments
 	}
 	name, ok := nanojs.ToString(args[0])
 	if !ok {
-		return nil, nanojs.ErrInvalidArgumentType{
+		return "", nil, nanojs.ErrInvalidArgumentType{
 			Name:     "first",
 			Expected: "string(compatible)",
 			Found:    args[0].TypeName(),
@@ -445,7 +504,7 @@ func osExec(args ...nanojs.Object) (nanojs.Object, error) {
 	for idx, arg := range args[1:] {
 		execArg, ok := nanojs.ToString(arg)
 		if !ok {
-			return nil, nanojs.ErrInvalidArgumentType{
+			return "", nil, nanojs.ErrInvalidArgumentType{
 				Name:     fmt.Sprintf("args[%d]", idx),
 				Expected: "string(compatible)",
 				Found:    args[1+idx].TypeName(),
@@ -453,7 +512,7 @@ func osExec(args ...nanojs.Object) (nanojs.Object, error) {
 		}
 		execArgs = append(execArgs, execArg)
 	}
-	return makeOSExecCommand(exec.Command(name, execArgs...)), nil
+	return name, execArgs, nil
 }
 
 func osFindProcess(args ...nanojs.Object) (nanojs.Object, error) {
@@ -476,75 +535,67 @@ func osFindProcess(args ...nanojs.Object) (nanojs.Object, error) {
 }
 
 func osStartProcess(args ...nanojs.Object) (nanojs.Object, error) {
+	name, argv, dir, env, err := osStartProcessArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := os.StartProcess(name, argv, &os.ProcAttr{
+		Dir: dir,
+		Env: env,
+	})
+	if err != nil {
+		return wrapError(err), nil
+	}
+	return makeOSProcess(proc), nil
+}
+
+// osStartProcessArgs returns the name, the argv, the directory and the
+// environment of start_process.
+func osStartProcessArgs(
+	args []nanojs.Object,
+) (name string, argv []string, dir string, env []string, err error) {
 	if len(args) != 4 {
-		return nil, nanojs.ErrWrongNumArguments
+		err = nanojs.ErrWrongNumArguments
+		return
 	}
 	name, ok := nanojs.ToString(args[0])
 	if !ok {
-		return nil, nanojs.ErrInvalidArgumentType{
+		err = nanojs.ErrInvalidArgumentType{
 			Name:     "first",
 			Expected: "string(compatible)",
 			Found:    args[0].TypeName(),
 		}
+		return
 	}
-	var argv []string
-	var err error
-	switch arg1 := args[1].(type) {
-	case *nanojs.Array:
-		argv, err = stringArray(arg1.Value, "second")
-		if err != nil {
-			return nil, err
-		}
-	case *nanojs.ImmutableArray:
-		argv, err = stringArray(arg1.Value, "second")
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, nanojs.ErrInvalidArgumentType{
-			Name:     "second",
-			Expected: "array",
-			Found:    arg1.TypeName(),
-		}
+	if argv, err = stringArrayArg(args[1], "second"); err != nil {
+		return
 	}
-
-	dir, ok := nanojs.ToString(args[2])
+	dir, ok = nanojs.ToString(args[2])
 	if !ok {
-		return nil, nanojs.ErrInvalidArgumentType{
+		err = nanojs.ErrInvalidArgumentType{
 			Name:     "third",
 			Expected: "string(compatible)",
 			Found:    args[2].TypeName(),
 		}
+		return
 	}
+	env, err = stringArrayArg(args[3], "fourth")
+	return
+}
 
-	var env []string
-	switch arg3 := args[3].(type) {
+// stringArrayArg returns the strings of the array argument.
+func stringArrayArg(arg nanojs.Object, argName string) ([]string, error) {
+	switch arg := arg.(type) {
 	case *nanojs.Array:
-		env, err = stringArray(arg3.Value, "fourth")
-		if err != nil {
-			return nil, err
-		}
+		return stringArray(arg.Value, argName)
 	case *nanojs.ImmutableArray:
-		env, err = stringArray(arg3.Value, "fourth")
-		if err != nil {
-			return nil, err
-		}
-	default:
-		return nil, nanojs.ErrInvalidArgumentType{
-			Name:     "fourth",
-			Expected: "array",
-			Found:    arg3.TypeName(),
-		}
+		return stringArray(arg.Value, argName)
 	}
-
-	proc, err := os.StartProcess(name, argv, &os.ProcAttr{
-		Dir: dir,
-		Env: env,
-	})
-	if err != nil {
-		return wrapError(err), nil
+	return nil, nanojs.ErrInvalidArgumentType{
+		Name:     argName,
+		Expected: "array",
+		Found:    arg.TypeName(),
 	}
-	return makeOSProcess(proc), nil
 }
 
 func stringArray(arr []nanojs.Object, argName string) ([]string, error) {
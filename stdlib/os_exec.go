@@ -80,25 +80,9 @@ func makeOSExecCommand(cmd *exec.Cmd) *nanojs.ImmutableMap {
 						return nil, nanojs.ErrWrongNumArguments
 					}
 
-					var env []string
-					var err error
-					switch arg0 := args[0].(type) {
-					case *nanojs.Array:
-						env, err = stringArray(arg0.Value, "first")
-						if err != nil {
-							return nil, err
-						}
-					case *nanojs.ImmutableArray:
-						env, err = stringArray(arg0.Value, "first")
-						if err != nil {
-							return nil, err
-						}
-					default:
-						return nil, nanojs.ErrInvalidArgumentType{
-							Name:     "first",
-							Expected: "array",
-							Found:    arg0.TypeName(),
-						}
+					env, err := stringArrayArg(args[0], "first")
+					if err != nil {
+						return nil, err
 					}
 					cmd.Env = env
 					return nanojs.UndefinedValue, nil
@@ -1,6 +1,7 @@
 package stdlib
 
 import (
+	"context"
 	"os"
 	"syscall"
 
@@ -58,19 +59,44 @@ func makeOSProcess(proc *os.Process) *nanojs.ImmutableMap {
 					return wrapError(proc.Signal(syscall.Signal(i1))), nil
 				},
 			},
-			"wait": &nanojs.UserFunction{
-				Name: "wait",
-				Value: func(args ...nanojs.Object) (nanojs.Object, error) {
-					if len(args) != 0 {
-						return nil, nanojs.ErrWrongNumArguments
-					}
-					state, err := proc.Wait()
-					if err != nil {
-						return wrapError(err), nil
-					}
-					return makeOSProcessState(state), nil
-				},
-			},
+			"wait": nanojs.NewContextFunction("wait", func(
+				ctx context.Context,
+				args ...nanojs.Object,
+			) (nanojs.Object, error) {
+				if len(args) != 0 {
+					return nil, nanojs.ErrWrongNumArguments
+				}
+				state, err := waitProcess(ctx, proc)
+				if err != nil {
+					return wrapError(err), nil
+				}
+				return makeOSProcessState(state), nil
+			}),
 		},
 	}
 }
+
+// waitProcess waits for the process to exit, and kills the process when the
+// context is done.
+func waitProcess(
+	ctx context.Context,
+	proc *os.Process,
+) (*os.ProcessState, error) {
+	if ctx.Done() == nil {
+		return proc.Wait()
+	}
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = proc.Kill()
+		case <-exited:
+		}
+	}()
+	state, err := proc.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return state, err
+}
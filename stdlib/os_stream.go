@@ -0,0 +1,55 @@
+package stdlib
+
+import (
+	"context"
+	"io"
+
+	"github.com/zeaphoo/nanojs/v2"
+)
+
+// makeOSReader returns the object of the standard input of the runs, which
+// is resolved by stream at the time of the call (see nanojs.Stdin).
+func makeOSReader(
+	stream func(ctx context.Context) io.Reader,
+) *nanojs.ImmutableMap {
+	return &nanojs.ImmutableMap{
+		Value: map[string]nanojs.Object{
+			// read(bytes) => int/error
+			"read": nanojs.NewContextFunction("read", func(
+				ctx context.Context,
+				args ...nanojs.Object,
+			) (nanojs.Object, error) {
+				return FuncAYRIE(stream(ctx).Read)(args...)
+			}),
+		},
+	}
+}
+
+// makeOSWriter returns the object of the standard output or error of the
+// runs, which is resolved by stream at the time of the call (see
+// nanojs.Stdout).
+func makeOSWriter(
+	stream func(ctx context.Context) io.Writer,
+) *nanojs.ImmutableMap {
+	return &nanojs.ImmutableMap{
+		Value: map[string]nanojs.Object{
+			// write(bytes) => int/error
+			"write": nanojs.NewContextFunction("write", func(
+				ctx context.Context,
+				args ...nanojs.Object,
+			) (nanojs.Object, error) {
+				return FuncAYRIE(stream(ctx).Write)(args...)
+			}),
+			// write_string(string) => int/error
+			"write_string": nanojs.NewContextFunction("write_string", func(
+				ctx context.Context,
+				args ...nanojs.Object,
+			) (nanojs.Object, error) {
+				w := stream(ctx)
+				return FuncASRIE(func(s string) (int, error) {
+					return io.WriteString(w, s)
+				})(args...)
+			}),
+		},
+	}
+}
@@ -0,0 +1,462 @@
+package stdlib
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zeaphoo/nanojs/v2"
+)
+
+// policyDenied are the functions denied unless they're allowed explicitly,
+// because they affect the whole host process.
+var policyDenied = []string{"os.chdir", "os.exit", "os.find_process"}
+
+// policyDeniedEnv are the environment variables the scripts can't set, as
+// they make the dynamic loader run code in the allowed commands.
+var policyDeniedEnv = []string{"LD_*", "DYLD_*"}
+
+// Policy is a capability policy restricting the functions of the standard
+// library modules imported by the scripts. Use Policy.GetModuleMap, or
+// Script.SetModulePolicy.
+//
+// A call denied by the policy returns an error value to the script, e.g.
+// error("permission denied: os.exec: command \"rm\" is not allowed"). The
+// policy denies:
+//
+//   - the functions denied by Deny, and os.chdir, os.exit and
+//     os.find_process unless they're allowed by Allow,
+//   - the files outside the directories allowed by AllowRead and AllowWrite,
+//   - the commands of os.exec and os.start_process not allowed by
+//     AllowCommand,
+//   - the environment variables of the dynamic loader, LD_* and DYLD_*,
+//     set by the scripts.
+//
+// The environment variables of the os module are virtual: they're the
+// variables set by SetEnv, and the changes by the scripts are not visible to
+// the host process. The scripts restricted by the same policy share its
+// virtual environment. The commands run with the virtual environment, and
+// the variables given to command.set_env and os.start_process are added to
+// it.
+//
+// The policy must be set up before the module maps are restricted by it, and
+// it must not be changed after.
+type Policy struct {
+	allow    []string
+	deny     []string
+	roots    []policyRoot
+	commands map[string][]*regexp.Regexp
+	envLock  sync.RWMutex
+	env      map[string]string
+}
+
+// policyRoot is a directory of the files allowed by the policy.
+type policyRoot struct {
+	dir   string
+	write bool
+}
+
+// NewPolicy creates a Policy that denies the files and the commands, with an
+// empty virtual environment.
+func NewPolicy() *Policy {
+	return &Policy{
+		commands: make(map[string][]*regexp.Regexp),
+		env:      make(map[string]string),
+	}
+}
+
+// Allow allows the functions matching the patterns of the "module.function"
+// names (see path.Match), e.g. "os.exit" or "os.*".
+func (p *Policy) Allow(patterns ...string) error {
+	if err := checkPatterns(patterns); err != nil {
+		return err
+	}
+	p.allow = append(p.allow, patterns...)
+	return nil
+}
+
+// Deny denies the functions matching the patterns of the "module.function"
+// names (see path.Match), e.g. "os.setenv" or "os.*". It takes precedence
+// over Allow.
+func (p *Policy) Deny(patterns ...string) error {
+	if err := checkPatterns(patterns); err != nil {
+		return err
+	}
+	p.deny = append(p.deny, patterns...)
+	return nil
+}
+
+// AllowRead allows reading the files in the directories and their
+// subdirectories.
+func (p *Policy) AllowRead(dirs ...string) error {
+	return p.addRoots(dirs, false)
+}
+
+// AllowWrite allows reading, writing, creating and removing the files in the
+// directories and their subdirectories.
+func (p *Policy) AllowWrite(dirs ...string) error {
+	return p.addRoots(dirs, true)
+}
+
+func (p *Policy) addRoots(dirs []string, write bool) error {
+	for _, dir := range dirs {
+		resolved, err := resolvePath(dir)
+		if err != nil {
+			return err
+		}
+		p.roots = append(p.roots, policyRoot{dir: resolved, write: write})
+	}
+	return nil
+}
+
+// AllowCommand allows running the command by os.exec and os.start_process
+// with the arguments each matching one of the regular expressions, e.g.
+// AllowCommand("git", "status|log", "--oneline"). The name must be the same
+// as the name the script runs the command with.
+func (p *Policy) AllowCommand(name string, args ...string) error {
+	patterns := p.commands[name]
+	for _, arg := range args {
+		re, err := regexp.Compile("^(?:" + arg + ")$")
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, re)
+	}
+	p.commands[name] = patterns
+	return nil
+}
+
+// SetEnv sets the variables of the virtual environment, replacing the
+// variables set before.
+func (p *Policy) SetEnv(env map[string]string) {
+	p.envLock.Lock()
+	defer p.envLock.Unlock()
+
+	p.env = make(map[string]string, len(env))
+	for k, v := range env {
+		p.env[k] = v
+	}
+}
+
+// GetModuleMap returns the module map that includes all modules for the
+// given module names (see GetModuleMap) restricted by the policy.
+func (p *Policy) GetModuleMap(names ...string) *nanojs.ModuleMap {
+	return GetModuleMap(names...).Restrict(p)
+}
+
+// Module returns the attributes of the builtin module imported by the name
+// with the functions restricted by the policy. It implements
+// nanojs.ModulePolicy.
+func (p *Policy) Module(
+	name string,
+	attrs map[string]nanojs.Object,
+) map[string]nanojs.Object {
+	restricted := make(map[string]nanojs.Object, len(attrs))
+	for key, attr := range attrs {
+		fn := name + "." + key
+		switch {
+		case !attr.CanCall():
+			restricted[key] = attr
+		case !p.allowed(fn):
+			restricted[key] = deniedFunc(key, fn)
+		default:
+			restricted[key] = p.osFunc(fn, attr)
+		}
+	}
+	return restricted
+}
+
+// allowed returns true if the policy allows the function.
+func (p *Policy) allowed(fn string) bool {
+	if matchAny(p.deny, fn) {
+		return false
+	}
+	return matchAny(p.allow, fn) || !matchAny(policyDenied, fn)
+}
+
+// checkPath returns an error if the policy denies the access to the file.
+func (p *Policy) checkPath(fn, name string, write bool) error {
+	return p.checkResolvedPath(fn, name, write, resolvePath)
+}
+
+// checkLinkPath is like checkPath for the functions acting on the file itself
+// rather than on the file it links to if it's a symbolic link.
+func (p *Policy) checkLinkPath(fn, name string, write bool) error {
+	return p.checkResolvedPath(fn, name, write, resolveLinkPath)
+}
+
+func (p *Policy) checkResolvedPath(
+	fn, name string,
+	write bool,
+	resolve func(string) (string, error),
+) error {
+	resolved, err := resolve(name)
+	if err == nil {
+		for _, root := range p.roots {
+			if (root.write || !write) && withinDir(root.dir, resolved) {
+				return nil
+			}
+		}
+	}
+	access := "readable"
+	if write {
+		access = "writable"
+	}
+	return &permissionError{
+		fn:     fn,
+		reason: fmt.Sprintf("%q is outside the %s directories", name, access),
+	}
+}
+
+// checkCommand returns an error if the policy denies running the command
+// with the arguments.
+func (p *Policy) checkCommand(fn, name string, args []string) error {
+	patterns, ok := p.commands[name]
+	if !ok {
+		return &permissionError{
+			fn:     fn,
+			reason: fmt.Sprintf("command %q is not allowed", name),
+		}
+	}
+	for _, arg := range args {
+		if !matchArg(patterns, arg) {
+			return &permissionError{
+				fn: fn,
+				reason: fmt.Sprintf("argument %q of command %q is not allowed",
+					arg, name),
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Policy) getenv(key string) string {
+	v, _ := p.lookupEnv(key)
+	return v
+}
+
+func (p *Policy) lookupEnv(key string) (string, bool) {
+	p.envLock.RLock()
+	defer p.envLock.RUnlock()
+
+	v, ok := p.env[key]
+	return v, ok
+}
+
+func (p *Policy) setenv(key, value string) error {
+	if key == "" || strings.ContainsAny(key, "=\x00") ||
+		strings.ContainsRune(value, 0) {
+		return fmt.Errorf("setenv: invalid argument")
+	}
+	if err := checkEnv("os.setenv", key); err != nil {
+		return err
+	}
+	p.envLock.Lock()
+	defer p.envLock.Unlock()
+
+	p.env[key] = value
+	return nil
+}
+
+func (p *Policy) unsetenv(key string) error {
+	p.envLock.Lock()
+	defer p.envLock.Unlock()
+
+	delete(p.env, key)
+	return nil
+}
+
+func (p *Policy) clearenv() {
+	p.envLock.Lock()
+	defer p.envLock.Unlock()
+
+	p.env = make(map[string]string)
+}
+
+// environ returns the virtual environment in the form of "key=value".
+func (p *Policy) environ() []string {
+	p.envLock.RLock()
+	defer p.envLock.RUnlock()
+
+	env := make([]string, 0, len(p.env))
+	for k, v := range p.env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// commandEnv returns the environment of a command, which is the virtual
+// environment with the variables in the form of "key=value" added. It's
+// never nil, so the command doesn't inherit the environment of the host.
+func (p *Policy) commandEnv(fn string, vars []string) ([]string, error) {
+	p.envLock.RLock()
+	values := make(map[string]string, len(p.env)+len(vars))
+	for k, v := range p.env {
+		values[k] = v
+	}
+	p.envLock.RUnlock()
+
+	for _, kv := range vars {
+		i := strings.IndexByte(kv, '=')
+		if i <= 0 || strings.ContainsRune(kv, 0) {
+			return nil, fmt.Errorf("%s: invalid environment variable %q",
+				fn, kv)
+		}
+		if err := checkEnv(fn, kv[:i]); err != nil {
+			return nil, err
+		}
+		values[kv[:i]] = kv[i+1:]
+	}
+	env := make([]string, 0, len(values))
+	for k, v := range values {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// checkEnv returns an error if the scripts can't set the environment
+// variable.
+func checkEnv(fn, key string) error {
+	if matchAny(policyDeniedEnv, key) {
+		return &permissionError{
+			fn:     fn,
+			reason: fmt.Sprintf("environment variable %q is not allowed", key),
+		}
+	}
+	return nil
+}
+
+// permissionError is the error of a call denied by the policy.
+type permissionError struct {
+	fn     string
+	reason string
+}
+
+func (e *permissionError) Error() string {
+	return fmt.Sprintf("permission denied: %s: %s", e.fn, e.reason)
+}
+
+func (e *permissionError) Is(target error) bool {
+	return target == os.ErrPermission
+}
+
+// deniedFunc returns the function that returns the permission error.
+func deniedFunc(name, fn string) nanojs.Object {
+	err := wrapError(&permissionError{
+		fn:     fn,
+		reason: "the function is not allowed",
+	})
+	return &nanojs.UserFunction{
+		Name: name,
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			return err, nil
+		},
+	}
+}
+
+func checkPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchArg(patterns []*regexp.Regexp, arg string) bool {
+	for _, re := range patterns {
+		if re.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSymlinks is the maximum number of the dangling symbolic links resolved
+// by resolvePath.
+const maxSymlinks = 255
+
+// resolvePath returns the absolute path of the file with the symbolic links
+// resolved. The file doesn't have to exist: the symbolic links of its
+// existing parent directories are resolved, and a dangling symbolic link is
+// resolved to the file it would create.
+func resolvePath(name string) (string, error) {
+	return resolvePathLinks(name, 0)
+}
+
+// resolveLinkPath is like resolvePath, but doesn't follow the symbolic link
+// of the last element of the path.
+func resolveLinkPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	dir, err := resolvePath(filepath.Dir(abs))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
+
+func resolvePathLinks(name string, links int) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	fi, err := os.Lstat(abs)
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if links >= maxSymlinks {
+			return "", fmt.Errorf("%s: too many symbolic links", name)
+		}
+		target, err := os.Readlink(abs)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(abs), target)
+		}
+		return resolvePathLinks(target, links+1)
+	}
+	dir, base := filepath.Split(abs)
+	if dir = filepath.Clean(dir); dir == abs {
+		return abs, nil
+	}
+	resolved, err = resolvePathLinks(dir, links)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, base), nil
+}
+
+// withinDir returns true if the path is the directory or in it.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
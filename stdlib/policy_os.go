@@ -0,0 +1,346 @@
+package stdlib
+
+import (
+	"context"
+	"os"
+	"os/exec"
+
+	"github.com/zeaphoo/nanojs/v2"
+)
+
+// osFuncNames are the names of the functions of the os module, by the
+// function objects, which identify the functions restricted by the policy in
+// the modules of any names.
+var osFuncNames = func() map[nanojs.Object]string {
+	names := make(map[nanojs.Object]string)
+	for name, attr := range osModule {
+		if attr.CanCall() {
+			names[attr] = name
+		}
+	}
+	return names
+}()
+
+// osWritePaths are the indexes of the arguments of the files written by the
+// functions of the os module.
+var osWritePaths = map[string][]int{
+	"chmod":      {0},
+	"chown":      {0},
+	"lchown":     {0},
+	"link":       {0, 1},
+	"mkdir":      {0},
+	"mkdir_all":  {0},
+	"remove":     {0},
+	"remove_all": {0},
+	"rename":     {0, 1},
+	"symlink":    {1},
+	"truncate":   {0},
+}
+
+// osLinkPaths are the names of the functions of the os module acting on the
+// files of their path arguments themselves rather than on the files they link
+// to if they're symbolic links.
+var osLinkPaths = map[string]bool{
+	"lchown":     true,
+	"link":       true,
+	"readlink":   true,
+	"remove":     true,
+	"remove_all": true,
+	"rename":     true,
+	"symlink":    true,
+}
+
+// osReadPaths are the indexes of the arguments of the files read by the
+// functions of the os module.
+var osReadPaths = map[string][]int{
+	"chdir":     {0},
+	"readlink":  {0},
+	"read_file": {0},
+	"stat":      {0},
+}
+
+// osFunc returns the function of the os module restricted by the policy, or
+// the attribute if it's not a function of the os module.
+func (p *Policy) osFunc(fn string, attr nanojs.Object) nanojs.Object {
+	name, ok := osFuncNames[attr]
+	if !ok {
+		return attr
+	}
+	uf := attr.(*nanojs.UserFunction)
+	if idxs, ok := osWritePaths[name]; ok {
+		return p.pathFunc(fn, uf, idxs, true, osLinkPaths[name])
+	}
+	if idxs, ok := osReadPaths[name]; ok {
+		return p.pathFunc(fn, uf, idxs, false, osLinkPaths[name])
+	}
+	switch name {
+	case "create":
+		return p.fileFunc(fn, uf, 1, func(args []nanojs.Object) bool {
+			return true
+		})
+	case "open":
+		return p.fileFunc(fn, uf, 1, func(args []nanojs.Object) bool {
+			return false
+		})
+	case "open_file":
+		return p.fileFunc(fn, uf, 3, func(args []nanojs.Object) bool {
+			flag, ok := nanojs.ToInt(args[1])
+			return !ok || flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|
+				os.O_CREATE|os.O_TRUNC) != 0
+		})
+	case "exec":
+		return nanojs.NewContextFunction(uf.Name, func(
+			ctx context.Context,
+			args ...nanojs.Object,
+		) (nanojs.Object, error) {
+			return p.osExec(ctx, fn, args)
+		})
+	case "exec_look_path":
+		return p.checkedFunc(uf, func(args []nanojs.Object) error {
+			if len(args) != 1 {
+				return nil
+			}
+			file, ok := nanojs.ToString(args[0])
+			if !ok {
+				return nil
+			}
+			return p.checkCommand(fn, file, nil)
+		})
+	case "start_process":
+		return &nanojs.UserFunction{
+			Name: uf.Name,
+			Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+				return p.osStartProcess(fn, args)
+			},
+		}
+	case "getenv":
+		return &nanojs.UserFunction{Name: uf.Name, Value: FuncASRS(p.getenv)}
+	case "lookup_env":
+		return &nanojs.UserFunction{
+			Name:  uf.Name,
+			Value: makeOSLookupEnv(p.lookupEnv),
+		}
+	case "expand_env":
+		return &nanojs.UserFunction{
+			Name:  uf.Name,
+			Value: makeOSExpandEnv(p.getenv),
+		}
+	case "setenv":
+		return &nanojs.UserFunction{Name: uf.Name, Value: FuncASSRE(p.setenv)}
+	case "unsetenv":
+		return &nanojs.UserFunction{Name: uf.Name, Value: FuncASRE(p.unsetenv)}
+	case "clearenv":
+		return &nanojs.UserFunction{Name: uf.Name, Value: FuncAR(p.clearenv)}
+	case "environ":
+		return &nanojs.UserFunction{Name: uf.Name, Value: FuncARSs(p.environ)}
+	}
+	return attr
+}
+
+// checkedFunc returns the function that calls the function if the check of
+// the arguments returns no error, or returns the error to the script.
+func (p *Policy) checkedFunc(
+	uf *nanojs.UserFunction,
+	check func(args []nanojs.Object) error,
+) nanojs.Object {
+	return nanojs.NewContextFunction(uf.Name, func(
+		ctx context.Context,
+		args ...nanojs.Object,
+	) (nanojs.Object, error) {
+		if err := check(args); err != nil {
+			return wrapError(err), nil
+		}
+		return uf.CallContext(ctx, args...)
+	})
+}
+
+// pathFunc returns the function that checks the access to the files of the
+// arguments at the indexes, or to the symbolic links themselves if link is
+// true. The arguments that are not strings are left to the function to
+// report.
+func (p *Policy) pathFunc(
+	fn string,
+	uf *nanojs.UserFunction,
+	idxs []int,
+	write, link bool,
+) nanojs.Object {
+	check := p.checkPath
+	if link {
+		check = p.checkLinkPath
+	}
+	return p.checkedFunc(uf, func(args []nanojs.Object) error {
+		for _, idx := range idxs {
+			if idx >= len(args) {
+				continue
+			}
+			if name, ok := nanojs.ToString(args[idx]); ok {
+				if err := check(fn, name, write); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// fileFunc returns the function opening the file of the first of the nargs
+// arguments, which is opened for writing if write returns true, and restricts
+// the file returned. The invalid arguments are left to the function to
+// report.
+func (p *Policy) fileFunc(
+	fn string,
+	uf *nanojs.UserFunction,
+	nargs int,
+	write func(args []nanojs.Object) bool,
+) nanojs.Object {
+	return nanojs.NewContextFunction(uf.Name, func(
+		ctx context.Context,
+		args ...nanojs.Object,
+	) (nanojs.Object, error) {
+		if len(args) != nargs {
+			return uf.CallContext(ctx, args...)
+		}
+		name, ok := nanojs.ToString(args[0])
+		if !ok {
+			return uf.CallContext(ctx, args...)
+		}
+		if err := p.checkPath(fn, name, write(args)); err != nil {
+			return wrapError(err), nil
+		}
+		ret, err := uf.CallContext(ctx, args...)
+		if err != nil {
+			return nil, err
+		}
+		return p.restrictFile(ret, name), nil
+	})
+}
+
+// restrictFile returns the file object of the file opened by the name with
+// the methods changing the file or the process restricted by the policy.
+func (p *Policy) restrictFile(ret nanojs.Object, name string) nanojs.Object {
+	file, ok := ret.(*nanojs.ImmutableMap)
+	if !ok {
+		return ret
+	}
+	value := make(map[string]nanojs.Object, len(file.Value))
+	for key, method := range file.Value {
+		value[key] = method
+	}
+	for _, key := range []string{"chmod", "chown"} {
+		method := value[key].(*nanojs.UserFunction)
+		fn := "file." + key
+		value[key] = p.checkedFunc(method, func(args []nanojs.Object) error {
+			return p.checkPath(fn, name, true)
+		})
+	}
+	if p.allowed("os.chdir") {
+		value["chdir"] = p.checkedFunc(value["chdir"].(*nanojs.UserFunction),
+			func(args []nanojs.Object) error {
+				return p.checkPath("file.chdir", name, false)
+			})
+	} else {
+		value["chdir"] = deniedFunc("chdir", "file.chdir")
+	}
+	return &nanojs.ImmutableMap{Value: value}
+}
+
+// osExec is exec with the command checked by the policy, and the virtual
+// environment.
+func (p *Policy) osExec(
+	ctx context.Context,
+	fn string,
+	args []nanojs.Object,
+) (nanojs.Object, error) {
+	name, execArgs, err := osExecArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkCommand(fn, name, execArgs); err != nil {
+		return wrapError(err), nil
+	}
+	cmd := exec.CommandContext(ctx, name, execArgs...)
+	cmd.Env = p.environ()
+	return p.restrictCommand(cmd, execArgs), nil
+}
+
+// restrictCommand returns the command object with the methods changing the
+// command restricted by the policy.
+func (p *Policy) restrictCommand(
+	cmd *exec.Cmd,
+	execArgs []string,
+) nanojs.Object {
+	obj := makeOSExecCommand(cmd)
+	value := make(map[string]nanojs.Object, len(obj.Value))
+	for key, method := range obj.Value {
+		value[key] = method
+	}
+	value["set_path"] = p.checkedFunc(
+		value["set_path"].(*nanojs.UserFunction),
+		func(args []nanojs.Object) error {
+			if len(args) != 1 {
+				return nil
+			}
+			path, ok := nanojs.ToString(args[0])
+			if !ok {
+				return nil
+			}
+			return p.checkCommand("command.set_path", path, execArgs)
+		})
+	value["set_dir"] = p.pathFunc("command.set_dir",
+		value["set_dir"].(*nanojs.UserFunction), []int{0}, false, false)
+	value["set_env"] = &nanojs.UserFunction{
+		Name: "set_env",
+		Value: func(args ...nanojs.Object) (nanojs.Object, error) {
+			if len(args) != 1 {
+				return nil, nanojs.ErrWrongNumArguments
+			}
+			vars, err := stringArrayArg(args[0], "first")
+			if err != nil {
+				return nil, err
+			}
+			env, err := p.commandEnv("command.set_env", vars)
+			if err != nil {
+				return wrapError(err), nil
+			}
+			cmd.Env = env
+			return nanojs.UndefinedValue, nil
+		},
+	}
+	return &nanojs.ImmutableMap{Value: value}
+}
+
+// osStartProcess is start_process with the command and the directory checked
+// by the policy, and the virtual environment.
+func (p *Policy) osStartProcess(
+	fn string,
+	args []nanojs.Object,
+) (nanojs.Object, error) {
+	name, argv, dir, vars, err := osStartProcessArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var execArgs []string
+	if len(argv) > 0 {
+		execArgs = argv[1:]
+	}
+	if err := p.checkCommand(fn, name, execArgs); err != nil {
+		return wrapError(err), nil
+	}
+	if dir != "" {
+		if err := p.checkPath(fn, dir, false); err != nil {
+			return wrapError(err), nil
+		}
+	}
+	env, err := p.commandEnv(fn, vars)
+	if err != nil {
+		return wrapError(err), nil
+	}
+	proc, err := os.StartProcess(name, argv, &os.ProcAttr{
+		Dir: dir,
+		Env: env,
+	})
+	if err != nil {
+		return wrapError(err), nil
+	}
+	return makeOSProcess(proc), nil
+}
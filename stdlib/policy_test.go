@@ -0,0 +1,289 @@
+package stdlib_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+	"github.com/zeaphoo/nanojs/v2/stdlib"
+)
+
+func runPolicy(
+	t *testing.T,
+	p *stdlib.Policy,
+	src string,
+	vars map[string]interface{},
+) *nanojs.Compiled {
+	s := nanojs.NewScript([]byte(src))
+	s.SetImports(stdlib.GetModuleMap("os", "text"))
+	s.SetModulePolicy(p)
+	for name, value := range vars {
+		require.NoError(t, s.Add(name, value))
+	}
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.NoError(t, c.Run())
+	return c
+}
+
+func policyError(c *nanojs.Compiled, name string) string {
+	if e, ok := c.Get(name).Object().(*nanojs.Error); ok {
+		s, _ := nanojs.ToString(e.Value)
+		return s
+	}
+	return ""
+}
+
+func TestPolicy_Files(t *testing.T) {
+	dir := t.TempDir()
+	ro := filepath.Join(dir, "ro")
+	rw := filepath.Join(dir, "rw")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{ro, rw, outside} {
+		require.NoError(t, os.Mkdir(d, 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(ro, "a"), []byte("A"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "b"), nil, 0644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(rw, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "new"),
+		filepath.Join(rw, "dangling")))
+
+	p := stdlib.NewPolicy()
+	require.NoError(t, p.AllowRead(ro))
+	require.NoError(t, p.AllowWrite(rw))
+
+	c := runPolicy(t, p, `
+os = import("os")
+a = string(os.read_file(ro + "/a"))
+create_ro = os.create(ro + "/x")
+f = os.create(rw + "/x")
+write = f.write_string("X")
+f.close()
+x = string(os.read_file(rw + "/x"))
+chmod_ro = os.chmod(ro + "/a", 0600)
+f = os.open(ro + "/a")
+file_chmod = f.chmod(0600)
+file_chdir = f.chdir()
+f.close()
+read_outside = os.read_file(outside + "/b")
+read_dotdot = os.read_file(rw + "/../outside/b")
+read_escape = os.read_file(rw + "/escape/b")
+create_dangling = os.create(rw + "/dangling")
+open_file_ro = os.open_file(ro + "/a", os.o_rdwr, 0)
+f = os.open_file(ro + "/a", os.o_rdonly, 0)
+f.close()
+rename_out = os.rename(rw + "/x", outside + "/x")
+remove = os.remove(rw + "/x")
+`, map[string]interface{}{"ro": ro, "rw": rw, "outside": outside})
+
+	require.Equal(t, "A", c.Get("a").String())
+	require.Equal(t, "X", c.Get("x").String())
+	require.Equal(t, true, c.Get("remove").Value())
+	require.Equal(t, `permission denied: os.create: "`+ro+
+		`/x" is outside the writable directories`,
+		policyError(c, "create_ro"))
+	require.Equal(t, `permission denied: os.read_file: "`+outside+
+		`/b" is outside the readable directories`,
+		policyError(c, "read_outside"))
+	for _, name := range []string{
+		"chmod_ro", "file_chmod", "file_chdir", "read_dotdot",
+		"read_escape", "create_dangling", "open_file_ro", "rename_out",
+	} {
+		require.True(t, policyError(c, name) != "", name)
+	}
+	_, err := os.Stat(filepath.Join(outside, "new"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPolicy_Links(t *testing.T) {
+	dir := t.TempDir()
+	rw := filepath.Join(dir, "rw")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{rw, outside} {
+		require.NoError(t, os.Mkdir(d, 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(rw, "a"), nil, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "b"), nil, 0644))
+	require.NoError(t, os.Symlink(filepath.Join(rw, "a"),
+		filepath.Join(outside, "in")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "b"),
+		filepath.Join(rw, "out")))
+
+	p := stdlib.NewPolicy()
+	require.NoError(t, p.AllowWrite(rw))
+
+	c := runPolicy(t, p, `
+os = import("os")
+remove_in = os.remove(outside + "/in")
+remove_all_in = os.remove_all(outside + "/in")
+rename_in = os.rename(outside + "/in", rw + "/in")
+readlink_in = os.readlink(outside + "/in")
+readlink_out = os.readlink(rw + "/out")
+remove_out = os.remove(rw + "/out")
+`, map[string]interface{}{"rw": rw, "outside": outside})
+
+	for _, name := range []string{
+		"remove_in", "remove_all_in", "rename_in", "readlink_in",
+	} {
+		require.True(t, policyError(c, name) != "", name)
+	}
+	require.Equal(t, filepath.Join(outside, "b"),
+		c.Get("readlink_out").String())
+	require.Equal(t, true, c.Get("remove_out").Value())
+	_, err := os.Lstat(filepath.Join(outside, "in"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(outside, "b"))
+	require.NoError(t, err)
+	_, err = os.Lstat(filepath.Join(rw, "out"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPolicy_Funcs(t *testing.T) {
+	p := stdlib.NewPolicy()
+	require.NoError(t, p.Deny("text.*", "os.getpid"))
+	require.NoError(t, p.Allow("os.*"))
+	require.Error(t, p.Deny("os.["))
+
+	c := runPolicy(t, p, `
+os = import("os")
+text = import("text")
+upper = text.to_upper("a")
+pid = os.getpid()
+ppid = os.getppid()
+`, nil)
+	require.Equal(t,
+		"permission denied: text.to_upper: the function is not allowed",
+		policyError(c, "upper"))
+	require.True(t, policyError(c, "pid") != "")
+	require.True(t, c.Get("ppid").Int() > 0)
+
+	// os.exit is denied unless it's allowed
+	c = runPolicy(t, stdlib.NewPolicy(), `
+os = import("os")
+exit = os.exit(1)
+`, nil)
+	require.Equal(t,
+		"permission denied: os.exit: the function is not allowed",
+		policyError(c, "exit"))
+
+	// the functions of the os module are restricted by any name
+	modules := stdlib.NewPolicy().GetModuleMap("os")
+	modules.AddBuiltinModule("sys",
+		modules.GetBuiltinModule("os").Attrs)
+	s := nanojs.NewScript([]byte(`
+sys = import("sys")
+out = sys.read_file("/etc/hostname")
+`))
+	s.SetImports(modules)
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.NoError(t, c.Run())
+	require.True(t, policyError(c, "out") != "")
+}
+
+func TestPolicy_Commands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no echo and env commands")
+	}
+	p := stdlib.NewPolicy()
+	require.NoError(t, p.AllowCommand("echo", "foo|bar", "-n"))
+	require.NoError(t, p.AllowCommand("env"))
+	require.Error(t, p.AllowCommand("echo", "("))
+	p.SetEnv(map[string]string{"GREETING": "hi"})
+
+	c := runPolicy(t, p, `
+os = import("os")
+out = string(os.exec("echo", "-n", "foo", "bar").output())
+arg = os.exec("echo", "baz")
+cmd = os.exec("ls")
+path = os.exec("echo", "foo").set_path("/bin/sh")
+env = string(os.exec("env").output())
+look = os.exec_look_path("sh")
+`, nil)
+	require.Equal(t, "foo bar", c.Get("out").String())
+	require.Equal(t,
+		`permission denied: os.exec: argument "baz" of command "echo" `+
+			`is not allowed`,
+		policyError(c, "arg"))
+	require.Equal(t,
+		`permission denied: os.exec: command "ls" is not allowed`,
+		policyError(c, "cmd"))
+	require.True(t, policyError(c, "path") != "")
+	require.True(t, policyError(c, "look") != "")
+	require.Equal(t, "GREETING=hi\n", c.Get("env").String())
+}
+
+func TestPolicy_CommandEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no env command and /bin/sh")
+	}
+	require.NoError(t, os.Setenv("HOST_SECRET", "1"))
+	defer os.Unsetenv("HOST_SECRET")
+	p := stdlib.NewPolicy()
+	require.NoError(t, p.AllowCommand("env"))
+	require.NoError(t, p.AllowCommand("/bin/sh", ".*"))
+	p.SetEnv(map[string]string{"A": "1"})
+	out := filepath.Join(t.TempDir(), "env")
+
+	c := runPolicy(t, p, `
+os = import("os")
+cmd = os.exec("env")
+cmd.set_env([])
+empty = string(cmd.output())
+cmd = os.exec("env")
+cmd.set_env(["B=2", "A=3"])
+added = string(cmd.output())
+preload = os.exec("env").set_env(["LD_PRELOAD=/tmp/x.so"])
+setenv = os.setenv("DYLD_INSERT_LIBRARIES", "/tmp/x.dylib")
+proc = os.start_process("/bin/sh", ["sh", "-c", "env > " + out], "", [])
+proc.wait()
+start_preload = os.start_process("/bin/sh", ["sh", "-c", "true"], "",
+	["LD_PRELOAD=/tmp/x.so"])
+`, map[string]interface{}{"out": out})
+	require.Equal(t, "A=1\n", c.Get("empty").String())
+	require.Equal(t, "A=3\nB=2\n", c.Get("added").String())
+	require.Equal(t,
+		`permission denied: command.set_env: environment variable `+
+			`"LD_PRELOAD" is not allowed`,
+		policyError(c, "preload"))
+	require.Equal(t,
+		`permission denied: os.setenv: environment variable `+
+			`"DYLD_INSERT_LIBRARIES" is not allowed`,
+		policyError(c, "setenv"))
+	require.Equal(t,
+		`permission denied: os.start_process: environment variable `+
+			`"LD_PRELOAD" is not allowed`,
+		policyError(c, "start_preload"))
+	env, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.False(t, strings.Contains(string(env), "HOST_SECRET"),
+		string(env))
+	require.True(t, strings.Contains(string(env), "A=1\n"), string(env))
+}
+
+func TestPolicy_Env(t *testing.T) {
+	p := stdlib.NewPolicy()
+	p.SetEnv(map[string]string{"A": "1"})
+
+	c := runPolicy(t, p, `
+os = import("os")
+a = os.getenv("A")
+path = os.lookup_env("PATH")
+os.setenv("B", "2")
+expanded = os.expand_env("$A-$B")
+os.unsetenv("A")
+environ = os.environ()
+`, nil)
+	require.Equal(t, "1", c.Get("a").String())
+	require.Equal(t, false, c.Get("path").Value())
+	require.Equal(t, "1-2", c.Get("expanded").String())
+	var environ []string
+	require.NoError(t, c.GetInto("environ", &environ))
+	require.Equal(t, []string{"B=2"}, environ)
+	_, ok := os.LookupEnv("B")
+	require.False(t, ok)
+}
@@ -94,6 +94,31 @@ func TestGetModules(t *testing.T) {
 	require.NotNil(t, mods.Get("text"))
 }
 
+func TestModuleFunctionValues(t *testing.T) {
+	// the functions can be called by Value without the context of a run
+	var check func(name string, o nanojs.Object)
+	check = func(name string, o nanojs.Object) {
+		switch o := o.(type) {
+		case *nanojs.UserFunction:
+			require.NotNil(t, o.Value, name)
+		case *nanojs.ImmutableMap:
+			for key, elem := range o.Value {
+				check(name+"."+key, elem)
+			}
+		}
+	}
+	p := stdlib.NewPolicy()
+	require.NoError(t, p.Allow("*.*"))
+	for name, mod := range stdlib.BuiltinModules {
+		for key, attr := range mod {
+			check(name+"."+key, attr)
+		}
+		for key, attr := range p.Module(name, mod) {
+			check(name+"."+key, attr)
+		}
+	}
+}
+
 type callres struct {
 	t *testing.T
 	o interface{}
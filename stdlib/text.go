@@ -1,6 +1,7 @@
 package stdlib
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -71,10 +72,10 @@ var textModule = map[string]nanojs.Object{
 		Name:  "index_any",
 		Value: FuncASSRI(strings.IndexAny),
 	}, // index_any(s, chars) => int
-	"join": &nanojs.UserFunction{
-		Name:  "join",
-		Value: textJoin,
-	}, // join(arr, sep) => string
+	"join": nanojs.NewContextFunction(
+		"join",
+		textJoin,
+	), // join(arr, sep) => string
 	"last_index": &nanojs.UserFunction{
 		Name:  "last_index",
 		Value: FuncASSRI(strings.LastIndex),
@@ -83,10 +84,10 @@ var textModule = map[string]nanojs.Object{
 		Name:  "last_index_any",
 		Value: FuncASSRI(strings.LastIndexAny),
 	}, // last_index_any(s, chars) => int
-	"repeat": &nanojs.UserFunction{
-		Name:  "repeat",
-		Value: textRepeat,
-	}, // repeat(s, count) => string
+	"repeat": nanojs.NewContextFunction(
+		"repeat",
+		textRepeat,
+	), // repeat(s, count) => string
 	"replace": &nanojs.UserFunction{
 		Name:  "replace",
 		Value: textReplace,
@@ -127,14 +128,14 @@ var textModule = map[string]nanojs.Object{
 		Name:  "to_upper",
 		Value: FuncASRS(strings.ToUpper),
 	}, // to_upper(s) => string
-	"pad_left": &nanojs.UserFunction{
-		Name:  "pad_left",
-		Value: textPadLeft,
-	}, // pad_left(s, pad_len, pad_with) => string
-	"pad_right": &nanojs.UserFunction{
-		Name:  "pad_right",
-		Value: textPadRight,
-	}, // pad_right(s, pad_len, pad_with) => string
+	"pad_left": nanojs.NewContextFunction(
+		"pad_left",
+		textPadLeft,
+	), // pad_left(s, pad_len, pad_with) => string
+	"pad_right": nanojs.NewContextFunction(
+		"pad_right",
+		textPadRight,
+	), // pad_right(s, pad_len, pad_with) => string
 	"trim": &nanojs.UserFunction{
 		Name:  "trim",
 		Value: FuncASSRS(strings.Trim),
@@ -585,7 +586,10 @@ func textSubstring(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	return
 }
 
-func textPadLeft(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func textPadLeft(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	argslen := len(args)
 	if argslen != 2 && argslen != 3 {
 		err = nanojs.ErrWrongNumArguments
@@ -615,6 +619,9 @@ func textPadLeft(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	if i2 > nanojs.MaxStringLen {
 		return nil, nanojs.ErrStringLimit
 	}
+	if err = nanojs.CheckMemory(ctx, int64(i2)); err != nil {
+		return nil, err
+	}
 
 	sLen := len(s1)
 	if sLen >= i2 {
@@ -648,7 +655,10 @@ func textPadLeft(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	return
 }
 
-func textPadRight(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func textPadRight(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	argslen := len(args)
 	if argslen != 2 && argslen != 3 {
 		err = nanojs.ErrWrongNumArguments
@@ -678,6 +688,9 @@ func textPadRight(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	if i2 > nanojs.MaxStringLen {
 		return nil, nanojs.ErrStringLimit
 	}
+	if err = nanojs.CheckMemory(ctx, int64(i2)); err != nil {
+		return nil, err
+	}
 
 	sLen := len(s1)
 	if sLen >= i2 {
@@ -711,7 +724,10 @@ func textPadRight(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	return
 }
 
-func textRepeat(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func textRepeat(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	if len(args) != 2 {
 		return nil, nanojs.ErrWrongNumArguments
 	}
@@ -737,11 +753,17 @@ func textRepeat(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	if len(s1)*i2 > nanojs.MaxStringLen {
 		return nil, nanojs.ErrStringLimit
 	}
+	if err := nanojs.CheckMemory(ctx, int64(len(s1)*i2)); err != nil {
+		return nil, err
+	}
 
 	return &nanojs.String{Value: strings.Repeat(s1, i2)}, nil
 }
 
-func textJoin(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func textJoin(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	if len(args) != 2 {
 		return nil, nanojs.ErrWrongNumArguments
 	}
@@ -793,9 +815,13 @@ func textJoin(args ...nanojs.Object) (ret nanojs.Object, err error) {
 	}
 
 	// make sure output length does not exceed the limit
-	if slen+len(s2)*(len(ss1)-1) > nanojs.MaxStringLen {
+	n := slen + len(s2)*(len(ss1)-1)
+	if n > nanojs.MaxStringLen {
 		return nil, nanojs.ErrStringLimit
 	}
+	if err := nanojs.CheckMemory(ctx, int64(n)); err != nil {
+		return nil, err
+	}
 
 	return &nanojs.String{Value: strings.Join(ss1, s2)}, nil
 }
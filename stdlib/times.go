@@ -1,6 +1,7 @@
 package stdlib
 
 import (
+	"context"
 	"time"
 
 	"github.com/zeaphoo/nanojs/v2"
@@ -40,10 +41,10 @@ var timesModule = map[string]nanojs.Object{
 	"october":             &nanojs.Int{Value: int64(time.October)},
 	"november":            &nanojs.Int{Value: int64(time.November)},
 	"december":            &nanojs.Int{Value: int64(time.December)},
-	"sleep": &nanojs.UserFunction{
-		Name:  "sleep",
-		Value: timesSleep,
-	}, // sleep(int)
+	"sleep": nanojs.NewContextFunction(
+		"sleep",
+		timesSleep,
+	), // sleep(int)
 	"parse_duration": &nanojs.UserFunction{
 		Name:  "parse_duration",
 		Value: timesParseDuration,
@@ -182,7 +183,10 @@ var timesModule = map[string]nanojs.Object{
 	}, // to_utc(time) => time
 }
 
-func timesSleep(args ...nanojs.Object) (ret nanojs.Object, err error) {
+func timesSleep(
+	ctx context.Context,
+	args ...nanojs.Object,
+) (ret nanojs.Object, err error) {
 	if len(args) != 1 {
 		err = nanojs.ErrWrongNumArguments
 		return
@@ -198,7 +202,14 @@ func timesSleep(args ...nanojs.Object) (ret nanojs.Object, err error) {
 		return
 	}
 
-	time.Sleep(time.Duration(i1))
+	timer := time.NewTimer(time.Duration(i1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
 	ret = nanojs.UndefinedValue
 
 	return
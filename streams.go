@@ -0,0 +1,70 @@
+package nanojs
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// Streams are the standard streams of a run, which the functions called by
+// the script read with Stdin, Stdout and Stderr. The nil streams are the
+// streams of the process.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// streamsKey is the context key of the Streams of a run.
+type streamsKey struct{}
+
+// ContextWithStreams returns a copy of the context carrying the streams,
+// which are the streams of the run of the context.
+func ContextWithStreams(ctx context.Context, s *Streams) context.Context {
+	return context.WithValue(ctx, streamsKey{}, s)
+}
+
+// contextStreams returns the streams of the run of the context, or nil.
+func contextStreams(ctx context.Context) *Streams {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(streamsKey{}).(*Streams)
+	return s
+}
+
+// Stdin returns the standard input of the run of the context, or os.Stdin.
+func Stdin(ctx context.Context) io.Reader {
+	if s := contextStreams(ctx); s != nil && s.Stdin != nil {
+		return s.Stdin
+	}
+	return os.Stdin
+}
+
+// Stdout returns the standard output of the run of the context, or
+// os.Stdout.
+func Stdout(ctx context.Context) io.Writer {
+	if s := contextStreams(ctx); s != nil && s.Stdout != nil {
+		return s.Stdout
+	}
+	return os.Stdout
+}
+
+// Stderr returns the standard error of the run of the context, or
+// os.Stderr.
+func Stderr(ctx context.Context) io.Writer {
+	if s := contextStreams(ctx); s != nil && s.Stderr != nil {
+		return s.Stderr
+	}
+	return os.Stderr
+}
+
+// copyStreams returns a copy of the streams, which may be nil, to be
+// changed.
+func copyStreams(s *Streams) *Streams {
+	if s == nil {
+		return &Streams{}
+	}
+	c := *s
+	return &c
+}
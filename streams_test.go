@@ -0,0 +1,63 @@
+package nanojs_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+	"github.com/zeaphoo/nanojs/v2/stdlib"
+)
+
+const streamsSrc = `
+fmt = import("fmt")
+os = import("os")
+fmt.println("hello " + name)
+fmt.printf("%d\n", 42)
+os.stdout.write_string("out\n")
+os.stderr.write(bytes("err\n"))
+buf = bytes(5)
+n = os.stdin.read(buf)
+input = string(buf[:n])
+`
+
+func TestScript_Streams(t *testing.T) {
+	s := nanojs.NewScript([]byte(streamsSrc))
+	s.SetImports(stdlib.GetModuleMap("fmt", "os"))
+	require.NoError(t, s.Add("name", "a"))
+	var stdout, stderr bytes.Buffer
+	s.SetStdout(&stdout)
+	s.SetStderr(&stderr)
+	s.SetStdin(strings.NewReader("input"))
+	c, err := s.Compile()
+	require.NoError(t, err)
+	require.NoError(t, c.Run())
+	require.Equal(t, "hello a\n42\nout\n", stdout.String())
+	require.Equal(t, "err\n", stderr.String())
+	require.Equal(t, "input", c.Get("input").String())
+
+	// the streams of a clone
+	clone := c.Clone()
+	var cloneOut bytes.Buffer
+	clone.SetStdout(&cloneOut)
+	clone.SetStdin(strings.NewReader("x"))
+	require.NoError(t, clone.Set("name", "b"))
+	require.NoError(t, clone.Run())
+	require.Equal(t, "hello b\n42\nout\n", cloneOut.String())
+	require.Equal(t, "err\nerr\n", stderr.String())
+	require.Equal(t, "hello a\n42\nout\n", stdout.String())
+
+	// the streams of a runner are reset
+	r := c.NewRunner()
+	var runnerOut bytes.Buffer
+	r.SetStdout(&runnerOut)
+	r.SetStdin(strings.NewReader("y"))
+	require.NoError(t, r.Run())
+	require.Equal(t, "hello a\n42\nout\n", runnerOut.String())
+	r.Reset()
+	r.SetStdin(strings.NewReader("z"))
+	require.NoError(t, r.Run())
+	require.Equal(t, "hello a\n42\nout\n", runnerOut.String())
+	require.Equal(t, "hello a\n42\nout\nhello a\n42\nout\n", stdout.String())
+}
@@ -28,6 +28,7 @@ type SymbolTable struct {
 	maxDefinition  int
 	freeSymbols    []*Symbol
 	builtinSymbols []*Symbol
+	globalNames    map[int]string
 }
 
 // NewSymbolTable creates a SymbolTable.
@@ -49,6 +50,9 @@ func (t *SymbolTable) Define(name string) *Symbol {
 	}
 	t.store[name] = symbol
 	t.updateMaxDefs(symbol.Index + 1)
+	if symbol.Scope == ScopeGlobal {
+		t.setGlobalName(symbol.Index, name)
+	}
 	return symbol
 }
 
@@ -119,6 +123,24 @@ func (t *SymbolTable) FreeSymbols() []*Symbol {
 	return t.freeSymbols
 }
 
+// GlobalNames returns the names of the global symbols defined in the scope
+// and its blocks, indexed by their symbol indexes. If the same index is used
+// by the symbols of different names, the name is empty as it depends on the
+// block of the instruction.
+func (t *SymbolTable) GlobalNames() []string {
+	if t.parent != nil {
+		return t.parent.GlobalNames()
+	}
+	var names []string
+	for index, name := range t.globalNames {
+		for len(names) <= index {
+			names = append(names, "")
+		}
+		names[index] = name
+	}
+	return names
+}
+
 // BuiltinSymbols returns builtin symbols for the scope.
 func (t *SymbolTable) BuiltinSymbols() []*Symbol {
 	if t.parent != nil {
@@ -152,6 +174,20 @@ func (t *SymbolTable) updateMaxDefs(numDefs int) {
 	}
 }
 
+func (t *SymbolTable) setGlobalName(index int, name string) {
+	if t.block {
+		t.parent.setGlobalName(index, name)
+		return
+	}
+	if t.globalNames == nil {
+		t.globalNames = make(map[int]string)
+	}
+	if prev, ok := t.globalNames[index]; ok && prev != name {
+		name = ""
+	}
+	t.globalNames[index] = name
+}
+
 func (t *SymbolTable) defineFree(original *Symbol) *Symbol {
 	// TODO: should we check duplicates?
 	t.freeSymbols = append(t.freeSymbols, original)
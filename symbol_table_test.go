@@ -91,6 +91,22 @@ func TestSymbolTable(t *testing.T) {
 	resolveExpect(t, local2Block2, "b", globalSymbol("b", 1), 3)
 }
 
+func TestSymbolTable_GlobalNames(t *testing.T) {
+	global := symbolTable()
+	global.Define("a")
+	block1 := global.Fork(true)
+	block1.Define("b")
+	block1.Define("c")
+	block2 := global.Fork(true)
+	block2.Define("b")
+	global.Define("d")
+	global.Fork(false).Define("e")
+
+	// index 1 is used by b in block 1 and d at the top level
+	require.Equal(t, []string{"a", "", "c"}, global.GlobalNames())
+	require.Equal(t, []string{"a", "", "c"}, block2.GlobalNames())
+}
+
 func symbol(
 	name string,
 	scope nanojs.SymbolScope,
@@ -0,0 +1,14 @@
+// functions, closures and variadic arguments
+add = function(a, b) { return a + b }
+counter = function() {
+	n = 0
+	return function() { n += 1; return n }
+}
+c = counter()
+c()
+sum = function(...xs) {
+	s = 0
+	for (i in xs) { s = add(s, xs[i]) }
+	return s
+}
+out = string(c()) + " " + string(sum(1, 2, 3, 4))
@@ -0,0 +1,4 @@
+// builtin module import references
+text = import("text")
+math = import("math")
+out = text.to_upper("nanojs") + " " + string(math.abs(-7))
@@ -0,0 +1,6 @@
+// source maps are kept for runtime errors
+out = "unreachable"
+f = function(x) {
+	return x.missing.field
+}
+f(1)
@@ -0,0 +1,4 @@
+// constants of every literal type
+a = [1, -2, 3.5, 'x', "str", true, false, undefined]
+m = {k: "v", n: [1, 2], e: error("oops")}
+out = string(a) + " " + m.k + " " + string(m.n) + " " + string(m.e.value)
@@ -134,3 +134,9 @@ func (v *Variable) Object() Object {
 func (v *Variable) IsUndefined() bool {
 	return v.value == UndefinedValue
 }
+
+// Decode stores the variable value in the Go value pointed to by target. See
+// Decode for the conversion rules.
+func (v *Variable) Decode(target interface{}) error {
+	return Decode(v.value, target)
+}
@@ -1,8 +1,12 @@
 package nanojs
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"sync/atomic"
+	"time"
 
 	"github.com/zeaphoo/nanojs/v2/parser"
 	"github.com/zeaphoo/nanojs/v2/token"
@@ -14,24 +18,43 @@ type frame struct {
 	freeVars    []*ObjectPtr
 	ip          int
 	basePointer int
+	line        int // last line reported to the debug hook
+	lastIP      int // last ip seen by the debug hook
 }
 
+// ctxCheckInterval is the number of the instructions executed between the
+// checks of the context of the run.
+const ctxCheckInterval = 1024
+
 // VM is a virtual machine that executes the bytecode compiled by Compiler.
 type VM struct {
-	constants   []Object
-	stack       [StackSize]Object
-	sp          int
-	globals     []Object
-	fileSet     *parser.SourceFileSet
-	frames      [MaxFrames]frame
-	framesIndex int
-	curFrame    *frame
-	curInsts    []byte
-	ip          int
-	aborting    int64
-	maxAllocs   int64
-	allocs      int64
-	err         error
+	constants    []Object
+	stack        [StackSize]Object
+	sp           int
+	globals      []Object
+	fileSet      *parser.SourceFileSet
+	frames       [MaxFrames]frame
+	framesIndex  int
+	curFrame     *frame
+	curInsts     []byte
+	ip           int
+	aborting     int64
+	maxAllocs    int64
+	allocs       int64
+	maxGas       int64
+	gas          int64
+	costs        *CostTable
+	maxMemory    int64
+	memory       int64
+	debugHook    DebugHook
+	hooks        Hooks
+	instructions int64
+	profiler     *Profiler
+	profileTicks int64 // the periods to sample, set by the profiler
+	streams      *Streams
+	ctx          context.Context
+	err          error
+	suspended    bool
 }
 
 // NewVM creates a VM.
@@ -51,6 +74,9 @@ func NewVM(
 		framesIndex: 1,
 		ip:          -1,
 		maxAllocs:   maxAllocs,
+		maxGas:      -1,
+		costs:       defaultCostTable,
+		maxMemory:   -1,
 	}
 	v.frames[0].fn = bytecode.MainFunction
 	v.frames[0].ip = -1
@@ -64,39 +90,239 @@ func (v *VM) Abort() {
 	atomic.StoreInt64(&v.aborting, 1)
 }
 
+// SetMaxGas sets the maximum amount of gas the VM can consume in a single run.
+// The VM returns ErrInstructionLimit error if it exceeds this limit. Set this
+// to a negative number to disable the limit.
+func (v *VM) SetMaxGas(n int64) {
+	v.maxGas = n
+}
+
+// SetCostTable sets the cost table used to charge gas for the executed
+// instructions and builtin function calls. A nil table resets the costs to
+// the defaults.
+func (v *VM) SetCostTable(t *CostTable) {
+	if t == nil {
+		t = defaultCostTable
+	}
+	v.costs = t
+}
+
+// GasUsed returns the amount of gas consumed by the last run. The gas is
+// counted only if the VM has a gas limit, a cost table, hooks, a debug hook, a
+// profiler or a context that can be done, and it's 0 otherwise.
+func (v *VM) GasUsed() int64 {
+	return v.gas
+}
+
+// SetMaxMemory sets the maximum number of bytes the VM can allocate for
+// strings, bytes, arrays and maps in a single run. The VM returns
+// ErrMemoryLimit error if it exceeds this limit. Set this to a negative number
+// to disable the limit.
+func (v *VM) SetMaxMemory(n int64) {
+	v.maxMemory = n
+}
+
+// MemoryUsed returns the number of bytes allocated by the last run.
+func (v *VM) MemoryUsed() int64 {
+	return v.memory
+}
+
+// SetDebugHook sets the hook that is called on the debug events during the
+// execution. Set this to nil to disable the hook.
+func (v *VM) SetDebugHook(hook DebugHook) {
+	v.debugHook = hook
+}
+
+// SetHooks sets the hooks that are called on the runtime events, e.g. to
+// collect the metrics of the runs (see StatsCollector). Set this to nil to
+// disable the hooks.
+func (v *VM) SetHooks(hooks Hooks) {
+	v.hooks = hooks
+}
+
+// SetProfiler sets the profiler sampling the runs. Set this to nil to disable
+// the profiling.
+func (v *VM) SetProfiler(p *Profiler) {
+	v.profiler = p
+}
+
+// Instructions returns the number of instructions executed by the last run.
+// Like the gas, the instructions are counted only if the run is metered (see
+// GasUsed).
+func (v *VM) Instructions() int64 {
+	return v.instructions
+}
+
+// SetStreams sets the standard streams of the runs, which the functions
+// called by the script read from the context (see Stdout). Set this to nil to
+// use the streams of the process.
+func (v *VM) SetStreams(s *Streams) {
+	v.streams = s
+}
+
+// runContext returns the context of the execution with the streams.
+func (v *VM) runContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if v.streams != nil {
+		ctx = ContextWithStreams(ctx, v.streams)
+	}
+	if v.maxMemory >= 0 {
+		ctx = context.WithValue(ctx, memoryKey{}, v)
+	}
+	return ctx
+}
+
 // Run starts the execution.
-func (v *VM) Run() (err error) {
+func (v *VM) Run() error {
+	return v.RunContext(context.Background())
+}
+
+// RunContext starts the execution with the context, which is passed to the
+// callable objects implementing ContextCaller. The execution stops with the
+// error of the context when the context is done.
+func (v *VM) RunContext(ctx context.Context) (err error) {
 	// reset VM states
+	v.ctx = v.runContext(ctx)
 	v.sp = 0
 	v.curFrame = &(v.frames[0])
 	v.curInsts = v.curFrame.fn.Instructions
 	v.framesIndex = 1
 	v.ip = -1
+	v.curFrame.line = 0
+	v.curFrame.lastIP = -1
 	v.allocs = v.maxAllocs + 1
+	v.gas = 0
+	v.memory = 0
+	v.instructions = 0
+	v.err = nil
+	v.suspended = false
 
+	return v.execute()
+}
+
+// Suspended returns true if the execution was suspended by ErrSuspend, or
+// restored from a snapshot, and it can be resumed.
+func (v *VM) Suspended() bool {
+	return v.suspended
+}
+
+// Resume continues the suspended execution. The result is the return value
+// of the call that suspended the execution.
+func (v *VM) Resume(result Object) error {
+	return v.ResumeContext(context.Background(), result)
+}
+
+// ResumeContext is like Resume but includes a context. See RunContext.
+func (v *VM) ResumeContext(ctx context.Context, result Object) error {
+	if !v.suspended {
+		return errors.New("vm is not suspended")
+	}
+	if result == nil {
+		result = UndefinedValue
+	}
+	v.ctx = v.runContext(ctx)
+	v.err = nil
+	v.suspended = false
+	v.stack[v.sp] = result
+	v.sp++
+	return v.execute()
+}
+
+// execute runs the instructions from the current state, and reports the
+// result to the hooks. The run is sampled by the profiler if it's set.
+func (v *VM) execute() error {
+	if v.profiler != nil {
+		v.profiler.begin(v)
+		defer v.profiler.end(v)
+	}
+	err := v.executeRun()
+	if v.hooks != nil {
+		if err != nil && err != ErrSuspend {
+			v.hooks.OnError(v, err)
+		}
+		v.hooks.OnExit(v, err)
+	}
+	return err
+}
+
+func (v *VM) executeRun() (err error) {
+	ctx := v.ctx
 	v.run()
 	atomic.StoreInt64(&v.aborting, 0)
 	err = v.err
+	if errors.Is(err, ErrSuspend) {
+		// the result passed to Resume is the return value of the call
+		v.suspended = true
+		return ErrSuspend
+	}
+	if err != nil && err == ctx.Err() {
+		// stopped by the context
+		v.framesIndex = 1
+		v.curFrame = &v.frames[0]
+		return ctx.Err()
+	}
 	if err != nil {
-		filePos := v.fileSet.Position(
-			v.curFrame.fn.SourcePos(v.ip - 1))
-		err = fmt.Errorf("Runtime Error: %w\n\tat %s",
-			err, filePos)
-		for v.framesIndex > 1 {
-			v.framesIndex--
-			v.curFrame = &v.frames[v.framesIndex-1]
-			filePos = v.fileSet.Position(
-				v.curFrame.fn.SourcePos(v.curFrame.ip - 1))
-			err = fmt.Errorf("%w\n\tat %s", err, filePos)
+		rerr := &RuntimeError{
+			Err:    err,
+			Frames: v.callFrames(v.ip - 1),
 		}
-		return err
+		rerr.Value, _ = FromInterface(err)
+		v.framesIndex = 1
+		v.curFrame = &v.frames[0]
+		return rerr
 	}
 	return nil
 }
 
 func (v *VM) run() {
+	maxGas := v.maxGas
+	if maxGas < 0 {
+		maxGas = math.MaxInt64
+	}
+	opCosts := &v.costs.Opcodes
+	maxMemory := v.maxMemory
+	if maxMemory < 0 {
+		maxMemory = math.MaxInt64
+	}
+	done := v.ctx.Done()
+	var ticks int
+	prof := v.profiler
+	// the instructions are metered only if something uses the counts, so
+	// the runs without limits, hooks and profiler pay for a single check
+	metered := v.maxGas >= 0 || v.costs != defaultCostTable ||
+		v.hooks != nil || v.debugHook != nil || prof != nil || done != nil
+
 	for atomic.LoadInt64(&v.aborting) == 0 {
 		v.ip++
+		if metered {
+			v.instructions++
+			if prof != nil && atomic.LoadInt64(&v.profileTicks) != 0 {
+				prof.sample(v)
+			}
+
+			if done != nil {
+				ticks++
+				if ticks%ctxCheckInterval == 0 {
+					select {
+					case <-done:
+						v.err = v.ctx.Err()
+						return
+					default:
+					}
+				}
+			}
+
+			v.gas += opCosts[v.curInsts[v.ip]]
+			if v.gas > maxGas {
+				v.err = ErrInstructionLimit
+				return
+			}
+			if v.debugHook != nil {
+				v.debugLine()
+			}
+		}
 
 		switch v.curInsts[v.ip] {
 		case parser.OpConstant:
@@ -125,9 +351,12 @@ func (v *VM) run() {
 				return
 			}
 
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if !v.alloc(res) {
+				return
+			}
+			v.memory += objectSize(res)
+			if v.memory > maxMemory {
+				v.err = ErrMemoryLimit
 				return
 			}
 
@@ -177,9 +406,7 @@ func (v *VM) run() {
 			switch x := operand.(type) {
 			case *Int:
 				var res Object = &Int{Value: ^x.Value}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(res) {
 					return
 				}
 				v.stack[v.sp] = res
@@ -196,18 +423,14 @@ func (v *VM) run() {
 			switch x := operand.(type) {
 			case *Int:
 				var res Object = &Int{Value: -x.Value}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(res) {
 					return
 				}
 				v.stack[v.sp] = res
 				v.sp++
 			case *Float:
 				var res Object = &Float{Value: -x.Value}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(res) {
 					return
 				}
 				v.stack[v.sp] = res
@@ -260,7 +483,7 @@ func (v *VM) run() {
 			}
 			val := v.stack[v.sp-numSelectors-1]
 			v.sp -= numSelectors + 1
-			e := indexAssign(v.globals[globalIndex], val, selectors)
+			e := v.indexAssign(v.globals[globalIndex], val, selectors)
 			if e != nil {
 				v.err = e
 				return
@@ -282,9 +505,12 @@ func (v *VM) run() {
 			v.sp -= numElements
 
 			var arr Object = &Array{Value: elements}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if !v.alloc(arr) {
+				return
+			}
+			v.memory += arraySize(elements)
+			if v.memory > maxMemory {
+				v.err = ErrMemoryLimit
 				return
 			}
 
@@ -295,16 +521,23 @@ func (v *VM) run() {
 			numElements := int(v.curInsts[v.ip]) | int(v.curInsts[v.ip-1])<<8
 			kv := make(map[string]Object)
 			for i := v.sp - numElements; i < v.sp; i += 2 {
-				key := v.stack[i]
-				value := v.stack[i+1]
-				kv[key.(*String).Value] = value
+				key, ok := v.stack[i].(*String)
+				if !ok {
+					v.err = fmt.Errorf("invalid map key type: %s",
+						v.stack[i].TypeName())
+					return
+				}
+				kv[key.Value] = v.stack[i+1]
 			}
 			v.sp -= numElements
 
 			var m Object = &Map{Value: kv}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if !v.alloc(m) {
+				return
+			}
+			v.memory += mapSize(kv)
+			if v.memory > maxMemory {
+				v.err = ErrMemoryLimit
 				return
 			}
 			v.stack[v.sp] = m
@@ -314,9 +547,7 @@ func (v *VM) run() {
 			var e Object = &Error{
 				Value: value,
 			}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if !v.alloc(e) {
 				return
 			}
 			v.stack[v.sp-1] = e
@@ -327,9 +558,7 @@ func (v *VM) run() {
 				var immutableArray Object = &ImmutableArray{
 					Value: value.Value,
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(immutableArray) {
 					return
 				}
 				v.stack[v.sp-1] = immutableArray
@@ -337,9 +566,7 @@ func (v *VM) run() {
 				var immutableMap Object = &ImmutableMap{
 					Value: value.Value,
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(immutableMap) {
 					return
 				}
 				v.stack[v.sp-1] = immutableMap
@@ -416,9 +643,7 @@ func (v *VM) run() {
 				var val Object = &Array{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(val) {
 					return
 				}
 				v.stack[v.sp] = val
@@ -453,9 +678,7 @@ func (v *VM) run() {
 				var val Object = &Array{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(val) {
 					return
 				}
 				v.stack[v.sp] = val
@@ -490,9 +713,7 @@ func (v *VM) run() {
 				var val Object = &String{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(val) {
 					return
 				}
 				v.stack[v.sp] = val
@@ -527,9 +748,7 @@ func (v *VM) run() {
 				var val Object = &Bytes{
 					Value: left.Value[lowIdx:highIdx],
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(val) {
 					return
 				}
 				v.stack[v.sp] = val
@@ -550,12 +769,20 @@ func (v *VM) run() {
 				v.sp--
 				switch arr := v.stack[v.sp].(type) {
 				case *Array:
+					if v.sp+len(arr.Value) > StackSize {
+						v.err = ErrStackOverflow
+						return
+					}
 					for _, item := range arr.Value {
 						v.stack[v.sp] = item
 						v.sp++
 					}
 					numArgs += len(arr.Value) - 1
 				case *ImmutableArray:
+					if v.sp+len(arr.Value) > StackSize {
+						v.err = ErrStackOverflow
+						return
+					}
 					for _, item := range arr.Value {
 						v.stack[v.sp] = item
 						v.sp++
@@ -609,10 +836,14 @@ func (v *VM) run() {
 						}
 						v.sp -= numArgs + 1
 						v.ip = -1 // reset IP to beginning of the frame
+						if v.hooks != nil {
+							v.hooks.OnCall(v, callee)
+						}
 						continue
 					}
 				}
-				if v.framesIndex >= MaxFrames {
+				if v.framesIndex >= MaxFrames || v.sp-numArgs+
+					callee.NumLocals+callee.maxStack > StackSize {
 					v.err = ErrStackOverflow
 					return
 				}
@@ -623,15 +854,47 @@ func (v *VM) run() {
 				v.curFrame.fn = callee
 				v.curFrame.freeVars = callee.Free
 				v.curFrame.basePointer = v.sp - numArgs
+				v.curFrame.line = 0
+				v.curFrame.lastIP = -1
 				v.curInsts = callee.Instructions
 				v.ip = -1
 				v.framesIndex++
 				v.sp = v.sp - numArgs + callee.NumLocals
+				if v.debugHook != nil {
+					v.debugHook(v, DebugCall)
+				}
+				if v.hooks != nil {
+					v.hooks.OnCall(v, callee)
+				}
 			} else {
+				if metered {
+					v.gas += v.costs.callCost(value)
+					if v.gas > maxGas {
+						v.err = ErrInstructionLimit
+						return
+					}
+				}
+
 				var args []Object
 				args = append(args, v.stack[v.sp-numArgs:v.sp]...)
-				ret, e := value.Call(args...)
+				var ret Object
+				var e error
+				var start time.Time
+				if v.hooks != nil {
+					start = time.Now()
+				}
+				if fn, ok := value.(ContextCaller); ok {
+					ret, e = fn.CallContext(v.ctx, args...)
+				} else {
+					ret, e = value.Call(args...)
+				}
+				if v.hooks != nil {
+					v.hooks.OnHostCall(v, value, time.Since(start), e)
+				}
 				v.sp -= numArgs + 1
+				// the context is checked at the next instruction as the
+				// call may have blocked
+				ticks = ctxCheckInterval - 1
 
 				// runtime error
 				if e != nil {
@@ -656,15 +919,31 @@ func (v *VM) run() {
 				if ret == nil {
 					ret = UndefinedValue
 				}
-				v.allocs--
-				if v.allocs == 0 {
-					v.err = ErrObjectAllocLimit
+				if !v.alloc(ret) {
+					return
+				}
+				if fn, ok := value.(*BuiltinFunction); ok &&
+					fn.Name == "append" {
+					// appended elements are already accounted, and the
+					// existing ones are shared with the first argument
+					v.memory += int64(numArgs-1) * elementSize
+				} else {
+					v.memory += allocatedSize(ret, args)
+				}
+				if v.memory > maxMemory {
+					v.err = ErrMemoryLimit
 					return
 				}
 				v.stack[v.sp] = ret
 				v.sp++
 			}
 		case parser.OpReturn:
+			if v.debugHook != nil {
+				v.debugHook(v, DebugReturn)
+			}
+			if v.hooks != nil {
+				v.hooks.OnReturn(v, v.curFrame.fn)
+			}
 			v.ip++
 			var retVal Object
 			if int(v.curInsts[v.ip]) == 1 {
@@ -723,7 +1002,7 @@ func (v *VM) run() {
 			if obj, ok := dst.(*ObjectPtr); ok {
 				dst = *obj.Value
 			}
-			if e := indexAssign(dst, val, selectors); e != nil {
+			if e := v.indexAssign(dst, val, selectors); e != nil {
 				v.err = e
 				return
 			}
@@ -747,7 +1026,8 @@ func (v *VM) run() {
 			numFree := int(v.curInsts[v.ip])
 			fn, ok := v.constants[constIndex].(*CompiledFunction)
 			if !ok {
-				v.err = fmt.Errorf("not function: %s", fn.TypeName())
+				v.err = fmt.Errorf("not function: %s",
+					v.constants[constIndex].TypeName())
 				return
 			}
 			free := make([]*ObjectPtr, numFree)
@@ -763,15 +1043,18 @@ func (v *VM) run() {
 			}
 			v.sp -= numFree
 			cl := &CompiledFunction{
+				Name:          fn.Name,
 				Instructions:  fn.Instructions,
 				NumLocals:     fn.NumLocals,
 				NumParameters: fn.NumParameters,
 				VarArgs:       fn.VarArgs,
+				SourceMap:     fn.SourceMap,
+				Locals:        fn.Locals,
+				FreeNames:     fn.FreeNames,
 				Free:          free,
+				maxStack:      fn.maxStack,
 			}
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if !v.alloc(cl) {
 				return
 			}
 			v.stack[v.sp] = cl
@@ -819,7 +1102,7 @@ func (v *VM) run() {
 			}
 			val := v.stack[v.sp-numSelectors-1]
 			v.sp -= numSelectors + 1
-			e := indexAssign(*v.curFrame.freeVars[freeIndex].Value,
+			e := v.indexAssign(*v.curFrame.freeVars[freeIndex].Value,
 				val, selectors)
 			if e != nil {
 				v.err = e
@@ -834,17 +1117,20 @@ func (v *VM) run() {
 				return
 			}
 			iterator = dst.Iterate()
-			v.allocs--
-			if v.allocs == 0 {
-				v.err = ErrObjectAllocLimit
+			if !v.alloc(iterator) {
 				return
 			}
 			v.stack[v.sp] = iterator
 			v.sp++
 		case parser.OpIteratorNext:
-			iterator := v.stack[v.sp-1]
+			iterator, ok := v.stack[v.sp-1].(Iterator)
+			if !ok {
+				v.err = fmt.Errorf("not iterator: %s",
+					v.stack[v.sp-1].TypeName())
+				return
+			}
 			v.sp--
-			hasMore := iterator.(Iterator).Next()
+			hasMore := iterator.Next()
 			if hasMore {
 				v.stack[v.sp] = TrueValue
 			} else {
@@ -852,15 +1138,25 @@ func (v *VM) run() {
 			}
 			v.sp++
 		case parser.OpIteratorKey:
-			iterator := v.stack[v.sp-1]
+			iterator, ok := v.stack[v.sp-1].(Iterator)
+			if !ok {
+				v.err = fmt.Errorf("not iterator: %s",
+					v.stack[v.sp-1].TypeName())
+				return
+			}
 			v.sp--
-			val := iterator.(Iterator).Key()
+			val := iterator.Key()
 			v.stack[v.sp] = val
 			v.sp++
 		case parser.OpIteratorValue:
-			iterator := v.stack[v.sp-1]
+			iterator, ok := v.stack[v.sp-1].(Iterator)
+			if !ok {
+				v.err = fmt.Errorf("not iterator: %s",
+					v.stack[v.sp-1].TypeName())
+				return
+			}
 			v.sp--
-			val := iterator.(Iterator).Value()
+			val := iterator.Value()
 			v.stack[v.sp] = val
 			v.sp++
 		case parser.OpSuspend:
@@ -872,12 +1168,69 @@ func (v *VM) run() {
 	}
 }
 
+// alloc counts the allocation of the object against the allocation limit and
+// reports it to the hooks. It returns false with the error set if the limit
+// is exceeded.
+func (v *VM) alloc(obj Object) bool {
+	v.allocs--
+	if v.allocs == 0 || v.hooks != nil {
+		return v.allocEvent(obj)
+	}
+	return true
+}
+
+// allocEvent is the slow path of alloc, which is kept out of it so alloc is
+// inlined.
+//
+//go:noinline
+func (v *VM) allocEvent(obj Object) bool {
+	if v.allocs == 0 {
+		v.err = ErrObjectAllocLimit
+		return false
+	}
+	v.hooks.OnAlloc(v, obj)
+	return true
+}
+
+// debugLine calls the debug hook if the current instruction starts a new line
+// or re-enters a line from a backward jump.
+func (v *VM) debugLine() {
+	pos := v.curFrame.fn.SourcePos(v.ip)
+	if pos == parser.NoPos {
+		return
+	}
+	line := v.fileSet.Position(pos).Line
+	newLine := line != v.curFrame.line || v.ip < v.curFrame.lastIP
+	v.curFrame.line = line
+	v.curFrame.lastIP = v.ip
+	if newLine {
+		v.debugHook(v, DebugLine)
+	}
+}
+
+// callFrames returns the call frames, innermost first. ip is the instruction
+// pointer of the current frame.
+func (v *VM) callFrames(ip int) []Frame {
+	frames := make([]Frame, 0, v.framesIndex)
+	for i := v.framesIndex - 1; i >= 0; i-- {
+		f := &v.frames[i]
+		if i < v.framesIndex-1 {
+			ip = f.ip - 1
+		}
+		frames = append(frames, Frame{
+			Name: f.fn.Name,
+			Pos:  v.fileSet.Position(f.fn.SourcePos(ip)),
+		})
+	}
+	return frames
+}
+
 // IsStackEmpty tests if the stack is empty or not.
 func (v *VM) IsStackEmpty() bool {
 	return v.sp == 0
 }
 
-func indexAssign(dst, src Object, selectors []Object) error {
+func (v *VM) indexAssign(dst, src Object, selectors []Object) error {
 	numSel := len(selectors)
 	for sidx := numSel - 1; sidx > 0; sidx-- {
 		next, err := dst.IndexGet(selectors[sidx])
@@ -894,6 +1247,10 @@ func indexAssign(dst, src Object, selectors []Object) error {
 		dst = next
 	}
 
+	var numEntries int
+	if m, ok := dst.(*Map); ok {
+		numEntries = len(m.Value)
+	}
 	if err := dst.IndexSet(selectors[0], src); err != nil {
 		if err == ErrNotIndexAssignable {
 			return fmt.Errorf("not index-assignable: %s", dst.TypeName())
@@ -903,5 +1260,12 @@ func indexAssign(dst, src Object, selectors []Object) error {
 		}
 		return err
 	}
+	if m, ok := dst.(*Map); ok && len(m.Value) > numEntries {
+		key, _ := ToString(selectors[0])
+		v.memory += int64(len(key)) + elementSize
+		if v.maxMemory >= 0 && v.memory > v.maxMemory {
+			return ErrMemoryLimit
+		}
+	}
 	return nil
 }
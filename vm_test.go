@@ -1168,6 +1168,44 @@ func TestVMErrorUnwrap(t *testing.T) {
 		"expected error as:%v, got:%v", wrapUserErr, asErr2)
 }
 
+func TestVMRuntimeError(t *testing.T) {
+	var rerr *nanojs.RuntimeError
+	expectErrorAs(t, `
+handler = function(x) {
+	return x + "foo"
+}
+rules = {
+	check: function(x) { return handler(x) }
+}
+rules.check(5)`, nil, &rerr)
+	require.Equal(t, "invalid operation: int + string", rerr.Err.Error())
+	require.Equal(t, &nanojs.Error{
+		Value: &nanojs.String{Value: "invalid operation: int + string"},
+	}, rerr.Value)
+	require.Equal(t, 3, len(rerr.Frames))
+	require.Equal(t, "handler", rerr.Frames[0].Name)
+	require.Equal(t, "test", rerr.Frames[0].Pos.Filename)
+	require.Equal(t, 3, rerr.Frames[0].Pos.Line)
+	require.Equal(t, 9, rerr.Frames[0].Pos.Column)
+	require.Equal(t, "check", rerr.Frames[1].Name)
+	require.Equal(t, 6, rerr.Frames[1].Pos.Line)
+	require.Equal(t, "", rerr.Frames[2].Name)
+	require.Equal(t, 8, rerr.Frames[2].Pos.Line)
+	require.Equal(t, "Runtime Error: invalid operation: int + string"+
+		"\n\tat handler (test:3:9)"+
+		"\n\tat check (test:6:30)"+
+		"\n\tat test:8:1", rerr.Error())
+
+	// closures keep the function name and source positions
+	expectError(t, `
+a = 5
+f = function() {
+	return function() { return a + "foo" }()
+}
+f()`, nil, "Runtime Error: invalid operation: int + string"+
+		"\n\tat test:4:29\n\tat f (test:4:9)\n\tat test:6:1")
+}
+
 func TestError(t *testing.T) {
 	expectRun(t, `out = error(1)`, nil, errorObject(1))
 	expectRun(t, `out = error(1).value`, nil, 1)
@@ -2675,6 +2713,90 @@ export { x: 1 }
 		1)
 }
 
+func TestModuleDeclarations(t *testing.T) {
+	lib := Opts().Module("lib", `
+base = 10
+export function add(a, b) { return a + b + base }
+export const answer = 42
+sub = function(a, b) { return a - b }
+export { sub, base as offset }
+export default function(x) { return x * 2 }
+`).Skip2ndPass()
+
+	// named imports
+	expectRun(t, `import { add, answer } from "lib"; out = add(answer, 1)`,
+		lib, 53)
+	expectRun(t, `import { sub as minus, offset } from "lib"
+out = minus(offset, 3)`, lib, 7)
+
+	// default import
+	expectRun(t, `import double from "lib"; out = double(4)`, lib, 8)
+	expectRun(t, `import double, { answer } from "lib"; out = double(answer)`,
+		lib, 84)
+
+	// namespace import
+	expectRun(t, `import * as l from "lib"; out = l.add(1, 2)`, lib, 13)
+	expectRun(t, `import double, * as l from "lib"; out = double(l.answer)`,
+		lib, 84)
+
+	// dynamic import returns the exports
+	expectRun(t, `out = import("lib").sub(5, 2)`, lib, 3)
+	expectRun(t, `out = import("lib")["default"](5)`, lib, 10)
+
+	// exports are immutable
+	expectError(t, `import * as l from "lib"; l.answer = 1`, lib,
+		"not index-assignable")
+
+	// modules exporting a value
+	expectRun(t, `import fn from "mod"; out = fn()`,
+		Opts().Module("mod", `export function() { return 5 }`).Skip2ndPass(), 5)
+	expectError(t, `import { fn } from "mod"`,
+		Opts().Module("mod", `export function() { return 5 }`),
+		"Compile Error: module 'mod' has no named exports\n\tat test:1:10")
+
+	// builtin modules
+	expectRun(t, `import { abs } from "math"; out = abs(-2)`,
+		Opts().Stdlib().Skip2ndPass(), 2.0)
+	expectRun(t, `import math from "math"; out = math.abs(-2)`,
+		Opts().Stdlib().Skip2ndPass(), 2.0)
+	expectError(t, `import { unknown } from "math"`, Opts().Stdlib(),
+		"Compile Error: module 'math' has no export 'unknown'\n\tat test:1:10")
+
+	// (main) -> mod1 -> lib
+	expectRun(t, `import { total } from "mod1"; out = total`,
+		lib.Module("mod1", `import { add as plus } from "lib"
+export const total = plus(1, 2)`), 13)
+
+	// compile errors
+	expectError(t, `import { mul } from "lib"`, lib,
+		"Compile Error: module 'lib' has no export 'mul'\n\tat test:1:10")
+	expectError(t, `import x from "mod"`,
+		Opts().Module("mod", `export const a = 1`),
+		"Compile Error: module 'mod' has no default export\n\tat test:1:8")
+	expectError(t, `import("mod")`,
+		Opts().Module("mod", `export const a = 1; export { a }`),
+		"Compile Error: duplicate export 'a'\n\tat mod:1:30")
+	expectError(t, `import("mod")`,
+		Opts().Module("mod", `export { a }`),
+		"Compile Error: unresolved reference 'a'\n\tat mod:1:10")
+	expectError(t, `import("mod")`,
+		Opts().Module("mod", `export const a = 1; export a`),
+		"Compile Error: export statement not allowed with named exports")
+	expectError(t, `import("mod")`,
+		Opts().Module("mod", `export 1; export const a = 1`),
+		"Compile Error: named exports not allowed with export statement")
+	expectError(t, `import("mod")`,
+		Opts().Module("mod", `f = function() { export const a = 1 }`),
+		"Compile Error: export not allowed inside function")
+	expectError(t, `f = function() { import { a } from "mod" }`,
+		Opts().Module("mod", `export const a = 1`),
+		"Compile Error: import not allowed inside function")
+
+	// export declarations define the variables in the main script
+	expectRun(t, `export function f() { return 3 }; out = f()`,
+		Opts().Skip2ndPass(), 3)
+}
+
 func TestModuleBlockScopes(t *testing.T) {
 	m := Opts().Module("rand",
 		&nanojs.BuiltinModule{
@@ -0,0 +1,280 @@
+package nanojs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// GoFunction is a Go function converted into a callable object by WrapFunc.
+type GoFunction struct {
+	ObjectImpl
+	Name string
+	fn   reflect.Value
+	plan *funcPlan
+}
+
+// WrapFunc converts a Go function of any signature into a callable object.
+// The arguments are decoded into the parameter types as Decode does, and
+// the results are converted as FromInterface does. If the last result is an
+// error, a non-nil error is returned to the script as an Error value. If the
+// first parameter is a context.Context, it receives the context of the run
+// (see Compiled.RunContext), or context.Background().
+func WrapFunc(fn interface{}) (Object, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("cannot wrap %T: not a function", fn)
+	}
+	f, err := newGoFunction(rv, funcName(rv))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func newGoFunction(fn reflect.Value, name string) (*GoFunction, error) {
+	plan, err := cachedFuncPlan(fn.Type())
+	if err != nil {
+		return nil, err
+	}
+	return &GoFunction{Name: name, fn: fn, plan: plan}, nil
+}
+
+// TypeName returns the name of the type.
+func (o *GoFunction) TypeName() string {
+	return "go-function:" + o.Name
+}
+
+func (o *GoFunction) String() string {
+	return "<go-function>"
+}
+
+// Copy returns a copy of the type.
+func (o *GoFunction) Copy() Object {
+	return &GoFunction{Name: o.Name, fn: o.fn, plan: o.plan}
+}
+
+// Equals returns true if the value of the type is equal to the value of
+// another object.
+func (o *GoFunction) Equals(_ Object) bool {
+	return false
+}
+
+// Call invokes the Go function with context.Background().
+func (o *GoFunction) Call(args ...Object) (Object, error) {
+	return o.CallContext(context.Background(), args...)
+}
+
+// CanCall returns whether the Object can be Called.
+func (o *GoFunction) CanCall() bool {
+	return true
+}
+
+// CallContext invokes the Go function with the context of the run.
+func (o *GoFunction) CallContext(
+	ctx context.Context,
+	args ...Object,
+) (Object, error) {
+	in, err := o.plan.arguments(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	var out []reflect.Value
+	if o.plan.variadic {
+		out = o.fn.CallSlice(in)
+	} else {
+		out = o.fn.Call(in)
+	}
+	return o.plan.results(out)
+}
+
+// funcPlan is how the arguments and the results of a function type are
+// converted, which is cached by the function type.
+type funcPlan struct {
+	context  bool           // the first parameter is a context.Context
+	params   []reflect.Type // the parameters other than the context
+	variadic bool
+	error    bool // the last result is an error
+	numOut   int  // the number of the results other than the error
+}
+
+var funcPlans sync.Map // map[reflect.Type]*funcPlan
+
+func cachedFuncPlan(t reflect.Type) (*funcPlan, error) {
+	if plan, ok := funcPlans.Load(t); ok {
+		return plan.(*funcPlan), nil
+	}
+	plan := &funcPlan{variadic: t.IsVariadic()}
+	for i := 0; i < t.NumIn(); i++ {
+		if i == 0 && t.In(i) == contextType {
+			plan.context = true
+			continue
+		}
+		plan.params = append(plan.params, t.In(i))
+	}
+	plan.numOut = t.NumOut()
+	if plan.numOut > 0 && t.Out(plan.numOut-1) == errorType {
+		plan.error = true
+		plan.numOut--
+	}
+	for i := 0; i < plan.numOut; i++ {
+		if k := t.Out(i).Kind(); k == reflect.Chan || k == reflect.Func ||
+			k == reflect.UnsafePointer {
+			return nil, fmt.Errorf("cannot wrap %s: unsupported result "+
+				"type: %s", t, t.Out(i))
+		}
+	}
+	funcPlans.Store(t, plan)
+	return plan, nil
+}
+
+// arguments decodes the arguments into the parameter values.
+func (p *funcPlan) arguments(
+	ctx context.Context,
+	args []Object,
+) ([]reflect.Value, error) {
+	numParams := len(p.params)
+	if p.variadic {
+		if len(args) < numParams-1 {
+			return nil, ErrWrongNumArguments
+		}
+	} else if len(args) != numParams {
+		return nil, ErrWrongNumArguments
+	}
+
+	in := make([]reflect.Value, 0, numParams+1)
+	if p.context {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	for i, t := range p.params {
+		if p.variadic && i == numParams-1 {
+			rest := reflect.MakeSlice(t, len(args)-i, len(args)-i)
+			for j := range args[i:] {
+				err := decodeArgument(args[i+j], rest.Index(j), i+j)
+				if err != nil {
+					return nil, err
+				}
+			}
+			in = append(in, rest)
+			break
+		}
+		v := reflect.New(t).Elem()
+		if err := decodeArgument(args[i], v, i); err != nil {
+			return nil, err
+		}
+		in = append(in, v)
+	}
+	return in, nil
+}
+
+// results converts the results of the function into the return value.
+func (p *funcPlan) results(out []reflect.Value) (Object, error) {
+	if p.error {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			return &Error{Value: &String{Value: err.Error()}}, nil
+		}
+		out = out[:len(out)-1]
+	}
+	switch len(out) {
+	case 0:
+		return UndefinedValue, nil
+	case 1:
+		return fromValue(out[0], 0)
+	}
+	arr := make([]Object, len(out))
+	for i, v := range out {
+		o, err := fromValue(v, 0)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = o
+	}
+	return &Array{Value: arr}, nil
+}
+
+func decodeArgument(arg Object, v reflect.Value, idx int) error {
+	if err := decodeValue(arg, v, "", 0); err != nil {
+		return ErrInvalidArgumentType{
+			Name:     argumentName(idx),
+			Expected: typeNameOf(v.Type()),
+			Found:    arg.TypeName(),
+		}
+	}
+	return nil
+}
+
+var argumentNames = []string{"first", "second", "third", "fourth", "fifth",
+	"sixth", "seventh", "eighth", "ninth", "tenth"}
+
+// argumentName returns the name of the argument used in the errors.
+func argumentName(idx int) string {
+	if idx < len(argumentNames) {
+		return argumentNames[idx]
+	}
+	return fmt.Sprintf("#%d", idx+1)
+}
+
+// typeNameOf returns the name of the script type that is decoded into the Go
+// type.
+func typeNameOf(t reflect.Type) string {
+	switch t {
+	case durationType:
+		return "int(duration)"
+	case timeType:
+		return "time"
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) &&
+		t.Kind() != reflect.String {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		return "array"
+	case reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "map"
+	case reflect.Ptr:
+		return typeNameOf(t.Elem())
+	case reflect.Interface:
+		return "object"
+	}
+	return t.String()
+}
+
+// funcName returns the name of the function without the package path.
+func funcName(fn reflect.Value) string {
+	f := runtime.FuncForPC(fn.Pointer())
+	if f == nil {
+		return ""
+	}
+	name := f.Name()
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
+}
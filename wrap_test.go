@@ -0,0 +1,138 @@
+package nanojs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zeaphoo/nanojs/v2"
+	"github.com/zeaphoo/nanojs/v2/require"
+)
+
+type wrapPoint struct {
+	X int `nanojs:"x"`
+	Y int `nanojs:"y"`
+}
+
+type ctxKey struct{}
+
+func TestWrapFunc(t *testing.T) {
+	expectWrapped(t, strings.ToUpper, `out = f("abc")`, "ABC")
+	expectWrapped(t, strings.Repeat, `out = f("ab", 2.0)`, "abab")
+	expectWrapped(t, func(a int8, b uint16) int32 {
+		return int32(a) * int32(b)
+	},
+		`out = f(-2, 3)`, int64(-6))
+	expectWrapped(t, func(sep string, s ...string) string {
+		return strings.Join(s, sep)
+	}, `out = [f("-"), f("-", "a"), f("-", "a", "b")]`,
+		[]interface{}{"", "a", "a-b"})
+	expectWrapped(t, fmt.Sprintf, `out = f("%d-%s", 1, "x")`, "1-x")
+	expectWrapped(t, func(p wrapPoint) *wrapPoint {
+		return &wrapPoint{X: p.Y, Y: p.X}
+	}, `out = f({x: 1, y: 2})`,
+		map[string]interface{}{"x": int64(2), "y": int64(1)})
+	expectWrapped(t, func(xs []float64) (float64, int) {
+		var sum float64
+		for _, x := range xs {
+			sum += x
+		}
+		return sum, len(xs)
+	}, `out = f([1, 2.5])`, []interface{}{3.5, int64(2)})
+	expectWrapped(t, func(d time.Duration) {}, `out = f("1s")`, nil)
+	expectWrapped(t, func(o nanojs.Object) string { return o.TypeName() },
+		`out = f([])`, "array")
+
+	// error results
+	div := func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	}
+	expectWrapped(t, div, `out = f(6, 3)`, int64(2))
+	expectWrapped(t, div, `e = f(1, 0); out = [is_error(e), e.value]`,
+		[]interface{}{true, "division by zero"})
+
+	// runtime errors
+	expectWrappedError(t, strings.ToUpper, `f(1)`,
+		"invalid type for argument 'first' in call to "+
+			"'go-function:strings.ToUpper': expected string, found int")
+	expectWrappedError(t, strings.Repeat, `f("a", 1.5)`,
+		"invalid type for argument 'second' in call to "+
+			"'go-function:strings.Repeat': expected int, found float")
+	expectWrappedError(t, strings.Repeat, `f("a")`,
+		"wrong number of arguments in call to 'go-function:strings.Repeat'")
+
+	_, err := nanojs.WrapFunc(1)
+	require.Error(t, err)
+	_, err = nanojs.WrapFunc(func() chan int { return nil })
+	require.Error(t, err)
+}
+
+func TestWrapFunc_Context(t *testing.T) {
+	fn, err := nanojs.WrapFunc(func(ctx context.Context, s string) string {
+		v, _ := ctx.Value(ctxKey{}).(string)
+		return v + s
+	})
+	require.NoError(t, err)
+
+	s := nanojs.NewScript([]byte(`out = f("!")`))
+	require.NoError(t, s.Add("f", fn))
+	c, err := s.Compile()
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "ctx")
+	require.NoError(t, c.RunContext(ctx))
+	require.Equal(t, "ctx!", c.Get("out").Value())
+	require.NoError(t, c.Run())
+	require.Equal(t, "!", c.Get("out").Value())
+
+	res, err := fn.Call(&nanojs.String{Value: "?"})
+	require.NoError(t, err)
+	require.Equal(t, &nanojs.String{Value: "?"}, res)
+}
+
+func TestFromInterface_Func(t *testing.T) {
+	o, err := nanojs.FromInterface(strings.TrimSpace)
+	require.NoError(t, err)
+	require.Equal(t, "go-function:strings.TrimSpace", o.TypeName())
+
+	o, err = nanojs.FromInterface(
+		func(args ...nanojs.Object) (nanojs.Object, error) {
+			return nil, errors.New("error")
+		})
+	require.NoError(t, err)
+	_, ok := o.(*nanojs.UserFunction)
+	require.True(t, ok)
+}
+
+func expectWrapped(
+	t *testing.T,
+	fn interface{},
+	src string,
+	expected interface{},
+) {
+	f, err := nanojs.WrapFunc(fn)
+	require.NoError(t, err)
+	s := nanojs.NewScript([]byte(src))
+	require.NoError(t, s.Add("f", f))
+	c, err := s.Run()
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprint(expected), fmt.Sprint(c.Get("out").Value()))
+}
+
+func expectWrappedError(t *testing.T, fn interface{}, src, expected string) {
+	f, err := nanojs.WrapFunc(fn)
+	require.NoError(t, err)
+	s := nanojs.NewScript([]byte(src))
+	require.NoError(t, s.Add("f", f))
+	_, err = s.Run()
+	require.Error(t, err)
+	var rerr *nanojs.RuntimeError
+	require.True(t, errors.As(err, &rerr))
+	require.Equal(t, expected, rerr.Err.Error())
+}